@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+/*
+The hashcat and john formats only output things that can be cracked, one kind of hash per file, each line being the
+account name and the hash in the form the tool wants (user:hash, which hashcat reads with --username). Files are
+labelled with the hashcat mode or john format that cracks them: <out>.nt.hashcat-1000, <out>.dcc2.john-mscash2 etc.
+
+	NT      hashcat 1000   john NT       user:<nt>                user:$NT$<nt>
+	LM      hashcat 3000   john LM       user:<half>              user:$LM$<half>, one line per half
+	DCC2    hashcat 2100   john mscash2  user:$DCC2$<iterations>#<user>#<hash>
+	AES128  hashcat 28800  john krb5-17  user:$krb5db$17$<principal>$<realm>$<key>    user:$krb17$<salt>$<key>
+	AES256  hashcat 28900  john krb5-18  user:$krb5db$18$<principal>$<realm>$<key>    user:$krb18$<salt>$<key>
+
+Kerberos keys are only output when the salt they were made with is known (from ntds.dit). Pre Vista cached
+credentials (mscash v1) aren't output.
+*/
+
+// Kinds of crackable hash
+const (
+	HASH_NT     = "nt"
+	HASH_LM     = "lm"
+	HASH_DCC2   = "dcc2"
+	HASH_AES128 = "aes128"
+	HASH_AES256 = "aes256"
+)
+
+var hashcatModes = map[string]string{
+	HASH_NT:     "1000",
+	HASH_LM:     "3000",
+	HASH_DCC2:   "2100",
+	HASH_AES128: "28800",
+	HASH_AES256: "28900",
+}
+
+var johnFormats = map[string]string{
+	HASH_NT:     "NT",
+	HASH_LM:     "LM",
+	HASH_DCC2:   "mscash2",
+	HASH_AES128: "krb5-17",
+	HASH_AES256: "krb5-18",
+}
+
+// kerberos key types, as named in the keys the dit reader outputs
+var aesKeyTypes = map[string]string{
+	"aes128-cts-hmac-sha1-96": HASH_AES128,
+	"aes256-cts-hmac-sha1-96": HASH_AES256,
+}
+
+// CrackOptions change what goes into the hashcat and john formats
+type CrackOptions struct {
+	//include the hashes of empty passwords (and blank LM hashes), which are skipped by default
+	KeepEmpty bool
+	//include password history, as <user>_history<n>
+	History bool
+	//only output each hash once, for the first account it's seen on
+	Dedup bool
+}
+
+// crackHash is a hash ready to be formatted for a cracking tool
+type crackHash struct {
+	kind string
+	user string
+	//hex hash, or the whole hash for DCC2
+	hash string
+	//kerberos salt
+	salt string
+}
+
+// HashcatFormat outputs each kind of hash to its own file, as user:hash for hashcat's --username
+func HashcatFormat(opts CrackOptions) Format {
+	return crackFormat(opts, func(h crackHash) []Line {
+		kind := hashcatKind(h.kind)
+		hash := h.hash
+		switch h.kind {
+		case HASH_LM:
+			r := []Line{}
+			for _, half := range lmHalves(h.hash, opts.KeepEmpty) {
+				r = append(r, Line{kind, h.user + ":" + half})
+			}
+			return r
+		case HASH_AES128, HASH_AES256:
+			realm, principal := splitSalt(h.salt, h.user)
+			hash = fmt.Sprintf("$krb5db$%s$%s$%s$%s", etype(h.kind), principal, realm, h.hash)
+		}
+		return []Line{{kind, h.user + ":" + hash}}
+	})
+}
+
+// JohnFormat outputs each kind of hash to its own file, tagged so john picks the right format for it
+func JohnFormat(opts CrackOptions) Format {
+	return crackFormat(opts, func(h crackHash) []Line {
+		kind := johnKind(h.kind)
+		switch h.kind {
+		case HASH_NT:
+			return []Line{{kind, h.user + ":$NT$" + h.hash}}
+		case HASH_LM:
+			r := []Line{}
+			for _, half := range lmHalves(h.hash, opts.KeepEmpty) {
+				r = append(r, Line{kind, h.user + ":$LM$" + half})
+			}
+			return r
+		case HASH_AES128, HASH_AES256:
+			return []Line{{kind, fmt.Sprintf("%s:$krb%s$%s$%s", h.user, etype(h.kind), h.salt, h.hash)}}
+		}
+		return []Line{{kind, h.user + ":" + h.hash}}
+	})
+}
+
+// hashcatKind is the output kind (and so the file suffix) of a kind of hash in the hashcat format
+func hashcatKind(kind string) string {
+	return "." + kind + ".hashcat-" + hashcatModes[kind]
+}
+
+// johnKind is the output kind (and so the file suffix) of a kind of hash in the john format
+func johnKind(kind string) string {
+	return "." + kind + ".john-" + johnFormats[kind]
+}
+
+// lmHalves splits a hex LM hash into the two DES halves, which are cracked separately. A password under 8
+// characters has a blank second half, which is left out unless keepEmpty.
+func lmHalves(hash string, keepEmpty bool) []string {
+	if len(hash) != 32 {
+		return nil
+	}
+	r := []string{}
+	empty := hex.EncodeToString(ditreader.EmptyLM[:8])
+	for _, half := range []string{hash[:16], hash[16:]} {
+		if half != empty || keepEmpty {
+			r = append(r, half)
+		}
+	}
+	return r
+}
+
+// crackFormat finds the crackable hashes in each dumped hash and formats them with render
+func crackFormat(opts CrackOptions, render func(h crackHash) []Line) Format {
+	seen := map[string]bool{}
+	return func(dh ditreader.DumpedHash) []Line {
+		r := []Line{}
+		for _, h := range crackHashes(dh, opts) {
+			if opts.Dedup {
+				key := h.kind + ":" + h.salt + ":" + h.hash
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			r = append(r, render(h)...)
+		}
+		return r
+	}
+}
+
+// crackHashes pulls the crackable hashes out of a dumped hash
+func crackHashes(dh ditreader.DumpedHash, opts CrackOptions) []crackHash {
+	if dh.Secret != "" {
+		return nil
+	}
+	if dh.CachedHash != "" {
+		//DOMAIN/user:$DCC2$10240#user#hash: (last write)
+		i := strings.Index(dh.CachedHash, "$DCC2$")
+		if i < 0 {
+			return nil
+		}
+		hash := dh.CachedHash[i:]
+		if j := strings.Index(hash, ":"); j >= 0 {
+			hash = hash[:j]
+		}
+		return []crackHash{{kind: HASH_DCC2, user: dh.Username, hash: hash}}
+	}
+
+	r := []crackHash{}
+	add := func(kind, user string, hash, empty []byte) {
+		if len(hash) == 0 || (!opts.KeepEmpty && bytes.Equal(hash, empty)) {
+			return
+		}
+		r = append(r, crackHash{kind: kind, user: user, hash: hex.EncodeToString(hash)})
+	}
+	add(HASH_NT, dh.Username, dh.NTHash, ditreader.EmptyNT)
+	add(HASH_LM, dh.Username, dh.LMHash, ditreader.EmptyLM)
+	if opts.History {
+		for i, h := range dh.History.NTHist {
+			add(HASH_NT, fmt.Sprintf("%s_history%d", dh.Username, i), h, ditreader.EmptyNT)
+		}
+		for i, h := range dh.History.LmHist {
+			add(HASH_LM, fmt.Sprintf("%s_history%d", dh.Username, i), h, ditreader.EmptyLM)
+		}
+	}
+
+	if dh.Supp.KerbSalt == "" {
+		return r
+	}
+	for _, k := range dh.Supp.KerbKeys {
+		//user:keytype:key
+		parts := strings.Split(k, ":")
+		if len(parts) < 3 {
+			continue
+		}
+		kind, ok := aesKeyTypes[parts[len(parts)-2]]
+		if !ok {
+			continue
+		}
+		r = append(r, crackHash{kind: kind, user: dh.Username, hash: parts[len(parts)-1], salt: dh.Supp.KerbSalt})
+	}
+	return r
+}
+
+// etype is the kerberos encryption type number of an AES hash kind
+func etype(kind string) string {
+	if kind == HASH_AES128 {
+		return "17"
+	}
+	return "18"
+}
+
+// splitSalt splits a kerberos salt back into the realm and principal it was made from. User salts are the realm
+// followed by the account name. Computer accounts have "host" and their DNS name after the realm, so the principal
+// starts at the first lower case letter.
+func splitSalt(salt, user string) (realm, principal string) {
+	if i := strings.LastIndex(user, "\\"); i >= 0 {
+		user = user[i+1:]
+	}
+	if len(salt) > len(user) && strings.HasSuffix(salt, user) {
+		return salt[:len(salt)-len(user)], user
+	}
+	for i, c := range salt {
+		if unicode.IsLower(c) {
+			return salt[:i], salt[i:]
+		}
+	}
+	return salt, ""
+}
@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"strings"
-	"sync"
+	"time"
 
+	"github.com/C-Sto/gosecretsdump/pkg/diskimage"
 	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
 	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/securityreader"
+	"github.com/C-Sto/gosecretsdump/pkg/softwarereader"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/vss"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
 )
 
 type Dumper interface {
@@ -19,6 +25,14 @@ type CLIArgs struct {
 	SystemLoc   string
 	NTDSLoc     string
 	SAMLoc      string
+	SecurityLoc string
+	SoftwareLoc string
+	//disk image (raw, VHD or VHDX) to find the hives and dit in
+	ImageLoc string
+	//also dump every volume shadow copy on the image
+	VSS bool
+	//directory or archive to find hives and dits in
+	FromLoc     string
 	LiveSAM     bool
 	Status      bool
 	EnabledOnly bool
@@ -26,218 +40,276 @@ type CLIArgs struct {
 	NoPrint     bool
 	Stream      bool
 	History     bool
+	//syskey startup password/floppy key (SecureBoot modes 2 and 3)
+	SyskeyPassword string
+	SyskeyFile     string
+	//SYSTEM control set to read instead of \Select\Current
+	ControlSet uint
+	//include deleted accounts carved from unallocated SAM hive space
+	Deleted bool
+	//output format (secretsdump, json, records, hashcat or john), and the hashcat/john options
+	Format    string
+	KeepEmpty bool
+	Dedup     bool
+}
+
+// Validate checks there's something to dump, and that everything that needs a SYSTEM hive has one
+func (s CLIArgs) Validate() error {
+	if s.NTDSLoc == "" && s.SAMLoc == "" && s.SecurityLoc == "" && s.SoftwareLoc == "" && s.ImageLoc == "" &&
+		s.FromLoc == "" && !s.LiveSAM {
+		return errors.New("nothing to dump, provide -ntds, -sam, -security, -software, -image, -from or -livesam")
+	}
+	for _, f := range []struct{ name, val string }{{"-ntds", s.NTDSLoc}, {"-sam", s.SAMLoc}, {"-security", s.SecurityLoc}} {
+		if f.val != "" && s.SystemLoc == "" {
+			return fmt.Errorf("%s needs the SYSTEM hive as well (-system)", f.name)
+		}
+	}
+	if s.VSS && s.ImageLoc == "" {
+		return errors.New("-vss only works with -image")
+	}
+	switch s.Format {
+	case "", FORMAT_SECRETSDUMP, FORMAT_JSON:
+		if s.KeepEmpty || s.Dedup {
+			return errors.New("-keep-empty and -dedup only work with -format hashcat or john")
+		}
+	case FORMAT_HASHCAT, FORMAT_JOHN:
+	case FORMAT_RECORDS:
+		if s.NTDSLoc == "" || s.SAMLoc != "" || s.SecurityLoc != "" || s.SoftwareLoc != "" || s.ImageLoc != "" ||
+			s.FromLoc != "" || s.LiveSAM {
+			return errors.New("-format records only dumps -ntds")
+		}
+		if s.Outfile == "" || s.Stream {
+			return errors.New("-format records needs -out, and can't -stream")
+		}
+		if s.KeepEmpty || s.Dedup {
+			return errors.New("-keep-empty and -dedup only work with -format hashcat or john")
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected %s, %s, %s, %s or %s", s.Format, FORMAT_SECRETSDUMP, FORMAT_JSON, FORMAT_RECORDS, FORMAT_HASHCAT, FORMAT_JOHN)
+	}
+	if s.NoPrint && s.Outfile == "" {
+		return errors.New("-noprint without -out wouldn't output anything")
+	}
+	return nil
+}
+
+// readerOptions are the options for each kind of reader, worked out once from the args
+type readerOptions struct {
+	dit      ditreader.Options
+	sam      samreader.Options
+	security securityreader.Options
+}
+
+func (s CLIArgs) readerOptions() (readerOptions, error) {
+	sys := systemreader.Options{StartupPassword: s.SyskeyPassword, ControlSet: uint32(s.ControlSet)}
+	if s.SyskeyFile != "" {
+		b, err := os.ReadFile(s.SyskeyFile)
+		if err != nil {
+			return readerOptions{}, err
+		}
+		sys.StartupKey = b
+	}
+	return readerOptions{
+		dit:      ditreader.Options{EnabledOnly: s.EnabledOnly, System: sys},
+		sam:      samreader.Options{System: sys, IncludeDeleted: s.Deleted},
+		security: securityreader.Options{System: sys},
+	}, nil
 }
 
 // CLI entrypoint for Impacket's secretsdump functionality
 func GoSecretsDump(s CLIArgs) error {
-	var dr Dumper
-	var err error
+	opts, err := s.readerOptions()
+	if err != nil {
+		return err
+	}
+	dumpers := []Dumper{}
 	if s.NTDSLoc != "" {
-		dr, err = ditreader.New(s.SystemLoc, s.NTDSLoc)
+		dr, err := ditreader.New(s.SystemLoc, s.NTDSLoc, opts.dit)
 		if err != nil {
 			return err
 		}
+		dumpers = append(dumpers, dr)
 	}
 
 	if s.SAMLoc != "" {
-		dr, err = samreader.New(s.SystemLoc, s.SAMLoc)
+		dr, err := samreader.New(s.SystemLoc, s.SAMLoc, opts.sam)
 		if err != nil {
 			return err
 		}
+		dumpers = append(dumpers, dr)
 	}
 
 	if s.LiveSAM {
-		dr, err = samreader.NewLive()
+		dr, err := samreader.NewLive(opts.sam)
 		if err != nil {
 			return err
 		}
+		dumpers = append(dumpers, dr)
 	}
 
-	//handle any output
-	dataChan := dr.GetOutChan()
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	if s.Outfile != "" {
-		fmt.Printf("Writing to file %s\n", s.Outfile)
-		if s.Stream {
-			go fileStreamWriter(dataChan, s, &wg)
-		} else {
-			go fileWriter(dataChan, s, &wg)
-		}
-	} else {
-		go consoleWriter(dataChan, s, &wg)
-	}
-	e := dr.Dump()
-	if e != nil {
-		return e
-	}
-	wg.Wait()
-	return e
-}
-
-func consoleWriter(val <-chan ditreader.DumpedHash, s CLIArgs, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for dh := range val {
-		if s.EnabledOnly {
-			if dh.UAC.AccountDisable {
-				continue
-			}
-		}
-		var append strings.Builder
-		if s.Status {
-			stat := "Enabled"
-			if dh.UAC.AccountDisable {
-				stat = "Disabled"
-			}
-			append.WriteString(" (status=")
-			append.WriteString(stat)
-			append.WriteString(")")
-		}
-		var hs strings.Builder
-		hs.WriteString(dh.HashString())
-		hs.WriteString(append.String())
-		hs.WriteString("\n")
-		if dh.Supp.Username != "" {
-			if dh.Supp.ClearPassword != "" {
-				hs.WriteString(dh.Supp.ClearString())
-				hs.WriteString(append.String())
-				hs.WriteString("\n")
-			}
-			if len(dh.Supp.KerbKeys) > 0 {
-				hs.WriteString(dh.Supp.KerbString())
-				hs.WriteString(append.String())
-				hs.WriteString("\n")
-			}
-			if s.History {
-				hs.WriteString(dh.HistoryString())
-			}
-			//pts = dh.Supp.HashString() + "\n"
+	if s.SecurityLoc != "" {
+		dr, err := securityreader.New(s.SystemLoc, s.SecurityLoc, opts.security)
+		if err != nil {
+			return err
 		}
-		fmt.Print(hs.String())
+		dumpers = append(dumpers, dr)
 	}
-}
 
-func fileWriter(val <-chan ditreader.DumpedHash, s CLIArgs, wg *sync.WaitGroup) {
-	defer wg.Done()
-	//build up the data to eventually write
-	hashes := strings.Builder{}
-	plaintext := strings.Builder{}
-	kerbs := strings.Builder{}
-
-	for dh := range val {
-		//dh := <-val
-		if s.EnabledOnly {
-			if dh.UAC.AccountDisable {
-				continue
-			}
-		}
-		var append strings.Builder
-		if s.Status {
-			stat := "Enabled"
-			if dh.UAC.AccountDisable {
-				stat = "Disabled"
-			}
-			append.WriteString(" (status=")
-			append.WriteString(stat)
-			append.WriteString(")")
-		}
-
-		var hs strings.Builder
-		hs.WriteString(dh.HashString())
-		hs.WriteString(append.String())
-		hs.WriteString("\n")
-		var pts strings.Builder
-		if dh.Supp.Username != "" {
-			if dh.Supp.ClearPassword != "" {
-				pts.WriteString(dh.Supp.ClearString())
-				pts.WriteString(append.String())
-				pts.WriteString("\n")
-			}
-			if len(dh.Supp.KerbKeys) > 0 {
-				kerbs.WriteString(dh.Supp.KerbString())
-				kerbs.WriteString(append.String())
-				kerbs.WriteString("\n")
-			}
-			if s.History {
-				hs.WriteString(dh.HistoryString())
-			}
-			//pts = dh.Supp.HashString() + "\n"
-			plaintext.WriteString(pts.String())
+	if s.SoftwareLoc != "" {
+		dr, err := softwarereader.New(s.SoftwareLoc)
+		if err != nil {
+			return err
 		}
-		hashes.WriteString(hs.String())
-		//fmt.Print(hs.String() + pts.String())
+		dumpers = append(dumpers, dr)
 	}
 
-	if hashes.Len() > 0 {
-		file, err := os.OpenFile(s.Outfile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if s.ImageLoc != "" {
+		d, err := diskimage.Open(s.ImageLoc)
 		if err != nil {
-			panic(err) //ok to panic here
+			return err
 		}
-		defer file.Close()
-		file.WriteString(hashes.String())
-	}
-
-	if plaintext.Len() > 0 {
-		ctfile, err := os.OpenFile(s.Outfile+".cleartext", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+		defer d.Close()
+		drs, err := imageDumpers(d, s, opts)
 		if err != nil {
-			panic(err) //ok to panic here
+			return err
 		}
-		defer ctfile.Close()
-		ctfile.WriteString(plaintext.String())
+		dumpers = append(dumpers, drs...)
 	}
 
-	if kerbs.Len() > 0 {
-		krbfile, err := os.OpenFile(s.Outfile+".kerb", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if s.FromLoc != "" {
+		drs, closer, err := fromDumpers(s, opts)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		defer krbfile.Close()
-		krbfile.WriteString(kerbs.String())
+		defer closer.Close()
+		dumpers = append(dumpers, drs...)
 	}
-}
 
-func fileStreamWriter(val <-chan ditreader.DumpedHash, s CLIArgs, wg *sync.WaitGroup) {
-	defer wg.Done()
-	file, err := os.OpenFile(s.Outfile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
-	if err != nil {
-		panic(err) //ok to panic here
+	if len(dumpers) == 0 {
+		return fmt.Errorf("nothing to dump, provide an ntds, sam, security or software hive, a disk image or a directory")
 	}
-	defer file.Close()
 
-	ctfile, err := os.OpenFile(s.Outfile+".cleartext", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if s.Outfile != "" {
+		fmt.Fprintf(os.Stderr, "Writing to file %s\n", s.Outfile)
+	}
+	//one sink per output file, so everything going to the same file ends up in it
+	//the main one is opened first, as opening it clears out files an earlier -vss run left for the snapshots
+	sink, err := newSink(s)
 	if err != nil {
-		panic(err) //ok to panic here
-	}
-	defer ctfile.Close()
-	count := 0
-	for dh := range val {
-		//dh := <-val
-		append := ""
-		if s.Status {
-			stat := "Enabled"
-			if dh.UAC.AccountDisable {
-				stat = "Disabled"
+		return err
+	}
+	sinks := map[string]Sink{s.Outfile: sink}
+	order := []string{s.Outfile}
+	var snapshot *vss.Snapshot
+	//dumpers can share a SYSTEM hive, which only needs reporting once
+	reported := map[string]bool{}
+	for _, dr := range dumpers {
+		args := s
+		//shadow copies get a header on screen, and their own files
+		if sd, ok := dr.(snapshotDumper); ok {
+			if sd.snapshot != snapshot {
+				snapshot = sd.snapshot
+				reported = map[string]bool{}
+				fmt.Fprintf(os.Stderr, "[*] Shadow copy %s, created %s\n", snapshot.ID, snapshot.Created.Format(time.RFC3339))
+			}
+			if s.Outfile != "" {
+				args.Outfile = s.Outfile + "." + sd.label()
+			}
+		}
+		sink, ok := sinks[args.Outfile]
+		if !ok {
+			if sink, err = newSink(args); err != nil {
+				break
+			}
+			sinks[args.Outfile] = sink
+			order = append(order, args.Outfile)
+		}
+		err = dump(dr, sink)
+		//image readers aren't opened until they're dumped, so what they recovered is only known afterwards
+		for _, rec := range recoveries(dr) {
+			if !reported[rec.Name] {
+				reported[rec.Name] = true
+				fmt.Fprintf(os.Stderr, "[*] %s: %s\n", rec.Name, rec)
 			}
-			append += " (status=" + stat + ")"
 		}
-		if s.EnabledOnly {
-			if dh.UAC.AccountDisable {
+		if err != nil {
+			//everything that could be dumped was, so carry on with the rest
+			if errors.Is(err, ditreader.ErrIncomplete) {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				err = nil
 				continue
 			}
+			if fd, ok := dr.(foundDumper); ok {
+				fmt.Fprintf(os.Stderr, "Failed dumping %s: %v\n", fd.name, err)
+				err = nil
+				continue
+			}
+			break
+		}
+	}
+	//whatever was dumped before an error still gets written out
+	for _, o := range order {
+		if cerr := sinks[o].Close(); cerr != nil && err == nil {
+			err = cerr
 		}
+	}
+	return err
+}
+
+// recoverer is a dumper that opened hives, which may have needed transaction logs replaying
+type recoverer interface {
+	Recoveries() []winregistry.Recovery
+}
 
-		hs := dh.HashString() + append + "\n"
-		pts := ""
-		if dh.Supp.Username != "" {
-			pts = dh.Supp.ClearString() + append + "\n"
-			ctfile.WriteString(pts)
+// recoveries is the dirty hives that dr opened, looking through the wrappers that image and -from dumpers come in
+func recoveries(dr Dumper) []winregistry.Recovery {
+	switch d := dr.(type) {
+	case snapshotDumper:
+		return recoveries(d.Dumper)
+	case foundDumper:
+		return recoveries(d.Dumper)
+	case recoverer:
+		return d.Recoveries()
+	}
+	return nil
+}
+
+// newSink sets up the output chain the args ask for: the console unless -noprint, files if -out is set, and only
+// enabled accounts with -enabled
+func newSink(s CLIArgs) (Sink, error) {
+	outputs := []Sink{}
+	if !s.NoPrint {
+		outputs = append(outputs, NewConsoleSink(os.Stdout, newFormat(s)))
+	}
+	if s.Outfile != "" {
+		f, err := NewFileSink(s.Outfile, newFormat(s), s.Stream)
+		if err != nil {
+			return nil, err
 		}
-		file.WriteString(hs)
-		fmt.Print(hs + pts)
+		outputs = append(outputs, f)
+	}
+	sink := NewMultiSink(outputs...)
+	if s.EnabledOnly {
+		sink = NewFilterSink(sink, KeepEnabled)
+	}
+	return sink, nil
+}
 
-		count++
-		if count%10 == 1 {
-			file.Sync()
+// dump runs a single dumper, feeding everything it finds to sink
+func dump(dr Dumper, sink Sink) error {
+	werr := make(chan error)
+	go func() {
+		var err error
+		for dh := range dr.GetOutChan() {
+			if e := sink.Write(dh); e != nil && err == nil {
+				err = e
+			}
 		}
+		werr <- err
+	}()
+	err := dr.Dump()
+	if e := <-werr; err == nil {
+		err = e
 	}
+	return err
 }
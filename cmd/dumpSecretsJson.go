@@ -13,67 +13,68 @@ type DumperJSON interface {
 	DumpJSON() error
 }
 
-// CLI entrypoint for dumping an AD database to JSON
-//
-// TODO: Stop panicking so much
+// CLI entrypoint for dumping every record of an AD database, with all its columns, to JSON (-format records)
 func GoSecretsDumpJSON(args CLIArgs) error {
 	var dr DumperJSON
 	var err error
 
-	if args.NTDSLoc != "" {
-		dr, err = ditreader.New(args.SystemLoc, args.NTDSLoc)
-		if err != nil {
-			return err
-		}
+	if args.NTDSLoc == "" {
+		return errors.New("please provide an ntds file")
 	}
-
-	dataChannel := dr.GetOutChan()
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-
 	if args.Stream {
-		panic(errors.New("stream output is not supported for JSON output"))
+		return errors.New("stream output is not supported for JSON output")
 	}
-
-	if args.Outfile != "" && !args.Stream {
-		go fileWriterJSON(dataChannel, args, &wg)
-	} else if args.Outfile != "" {
-		panic(errors.New("please provide an output file"))
-	} else if args.Stream {
-		panic(errors.New("stream output is not supported for JSON output"))
-	} else {
-		panic(errors.New("console output is not supported for JSON output"))
+	if args.Outfile == "" {
+		return errors.New("console output is not supported for JSON output, please provide an output file")
 	}
 
-	err = dr.DumpJSON()
+	opts, err := args.readerOptions()
+	if err != nil {
+		return err
+	}
+	dr, err = ditreader.New(args.SystemLoc, args.NTDSLoc, opts.dit)
 	if err != nil {
 		return err
 	}
 
+	dataChannel := dr.GetOutChan()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var werr error
+	go fileWriterJSON(dataChannel, args, &wg, &werr)
+
+	err = dr.DumpJSON()
 	wg.Wait()
-	return err
+	if err != nil {
+		return err
+	}
+	return werr
 }
 
 // Goroutine for writing JSON output to the target file
-func fileWriterJSON(val <-chan ditreader.DumpedHash, args CLIArgs, wg *sync.WaitGroup) {
+func fileWriterJSON(val <-chan ditreader.DumpedHash, args CLIArgs, wg *sync.WaitGroup, errOut *error) {
 	defer wg.Done()
 
 	// Open + truncate the file
-	file, err := os.OpenFile(args.Outfile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	file, err := os.OpenFile(args.Outfile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 	if err != nil {
-		panic(err)
+		*errOut = err
+		drain(val)
+		return
 	}
 
 	defer file.Close()
 
-	if err := file.Truncate(0); err != nil {
-		panic(err)
-	}
-
 	// Write hashes from the channel
 	for dh := range val {
-		if _, err := file.WriteString(dh.JsonString); err != nil {
-			panic(err)
+		if _, err := file.WriteString(dh.JsonString); err != nil && *errOut == nil {
+			*errOut = err
 		}
 	}
 }
+
+// drain empties the channel, so a dumper doesn't block forever when a writer gives up early
+func drain(val <-chan ditreader.DumpedHash) {
+	for range val {
+	}
+}
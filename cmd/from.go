@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/C-Sto/gosecretsdump/pkg/discover"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/securityreader"
+	"github.com/C-Sto/gosecretsdump/pkg/softwarereader"
+)
+
+// fromDumpers finds everything dumpable in a directory or archive (IFM output, backups, a pile of collected
+// hives), and sets up a dumper for each pairing that works. Files that fail to open are skipped, so one bad hive
+// doesn't stop the rest being dumped. The closer releases the archive once dumping is done.
+func fromDumpers(s CLIArgs, opts readerOptions) ([]Dumper, io.Closer, error) {
+	fsys, closer, err := discover.Open(s.FromLoc)
+	if err != nil {
+		return nil, nil, err
+	}
+	found, err := discover.Scan(fsys)
+	if err != nil {
+		closer.Close()
+		return nil, nil, fmt.Errorf("%s: %w", s.FromLoc, err)
+	}
+
+	dumpers := []Dumper{}
+	for _, set := range discover.Pair(found) {
+		if set.System != "" {
+			fmt.Fprintf(os.Stderr, "Found SYSTEM %s\n", set.System)
+		}
+		for _, f := range set.NTDS {
+			fmt.Fprintf(os.Stderr, "  ntds.dit %s\n", f)
+			dr, err := ditreader.NewFS(fsys, set.System, f, opts.dit)
+			dumpers = addDumper(dumpers, f, dr, err)
+		}
+		for _, f := range set.SAM {
+			fmt.Fprintf(os.Stderr, "  SAM %s\n", f)
+			dr, err := samreader.NewFS(fsys, set.System, f, opts.sam)
+			dumpers = addDumper(dumpers, f, dr, err)
+		}
+		for _, f := range set.Security {
+			fmt.Fprintf(os.Stderr, "  SECURITY %s\n", f)
+			dr, err := securityreader.NewFS(fsys, set.System, f, opts.security)
+			dumpers = addDumper(dumpers, f, dr, err)
+		}
+		for _, f := range set.Software {
+			fmt.Fprintf(os.Stderr, "  SOFTWARE %s\n", f)
+			dr, err := softwarereader.NewFS(fsys, f)
+			dumpers = addDumper(dumpers, f, dr, err)
+		}
+	}
+	if len(dumpers) == 0 {
+		closer.Close()
+		return nil, nil, fmt.Errorf("%s: %w (found %s, but nothing that pairs with a SYSTEM hive)", s.FromLoc, discover.ErrNothingFound, foundList(found))
+	}
+	return dumpers, closer, nil
+}
+
+// foundDumper is a dumper for a file turned up by -from. If it fails the rest still get dumped.
+type foundDumper struct {
+	Dumper
+	name string
+}
+
+// addDumper adds dr to dumpers, unless it failed to open
+func addDumper(dumpers []Dumper, name string, dr Dumper, err error) []Dumper {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", name, err)
+		return dumpers
+	}
+	return append(dumpers, foundDumper{dr, name})
+}
+
+func foundList(found []discover.Found) string {
+	r := []string{}
+	for _, f := range found {
+		r = append(r, f.Kind+" "+f.Path)
+	}
+	return strings.Join(r, ", ")
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/diskimage"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/ntfs"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/securityreader"
+	"github.com/C-Sto/gosecretsdump/pkg/softwarereader"
+	"github.com/C-Sto/gosecretsdump/pkg/vss"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// Where Windows keeps things, relative to the root of the system volume
+const (
+	IMAGE_SYSTEM   = "Windows/System32/config/SYSTEM"
+	IMAGE_SAM      = "Windows/System32/config/SAM"
+	IMAGE_SECURITY = "Windows/System32/config/SECURITY"
+	IMAGE_SOFTWARE = "Windows/System32/config/SOFTWARE"
+	IMAGE_NTDS     = "Windows/NTDS/ntds.dit"
+)
+
+// imageDumpers looks for Windows installs on the NTFS partitions of a disk image, and sets up a dumper for each of
+// the hives (and dit) found. Everything is read straight out of the image, nothing is extracted to disk. With -vss,
+// every shadow copy of the partition is dumped as well.
+func imageDumpers(d *diskimage.Disk, s CLIArgs, opts readerOptions) ([]Dumper, error) {
+	if d.Dirty {
+		fmt.Fprintln(os.Stderr, "Warning: the image has unreplayed log entries, the most recent writes may be missing")
+	}
+	parts, err := d.NTFSPartitions()
+	if err != nil {
+		return nil, err
+	}
+	dumpers := []Dumper{}
+	for _, p := range parts {
+		vol, err := ntfs.New(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping partition %d: %v\n", p.Index, err)
+			continue
+		}
+		if !exists(vol, IMAGE_SYSTEM) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Found Windows on partition %d (%d bytes at offset %d)\n", p.Index, p.Size, p.Start)
+		dumpers = append(dumpers, volumeDumpers(vol, opts)...)
+		if !s.VSS {
+			continue
+		}
+
+		snaps, err := vss.Snapshots(p)
+		if errors.Is(err, vss.ErrNoSnapshots) {
+			fmt.Fprintf(os.Stderr, "No shadow copies on partition %d\n", p.Index)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping shadow copies on partition %d: %v\n", p.Index, err)
+			continue
+		}
+		for _, snap := range snaps {
+			vol, err := ntfs.New(snap)
+			if err == nil && !exists(vol, IMAGE_SYSTEM) {
+				err = fmt.Errorf("no SYSTEM hive")
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping shadow copy %s: %v\n", snap.ID, err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Found shadow copy %s, created %s\n", snap.ID, snap.Created.Format(time.RFC3339))
+			for _, dr := range volumeDumpers(vol, opts) {
+				dumpers = append(dumpers, snapshotDumper{Dumper: dr, snapshot: snap})
+			}
+		}
+	}
+	if len(dumpers) == 0 {
+		return nil, fmt.Errorf("no Windows install found on the %d NTFS partition(s) of the image", len(parts))
+	}
+	return dumpers, nil
+}
+
+// volumeDumpers sets up a dumper for each of the hives (and dit) on a Windows system volume. The readers aren't
+// opened until they're dumped.
+func volumeDumpers(vol fs.FS, opts readerOptions) []Dumper {
+	dumpers := []Dumper{}
+	if exists(vol, IMAGE_NTDS) {
+		dumpers = append(dumpers, newLazyDumper(func() (Dumper, error) {
+			return ditreader.NewFS(vol, IMAGE_SYSTEM, IMAGE_NTDS, opts.dit)
+		}))
+	}
+	if exists(vol, IMAGE_SAM) {
+		dumpers = append(dumpers, newLazyDumper(func() (Dumper, error) {
+			return samreader.NewFS(vol, IMAGE_SYSTEM, IMAGE_SAM, opts.sam)
+		}))
+	}
+	if exists(vol, IMAGE_SECURITY) {
+		dumpers = append(dumpers, newLazyDumper(func() (Dumper, error) {
+			return securityreader.NewFS(vol, IMAGE_SYSTEM, IMAGE_SECURITY, opts.security)
+		}))
+	}
+	if exists(vol, IMAGE_SOFTWARE) {
+		dumpers = append(dumpers, newLazyDumper(func() (Dumper, error) {
+			return softwarereader.NewFS(vol, IMAGE_SOFTWARE)
+		}))
+	}
+	return dumpers
+}
+
+// lazyDumper opens its reader when it's dumped, and lets go of it afterwards. Readers hold everything they read in
+// memory, so with a dit in every shadow copy only one of them is held at a time.
+type lazyDumper struct {
+	open       func() (Dumper, error)
+	out        chan ditreader.DumpedHash
+	recoveries []winregistry.Recovery
+}
+
+func newLazyDumper(open func() (Dumper, error)) *lazyDumper {
+	return &lazyDumper{open: open, out: make(chan ditreader.DumpedHash)}
+}
+
+func (d *lazyDumper) GetOutChan() <-chan ditreader.DumpedHash {
+	return d.out
+}
+
+func (d *lazyDumper) Dump() error {
+	defer close(d.out)
+	dr, err := d.open()
+	if err != nil {
+		return err
+	}
+	d.recoveries = recoveries(dr)
+	done := make(chan struct{})
+	go func() {
+		for dh := range dr.GetOutChan() {
+			d.out <- dh
+		}
+		close(done)
+	}()
+	err = dr.Dump()
+	<-done
+	return err
+}
+
+// Recoveries is the dirty hives the reader opened, once it has been dumped
+func (d *lazyDumper) Recoveries() []winregistry.Recovery {
+	return d.recoveries
+}
+
+// snapshotDumper is a dumper reading from a shadow copy, rather than the current state of a volume
+type snapshotDumper struct {
+	Dumper
+	snapshot *vss.Snapshot
+}
+
+// label names the snapshot's output, by when it was taken
+func (d snapshotDumper) label() string {
+	return "vss-" + d.snapshot.Created.UTC().Format("20060102T150405Z")
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
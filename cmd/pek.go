@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+// CLI entrypoint for printing the decrypted PEK list of an AD database
+func PrintPEK(args CLIArgs) error {
+	opts, err := args.readerOptions()
+	if err != nil {
+		return err
+	}
+	dr, err := ditreader.New(args.SystemLoc, args.NTDSLoc, opts.dit)
+	if err != nil {
+		return err
+	}
+	peks, err := dr.PEK()
+	if err != nil {
+		return err
+	}
+	for i, pek := range peks {
+		fmt.Printf("PEK # %d found and decrypted: %s\n", i, hex.EncodeToString(pek))
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+/*
+Output goes through a chain of sinks. Filters decide which hashes get through, a Format turns each hash into lines,
+and the console and file sinks write those lines out. Every dumper writing to the same -out shares one chain, so the
+flags mean the same thing whichever way the output is going, and any number of outputs can be fed in one pass.
+*/
+
+// Sink receives dumped hashes. Close must be called once everything has been written, as some sinks hold on to
+// their output until then.
+type Sink interface {
+	Write(dh ditreader.DumpedHash) error
+	Close() error
+}
+
+// Output formats
+const (
+	FORMAT_SECRETSDUMP = "secretsdump"
+	FORMAT_JSON        = "json"
+	FORMAT_HASHCAT     = "hashcat"
+	FORMAT_JOHN        = "john"
+	//every ntds record with all of its columns, rather than the accounts as dumped hashes
+	FORMAT_RECORDS = "records"
+)
+
+// Kinds of output. The kind is also the suffix added to -out for the file it's written to.
+const (
+	OUT_HASHES    = ""
+	OUT_CLEARTEXT = ".cleartext"
+	OUT_KERB      = ".kerb"
+	OUT_SECRETS   = ".secrets"
+	OUT_CACHED    = ".cached"
+)
+
+// outputKinds is every kind of output any format can produce
+func outputKinds() []string {
+	r := []string{OUT_HASHES, OUT_CLEARTEXT, OUT_KERB, OUT_SECRETS, OUT_CACHED}
+	for kind := range hashcatModes {
+		r = append(r, hashcatKind(kind), johnKind(kind))
+	}
+	return r
+}
+
+// Line is a line of formatted output
+type Line struct {
+	Kind string
+	Text string
+}
+
+// Format turns a dumped hash into lines of output
+type Format func(dh ditreader.DumpedHash) []Line
+
+// SecretsdumpFormat is impacket's secretsdump output. With status, account lines say whether the account is
+// enabled. With history, the password history follows each account's hash.
+func SecretsdumpFormat(status, history bool) Format {
+	return func(dh ditreader.DumpedHash) []Line {
+		if dh.Secret != "" {
+			return []Line{{OUT_SECRETS, dh.Secret}}
+		}
+		if dh.CachedHash != "" {
+			return []Line{{OUT_CACHED, dh.CachedHash}}
+		}
+		suffix := ""
+		if status {
+			stat := "Enabled"
+			if dh.UAC.Has(ditreader.UF_ACCOUNTDISABLE) {
+				stat = "Disabled"
+			}
+			suffix += " (status=" + stat + ")"
+		}
+		if dh.Deleted {
+			suffix += " (deleted)"
+		}
+		r := []Line{{OUT_HASHES, dh.HashString() + suffix}}
+		if dh.Supp.Username != "" {
+			if dh.Supp.ClearPassword != "" {
+				r = append(r, Line{OUT_CLEARTEXT, dh.Supp.ClearString() + suffix})
+			}
+			for _, k := range dh.Supp.KerbKeys {
+				r = append(r, Line{OUT_KERB, k + suffix})
+			}
+		}
+		if history {
+			for _, h := range dh.HistoryStrings() {
+				r = append(r, Line{OUT_HASHES, h})
+			}
+		}
+		return r
+	}
+}
+
+// JSONFormat is one JSON object per line, everything going to the one file
+func JSONFormat(dh ditreader.DumpedHash) []Line {
+	//nothing in a DumpedHash can fail to marshal
+	b, _ := json.Marshal(dh)
+	return []Line{{OUT_HASHES, string(b)}}
+}
+
+// newFormat is the format the args ask for. Formats can keep state (to dedup), so every output needs its own.
+func newFormat(s CLIArgs) Format {
+	opts := CrackOptions{KeepEmpty: s.KeepEmpty, History: s.History, Dedup: s.Dedup}
+	switch s.Format {
+	case FORMAT_HASHCAT:
+		return HashcatFormat(opts)
+	case FORMAT_JOHN:
+		return JohnFormat(opts)
+	case FORMAT_JSON:
+		return JSONFormat
+	}
+	return SecretsdumpFormat(s.Status, s.History)
+}
+
+// KeepEnabled is a filter that drops disabled accounts. Secrets and cached credentials aren't accounts, so they
+// always get through.
+func KeepEnabled(dh ditreader.DumpedHash) bool {
+	return dh.Secret != "" || dh.CachedHash != "" || !dh.UAC.Has(ditreader.UF_ACCOUNTDISABLE)
+}
+
+type filterSink struct {
+	next Sink
+	keep func(dh ditreader.DumpedHash) bool
+}
+
+// NewFilterSink passes on the hashes that keep returns true for
+func NewFilterSink(next Sink, keep func(dh ditreader.DumpedHash) bool) Sink {
+	return filterSink{next: next, keep: keep}
+}
+
+func (s filterSink) Write(dh ditreader.DumpedHash) error {
+	if !s.keep(dh) {
+		return nil
+	}
+	return s.next.Write(dh)
+}
+
+func (s filterSink) Close() error {
+	return s.next.Close()
+}
+
+type multiSink []Sink
+
+// NewMultiSink writes to all of sinks. An error from one doesn't stop the others being written to, the first error
+// is returned.
+func NewMultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Write(dh ditreader.DumpedHash) error {
+	var err error
+	for _, s := range m {
+		if e := s.Write(dh); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m multiSink) Close() error {
+	var err error
+	for _, s := range m {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type consoleSink struct {
+	w      io.Writer
+	format Format
+}
+
+// NewConsoleSink writes every kind of output to w
+func NewConsoleSink(w io.Writer, format Format) Sink {
+	return consoleSink{w: w, format: format}
+}
+
+func (s consoleSink) Write(dh ditreader.DumpedHash) error {
+	for _, l := range s.format(dh) {
+		if _, err := fmt.Fprintln(s.w, l.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s consoleSink) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	base   string
+	format Format
+	stream bool
+	//kinds in the order they were first seen, so files are written in a predictable order
+	kinds []string
+	files map[string]*os.File
+	bufs  map[string]*strings.Builder
+}
+
+// NewFileSink writes each kind of output to its own file: base for hashes, base.cleartext for cleartext passwords
+// and so on. Files are only created once there's something to put in them. Every file an earlier run could have
+// written for base, in any format, is removed up front (along with the base.vss-* files of an earlier -vss run), so
+// nothing stale is left next to the new output. When streaming, lines are written as they arrive. Otherwise
+// everything is kept in memory and written out on Close, which is a lot quicker for big dits.
+func NewFileSink(base string, format Format, stream bool) (Sink, error) {
+	for _, k := range outputKinds() {
+		if err := os.Remove(base + k); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err := removeSnapshotOutput(base); err != nil {
+		return nil, err
+	}
+	return &fileSink{
+		base:   base,
+		format: format,
+		stream: stream,
+		files:  map[string]*os.File{},
+		bufs:   map[string]*strings.Builder{},
+	}, nil
+}
+
+// removeSnapshotOutput removes the files written for each shadow copy by an earlier -vss run with base as its output.
+// Not every run finds the same shadow copies, so they're found by name rather than worked out.
+func removeSnapshotOutput(base string) error {
+	dir, name := filepath.Split(base)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), name+".vss-") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) Write(dh ditreader.DumpedHash) error {
+	for _, l := range s.format(dh) {
+		if !s.stream {
+			b, ok := s.bufs[l.Kind]
+			if !ok {
+				b = &strings.Builder{}
+				s.bufs[l.Kind] = b
+				s.kinds = append(s.kinds, l.Kind)
+			}
+			b.WriteString(l.Text)
+			b.WriteString("\n")
+			continue
+		}
+		f, ok := s.files[l.Kind]
+		if !ok {
+			var err error
+			f, err = os.Create(s.base + l.Kind)
+			if err != nil {
+				return err
+			}
+			s.files[l.Kind] = f
+			s.kinds = append(s.kinds, l.Kind)
+		}
+		if _, err := f.WriteString(l.Text + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	var err error
+	for _, k := range s.kinds {
+		var e error
+		if s.stream {
+			e = s.files[k].Close()
+		} else {
+			e = os.WriteFile(s.base+k, []byte(s.bufs[k].String()), 0644)
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	s.kinds = nil
+	return err
+}
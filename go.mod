@@ -4,6 +4,8 @@ go 1.21
 
 require (
 	github.com/charmbracelet/log v0.3.1
+	github.com/klauspost/compress v1.17.4
+	golang.org/x/crypto v0.14.0
 	golang.org/x/sys v0.13.0
 	golang.org/x/text v0.13.0
 )
@@ -0,0 +1,13 @@
+// Package corrupt is shared by the parsers of on disk structures (registry hives, ESE databases)
+package corrupt
+
+import "fmt"
+
+// Recover is deferred by the exported entry points of a parser as a last line of defence. Offsets and sizes read
+// from disk are bounds checked where they're used, but if one is missed and parsing bad data panics, the panic is
+// returned as an error wrapping sentinel instead of taking the whole program down.
+func Recover(err *error, sentinel error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", sentinel, r)
+	}
+}
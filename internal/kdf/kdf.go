@@ -0,0 +1,26 @@
+// Package kdf derives the keys Windows makes from a password: the NT hash, and the kerberos AES keys
+package kdf
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// NTHash returns the NT hash of a password (MD4 of the UTF-16LE password)
+func NTHash(password string) []byte {
+	u := utf16.Encode([]rune(password))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return MD4(b)
+}
+
+// MD4 returns the MD4 digest of b
+func MD4(b []byte) []byte {
+	h := md4.New()
+	h.Write(b)
+	return h.Sum(nil)
+}
@@ -0,0 +1,104 @@
+package kdf
+
+import (
+	"crypto/aes"
+	"crypto/sha1"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// AES_ITERATIONS is the default PBKDF2 iteration count for the kerberos AES enctypes (RFC 3962)
+const AES_ITERATIONS = 4096
+
+// AESStringToKey derives a kerberos AES key (16 bytes for aes128-cts-hmac-sha1-96, 32 for aes256) from a password
+// and salt, as per RFC 3962: DK(random2key(PBKDF2-HMAC-SHA1(password, salt, iterations)), "kerberos")
+func AESStringToKey(password, salt []byte, iterations, keyLen int) ([]byte, error) {
+	if keyLen != 16 && keyLen != 32 {
+		return nil, fmt.Errorf("Bad AES key length %d", keyLen)
+	}
+	tkey := pbkdf2.Key(password, salt, iterations, keyLen, sha1.New)
+	return deriveKey(tkey, []byte("kerberos"))
+}
+
+// deriveKey is DK(key, constant) from RFC 3961, using AES as the block cipher
+func deriveKey(key, constant []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	//DR: encrypt the n-folded constant, then keep encrypting the output until there is enough key material
+	r := []byte{}
+	in := NFold(constant, aes.BlockSize)
+	for len(r) < len(key) {
+		out := make([]byte, aes.BlockSize)
+		block.Encrypt(out, in)
+		r = append(r, out...)
+		in = out
+	}
+	//random-to-key is the identity function for AES
+	return r[:len(key)], nil
+}
+
+// NFold stretches or shrinks b to n bytes using the n-fold operation from RFC 3961
+func NFold(b []byte, n int) []byte {
+	if len(b) == 0 {
+		return make([]byte, n)
+	}
+	l := len(b)
+	lcm := n * l / gcd(n, l)
+
+	//lcm/l copies of b, each rotated right by 13 bits more than the last
+	big := make([]byte, 0, lcm)
+	for i := 0; i < lcm/l; i++ {
+		big = append(big, rotateRight(b, 13*i)...)
+	}
+
+	//add the n byte slices together as big endian ones' complement numbers
+	sum := make([]int, n)
+	for p := 0; p < lcm; p += n {
+		for i := 0; i < n; i++ {
+			sum[i] += int(big[p+i])
+		}
+	}
+	for {
+		carry := false
+		next := make([]int, n)
+		for i := 0; i < n; i++ {
+			//carries move left, and the leftmost wraps around to the end
+			next[i] = sum[i]&0xff + sum[(i+1)%n]>>8
+			if next[i] > 0xff {
+				carry = true
+			}
+		}
+		sum = next
+		if !carry {
+			break
+		}
+	}
+	r := make([]byte, n)
+	for i := range sum {
+		r[i] = byte(sum[i])
+	}
+	return r
+}
+
+// rotateRight rotates b to the right by nbits bits
+func rotateRight(b []byte, nbits int) []byte {
+	l := len(b)
+	nbytes, remain := (nbits/8)%l, uint(nbits%8)
+	r := make([]byte, l)
+	for i := range b {
+		hi := b[((i-nbytes)%l+l)%l]
+		lo := b[((i-nbytes-1)%l+l)%l]
+		r[i] = hi>>remain | lo<<(8-remain)
+	}
+	return r
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
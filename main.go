@@ -17,16 +17,41 @@ func main() {
 		version = "DEV"
 	}
 
-	fmt.Println("gosecretsdump v" + version + " (@C__Sto)")
+	fmt.Fprintln(os.Stderr, "gosecretsdump v"+version+" (@C__Sto)")
 
 	args := cmd.CLIArgs{}
 
+	//gosecretsdump pek -ntds ntds.dit -system SYSTEM
+	if len(os.Args) > 1 && os.Args[1] == "pek" {
+		pekFlags := flag.NewFlagSet("pek", flag.ExitOnError)
+		pekFlags.StringVar(&args.NTDSLoc, "ntds", "", "Location of the NTDS file (required)")
+		pekFlags.StringVar(&args.SystemLoc, "system", "", "Location of the SYSTEM file (required)")
+		pekFlags.UintVar(&args.ControlSet, "controlset", 0, "SYSTEM control set to take the bootkey from (eg 2 for ControlSet002), instead of the current one")
+		pekFlags.StringVar(&args.SyskeyPassword, "syskey-password", "", "Syskey startup password, for old systems using SecureBoot mode 2")
+		pekFlags.StringVar(&args.SyskeyFile, "syskey-file", "", "Location of the StartupKey.Key file, for old systems using SecureBoot mode 3")
+		pekFlags.Parse(os.Args[2:])
+		if args.NTDSLoc == "" || args.SystemLoc == "" {
+			pekFlags.Usage()
+			os.Exit(1)
+		}
+		if e := cmd.PrintPEK(args); e != nil {
+			fmt.Fprintln(os.Stderr, "Error:", e)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var vers bool
 
 	flag.StringVar(&args.Outfile, "out", "", "Location to export output")
-	flag.StringVar(&args.NTDSLoc, "ntds", "", "Location of the NTDS file (required)")
-	flag.StringVar(&args.SystemLoc, "system", "", "Location of the SYSTEM file (required)")
+	flag.StringVar(&args.NTDSLoc, "ntds", "", "Location of the NTDS file")
+	flag.StringVar(&args.SystemLoc, "system", "", "Location of the SYSTEM file (required for -ntds, -sam and -security)")
 	flag.StringVar(&args.SAMLoc, "sam", "", "Location of SAM registry hive")
+	flag.StringVar(&args.SecurityLoc, "security", "", "Location of SECURITY registry hive (LSA secrets)")
+	flag.StringVar(&args.SoftwareLoc, "software", "", "Location of SOFTWARE registry hive (autologon and VNC passwords, doesn't need SYSTEM)")
+	flag.StringVar(&args.FromLoc, "from", "", "Directory or zip/tar(.gz/.zst) archive to search for ntds.dit and hives (IFM output, backups), dumping everything that pairs up")
+	flag.StringVar(&args.ImageLoc, "image", "", "Location of a raw/dd, VHD or VHDX disk image to dump (finds the hives and dit on its NTFS partitions)")
+	flag.BoolVar(&args.VSS, "vss", false, "With -image, also dump every volume shadow copy (output files get a .vss-<creation time> suffix)")
 	flag.BoolVar(&args.LiveSAM, "livesam", false, "Get hashes from live system. Only works on local machine hashes (SAM), only works on Windows.")
 	flag.BoolVar(&args.Status, "status", false, "Include status in hash output")
 	flag.BoolVar(&args.EnabledOnly, "enabled", false, "Only output enabled accounts")
@@ -34,22 +59,34 @@ func main() {
 	flag.BoolVar(&args.Stream, "stream", false, "Stream to files rather than writing in a block. Can be much slower.")
 	flag.BoolVar(&vers, "version", false, "Print version and exit")
 	flag.BoolVar(&args.History, "history", false, "Include Password History")
+	flag.StringVar(&args.SyskeyPassword, "syskey-password", "", "Syskey startup password, for old systems using SecureBoot mode 2")
+	flag.BoolVar(&args.Deleted, "deleted", false, "Include deleted accounts recovered from unallocated space in the SAM hive")
+	flag.StringVar(&args.Format, "format", "", "Output format: secretsdump (default), json (one object per line), records (every ntds record with all its columns, as one JSON array in -out), hashcat or john. hashcat and john write NT, LM, DCC2 and Kerberos AES hashes to separate files, labelled with the mode/format that cracks them")
+	flag.BoolVar(&args.KeepEmpty, "keep-empty", false, "With -format hashcat or john, include the hashes of empty passwords")
+	flag.BoolVar(&args.Dedup, "dedup", false, "With -format hashcat or john, only output each hash once, for the first account that has it")
+	flag.StringVar(&args.SyskeyFile, "syskey-file", "", "Location of the StartupKey.Key file, for old systems using SecureBoot mode 3")
+	flag.UintVar(&args.ControlSet, "controlset", 0, "SYSTEM control set to take the bootkey and settings from (eg 2 for ControlSet002), instead of the current one")
 	flag.Parse()
 
 	if vers {
 		os.Exit(0)
 	}
 
-	if args.SystemLoc == "" && (args.NTDSLoc == "" && args.SAMLoc == "") && !args.LiveSAM {
+	if err := args.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// e := cmd.GoSecretsDump(s)
-
-	e := cmd.GoSecretsDumpJSON(args)
+	var e error
+	if args.Format == cmd.FORMAT_RECORDS {
+		e = cmd.GoSecretsDumpJSON(args)
+	} else {
+		e = cmd.GoSecretsDump(args)
+	}
 	if e != nil {
-		panic(e)
+		fmt.Fprintln(os.Stderr, "Error:", e)
+		os.Exit(1)
 	}
 }
 
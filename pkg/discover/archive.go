@@ -0,0 +1,216 @@
+package discover
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive magic
+const (
+	ZIP_MAGIC  = "PK\x03\x04"
+	GZIP_MAGIC = "\x1f\x8b"
+	ZSTD_MAGIC = "\x28\xb5\x2f\xfd"
+	TAR_MAGIC  = "ustar" //at 257
+)
+
+// Open opens a directory, or a zip or tar archive (optionally gzip or zstd compressed), as an fs.FS. Archives are
+// recognised by their contents rather than their extension. Tar archives are read into memory. The returned closer
+// must be called when finished with the FS.
+func Open(name string) (fs.FS, io.Closer, error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.IsDir() {
+		return os.DirFS(name), noClose{}, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%w: %s is too short to be an archive", ErrUnknownArchive, name)
+	}
+	if string(magic) == ZIP_MAGIC {
+		f.Close()
+		z, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return z, z, nil
+	}
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	var r io.Reader = bufio.NewReader(f)
+	switch {
+	case strings.HasPrefix(string(magic), GZIP_MAGIC):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case string(magic) == ZSTD_MAGIC:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	m, err := readTar(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	return m, noClose{}, nil
+}
+
+type noClose struct{}
+
+func (noClose) Close() error { return nil }
+
+// readTar reads a whole tar archive into memory
+func readTar(r io.Reader) (memFS, error) {
+	br := bufio.NewReader(r)
+	if hdr, err := br.Peek(262); err != nil || string(hdr[257:262]) != TAR_MAGIC {
+		return nil, ErrUnknownArchive
+	}
+	m := memFS{".": &memFile{name: ".", dir: true}}
+	tr := tar.NewReader(br)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(strings.ReplaceAll(h.Name, "\\", "/"), "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			m.add(name, &memFile{name: name, dir: true, modTime: h.ModTime})
+		case tar.TypeReg:
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			m.add(name, &memFile{name: name, data: b, modTime: h.ModTime})
+		}
+	}
+}
+
+// memFS is an archive that has been read into memory, keyed by path
+type memFS map[string]*memFile
+
+type memFile struct {
+	name     string
+	dir      bool
+	data     []byte
+	modTime  time.Time
+	children []string
+}
+
+// add puts f in the FS, creating any parent directories the archive didn't have entries for
+func (m memFS) add(name string, f *memFile) {
+	if old, ok := m[name]; ok {
+		if old.dir && f.dir {
+			return
+		}
+		f.children = old.children
+	} else {
+		parent := path.Dir(name)
+		if _, ok := m[parent]; !ok {
+			m.add(parent, &memFile{name: parent, dir: true})
+		}
+		m[parent].children = append(m[parent].children, path.Base(name))
+	}
+	m[name] = f
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.dir {
+		names := append([]string{}, f.children...)
+		sort.Strings(names)
+		entries := []fs.DirEntry{}
+		for _, n := range names {
+			entries = append(entries, fs.FileInfoToDirEntry(memInfo{m[path.Join(name, n)]}))
+		}
+		return &memDir{memInfo{f}, entries}, nil
+	}
+	return &memReader{memInfo{f}, bytes.NewReader(f.data)}, nil
+}
+
+type memInfo struct{ f *memFile }
+
+func (i memInfo) Name() string       { return path.Base(i.f.name) }
+func (i memInfo) Size() int64        { return int64(len(i.f.data)) }
+func (i memInfo) ModTime() time.Time { return i.f.modTime }
+func (i memInfo) IsDir() bool        { return i.f.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.f.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type memReader struct {
+	info memInfo
+	*bytes.Reader
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+type memDir struct {
+	info    memInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.f.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	r := d.entries
+	if n > 0 && len(r) > n {
+		r = r[:n]
+	}
+	d.entries = d.entries[len(r):]
+	if n > 0 && len(r) == 0 {
+		return r, io.EOF
+	}
+	return r, nil
+}
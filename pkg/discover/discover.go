@@ -0,0 +1,196 @@
+package discover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+/*
+Files are identified by their contents, so it doesn't matter what a backup tool called them or where it put them.
+
+Hives start with "regf", with the file type at 0x1c (0 for a hive, anything else is a transaction log). Which hive
+it is comes from its keys: SYSTEM has \Select, SAM has \SAM\Domains\Account and so on.
+
+ESE databases have 0x89abcdef at 4, and the file type at 0x0c (0 for a database, 1 for a streaming file). The catalog
+near the start of ntds.dit names its tables, so "datatable" shows up in the first few pages.
+*/
+
+// Kinds of file that can be dumped
+const (
+	KIND_NTDS     = "ntds"
+	KIND_SYSTEM   = "system"
+	KIND_SAM      = "sam"
+	KIND_SECURITY = "security"
+	KIND_SOFTWARE = "software"
+
+	HIVE_MAGIC = "regf"
+	ESE_MAGIC  = "\xef\xcd\xab\x89" //at 4
+
+	//how much of a database to look through for the datatable
+	eseProbeSize = 1 << 20
+)
+
+// hiveKinds is the key that identifies each hive
+var hiveKinds = []struct {
+	kind, key string
+}{
+	{KIND_SYSTEM, "\\Select"},
+	{KIND_SAM, "\\SAM\\Domains\\Account"},
+	{KIND_SECURITY, "\\Policy"},
+	{KIND_SOFTWARE, "\\Microsoft\\Windows NT\\CurrentVersion"},
+}
+
+// Found is a file that can be dumped
+type Found struct {
+	Path string
+	Kind string
+}
+
+// Scan walks fsys looking for ntds.dit and the SYSTEM, SAM, SECURITY and SOFTWARE hives. Files that can't be read,
+// or aren't one of those, are skipped. ErrNothingFound is returned if none of them turn up.
+func Scan(fsys fs.FS) ([]Found, error) {
+	r := []Found{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == "." {
+				return err
+			}
+			//unreadable directory, carry on with the rest
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if kind := identify(fsys, name); kind != "" {
+			r = append(r, Found{Path: name, Kind: kind})
+		}
+		return nil
+	})
+	if err == nil && len(r) == 0 {
+		err = ErrNothingFound
+	}
+	return r, err
+}
+
+// identify works out what kind of file name is, returning "" for anything that isn't useful
+func identify(fsys fs.FS, name string) string {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	hdr := make([]byte, 0x20)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return ""
+	}
+	switch {
+	case string(hdr[:4]) == HIVE_MAGIC && binary.LittleEndian.Uint32(hdr[0x1c:]) == 0:
+		rest, err := io.ReadAll(f)
+		if err != nil {
+			return ""
+		}
+		return hiveKind(append(hdr, rest...))
+	case string(hdr[4:8]) == ESE_MAGIC && binary.LittleEndian.Uint32(hdr[0x0c:]) == 0:
+		probe := make([]byte, eseProbeSize)
+		n, _ := io.ReadFull(f, probe)
+		if bytes.Contains(probe[:n], []byte("datatable")) {
+			return KIND_NTDS
+		}
+	}
+	return ""
+}
+
+// hiveKind works out which hive data is from its keys
+func hiveKind(data []byte) string {
+	reg, err := winregistry.InitReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+	for _, k := range hiveKinds {
+		if _, err := reg.KeyInfo(k.key); err == nil {
+			return k.kind
+		}
+	}
+	return ""
+}
+
+// Set is the files from one machine: a SYSTEM hive and everything that can be dumped with its bootkey. A set from a
+// collection without any SYSTEM hives only has SOFTWARE hives, which don't need one.
+type Set struct {
+	System   string
+	NTDS     []string
+	SAM      []string
+	Security []string
+	Software []string
+}
+
+// Pair groups found files by machine. Each file goes with the SYSTEM hive nearest to it in the directory tree (so
+// ntdsutil IFM output, with Active Directory\ntds.dit next to registry\SYSTEM, pairs up, as do several machines'
+// worth of files in their own directories). Files that can't be paired with a SYSTEM hive are left out, apart from
+// SOFTWARE hives.
+func Pair(found []Found) []Set {
+	sets := []*Set{}
+	for _, f := range found {
+		if f.Kind == KIND_SYSTEM {
+			sets = append(sets, &Set{System: f.Path})
+		}
+	}
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].System < sets[j].System })
+	var orphans *Set
+	for _, f := range found {
+		if f.Kind == KIND_SYSTEM {
+			continue
+		}
+		var best *Set
+		bestScore := -1
+		for _, s := range sets {
+			if score := commonDirs(f.Path, s.System); score > bestScore {
+				best, bestScore = s, score
+			}
+		}
+		if best == nil {
+			if f.Kind != KIND_SOFTWARE {
+				continue
+			}
+			if orphans == nil {
+				orphans = &Set{}
+			}
+			best = orphans
+		}
+		switch f.Kind {
+		case KIND_NTDS:
+			best.NTDS = append(best.NTDS, f.Path)
+		case KIND_SAM:
+			best.SAM = append(best.SAM, f.Path)
+		case KIND_SECURITY:
+			best.Security = append(best.Security, f.Path)
+		case KIND_SOFTWARE:
+			best.Software = append(best.Software, f.Path)
+		}
+	}
+	r := []Set{}
+	for _, s := range sets {
+		r = append(r, *s)
+	}
+	if orphans != nil {
+		r = append(r, *orphans)
+	}
+	return r
+}
+
+// commonDirs counts the directories two paths have in common, from the root
+func commonDirs(a, b string) int {
+	da, db := strings.Split(path.Dir(a), "/"), strings.Split(path.Dir(b), "/")
+	n := 0
+	for n < len(da) && n < len(db) && strings.EqualFold(da[n], db[n]) && da[n] != "." {
+		n++
+	}
+	return n
+}
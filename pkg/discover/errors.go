@@ -0,0 +1,10 @@
+package discover
+
+import "errors"
+
+var (
+	// ErrUnknownArchive is returned when a file passed to Open isn't a zip or tar archive
+	ErrUnknownArchive = errors.New("not a zip or tar archive")
+	// ErrNothingFound is returned when a scan doesn't turn up anything that can be dumped
+	ErrNothingFound = errors.New("no dumpable files found")
+)
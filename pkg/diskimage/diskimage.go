@@ -0,0 +1,163 @@
+package diskimage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Image formats
+const (
+	FORMAT_RAW  = "raw"
+	FORMAT_VHD  = "vhd"
+	FORMAT_VHDX = "vhdx"
+)
+
+// Disk is a disk image, read as if it were the raw disk. The container format (raw, VHD or VHDX) is worked out
+// from the image itself, so the file extension doesn't matter.
+type Disk struct {
+	ra         io.ReaderAt
+	size       int64
+	format     string
+	sectorSize int64
+	//Dirty is set for VHDX images with a log that hasn't been replayed, recent writes may be missing
+	Dirty  bool
+	closer io.Closer
+}
+
+// Open opens the disk image at path
+func Open(path string) (*Disk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d, err := NewReader(f, st.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d.closer = f
+	return d, nil
+}
+
+// NewReader reads a disk image of size bytes from r
+func NewReader(r io.ReaderAt, size int64) (*Disk, error) {
+	d := &Disk{ra: r, size: size, format: FORMAT_RAW, sectorSize: 512}
+	magic := make([]byte, 8)
+	if size >= 8 {
+		if _, err := r.ReadAt(magic, 0); err != nil {
+			return nil, err
+		}
+	}
+	if string(magic) == VHDX_SIGNATURE {
+		return d, d.openVHDX(size)
+	}
+	//VHDs have their footer at the end, dynamic ones also have a copy at the start
+	if size >= VHD_FOOTER_SIZE {
+		footer := make([]byte, VHD_FOOTER_SIZE)
+		if _, err := r.ReadAt(footer, size-VHD_FOOTER_SIZE); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(footer, []byte(VHD_COOKIE)) {
+			return d, d.openVHD(footer, size)
+		}
+	}
+	return d, nil
+}
+
+// ReadAt reads from the virtual disk. Unallocated areas of sparse images read as zeros.
+func (d *Disk) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	short := false
+	if int64(len(p)) > d.size-off {
+		p = p[:d.size-off]
+		short = true
+	}
+	n, err := d.ra.ReadAt(p, off)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	if err == nil && short {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Size is the size of the virtual disk (not of the image file)
+func (d *Disk) Size() int64 {
+	return d.size
+}
+
+// Format is the container format of the image, one of the FORMAT_ constants
+func (d *Disk) Format() string {
+	return d.format
+}
+
+// SectorSize is the logical sector size of the disk, used for partition table addresses
+func (d *Disk) SectorSize() int64 {
+	return d.sectorSize
+}
+
+// Close closes the image file, if the Disk was created with Open
+func (d *Disk) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	return d.closer.Close()
+}
+
+// readFull reads len(p) bytes at off, treating a short read as a corrupt image
+func readFull(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return fmt.Errorf("%w: short read of %d bytes at 0x%x", ErrCorruptImage, len(p), off)
+	}
+	return err
+}
+
+// blockReader reads through a block allocation table. locate returns the file offset of the block holding off, or
+// -1 if the block isn't allocated (reads as zeros).
+type blockReader struct {
+	r         io.ReaderAt
+	blockSize int64
+	locate    func(block int64) (int64, error)
+}
+
+func (b blockReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		block := (off + int64(n)) / b.blockSize
+		inBlock := (off + int64(n)) % b.blockSize
+		chunk := p[n:]
+		if int64(len(chunk)) > b.blockSize-inBlock {
+			chunk = chunk[:b.blockSize-inBlock]
+		}
+		fileOff, err := b.locate(block)
+		if err != nil {
+			return n, err
+		}
+		if fileOff < 0 {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else if err := readFull(b.r, chunk, fileOff+inBlock); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
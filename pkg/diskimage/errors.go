@@ -0,0 +1,12 @@
+package diskimage
+
+import "errors"
+
+var (
+	// ErrCorruptImage is returned when an image's metadata (footer, headers, allocation table) doesn't parse
+	ErrCorruptImage = errors.New("corrupt disk image")
+	// ErrUnsupported is returned for image features we don't handle, like differencing disks
+	ErrUnsupported = errors.New("unsupported disk image")
+	// ErrNoPartitions is returned when no partition table (or bare volume) can be found on a disk
+	ErrNoPartitions = errors.New("no partitions found")
+)
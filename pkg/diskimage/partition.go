@@ -0,0 +1,200 @@
+package diskimage
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+/*
+MBR: four 16 byte entries at 446, signature 0x55aa at 510. Each entry has the type at 4, first LBA at 8 and sector
+count at 12. Extended partitions (types 0x05, 0x0f and 0x85) hold a chain of EBRs, each with the logical partition
+(relative to the EBR) in the first entry and the next EBR (relative to the extended partition) in the second.
+
+GPT: a protective MBR with a single 0xee partition, then the header at LBA 1: "EFI PART", partition entry LBA at
+0x48, entry count at 0x50 and entry size at 0x54. Entries are the type GUID, unique GUID, first and last LBA,
+attributes and a 36 character UTF-16 name.
+*/
+
+const (
+	MBR_EXTENDED     = 0x05
+	MBR_EXTENDED_LBA = 0x0f
+	MBR_EXTENDED_LNX = 0x85
+	MBR_GPT          = 0xee
+
+	NTFS_OEM_ID = "NTFS    "
+
+	maxPartitions = 256
+)
+
+// Partition is a single partition on a disk. It reads the partition as if it were its own volume.
+type Partition struct {
+	Index int   //1 based, in table order (logical MBR partitions come after the primary ones)
+	Start int64 //byte offset on the disk
+	Size  int64
+	//MBR type byte (eg 0x07), or the GPT type GUID
+	Type string
+	//GPT partition name
+	Name string
+	*io.SectionReader
+}
+
+// IsNTFS returns true if the partition starts with an NTFS boot sector
+func (p Partition) IsNTFS() bool {
+	b := make([]byte, 11)
+	if _, err := p.ReadAt(b, 0); err != nil {
+		return false
+	}
+	return string(b[3:]) == NTFS_OEM_ID
+}
+
+// Partitions reads the partition table of the disk. A disk that is a bare volume (no partition table, just a boot
+// sector at the start) is returned as a single partition covering the whole disk.
+func (d *Disk) Partitions() ([]Partition, error) {
+	mbr := make([]byte, 512)
+	if err := readFull(d, mbr, 0); err != nil {
+		return nil, err
+	}
+	whole := []Partition{d.partition(1, 0, d.size, "volume", "")}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, fmt.Errorf("%w: no MBR signature", ErrNoPartitions)
+	}
+	//a volume boot sector has the same signature, so check for a filesystem before trusting the entries
+	if whole[0].IsNTFS() {
+		return whole, nil
+	}
+	r := []Partition{}
+	extended := []int64{}
+	for i := 0; i < 4; i++ {
+		e := mbr[446+i*16:]
+		typ := e[4]
+		start, count := int64(binary.LittleEndian.Uint32(e[8:])), int64(binary.LittleEndian.Uint32(e[12:]))
+		switch {
+		case typ == 0 || count == 0:
+			continue
+		case typ == MBR_GPT:
+			return d.gptPartitions()
+		case typ == MBR_EXTENDED || typ == MBR_EXTENDED_LBA || typ == MBR_EXTENDED_LNX:
+			extended = append(extended, start)
+			continue
+		}
+		r = append(r, d.partition(len(r)+1, start*d.sectorSize, count*d.sectorSize, fmt.Sprintf("0x%02x", typ), ""))
+	}
+	for _, ext := range extended {
+		logical, err := d.ebrPartitions(ext, len(r))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, logical...)
+	}
+	if len(r) == 0 {
+		return nil, ErrNoPartitions
+	}
+	return r, nil
+}
+
+// NTFSPartitions returns the partitions that hold NTFS volumes
+func (d *Disk) NTFSPartitions() ([]Partition, error) {
+	parts, err := d.Partitions()
+	if err != nil {
+		return nil, err
+	}
+	r := []Partition{}
+	for _, p := range parts {
+		if p.IsNTFS() {
+			r = append(r, p)
+		}
+	}
+	return r, nil
+}
+
+func (d *Disk) partition(index int, start, size int64, typ, name string) Partition {
+	if start > d.size {
+		start = d.size
+	}
+	if start+size > d.size {
+		size = d.size - start
+	}
+	return Partition{Index: index, Start: start, Size: size, Type: typ, Name: name, SectionReader: io.NewSectionReader(d, start, size)}
+}
+
+// ebrPartitions follows the EBR chain of the extended partition starting at LBA ext
+func (d *Disk) ebrPartitions(ext int64, count int) ([]Partition, error) {
+	r := []Partition{}
+	ebr := make([]byte, 512)
+	for next := int64(0); len(r) < maxPartitions; {
+		lba := ext + next
+		if err := readFull(d, ebr, lba*d.sectorSize); err != nil {
+			return nil, err
+		}
+		if ebr[510] != 0x55 || ebr[511] != 0xaa {
+			return nil, fmt.Errorf("%w: bad EBR signature at LBA %d", ErrCorruptImage, lba)
+		}
+		if typ, start, n := ebr[446+4], int64(binary.LittleEndian.Uint32(ebr[446+8:])), int64(binary.LittleEndian.Uint32(ebr[446+12:])); typ != 0 && n != 0 {
+			r = append(r, d.partition(count+len(r)+1, (lba+start)*d.sectorSize, n*d.sectorSize, fmt.Sprintf("0x%02x", typ), ""))
+		}
+		link := ebr[446+16:]
+		if link[4] == 0 {
+			break
+		}
+		n := int64(binary.LittleEndian.Uint32(link[8:]))
+		if n <= next {
+			return nil, fmt.Errorf("%w: EBR chain loops at LBA %d", ErrCorruptImage, lba)
+		}
+		next = n
+	}
+	return r, nil
+}
+
+func (d *Disk) gptPartitions() ([]Partition, error) {
+	hdr := make([]byte, 92)
+	if err := readFull(d, hdr, d.sectorSize); err != nil {
+		return nil, err
+	}
+	if string(hdr[:8]) != "EFI PART" {
+		return nil, fmt.Errorf("%w: protective MBR without a GPT header", ErrCorruptImage)
+	}
+	entryLBA := int64(binary.LittleEndian.Uint64(hdr[0x48:]))
+	count := int64(binary.LittleEndian.Uint32(hdr[0x50:]))
+	size := int64(binary.LittleEndian.Uint32(hdr[0x54:]))
+	//entries are 128 bytes in practice, the cap just stops a corrupt header asking for gigabytes
+	if size < 128 || size > 4096 || count > 1024 {
+		return nil, fmt.Errorf("%w: bad GPT entry size %d or count %d", ErrCorruptImage, size, count)
+	}
+	entries := make([]byte, count*size)
+	if err := readFull(d, entries, entryLBA*d.sectorSize); err != nil {
+		return nil, err
+	}
+	r := []Partition{}
+	for i := int64(0); i < count; i++ {
+		e := entries[i*size:]
+		typ := e[:16]
+		if string(typ) == string(make([]byte, 16)) {
+			continue
+		}
+		first, last := int64(binary.LittleEndian.Uint64(e[32:])), int64(binary.LittleEndian.Uint64(e[40:]))
+		if last < first {
+			return nil, fmt.Errorf("%w: GPT entry %d ends before it starts", ErrCorruptImage, i)
+		}
+		name := make([]uint16, 36)
+		for j := range name {
+			name[j] = binary.LittleEndian.Uint16(e[56+j*2:])
+		}
+		r = append(r, d.partition(len(r)+1, first*d.sectorSize, (last-first+1)*d.sectorSize, guidString(typ), strings.TrimRight(string(utf16.Decode(name)), "\x00")))
+	}
+	if len(r) == 0 {
+		return nil, ErrNoPartitions
+	}
+	return r, nil
+}
+
+// guidString formats an on disk GUID
+func guidString(b []byte) string {
+	g := append([]byte{}, b[:16]...)
+	swapGUID(g)
+	h := strings.ToUpper(hex.EncodeToString(g))
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
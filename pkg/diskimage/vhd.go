@@ -0,0 +1,92 @@
+package diskimage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+VHD (Virtual Hard Disk v1). Everything is big endian.
+
+Footer, the last 512 bytes of the file (dynamic disks also have a copy at the start):
+
+	0  Cookie "conectix"
+	16 DataOffset uint64 (dynamic header offset, 0xffffffffffffffff for fixed disks)
+	48 CurrentSize uint64
+	60 DiskType uint32
+
+Dynamic disk header, at DataOffset:
+
+	0  Cookie "cxsparse"
+	16 TableOffset uint64 (block allocation table)
+	28 MaxTableEntries uint32
+	32 BlockSize uint32
+
+The BAT is a list of uint32 sector numbers, 0xffffffff for blocks that haven't been written. Each block starts with
+a sector bitmap (rounded up to whole sectors), followed by the block's data.
+*/
+
+const (
+	VHD_COOKIE         = "conectix"
+	VHD_DYNAMIC_COOKIE = "cxsparse"
+	VHD_FOOTER_SIZE    = 512
+
+	VHD_FIXED        = 2
+	VHD_DYNAMIC      = 3
+	VHD_DIFFERENCING = 4
+
+	vhdUnallocated = 0xffffffff
+)
+
+func (d *Disk) openVHD(footer []byte, fileSize int64) error {
+	d.format = FORMAT_VHD
+	d.size = int64(binary.BigEndian.Uint64(footer[48:]))
+	switch diskType := binary.BigEndian.Uint32(footer[60:]); diskType {
+	case VHD_FIXED:
+		//the data is the start of the file, the footer is after it
+		if d.size > fileSize-VHD_FOOTER_SIZE {
+			return fmt.Errorf("%w: fixed VHD of %d bytes in a %d byte file", ErrCorruptImage, d.size, fileSize)
+		}
+		return nil
+	case VHD_DYNAMIC:
+	case VHD_DIFFERENCING:
+		return fmt.Errorf("%w: differencing VHD, open the parent disk instead", ErrUnsupported)
+	default:
+		return fmt.Errorf("%w: unknown VHD disk type %d", ErrCorruptImage, diskType)
+	}
+
+	hdr := make([]byte, 1024)
+	if err := readFull(d.ra, hdr, int64(binary.BigEndian.Uint64(footer[16:]))); err != nil {
+		return err
+	}
+	if string(hdr[:8]) != VHD_DYNAMIC_COOKIE {
+		return fmt.Errorf("%w: bad VHD dynamic header cookie %q", ErrCorruptImage, hdr[:8])
+	}
+	blockSize := int64(binary.BigEndian.Uint32(hdr[32:]))
+	entries := int64(binary.BigEndian.Uint32(hdr[28:]))
+	if blockSize == 0 || blockSize%512 != 0 || blockSize > 1<<30 || entries*4 > fileSize || entries*blockSize < d.size {
+		return fmt.Errorf("%w: bad VHD block size %d for %d entries", ErrCorruptImage, blockSize, entries)
+	}
+	bat := make([]byte, entries*4)
+	if err := readFull(d.ra, bat, int64(binary.BigEndian.Uint64(hdr[16:]))); err != nil {
+		return err
+	}
+	//one bit per sector, padded out to a whole sector
+	bitmapSize := (blockSize/512/8 + 511) / 512 * 512
+
+	d.ra = blockReader{
+		r:         d.ra,
+		blockSize: blockSize,
+		locate: func(block int64) (int64, error) {
+			if block >= entries {
+				return 0, fmt.Errorf("%w: block %d past the end of the VHD BAT", ErrCorruptImage, block)
+			}
+			sector := binary.BigEndian.Uint32(bat[block*4:])
+			if sector == vhdUnallocated {
+				return -1, nil
+			}
+			return int64(sector)*512 + bitmapSize, nil
+		},
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+/*
+VHDX. Everything is little endian, GUIDs are in the usual mixed endian layout.
+
+	0     File type identifier "vhdxfile"
+	64K   Header 1, 128K Header 2 (the one with the highest SequenceNumber and a good checksum wins)
+	192K  Region table 1, 256K Region table 2 (copies)
+
+Header: Signature "head", Checksum uint32 (CRC-32C of the 4K header with the checksum zeroed), SequenceNumber uint64,
+FileWriteGuid, DataWriteGuid, LogGuid (zero when there's nothing in the log to replay), then versions and the log
+location.
+
+Region table: Signature "regi", Checksum (over 64K), EntryCount uint32, then 32 byte entries of Guid, FileOffset
+uint64, Length uint32, Required uint32. The two regions we need are the BAT and the metadata.
+
+Metadata region: Signature "metadata", EntryCount uint16 at 10, then 32 byte entries from offset 32 of ItemId,
+Offset uint32 (from the start of the region), Length uint32.
+
+BAT entries are uint64: the low 3 bits are the block state, bits 20+ are the file offset in MB. After every
+ChunkRatio payload entries there's a sector bitmap entry (only used by differencing disks).
+*/
+
+const (
+	VHDX_SIGNATURE = "vhdxfile"
+
+	vhdxHeader1      = 64 * 1024
+	vhdxHeader2      = 128 * 1024
+	vhdxHeaderSize   = 4 * 1024
+	vhdxRegionTable1 = 192 * 1024
+	vhdxRegionSize   = 64 * 1024
+
+	VHDX_BLOCK_NOT_PRESENT     = 0
+	VHDX_BLOCK_UNDEFINED       = 1
+	VHDX_BLOCK_ZERO            = 2
+	VHDX_BLOCK_UNMAPPED        = 3
+	VHDX_BLOCK_FULLY_PRESENT   = 6
+	VHDX_BLOCK_PARTIAL_PRESENT = 7
+)
+
+var (
+	vhdxBATRegion      = guid("2DC27766-F623-4200-9D64-115E9BFD4A08")
+	vhdxMetadataRegion = guid("8B7CA206-4790-4B9A-B8FE-575F050F886E")
+	vhdxFileParameters = guid("CAA16737-FA36-4D43-B3B6-33F0AA44E76B")
+	vhdxVirtualSize    = guid("2FA54224-CD1B-4876-B211-5DBED83BF4B8")
+	vhdxLogicalSector  = guid("8141BF1D-A96F-4709-BA47-F233A8FAAB5F")
+)
+
+// guid converts a GUID string to its on disk form (the first three groups are little endian)
+func guid(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		panic("bad guid " + s)
+	}
+	swapGUID(b)
+	return b
+}
+
+// swapGUID switches the first three groups of a GUID between big and little endian
+func swapGUID(b []byte) {
+	for _, r := range [][2]int{{0, 4}, {4, 6}, {6, 8}} {
+		for i, j := r[0], r[1]-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// vhdxChecksum checks the CRC-32C at offset 4 of a header or region table
+func vhdxChecksum(b []byte) bool {
+	want := binary.LittleEndian.Uint32(b[4:])
+	c := append([]byte{}, b...)
+	binary.LittleEndian.PutUint32(c[4:], 0)
+	return crc32.Checksum(c, castagnoli) == want
+}
+
+func (d *Disk) openVHDX(fileSize int64) error {
+	d.format = FORMAT_VHDX
+
+	//current header
+	var hdr []byte
+	var seq uint64
+	for _, off := range []int64{vhdxHeader1, vhdxHeader2} {
+		h := make([]byte, vhdxHeaderSize)
+		if err := readFull(d.ra, h, off); err != nil {
+			return err
+		}
+		if string(h[:4]) != "head" || !vhdxChecksum(h) {
+			continue
+		}
+		if s := binary.LittleEndian.Uint64(h[8:]); hdr == nil || s > seq {
+			hdr, seq = h, s
+		}
+	}
+	if hdr == nil {
+		return fmt.Errorf("%w: no valid VHDX header", ErrCorruptImage)
+	}
+	d.Dirty = !bytes.Equal(hdr[48:64], make([]byte, 16))
+
+	//region table
+	var regions map[string][2]int64
+	for _, off := range []int64{vhdxRegionTable1, vhdxRegionTable1 + vhdxRegionSize} {
+		rt := make([]byte, vhdxRegionSize)
+		if err := readFull(d.ra, rt, off); err != nil {
+			return err
+		}
+		if string(rt[:4]) != "regi" || !vhdxChecksum(rt) {
+			continue
+		}
+		count := int(binary.LittleEndian.Uint32(rt[8:]))
+		if 16+count*32 > len(rt) {
+			continue
+		}
+		regions = map[string][2]int64{}
+		for i := 0; i < count; i++ {
+			e := rt[16+i*32:]
+			regions[string(e[:16])] = [2]int64{int64(binary.LittleEndian.Uint64(e[16:])), int64(binary.LittleEndian.Uint32(e[24:]))}
+		}
+		break
+	}
+	batRegion, okBAT := regions[string(vhdxBATRegion)]
+	mdRegion, okMD := regions[string(vhdxMetadataRegion)]
+	if !okBAT || !okMD {
+		return fmt.Errorf("%w: VHDX region table missing the BAT or metadata", ErrCorruptImage)
+	}
+
+	//metadata
+	if mdRegion[1] < 32 || mdRegion[0]+mdRegion[1] > fileSize {
+		return fmt.Errorf("%w: bad VHDX metadata region", ErrCorruptImage)
+	}
+	md := make([]byte, mdRegion[1])
+	if err := readFull(d.ra, md, mdRegion[0]); err != nil {
+		return err
+	}
+	if string(md[:8]) != "metadata" {
+		return fmt.Errorf("%w: bad VHDX metadata signature %q", ErrCorruptImage, md[:8])
+	}
+	items := map[string][]byte{}
+	for i := 0; i < int(binary.LittleEndian.Uint16(md[10:])) && 32+i*32+32 <= len(md); i++ {
+		e := md[32+i*32:]
+		off, length := int64(binary.LittleEndian.Uint32(e[16:])), int64(binary.LittleEndian.Uint32(e[20:]))
+		if off+length > int64(len(md)) {
+			return fmt.Errorf("%w: VHDX metadata item outside the region", ErrCorruptImage)
+		}
+		items[string(e[:16])] = md[off : off+length]
+	}
+	params, size, sector := items[string(vhdxFileParameters)], items[string(vhdxVirtualSize)], items[string(vhdxLogicalSector)]
+	if len(params) < 8 || len(size) < 8 || len(sector) < 4 {
+		return fmt.Errorf("%w: VHDX metadata missing required items", ErrCorruptImage)
+	}
+	blockSize := int64(binary.LittleEndian.Uint32(params))
+	if binary.LittleEndian.Uint32(params[4:])&2 != 0 {
+		return fmt.Errorf("%w: differencing VHDX, open the parent disk instead", ErrUnsupported)
+	}
+	d.size = int64(binary.LittleEndian.Uint64(size))
+	d.sectorSize = int64(binary.LittleEndian.Uint32(sector))
+	if blockSize < 1<<20 || blockSize > 256<<20 || (d.sectorSize != 512 && d.sectorSize != 4096) {
+		return fmt.Errorf("%w: bad VHDX block size %d or sector size %d", ErrCorruptImage, blockSize, d.sectorSize)
+	}
+
+	//BAT
+	chunkRatio := (int64(1) << 23) * d.sectorSize / blockSize
+	blocks := (d.size + blockSize - 1) / blockSize
+	entries := blocks + (blocks-1)/chunkRatio
+	if batRegion[1] < entries*8 || batRegion[0]+batRegion[1] > fileSize {
+		return fmt.Errorf("%w: VHDX BAT too small for %d blocks", ErrCorruptImage, blocks)
+	}
+	bat := make([]byte, entries*8)
+	if err := readFull(d.ra, bat, batRegion[0]); err != nil {
+		return err
+	}
+	d.ra = blockReader{
+		r:         d.ra,
+		blockSize: blockSize,
+		locate: func(block int64) (int64, error) {
+			if block >= blocks {
+				return 0, fmt.Errorf("%w: block %d past the end of the VHDX", ErrCorruptImage, block)
+			}
+			e := binary.LittleEndian.Uint64(bat[(block+block/chunkRatio)*8:])
+			switch e & 7 {
+			case VHDX_BLOCK_FULLY_PRESENT:
+				return int64(e>>20) << 20, nil
+			case VHDX_BLOCK_PARTIAL_PRESENT:
+				return 0, fmt.Errorf("%w: partially present block in VHDX", ErrUnsupported)
+			}
+			//not present, zero, unmapped and undefined all read as zeros
+			return -1, nil
+		},
+	}
+	return nil
+}
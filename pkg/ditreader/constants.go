@@ -12,7 +12,10 @@ const (
 	nlogonCount              = "ATTj589993"
 	nsAMAccountName          = "ATTm590045"
 	nsAMAccountType          = "ATTj590126"
-	nlastLogonTimestamp      = "ATTq589876"
+	nlastLogon               = "ATTq589876"
+	nlastLogonTimestamp      = "ATTq591520"
+	nbadPwdCount             = "ATTj589836"
+	nbadPasswordTime         = "ATTq589873"
 	nuserPrincipalName       = "ATTm590480"
 	nunicodePwd              = "ATTk589914"
 	ndBCSPwd                 = "ATTk589879"
@@ -21,6 +24,10 @@ const (
 	npekList                 = "ATTk590689"
 	nsupplementalCredentials = "ATTk589949"
 	npwdLastSet              = "ATTq589920"
+	nlockoutTime             = "ATTq590486"
+	nreplPropertyMetaData    = "ATTk589827"
+	nuacComputed             = "ATTj591284" //msDS-User-Account-Control-Computed
+	nobjectClass             = "ATTc0"
 )
 
 var kerbkeytype = map[uint32]string{
@@ -39,7 +46,10 @@ var nnToInternal = map[string]string{
 	"logonCount":              "ATTj589993",
 	"sAMAccountName":          "ATTm590045",
 	"sAMAccountType":          "ATTj590126",
-	"lastLogonTimestamp":      "ATTq589876",
+	"lastLogon":               "ATTq589876",
+	"lastLogonTimestamp":      "ATTq591520",
+	"badPwdCount":             "ATTj589836",
+	"badPasswordTime":         "ATTq589873",
 	"userPrincipalName":       "ATTm590480",
 	"unicodePwd":              "ATTk589914",
 	"dBCSPwd":                 "ATTk589879",
@@ -48,12 +58,17 @@ var nnToInternal = map[string]string{
 	"pekList":                 "ATTk590689",
 	"supplementalCredentials": "ATTk589949",
 	"pwdLastSet":              "ATTq589920",
+	"lockoutTime":             "ATTq590486",
+	"replPropertyMetaData":    "ATTk589827",
+	"objectClass":             "ATTc0",
+
+	"msDS-User-Account-Control-Computed": "ATTj591284",
 }
 
 var accTypes = map[int32]string{
-	0x30000000: "SAM_NORMAL_USER_ACCOUNT",
-	0x30000001: "SAM_MACHINE_ACCOUNT",
-	0x30000002: "SAM_TRUST_ACCOUNT",
+	SAM_NORMAL_USER_ACCOUNT: "SAM_NORMAL_USER_ACCOUNT",
+	SAM_MACHINE_ACCOUNT:     "SAM_MACHINE_ACCOUNT",
+	SAM_TRUST_ACCOUNT:       "SAM_TRUST_ACCOUNT",
 }
 
 var EmptyNT = []byte{0x31, 0xd6, 0xcf, 0xe0, 0xd1, 0x6a, 0xe9, 0x31, 0xb7, 0x3c, 0x59, 0xd7, 0xe0, 0xc0, 0x89, 0xc0}
@@ -4,15 +4,13 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des"
-	"crypto/md5"
-	"crypto/rc4"
 	"encoding/binary"
 	"fmt"
 )
 
 func RemoveDES(b []byte, rid uint32) ([]byte, error) {
-	if len(b) < 8 {
-		return nil, fmt.Errorf("Des ciphertext not long enough. Expected x>8, got x=%d", len(b))
+	if len(b) < 16 {
+		return nil, fmt.Errorf("%w: des ciphertext not long enough. Expected x>=16, got x=%d", ErrBadHash, len(b))
 	}
 	// //ridI, err := strconv.Atoi(rid)
 	// if err != nil {
@@ -47,10 +45,11 @@ func DeriveKey(baseKey uint32) (k1, k2 []byte) {
 		key[3], key[0], key[1],
 	}
 
-	return transformKey(key1), transformKey(key2)
+	return TransformKey(key1), TransformKey(key2)
 }
 
-func transformKey(inKey []byte) []byte {
+// TransformKey expands a 7 byte key into an 8 byte DES key (adding the parity bits)
+func TransformKey(inKey []byte) []byte {
 	outKey := []byte{}
 	outKey = append(outKey, inKey[0]>>0x01)
 	outKey = append(outKey, ((inKey[0]&0x01)<<6)|inKey[1]>>2)
@@ -66,21 +65,10 @@ func transformKey(inKey []byte) []byte {
 	return outKey
 }
 
-func (d DitReader) removeRC4(c CryptedHash) ([]byte, error) {
-	tmpKey := md5.Sum(append(d.pek[int(c.Header[4])], c.KeyMaterial[:]...))
-	lol, err := rc4.NewCipher(tmpKey[:])
-	if err != nil {
-		return nil, err
-	}
-	plain := make([]byte, len(c.EncryptedHash))
-	lol.XORKeyStream(plain, c.EncryptedHash[:])
-	return plain, nil
-}
-
 // NewCryptedHash creates a CryptedHash object containing key material and encrypted content.
 func NewCryptedHash(inData []byte) (CryptedHash, error) {
-	if len(inData) < 16 {
-		return CryptedHash{}, fmt.Errorf("Invalid crypted hash length. Expected x>16, got x=%d", len(inData))
+	if len(inData) < 24 {
+		return CryptedHash{}, fmt.Errorf("%w: invalid crypted hash length. Expected x>=24, got x=%d", ErrBadHash, len(inData))
 	}
 	cursor := 0
 	r := CryptedHash{}
@@ -99,6 +87,9 @@ type CryptedHash struct {
 }
 
 func DecryptAES(key, value, iv []byte) ([]byte, error) {
+	if len(value)%aes.BlockSize != 0 || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("Bad AES input: %d byte ciphertext, %d byte IV", len(value), len(iv))
+	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
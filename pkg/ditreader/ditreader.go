@@ -4,40 +4,77 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/rc4"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"sync"
 
 	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
 
 	"github.com/C-Sto/gosecretsdump/pkg/esent"
 )
 
-// Create a new DitReader
-func New(system, ntds string) (DitReader, error) {
-	r := DitReader{
-		isRemote:           false,
-		history:            false,
-		noLMHash:           true,
-		remoteOps:          "",
-		useVSSMethod:       false,
-		justNTLM:           false,
-		pwdLastSet:         false,
-		resumeSession:      "",
-		outputFileName:     "",
-		justUser:           "",
-		printUserStatus:    false,
-		systemHiveLocation: system,
-		ntdsFileLocation:   ntds,
-		//db:                 esent.Esedb{}.Init(ntds),
-		userData: make(chan DumpedHash, 500),
+// Create a new DitReader. Options are optional, only the first one is used.
+func New(system, ntds string, opts ...Options) (DitReader, error) {
+	db, err := esent.Esedb{}.Init(ntds)
+	if err != nil {
+		return DitReader{}, err
 	}
+	r, err := newDitReader(db, nil, opts)
+	r.systemHiveLocation = system
+	r.ntdsFileLocation = ntds
+	return r, err
+}
 
-	var err error
-	r.db, err = esent.Esedb{}.Init(ntds)
+// NewReader creates a DitReader for a SYSTEM hive and ntds.dit read from io.ReaderAts (of the given sizes). Both are
+// read into memory, so the readers aren't needed after this returns.
+func NewReader(system io.ReaderAt, systemSize int64, ntds io.ReaderAt, ntdsSize int64, opts ...Options) (DitReader, error) {
+	ls, err := systemreader.NewReader(system, systemSize)
 	if err != nil {
-		return r, err
+		return DitReader{}, err
+	}
+	db, err := esent.Esedb{}.InitReader(ntds, ntdsSize)
+	if err != nil {
+		return DitReader{}, err
+	}
+	return newDitReader(db, &ls, opts)
+}
+
+// NewFS creates a DitReader for the SYSTEM hive and ntds.dit called system and ntds in fsys
+func NewFS(fsys fs.FS, system, ntds string, opts ...Options) (DitReader, error) {
+	ls, err := systemreader.NewFS(fsys, system)
+	if err != nil {
+		return DitReader{}, err
+	}
+	db, err := esent.Esedb{}.InitFS(fsys, ntds)
+	if err != nil {
+		return DitReader{}, err
+	}
+	return newDitReader(db, &ls, opts)
+}
+
+// newDitReader sets up a DitReader for an opened database. system may be nil, in which case the SYSTEM hive is
+// opened from systemHiveLocation when the keys are needed.
+func newDitReader(db esent.Esedb, system *systemreader.SystemReader, opts []Options) (DitReader, error) {
+	r := DitReader{
+		isRemote:      false,
+		history:       false,
+		noLMHash:      true,
+		remoteOps:     "",
+		useVSSMethod:  false,
+		resumeSession: "",
+		db:            db,
+		system:        system,
+		userData:      make(chan DumpedHash, 500),
+	}
+
+	if len(opts) > 0 {
+		r.opts = opts[0]
 	}
+
+	var err error
 	r.cursor, err = r.db.OpenTable("datatable")
 	if err != nil {
 		return r, err
@@ -56,15 +93,13 @@ type DitReader struct {
 	remoteOps string
 
 	useVSSMethod       bool
-	justNTLM           bool
-	pwdLastSet         bool
 	resumeSession      string
 	outputFileName     string
 	systemHiveLocation string
 	ntdsFileLocation   string
+	system             *systemreader.SystemReader
 
-	justUser        string
-	printUserStatus bool
+	opts Options
 
 	perSecretCallback bool // nil
 	secret            bool //nil
@@ -73,13 +108,11 @@ type DitReader struct {
 
 	db       esent.Esedb
 	cursor   *esent.Cursor
-	pek      [][]byte
+	pek      PEKList
 	tmpUsers []esent.Esent_record
 
 	//output chans
-	userData    chan DumpedHash
-	decryptWork chan esent.Esent_record
-	cryptwg     *sync.WaitGroup
+	userData chan DumpedHash
 
 	//settings Settings
 }
@@ -93,87 +126,108 @@ func (d DitReader) GetOutChan() <-chan DumpedHash {
 	return d.userData
 }
 
-func (d DitReader) Dump() error {
-	//if local (always local for now)
-	if d.systemHiveLocation != "" {
-		ls, err := systemreader.New(d.systemHiveLocation)
-		if err != nil {
-			return err
-		}
-		d.bootKey = ls.BootKey()
-		if d.ntdsFileLocation != "" {
-			d.noLMHash = ls.HasNoLMHashPolicy()
-		}
-	} else {
-		return fmt.Errorf("System hive empty")
+// Recoveries reports the SYSTEM hive if transaction logs had to be replayed when it was opened
+func (d DitReader) Recoveries() []winregistry.Recovery {
+	if d.system == nil {
+		return nil
 	}
+	return d.system.Recoveries()
+}
 
-	d.getPek()
-	if len(d.pek) < 1 {
-		return fmt.Errorf("NO PEK FOUND THIS IS VERY BAD")
+func (d DitReader) Dump() error {
+	defer close(d.userData)
+	if err := d.loadKeys(); err != nil {
+		return err
 	}
 
+	errs := []error{}
 	for {
 		//read each record from the db
 		record, err := d.db.GetNextRow(d.cursor)
 		if err != nil {
-			if err.Error() == "ignore" {
+			if errors.Is(err, esent.ErrNoMoreRecords) {
 				break //we will get an 'ignore' error when there are no more records
 			}
-			fmt.Println("Couldn't get row due to error: ", err.Error())
+			errs = append(errs, fmt.Errorf("couldn't read row: %w", err))
 			continue
 		}
 
 		//check for the right kind of record
-		v, ook := record.GetLongVal(nsAMAccountType)
-		if ook {
-			if _, ok := accTypes[v]; ok {
-				dh, err := d.DecryptRecord(record)
-				if err != nil {
-					fmt.Println("Coudln't decrypt record:", err.Error())
-					continue
-				}
-				d.userData <- dh
+		if len(d.opts.AccountTypes) == 0 {
+			if v, ok := record.GetLongVal(nsAMAccountType); !ok || accTypes[v] == "" {
+				continue
+			}
+		}
+		if !d.opts.Match(record) {
+			continue
+		}
 
+		dh, err := d.DecryptRecord(record)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't decrypt record: %w", err))
+			if !errors.Is(err, ErrBadSupp) {
+				continue
 			}
 		}
+		d.userData <- dh
 	}
-	close(d.userData)
-	return nil
+	return Incomplete(errs)
 }
 
-func (d *DitReader) decryptWorker() {
-	for {
-		r := <-d.decryptWork
-		//attempt to decrypt the record
-		dh, err := d.DecryptRecord(r)
+// loadKeys reads the bootkey and LM policy out of the system hive and decrypts the PEK list with it
+func (d *DitReader) loadKeys() error {
+	ls := d.system
+	if ls == nil {
+		if d.systemHiveLocation == "" {
+			return fmt.Errorf("System hive empty")
+		}
+		l, err := systemreader.New(d.systemHiveLocation)
 		if err != nil {
-			fmt.Println("Coudln't decrypt record:", err.Error())
-			return
+			return err
 		}
-		d.userData <- dh
-		d.cryptwg.Done()
+		ls = &l
+	}
+	if err := ls.Use(d.opts.System); err != nil {
+		return err
+	}
+	var err error
+	if d.bootKey, err = ls.BootKey(); err != nil {
+		return err
+	}
+	if d.noLMHash, err = ls.HasNoLMHashPolicy(); err != nil {
+		return err
 	}
+
+	if _, err := d.getPek(); err != nil {
+		return err
+	}
+	if len(d.pek) < 1 {
+		return ErrNoPEK
+	}
+	return nil
 }
 
-func (d DitReader) PEK() ([][]byte, error) {
+// PEK returns the decrypted PEK list, in index order
+func (d DitReader) PEK() (PEKList, error) {
 	if len(d.pek) < 1 {
-		return d.getPek()
+		if err := d.loadKeys(); err != nil {
+			return nil, err
+		}
 	}
 
 	return d.pek, nil
 }
 
-func (d *DitReader) getPek() ([][]byte, error) {
+func (d *DitReader) getPek() (PEKList, error) {
 	pekList := []byte{}
 	for {
 		record, err := d.db.GetNextRow(d.cursor)
-		if err != nil && err.Error() != "ignore" {
-			return nil, err
-		}
-		if err != nil && err.Error() == "ignore" {
+		if errors.Is(err, esent.ErrNoMoreRecords) {
 			break //lol fml
 		}
+		if err != nil {
+			return nil, err
+		}
 
 		if v, ok := record.GetBytVal(npekList); ok && len(v) > 0 {
 			//if v, ok := record.Column[pekList"]]; ok && len(v.BytVal) > 0 {
@@ -192,7 +246,7 @@ func (d *DitReader) getPek() ([][]byte, error) {
 		encryptedPekList, err := NewPeklistEnc(pekList)
 		if err != nil {
 			//should probably hard fail here
-			return nil, err
+			return nil, fmt.Errorf("%w: %s", ErrBadPEK, err)
 		}
 		if bytes.Compare(encryptedPekList.Header[:4], []byte{2, 0, 0, 0}) == 0 {
 			//up to windows 2012 r2 something something
@@ -226,12 +280,16 @@ func (d *DitReader) getPek() ([][]byte, error) {
 				# CipherText: PEKLIST_ENC['EncryptedPek']
 				# IV: PEKLIST_ENC['KeyMaterial']
 			*/
+			if len(encryptedPekList.EncryptedPek)%16 != 0 {
+				return nil, fmt.Errorf("%w: encrypted PEK list is not a multiple of the block size (%d bytes)", ErrBadPEK, len(encryptedPekList.EncryptedPek))
+			}
 			ePek, err := DecryptAES(d.bootKey, encryptedPekList.EncryptedPek, encryptedPekList.KeyMaterial[:])
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w: %s", ErrBadPEK, err)
 			}
-			decryptedPekList := NewPeklistPlain(ePek)
-			d.pek = append(d.pek, decryptedPekList.DecryptedPek[4:20])
+			d.pek = append(d.pek, NewPeklistPlain(ePek).AESKeys()...)
+		} else {
+			return nil, fmt.Errorf("%w: unknown PEK list header %x", ErrBadPEK, encryptedPekList.Header[:4])
 		}
 	}
 	return d.pek, nil
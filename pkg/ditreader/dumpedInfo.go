@@ -2,22 +2,13 @@ package ditreader
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 	u "unicode"
 )
 
-type uacFlags struct {
-	Script, AccountDisable, HomeDirRequired,
-	Lockout, PasswdNotReqd, EncryptedTextPwdAllowed,
-	TempDupAccount, NormalAccount, InterDomainTrustAcct,
-	WorkstationTrustAccount, ServerTrustAccount,
-	DontExpirePassword, MNSLogonAccount, SmartcardRequired,
-	TrustedForDelegation, NotDelegated, UseDESOnly,
-	DontPreauth, PasswordExpired, TrustedToAuthForDelegation,
-	PartialSecrets bool
-}
-
 // https://stackoverflow.com/questions/53069040/checking-a-string-contains-only-ascii-characters
 func isASCII(s string) bool {
 	for i := 0; i < len(s); i++ {
@@ -28,39 +19,13 @@ func isASCII(s string) bool {
 	return true
 }
 
-// whoa this is a dumb way of doing it,
-// but I've had too many rums to think of the actual way
-func decodeUAC(val int) uacFlags {
-	r := uacFlags{}
-	r.Script = val|1 == val
-	r.AccountDisable = val|2 == val
-	r.HomeDirRequired = val|8 == val
-	r.Lockout = val|6 == val
-	r.PasswdNotReqd = val|32 == val
-	r.EncryptedTextPwdAllowed = val|128 == val
-	r.TempDupAccount = val|256 == val
-	r.NormalAccount = val|512 == val
-	r.InterDomainTrustAcct = val|2048 == val
-	r.WorkstationTrustAccount = val|4096 == val
-	r.ServerTrustAccount = val|8192 == val
-	r.DontExpirePassword = val|65536 == val
-	r.MNSLogonAccount = val|131072 == val
-	r.SmartcardRequired = val|262144 == val
-	r.TrustedForDelegation = val|524288 == val
-	r.NotDelegated = val|1048576 == val
-	r.UseDESOnly = val|2097152 == val
-	r.DontPreauth = val|4194304 == val
-	r.PasswordExpired = val|8388608 == val
-	r.TrustedToAuthForDelegation = val|16777216 == val
-	r.PartialSecrets = val|67108864 == val
-	return r
-}
-
 type SuppInfo struct {
 	Username      string
 	ClearPassword string
 	NotASCII      bool
 	KerbKeys      []string
+	//salt the AES keys were made with (realm + account name for users), needed to crack them
+	KerbSalt string
 }
 
 func (s SuppInfo) ClearString() string {
@@ -76,64 +41,82 @@ func (s SuppInfo) KerbString() string {
 }
 
 type DumpedHash struct {
-	Username   string
-	LMHash     []byte
-	NTHash     []byte
-	Rid        uint32
-	Enabled    bool
-	UAC        uacFlags
-	Supp       SuppInfo
-	History    PwdHistory
-	JsonString string
+	Username     string
+	LMHash       []byte
+	NTHash       []byte
+	Rid          uint32
+	Enabled      bool
+	UAC          UACFlags
+	Supp         SuppInfo
+	History      PwdHistory
+	Logon        LogonInfo
+	ReplMetaData ReplMetaData
+	JsonString   string
+	//preformatted output for things that aren't account hashes (LSA secrets etc)
+	Secret string
+	//preformatted domain cached credential ($DCC2$ etc)
+	CachedHash string
+	//recovered from unallocated hive space, the account no longer exists
+	Deleted bool
 }
 
 type PwdHistory struct {
 	LmHist [][]byte
 	NTHist [][]byte
+	//when the current password was set, and how many times it has been changed (from the unicodePwd
+	//replication metadata where available, otherwise pwdLastSet with no change count)
+	PwdLastSet     time.Time
+	PwdChangeCount uint32
 }
 
+// LogonInfo is the logon/lockout metadata of an account. Times are zero if never set.
+type LogonInfo struct {
+	LastLogon       time.Time
+	LastBadPassword time.Time
+	AccountExpires  time.Time
+	BadPwdCount     uint32
+	LogonCount      uint32
+}
+
+// HistoryStrings is the password history in secretsdump's format. history0 is the current password, so its lines
+// say when it was set if that's known.
 func (d DumpedHash) HistoryStrings() []string {
 	r := make([]string, 0, len(d.History.NTHist))
 	for i, v := range d.History.LmHist {
-		r = append(r, fmt.Sprintf("%s_history%d:%d:%s:%s:::",
+		r = append(r, fmt.Sprintf("%s_history%d:%d:%s:%s:::%s",
 			d.Username,
 			i,
 			d.Rid,
 			hex.EncodeToString(v),
 			hex.EncodeToString(EmptyNT),
+			d.historySuffix(i),
 		))
 	}
 	for i, v := range d.History.NTHist {
-		r = append(r, fmt.Sprintf("%s_history%d:%d:%s:%s:::",
+		r = append(r, fmt.Sprintf("%s_history%d:%d:%s:%s:::%s",
 			d.Username,
 			i,
 			d.Rid,
 			hex.EncodeToString(EmptyLM),
 			hex.EncodeToString(v),
+			d.historySuffix(i),
 		))
 	}
 	return r
 }
 
+// historySuffix is the pwdLastSet note for the i'th history entry, in the form impacket's -pwd-last-set uses
+func (d DumpedHash) historySuffix(i int) string {
+	if i != 0 || d.History.PwdLastSet.IsZero() {
+		return ""
+	}
+	return " (pwdLastSet=" + d.History.PwdLastSet.UTC().Format("2006-01-02 15:04") + ")"
+}
+
 func (d DumpedHash) HistoryString() string {
 	r := strings.Builder{}
-	for i, v := range d.History.LmHist {
-		r.WriteString(fmt.Sprintf("%s_history%d:%d:%s:%s:::\n",
-			d.Username,
-			i,
-			d.Rid,
-			hex.EncodeToString(v),
-			hex.EncodeToString(EmptyNT),
-		))
-	}
-	for i, v := range d.History.NTHist {
-		r.WriteString(fmt.Sprintf("%s_history%d:%d:%s:%s:::\n",
-			d.Username,
-			i,
-			d.Rid,
-			hex.EncodeToString(EmptyLM),
-			hex.EncodeToString(v),
-		))
+	for _, h := range d.HistoryStrings() {
+		r.WriteString(h + "\n")
 	}
 	return r.String()
 }
@@ -146,3 +129,86 @@ func (d DumpedHash) HashString() string {
 		hex.EncodeToString(d.NTHash))
 	return answer
 }
+
+// jsonHash is how a DumpedHash looks as JSON: hashes in hex, and anything not set left out
+type jsonHash struct {
+	Username    string         `json:"username,omitempty"`
+	Rid         uint32         `json:"rid,omitempty"`
+	LMHash      string         `json:"lmHash,omitempty"`
+	NTHash      string         `json:"ntHash,omitempty"`
+	UAC         UACFlags       `json:"uac,omitempty"`
+	Deleted     bool           `json:"deleted,omitempty"`
+	Cleartext   string         `json:"cleartext,omitempty"`
+	KerbKeys    []string       `json:"kerberosKeys,omitempty"`
+	KerbSalt    string         `json:"kerberosSalt,omitempty"`
+	LMHistory   []string       `json:"lmHistory,omitempty"`
+	NTHistory   []string       `json:"ntHistory,omitempty"`
+	PwdLastSet  string         `json:"pwdLastSet,omitempty"`
+	PwdChanges  uint32         `json:"pwdChangeCount,omitempty"`
+	LastLogon   string         `json:"lastLogon,omitempty"`
+	LastBadPwd  string         `json:"lastBadPassword,omitempty"`
+	Expires     string         `json:"accountExpires,omitempty"`
+	BadPwdCount uint32         `json:"badPwdCount,omitempty"`
+	LogonCount  uint32         `json:"logonCount,omitempty"`
+	Secret      string         `json:"secret,omitempty"`
+	CachedHash  string         `json:"cachedHash,omitempty"`
+	ReplMeta    []jsonReplMeta `json:"replMetaData,omitempty"`
+}
+
+// jsonReplMeta is a replication metadata entry as JSON
+type jsonReplMeta struct {
+	AttrID         uint32 `json:"attributeId"`
+	Version        uint32 `json:"version"`
+	Changed        string `json:"changed,omitempty"`
+	OriginatingDSA string `json:"originatingDsa,omitempty"`
+	OriginatingUSN int64  `json:"originatingUsn"`
+	LocalUSN       int64  `json:"localUsn"`
+}
+
+// MarshalJSON encodes the dumped hash as a flat object, with hashes in hex and times in RFC 3339
+func (d DumpedHash) MarshalJSON() ([]byte, error) {
+	j := jsonHash{
+		Username:    d.Username,
+		Rid:         d.Rid,
+		LMHash:      hex.EncodeToString(d.LMHash),
+		NTHash:      hex.EncodeToString(d.NTHash),
+		UAC:         d.UAC,
+		Deleted:     d.Deleted,
+		Cleartext:   d.Supp.ClearPassword,
+		KerbKeys:    d.Supp.KerbKeys,
+		KerbSalt:    d.Supp.KerbSalt,
+		PwdLastSet:  jsonTime(d.History.PwdLastSet),
+		PwdChanges:  d.History.PwdChangeCount,
+		LastLogon:   jsonTime(d.Logon.LastLogon),
+		LastBadPwd:  jsonTime(d.Logon.LastBadPassword),
+		Expires:     jsonTime(d.Logon.AccountExpires),
+		BadPwdCount: d.Logon.BadPwdCount,
+		LogonCount:  d.Logon.LogonCount,
+		Secret:      d.Secret,
+		CachedHash:  d.CachedHash,
+	}
+	for _, h := range d.History.LmHist {
+		j.LMHistory = append(j.LMHistory, hex.EncodeToString(h))
+	}
+	for _, h := range d.History.NTHist {
+		j.NTHistory = append(j.NTHistory, hex.EncodeToString(h))
+	}
+	for _, e := range d.ReplMetaData {
+		j.ReplMeta = append(j.ReplMeta, jsonReplMeta{
+			AttrID:         e.AttrID,
+			Version:        e.Version,
+			Changed:        jsonTime(e.Changed),
+			OriginatingDSA: e.OriginatingDSA,
+			OriginatingUSN: e.OriginatingUSN,
+			LocalUSN:       e.LocalUSN,
+		})
+	}
+	return json.Marshal(j)
+}
+
+func jsonTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
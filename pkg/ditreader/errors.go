@@ -0,0 +1,36 @@
+package ditreader
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoPEK is returned when the database doesn't contain a PEK list, or none of it could be decrypted
+	ErrNoPEK = errors.New("no PEK found")
+	// ErrBadPEK is returned when the PEK list or a PEK encrypted attribute doesn't decrypt
+	ErrBadPEK = errors.New("bad PEK")
+	// ErrBadHash is returned for encrypted hash attributes that are the wrong size
+	ErrBadHash = errors.New("bad encrypted hash")
+	// ErrBadSupp is returned for supplemental credentials that don't decrypt or parse. The hashes of the account
+	// are still good.
+	ErrBadSupp = errors.New("bad supplemental credentials")
+	// ErrIncomplete is returned by Dump when some records couldn't be read or decrypted. Everything else has still
+	// been sent to the output channel.
+	ErrIncomplete = errors.New("dump incomplete")
+)
+
+// maxSkipped is how many skipped records are listed in an ErrIncomplete error before the rest are just counted
+const maxSkipped = 10
+
+// Incomplete wraps the errors of skipped records in ErrIncomplete, or returns nil if nothing was skipped
+func Incomplete(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	n := len(errs)
+	if n > maxSkipped {
+		errs = append(errs[:maxSkipped:maxSkipped], fmt.Errorf("and %d more", n-maxSkipped))
+	}
+	return fmt.Errorf("%w, %d skipped:\n%w", ErrIncomplete, n, errors.Join(errs...))
+}
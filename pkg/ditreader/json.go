@@ -1,50 +1,36 @@
 package ditreader
 
 import (
-	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"unicode"
 
 	"github.com/C-Sto/gosecretsdump/pkg/esent"
-	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
 )
 
 type M map[string]interface{}
 
 // TODO: Map column names to human-readable
 func (d DitReader) DumpJSON() error {
-	//if local (always local for now)
-	if d.systemHiveLocation != "" {
-		ls, err := systemreader.New(d.systemHiveLocation)
-		if err != nil {
-			return err
-		}
-		d.bootKey = ls.BootKey()
-		if d.ntdsFileLocation != "" {
-			d.noLMHash = ls.HasNoLMHashPolicy()
-		}
-	} else {
-		return fmt.Errorf("System hive empty")
-	}
-
-	d.getPek()
-	if len(d.pek) < 1 {
-		return fmt.Errorf("NO PEK FOUND THIS IS VERY BAD")
+	defer close(d.userData)
+	if err := d.loadKeys(); err != nil {
+		return err
 	}
 
 	var records []M
+	errs := []error{}
 
 	for {
 		//read each record from the db
 		record, err := d.db.GetNextRow(d.cursor)
 		if err != nil {
-			if err.Error() == "ignore" {
+			if errors.Is(err, esent.ErrNoMoreRecords) {
 				break //we will get an 'ignore' error when there are no more records
 			}
-			fmt.Println("Couldn't get row due to error: ", err.Error())
+			errs = append(errs, fmt.Errorf("couldn't read row: %w", err))
 			continue
 		}
 
@@ -60,9 +46,13 @@ func (d DitReader) DumpJSON() error {
 			}
 		}
 
-		if validUsername {
+		if validUsername && d.opts.Match(record) {
 			// RecordToJSON?
-			parsedRecord := record.ZachsRecordParse()
+			parsedRecord, err := record.ZachsRecordParse()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("couldn't parse record: %w", err))
+				continue
+			}
 
 			if lmHash, err := d.GetLMHash(record); err == nil {
 				parsedRecord["lmHash"] = lmHash
@@ -98,172 +88,67 @@ func (d DitReader) DumpJSON() error {
 	jsonString, err := json.Marshal(records2)
 	// jsonString, err := json.Marshal(records2[:100])
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	d.userData <- DumpedHash{JsonString: string(jsonString)}
-
-	close(d.userData)
-	return nil
+	return Incomplete(errs)
 }
 
 func (d *DitReader) RecordToJSON(record esent.Esent_record) (map[string]interface{}, error) {
-	dh := DumpedHash{}
-	v, _ := record.GetBytVal(nobjectSid)
-	sid, err := NewSAMRRPCSID(v) //record.Column[z].BytVal)
+	rid, err := recordRid(record)
 	if err != nil {
 		return nil, err
 	}
-	//dh.Rid = sid.FormatCanonical()[strings.LastIndex(sid.FormatCanonical(), "-")+1:]
-	dh.Rid = sid.Rid()
-
-	//lm hash
-	if b, err := record.GetBytVal(ndBCSPwd); err && len(b) > 0 {
-		//if record.Column[ndBCSPwd"]].StrVal != "" {
-		var tmpLM []byte
-		encryptedLM, err := NewCryptedHash(b)
-		if err != nil {
-			return nil, err
-		}
-		if bytes.Equal(encryptedLM.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedLMW := NewCryptedHashW16(b)
-			pekIndex := encryptedLMW.Header
-			tmpLM, err = DecryptAES(d.pek[pekIndex[4]], encryptedLMW.EncryptedHash[:16], encryptedLMW.KeyMaterial[:])
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			tmpLM, err = d.removeRC4(encryptedLM)
-			if err != nil {
-				return nil, err
-			}
-		}
-		dh.LMHash, err = RemoveDES(tmpLM, dh.Rid)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		//hard coded empty lm hash
-		dh.LMHash = EmptyLM //, _ = hex.DecodeString("aad3b435b51404eeaad3b435b51404ee")
+	lm, err := d.lmHash(record, rid)
+	if err != nil {
+		return nil, err
 	}
-
-	//nt hash
-	if v, _ := record.GetBytVal(nunicodePwd); len(v) > 0 { //  record.Column[nunicodePwd"]].BytVal; len(v) > 0 {
-		var tmpNT []byte
-		encryptedNT, err := NewCryptedHash(v)
-		if err != nil {
-			return nil, err
-		}
-		if bytes.Equal(encryptedNT.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedNTW := NewCryptedHashW16(v)
-			pekIndex := encryptedNTW.Header
-			tmpNT, err = DecryptAES(d.pek[pekIndex[4]], encryptedNTW.EncryptedHash[:16], encryptedNTW.KeyMaterial[:])
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			tmpNT, err = d.removeRC4(encryptedNT)
-			if err != nil {
-				return nil, err
-			}
-		}
-		dh.NTHash, err = RemoveDES(tmpNT, dh.Rid)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		//hard coded empty NTLM hash
-		dh.NTHash = EmptyNT //, _ = hex.DecodeString("31D6CFE0D16AE931B73C59D7E0C089C0")
+	nt, err := d.ntHash(record, rid)
+	if err != nil {
+		return nil, err
 	}
 
-	ditDump := record.ZachsRecordParse()
-	ditDump["lmHash"] = hex.EncodeToString(dh.LMHash)
-	ditDump["ntlmHash"] = hex.EncodeToString(dh.NTHash)
+	ditDump, err := record.ZachsRecordParse()
+	if err != nil {
+		return nil, err
+	}
+	ditDump["lmHash"] = hex.EncodeToString(lm)
+	ditDump["ntlmHash"] = hex.EncodeToString(nt)
 
 	return ditDump, nil
 }
 
 func (d *DitReader) GetLMHash(record esent.Esent_record) (string, error) {
-	dh := DumpedHash{}
-	v, _ := record.GetBytVal(nobjectSid)
-	sid, err := NewSAMRRPCSID(v) //record.Column[z].BytVal)
+	rid, err := recordRid(record)
 	if err != nil {
 		return "", err
 	}
-	//dh.Rid = sid.FormatCanonical()[strings.LastIndex(sid.FormatCanonical(), "-")+1:]
-	dh.Rid = sid.Rid()
-
-	//lm hash
-	if b, err := record.GetBytVal(ndBCSPwd); err && len(b) > 0 {
-		//if record.Column[ndBCSPwd"]].StrVal != "" {
-		var tmpLM []byte
-		encryptedLM, err := NewCryptedHash(b)
-		if err != nil {
-			return "", err
-		}
-		if bytes.Equal(encryptedLM.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedLMW := NewCryptedHashW16(b)
-			pekIndex := encryptedLMW.Header
-			tmpLM, err = DecryptAES(d.pek[pekIndex[4]], encryptedLMW.EncryptedHash[:16], encryptedLMW.KeyMaterial[:])
-			if err != nil {
-				return "", err
-			}
-		} else {
-			tmpLM, err = d.removeRC4(encryptedLM)
-			if err != nil {
-				return "", err
-			}
-		}
-		dh.LMHash, err = RemoveDES(tmpLM, dh.Rid)
-		if err != nil {
-			return "", err
-		}
-	} else {
-		//hard coded empty lm hash
-		dh.LMHash = EmptyLM //, _ = hex.DecodeString("aad3b435b51404eeaad3b435b51404ee")
+	lm, err := d.lmHash(record, rid)
+	if err != nil {
+		return "", err
 	}
-
-	return hex.EncodeToString(dh.LMHash), nil
+	return hex.EncodeToString(lm), nil
 }
 
 func (d *DitReader) GetNTLMHash(record esent.Esent_record) (string, error) {
-	dh := DumpedHash{}
-	v, _ := record.GetBytVal(nobjectSid)
-	sid, err := NewSAMRRPCSID(v) //record.Column[z].BytVal)
+	rid, err := recordRid(record)
 	if err != nil {
 		return "", err
 	}
-	//dh.Rid = sid.FormatCanonical()[strings.LastIndex(sid.FormatCanonical(), "-")+1:]
-	dh.Rid = sid.Rid()
-
-	//nt hash
-	if v, _ := record.GetBytVal(nunicodePwd); len(v) > 0 { //  record.Column[nunicodePwd"]].BytVal; len(v) > 0 {
-		var tmpNT []byte
-		encryptedNT, err := NewCryptedHash(v)
-		if err != nil {
-			return "", err
-		}
-		if bytes.Equal(encryptedNT.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedNTW := NewCryptedHashW16(v)
-			pekIndex := encryptedNTW.Header
-			tmpNT, err = DecryptAES(d.pek[pekIndex[4]], encryptedNTW.EncryptedHash[:16], encryptedNTW.KeyMaterial[:])
-			if err != nil {
-				return "", err
-			}
-		} else {
-			tmpNT, err = d.removeRC4(encryptedNT)
-			if err != nil {
-				return "", err
-			}
-		}
-		dh.NTHash, err = RemoveDES(tmpNT, dh.Rid)
-		if err != nil {
-			return "", err
-		}
-	} else {
-		//hard coded empty NTLM hash
-		dh.NTHash = EmptyNT //, _ = hex.DecodeString("31D6CFE0D16AE931B73C59D7E0C089C0")
+	nt, err := d.ntHash(record, rid)
+	if err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(nt), nil
+}
 
-	return hex.EncodeToString(dh.NTHash), nil
+// recordRid returns the RID from the objectSid of a record
+func recordRid(record esent.Esent_record) (uint32, error) {
+	v, _ := record.GetBytVal(nobjectSid)
+	sid, err := NewSAMRRPCSID(v)
+	if err != nil {
+		return 0, err
+	}
+	return sid.Rid(), nil
 }
@@ -0,0 +1,165 @@
+package ditreader
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/C-Sto/gosecretsdump/pkg/esent"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+)
+
+// sAMAccountType values for the accounts that have hashes worth dumping
+const (
+	SAM_NORMAL_USER_ACCOUNT = 0x30000000
+	SAM_MACHINE_ACCOUNT     = 0x30000001
+	SAM_TRUST_ACCOUNT       = 0x30000002
+)
+
+// objectClass values (ATTRTYP) for ObjectClasses. Computer objects are also of class user.
+const (
+	CLASS_USER     = 0x000A0009
+	CLASS_COMPUTER = 0x0003001E
+)
+
+// RIDRange is an inclusive range of RIDs
+type RIDRange struct {
+	Min uint32
+	Max uint32
+}
+
+// RecordFilter is a custom predicate run against a row of the datatable. Returning false skips the row.
+type RecordFilter func(record esent.Esent_record) bool
+
+// Options controls which rows of the dit are dumped. Every filter is evaluated against the raw row before
+// anything is decrypted, so skipped rows are never decrypted. The zero value dumps every user, machine and trust account.
+type Options struct {
+	//sAMAccountType values to dump. Empty means users, machines and trusts.
+	AccountTypes []int32
+	//skip accounts with ACCOUNTDISABLE set
+	EnabledOnly bool
+	//only dump accounts whose sAMAccountName matches
+	UsernameRegex *regexp.Regexp
+	//only dump accounts with a RID inside one of the ranges
+	RIDRanges []RIDRange
+	//only dump objects that are (or inherit from) one of the classes
+	ObjectClasses []uint32
+	//only dump objects with a uSNChanged greater than this
+	ChangedSinceUSN int64
+	//custom predicates, all must return true for a row to be dumped
+	Filters []RecordFilter
+	//overrides what's read from the SYSTEM hive: the control set, and the bootkey for syskey startup modes
+	System systemreader.Options
+}
+
+// Match returns true if the record passes every filter that has been set
+func (o Options) Match(record esent.Esent_record) bool {
+	if len(o.AccountTypes) > 0 {
+		v, ok := record.GetLongVal(nsAMAccountType)
+		if !ok || !containsInt32(o.AccountTypes, v) {
+			return false
+		}
+	}
+
+	if o.EnabledOnly {
+		if v, _ := record.GetLongVal(nuserAccountControl); UACFlags(v).Has(UF_ACCOUNTDISABLE) {
+			return false
+		}
+	}
+
+	if o.UsernameRegex != nil {
+		name, err := record.StrVal(nsAMAccountName)
+		if err != nil || !o.UsernameRegex.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(o.RIDRanges) > 0 {
+		rid, err := recordRid(record)
+		if err != nil {
+			return false
+		}
+		inRange := false
+		for _, r := range o.RIDRanges {
+			if rid >= r.Min && rid <= r.Max {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return false
+		}
+	}
+
+	if len(o.ObjectClasses) > 0 {
+		found := false
+		for _, c := range objectClasses(record) {
+			if containsUint32(o.ObjectClasses, c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if o.ChangedSinceUSN > 0 {
+		if v, ok := record.GetLngLngVal(nuSNChanged); !ok || int64(v) <= o.ChangedSinceUSN {
+			return false
+		}
+	}
+
+	for _, f := range o.Filters {
+		if !f(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// objectClasses returns the class chain of a record. objectClass is multi valued, and multi valued columns come out
+// of esent hex encoded: a list of uint16 offsets (the first offset also gives the size of the list) followed by
+// the values themselves.
+func objectClasses(record esent.Esent_record) []uint32 {
+	v, ok := record.GetBytVal(nobjectClass)
+	if !ok || len(v) < 4 {
+		return nil
+	}
+	b, err := hex.DecodeString(string(v))
+	if err != nil {
+		//single value, stored as is
+		return []uint32{binary.LittleEndian.Uint32(v[:4])}
+	}
+	if len(b) < 2 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint16(b[:2])&0x7fff) / 2
+	r := []uint32{}
+	for i := 0; i < count && i*2+2 <= len(b); i++ {
+		off := int(binary.LittleEndian.Uint16(b[i*2:]) & 0x7fff)
+		if off+4 > len(b) {
+			break
+		}
+		r = append(r, binary.LittleEndian.Uint32(b[off:off+4]))
+	}
+	return r
+}
+
+func containsInt32(l []int32, v int32) bool {
+	for _, x := range l {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint32(l []uint32, v uint32) bool {
+	for _, x := range l {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
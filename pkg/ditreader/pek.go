@@ -1,6 +1,13 @@
 package ditreader
 
-import "fmt"
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"fmt"
+)
 
 type PeklistEnc struct {
 	Header       [8]byte
@@ -33,6 +40,10 @@ type PeklistPlain struct {
 // NewPeklistPlain returns a cleartext peklist object from the passed in record
 func NewPeklistPlain(lData []byte) PeklistPlain {
 	r := PeklistPlain{}
+	if len(lData) < 32 {
+		//no room for any keys, callers will see an empty list
+		return r
+	}
 	//lData := make([]byte, len(data))
 	//copy(lData, data)
 	copy(r.Header[:], lData[:32])
@@ -42,6 +53,22 @@ func NewPeklistPlain(lData []byte) PeklistPlain {
 	return r
 }
 
+// AESKeys reads the keys out of a Windows 2016+ (AES encrypted) PEK list. Entries are an index (4 byte LE int)
+// followed by the 16 byte key, in ascending order. The list ends with an entry that has a non-sequential index
+// (08080808 has been observed) or when the data runs out.
+func (p PeklistPlain) AESKeys() PEKList {
+	r := PEKList{}
+	pekLen := 20
+	for i := 0; (i+1)*pekLen <= len(p.DecryptedPek); i++ {
+		entry := p.DecryptedPek[i*pekLen : (i+1)*pekLen]
+		if binary.LittleEndian.Uint32(entry[:4]) != uint32(i) {
+			break
+		}
+		r = append(r, NewPekKey(entry))
+	}
+	return r
+}
+
 type PekKey struct {
 	Header  [1]byte
 	Padding [3]byte
@@ -53,3 +80,72 @@ func NewPekKey(lData []byte) []byte {
 	//copy(r.Key[:], lData[4:20])
 	return lData[4:20]
 }
+
+// PEKList is the list of decrypted password encryption keys from the dit
+type PEKList [][]byte
+
+var aesHeader = []byte{0x13, 0, 0, 0}
+
+// Decrypt removes the PEK encryption layer from an encrypted attribute (unicodePwd, dBCSPwd, ntPwdHistory,
+// lmPwdHistory, supplementalCredentials). Windows 2016+ blobs (0x13 header) are AES, everything else is RC4.
+func (p PEKList) Decrypt(blob []byte) ([]byte, error) {
+	ch, err := NewCryptedHash(blob)
+	if err != nil {
+		return nil, err
+	}
+	pekIndex := int(ch.Header[4])
+	if pekIndex >= len(p) {
+		return nil, fmt.Errorf("%w: PEK index %d out of range, only %d PEK(s) decrypted", ErrBadPEK, pekIndex, len(p))
+	}
+	pek := p[pekIndex]
+
+	if bytes.Equal(ch.Header[:4], aesHeader) {
+		if len(blob) < 28 {
+			return nil, fmt.Errorf("%w: invalid AES crypted hash length. Expected x>=28, got x=%d", ErrBadHash, len(blob))
+		}
+		enc := NewCryptedHashW16History(blob)
+		if len(enc.EncryptedHash)%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("%w: AES ciphertext is not a multiple of the block size (%d bytes)", ErrBadHash, len(enc.EncryptedHash))
+		}
+		return DecryptAES(pek, enc.EncryptedHash, enc.KeyMaterial[:])
+	}
+
+	tmpKey := md5.Sum(append(append([]byte{}, pek...), ch.KeyMaterial[:]...))
+	rc, err := rc4.NewCipher(tmpKey[:])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(ch.EncryptedHash))
+	rc.XORKeyStream(plain, ch.EncryptedHash)
+	return plain, nil
+}
+
+// DecryptHash decrypts a single NT or LM hash attribute (unicodePwd or dBCSPwd)
+func (p PEKList) DecryptHash(blob []byte, rid uint32) ([]byte, error) {
+	plain, err := p.Decrypt(blob)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < 16 {
+		return nil, fmt.Errorf("%w: decrypted hash too short. Expected x>=16, got x=%d", ErrBadHash, len(plain))
+	}
+	return RemoveDES(plain[:16], rid)
+}
+
+// DecryptHistory decrypts a password history attribute (ntPwdHistory or lmPwdHistory). The first entry is
+// the current password, which is already reported as the hash itself, so it is not returned.
+func (p PEKList) DecryptHistory(blob []byte, rid uint32) ([][]byte, error) {
+	plain, err := p.Decrypt(blob)
+	if err != nil {
+		return nil, err
+	}
+	r := [][]byte{}
+	for i := 16; i+16 <= len(plain); i += 16 {
+		h, err := RemoveDES(plain[i:i+16], rid)
+		if err != nil {
+			return r, err
+		}
+		r = append(r, h)
+	}
+	return r, nil
+}
@@ -1,83 +1,32 @@
 package ditreader
 
 import (
-	"bytes"
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"strings"
 
 	"github.com/C-Sto/gosecretsdump/pkg/esent"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
 	"golang.org/x/text/encoding/unicode"
 )
 
+// DecryptRecord reads and decrypts an account record. ErrBadSupp errors come with a usable DumpedHash, missing only
+// the supplemental credentials.
 func (d *DitReader) DecryptRecord(record esent.Esent_record) (DumpedHash, error) {
 	dh := DumpedHash{}
-	v, _ := record.GetBytVal(nobjectSid)
-	sid, err := NewSAMRRPCSID(v) //record.Column[z].BytVal)
+	var err error
+	dh.Rid, err = recordRid(record)
 	if err != nil {
 		return dh, err
 	}
-	//dh.Rid = sid.FormatCanonical()[strings.LastIndex(sid.FormatCanonical(), "-")+1:]
-	dh.Rid = sid.Rid()
 
-	//lm hash
-	if b, err := record.GetBytVal(ndBCSPwd); err && len(b) > 0 {
-		//if record.Column[ndBCSPwd"]].StrVal != "" {
-		var tmpLM []byte
-		encryptedLM, err := NewCryptedHash(b)
-		if err != nil {
-			return dh, err
-		}
-		if bytes.Equal(encryptedLM.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedLMW := NewCryptedHashW16(b)
-			pekIndex := encryptedLMW.Header
-			tmpLM, err = DecryptAES(d.pek[pekIndex[4]], encryptedLMW.EncryptedHash[:16], encryptedLMW.KeyMaterial[:])
-			if err != nil {
-				return dh, err
-			}
-		} else {
-			tmpLM, err = d.removeRC4(encryptedLM)
-			if err != nil {
-				return dh, err
-			}
-		}
-		dh.LMHash, err = RemoveDES(tmpLM, dh.Rid)
-		if err != nil {
-			return dh, err
-		}
-	} else {
-		//hard coded empty lm hash
-		dh.LMHash = EmptyLM //, _ = hex.DecodeString("aad3b435b51404eeaad3b435b51404ee")
+	dh.LMHash, err = d.lmHash(record, dh.Rid)
+	if err != nil {
+		return dh, err
 	}
-
-	//nt hash
-	if v, _ := record.GetBytVal(nunicodePwd); len(v) > 0 { //  record.Column[nunicodePwd"]].BytVal; len(v) > 0 {
-		var tmpNT []byte
-		encryptedNT, err := NewCryptedHash(v)
-		if err != nil {
-			return dh, err
-		}
-		if bytes.Equal(encryptedNT.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedNTW := NewCryptedHashW16(v)
-			pekIndex := encryptedNTW.Header
-			tmpNT, err = DecryptAES(d.pek[pekIndex[4]], encryptedNTW.EncryptedHash[:16], encryptedNTW.KeyMaterial[:])
-			if err != nil {
-				return dh, err
-			}
-		} else {
-			tmpNT, err = d.removeRC4(encryptedNT)
-			if err != nil {
-				return dh, err
-			}
-		}
-		dh.NTHash, err = RemoveDES(tmpNT, dh.Rid)
-		if err != nil {
-			return dh, err
-		}
-	} else {
-		//hard coded empty NTLM hash
-		dh.NTHash = EmptyNT //, _ = hex.DecodeString("31D6CFE0D16AE931B73C59D7E0C089C0")
+	dh.NTHash, err = d.ntHash(record, dh.Rid)
+	if err != nil {
+		return dh, err
 	}
 
 	// account name
@@ -97,60 +46,65 @@ func (d *DitReader) DecryptRecord(record esent.Esent_record) (DumpedHash, error)
 	//Password history LM
 	if !d.noLMHash {
 		if v, _ := record.GetBytVal(nlmPwdHistory); len(v) > 0 { //&& len(v) > 0 {
-			ch, err := NewCryptedHash(v)
+			dh.History.LmHist, err = d.pek.DecryptHistory(v, dh.Rid)
 			if err != nil {
 				return dh, err
 			}
-			var tmphst []byte
-			tmphst, err = d.removeRC4(ch)
-			if err != nil {
-				return dh, err
-			}
-
-			for i := 16; i < len(tmphst); i += 16 {
-				hst1 := tmphst[i : i+16]
-				hst2, err := RemoveDES(hst1, dh.Rid)
-				dh.History.LmHist = append(dh.History.LmHist, hst2)
-				if err != nil {
-					return dh, err
-				}
-			}
 		}
 	}
 
 	//password history NT
 	if v, _ := record.GetBytVal(nntPwdHistory); len(v) > 0 { //&& len(v) > 0 {
-		ch, err := NewCryptedHash(v)
+		dh.History.NTHist, err = d.pek.DecryptHistory(v, dh.Rid)
 		if err != nil {
 			return dh, err
 		}
-		var tmphst []byte
-		if bytes.Equal(ch.Header[:4], []byte("\x13\x00\x00\x00")) {
-			encryptedNTW := NewCryptedHashW16History(v)
-			pekIndex := encryptedNTW.Header
-			tmphst, err = DecryptAES(d.pek[pekIndex[4]], encryptedNTW.EncryptedHash[:], encryptedNTW.KeyMaterial[:])
-			if err != nil {
-				return dh, err
-			}
-		} else {
-			tmphst, err = d.removeRC4(ch)
-			if err != nil {
-				return dh, err
-			}
-		}
-		for i := 16; i < len(tmphst); i += 16 {
-			hst1 := tmphst[i : i+16]
-			hst2, err := RemoveDES(hst1, dh.Rid)
-			if err != nil {
-				return dh, err
-			}
-			dh.History.NTHist = append(dh.History.NTHist, hst2)
+	}
+
+	//replication metadata, which is usually too big for the record and comes out of the long value tree. If it's
+	//missing or can't be parsed, pwdLastSet still says when the password was set.
+	if v, _ := record.GetBytVal(nreplPropertyMetaData); len(v) > 0 {
+		if md, err := ParseReplMetaData(v); err == nil {
+			dh.ReplMetaData = md
 		}
+	}
+	if e, ok := dh.ReplMetaData.Attribute(attrID(nunicodePwd)); ok {
+		dh.History.PwdLastSet = e.Changed
+		dh.History.PwdChangeCount = e.Version
+	} else if v, ok := record.GetLngLngVal(npwdLastSet); ok {
+		dh.History.PwdLastSet = winregistry.FiletimeToTime(v)
+	}
 
+	//logon metadata. lastLogon isn't replicated, lastLogonTimestamp is but lags behind, so use whichever is newer
+	lastLogon, _ := record.GetLngLngVal(nlastLogon)
+	if v, _ := record.GetLngLngVal(nlastLogonTimestamp); v > lastLogon {
+		lastLogon = v
+	}
+	dh.Logon.LastLogon = winregistry.FiletimeToTime(lastLogon)
+	if v, ok := record.GetLngLngVal(nbadPasswordTime); ok {
+		dh.Logon.LastBadPassword = winregistry.FiletimeToTime(v)
+	}
+	if v, ok := record.GetLngLngVal(naccountExpires); ok {
+		dh.Logon.AccountExpires = winregistry.FiletimeToTime(v)
+	}
+	if v, ok := record.GetLongVal(nbadPwdCount); ok {
+		dh.Logon.BadPwdCount = uint32(v)
 	}
+	if v, ok := record.GetLongVal(nlogonCount); ok {
+		dh.Logon.LogonCount = uint32(v)
+	}
+
 	//check if account is enabled
 	if v, _ := record.GetLongVal(nuserAccountControl); v != 0 { // record.Column[nuserAccountControl"]].Long; v != 0 {
-		dh.UAC = decodeUAC(int(v))
+		dh.UAC = UACFlags(v)
+	}
+	//lockout and password expiry only live in the computed attribute
+	if v, ok := record.GetLongVal(nuacComputed); ok && v != 0 {
+		dh.UAC |= UACFlags(v)
+	} else {
+		lockout, _ := record.GetLngLngVal(nlockoutTime)
+		pwdLastSet, hasPwdLastSet := record.GetLngLngVal(npwdLastSet)
+		dh.UAC |= ComputedUAC(dh.UAC, int64(lockout), int64(pwdLastSet), hasPwdLastSet)
 	}
 
 	//check if cleartext exists
@@ -159,20 +113,37 @@ func (d *DitReader) DecryptRecord(record esent.Esent_record) (DumpedHash, error)
 		var err error
 		dh.Supp, err = d.decryptSupp(record)
 		if err != nil {
-			fmt.Println("Error: ", err)
+			return dh, fmt.Errorf("%w for %s: %w", ErrBadSupp, dh.Username, err)
 		}
 	}
 
 	return dh, nil
 }
 
+// lmHash returns the decrypted LM hash of a record, or the empty LM hash if it has none
+func (d DitReader) lmHash(record esent.Esent_record, rid uint32) ([]byte, error) {
+	if b, ok := record.GetBytVal(ndBCSPwd); ok && len(b) > 0 {
+		return d.pek.DecryptHash(b, rid)
+	}
+	//hard coded empty lm hash
+	return EmptyLM, nil
+}
+
+// ntHash returns the decrypted NT hash of a record, or the empty NT hash if it has none
+func (d DitReader) ntHash(record esent.Esent_record, rid uint32) ([]byte, error) {
+	if b, _ := record.GetBytVal(nunicodePwd); len(b) > 0 {
+		return d.pek.DecryptHash(b, rid)
+	}
+	//hard coded empty NTLM hash
+	return EmptyNT, nil
+}
+
 func (d DitReader) decryptSupp(record esent.Esent_record) (SuppInfo, error) {
 	r := SuppInfo{}
 
 	bval, _ := record.GetBytVal(nsupplementalCredentials) // record.Column[nsupplementalCredentials"]]
 	if len(bval) > 24 {                                   //is the value above the minimum for plaintex passwords?
 		username, _ := record.StrVal(nsAMAccountName)
-		var plainBytes []byte
 		//check if the record is something something? has a UPN?
 		if v, _ := record.StrVal(nuserPrincipalName); v != "" { //record.Column[nuserPrincipalName"]].StrVal != "" {
 			domain := v
@@ -181,28 +152,10 @@ func (d DitReader) decryptSupp(record esent.Esent_record) (SuppInfo, error) {
 			username = fmt.Sprintf("%s\\%s", domain, username)
 		}
 		//fmt.Println(val.BytVal)
-		ct, err := NewCryptedHash(bval)
+		plainBytes, err := d.pek.Decrypt(bval)
 		if err != nil {
 			return r, err
 		}
-		//ct := crypted_hash{}.Init(val.BytVal)
-
-		//check for windows 2016 tp4
-		if bytes.Equal(ct.Header[:4], []byte{0x13, 0, 0, 0}) {
-			//fmt.Println("TODO: WINDOWS 2016 SUPP DATA FOR PLAINTEXT")
-			pekIndex := binary.LittleEndian.Uint16(ct.Header[4:6])
-			plainBytes, err = DecryptAES(d.pek[pekIndex],
-				ct.EncryptedHash[4:],
-				ct.KeyMaterial[:])
-			if err != nil {
-				return r, err
-			}
-		} else {
-			plainBytes, err = d.removeRC4(ct)
-			if err != nil {
-				return r, err
-			}
-		}
 		if len(plainBytes) < 100 {
 			return r, fmt.Errorf("bad length for user properties: expecting >100 got %d ", len(plainBytes))
 		}
@@ -222,6 +175,12 @@ func (d DitReader) decryptSupp(record esent.Esent_record) (SuppInfo, error) {
 				}
 				cursor := 0
 				rec := NewSAMRKerbStoredCredNew(nhex)
+				if end := int(rec.DefaultSaltOffset) + int(rec.DefaultSaltLength); rec.DefaultSaltLength > 0 && end <= len(nhex) {
+					salt, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().String(string(nhex[rec.DefaultSaltOffset:end]))
+					if err == nil {
+						r.KerbSalt = salt
+					}
+				}
 				r.KerbKeys = make([]string, rec.CredentialCount)
 				for credIndex := uint16(0); credIndex < rec.CredentialCount; credIndex++ {
 					keyData := NewSAMRKerbKeyDataNew(rec.Buffer[cursor:])
@@ -0,0 +1,115 @@
+package ditreader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+replPropertyMetaData is stored in the dit as a PROPERTY_META_DATA_VECTOR (version 1):
+
+	dwVersion   uint32
+	dwReserved  uint32
+	cNumEntries uint32
+	dwReserved  uint32
+	rgMetaData  [cNumEntries]PROPERTY_META_DATA
+
+	PROPERTY_META_DATA:
+	attrType           uint32 (ATTRTYP, the same number used in the ATTx column names)
+	dwVersion          uint32
+	timeChanged        int64  (DSTIME, seconds since 1601)
+	uuidDsaOriginating [16]byte
+	usnOriginating     int64
+	usnProperty        int64
+*/
+
+const replMetaHeaderLen = 16
+const replMetaEntryLen = 48
+
+// ReplMetaEntry is the replication metadata for a single attribute of an object
+type ReplMetaEntry struct {
+	AttrID  uint32
+	Version uint32
+	Changed time.Time
+	//invocation id of the DC the change originated on
+	OriginatingDSA string
+	OriginatingUSN int64
+	LocalUSN       int64
+}
+
+// ReplMetaData holds the parsed replPropertyMetaData of an object
+type ReplMetaData []ReplMetaEntry
+
+// Attribute returns the metadata entry for the attribute with the given ATTRTYP
+func (r ReplMetaData) Attribute(attrID uint32) (ReplMetaEntry, bool) {
+	for _, e := range r {
+		if e.AttrID == attrID {
+			return e, true
+		}
+	}
+	return ReplMetaEntry{}, false
+}
+
+// ParseReplMetaData parses a raw replPropertyMetaData value
+func ParseReplMetaData(b []byte) (ReplMetaData, error) {
+	if len(b) < replMetaHeaderLen {
+		return nil, fmt.Errorf("replPropertyMetaData too short: expected at least %d bytes, got %d", replMetaHeaderLen, len(b))
+	}
+	if v := binary.LittleEndian.Uint32(b[:4]); v != 1 {
+		return nil, fmt.Errorf("unsupported replPropertyMetaData version %d", v)
+	}
+	count := binary.LittleEndian.Uint32(b[8:12])
+	if int(count) > (len(b)-replMetaHeaderLen)/replMetaEntryLen {
+		return nil, fmt.Errorf("replPropertyMetaData truncated: %d entries in %d bytes", count, len(b))
+	}
+	r := make(ReplMetaData, 0, count)
+	curs := replMetaHeaderLen
+	for i := uint32(0); i < count; i++ {
+		e := b[curs : curs+replMetaEntryLen]
+		r = append(r, ReplMetaEntry{
+			AttrID:         binary.LittleEndian.Uint32(e[0:4]),
+			Version:        binary.LittleEndian.Uint32(e[4:8]),
+			Changed:        DSTimeToTime(int64(binary.LittleEndian.Uint64(e[8:16]))),
+			OriginatingDSA: FormatGUID(e[16:32]),
+			OriginatingUSN: int64(binary.LittleEndian.Uint64(e[32:40])),
+			LocalUSN:       int64(binary.LittleEndian.Uint64(e[40:48])),
+		})
+		curs += replMetaEntryLen
+	}
+	return r, nil
+}
+
+// attrID converts an internal column name (ATTk589914) into its ATTRTYP (589914)
+func attrID(column string) uint32 {
+	var r uint32
+	for _, c := range column[4:] {
+		r = r*10 + uint32(c-'0')
+	}
+	return r
+}
+
+// seconds between 1601-01-01 and 1970-01-01
+const epochDiff = 11644473600
+
+// DSTimeToTime converts a DSTIME (seconds since 1601) to a time.Time
+func DSTimeToTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t-epochDiff, 0).UTC()
+}
+
+// FormatGUID formats a little-endian GUID the way Windows does
+func FormatGUID(b []byte) string {
+	if len(b) < 16 {
+		return ""
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16],
+	)
+}
@@ -0,0 +1,149 @@
+package ditreader
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// UACFlags is the userAccountControl bitmask of an account.
+// https://learn.microsoft.com/en-us/troubleshoot/windows-server/active-directory/useraccountcontrol-manipulate-account-properties
+type UACFlags uint32
+
+// userAccountControl bits. LOCKOUT, PASSWORD_EXPIRED, PARTIAL_SECRETS_ACCOUNT and USE_AES_KEYS are only
+// reliable when read from msDS-User-Account-Control-Computed (or derived, see ComputedUAC).
+const (
+	UF_SCRIPT                         UACFlags = 0x1
+	UF_ACCOUNTDISABLE                 UACFlags = 0x2
+	UF_HOMEDIR_REQUIRED               UACFlags = 0x8
+	UF_LOCKOUT                        UACFlags = 0x10
+	UF_PASSWD_NOTREQD                 UACFlags = 0x20
+	UF_PASSWD_CANT_CHANGE             UACFlags = 0x40
+	UF_ENCRYPTED_TEXT_PWD_ALLOWED     UACFlags = 0x80
+	UF_TEMP_DUPLICATE_ACCOUNT         UACFlags = 0x100
+	UF_NORMAL_ACCOUNT                 UACFlags = 0x200
+	UF_INTERDOMAIN_TRUST_ACCOUNT      UACFlags = 0x800
+	UF_WORKSTATION_TRUST_ACCOUNT      UACFlags = 0x1000
+	UF_SERVER_TRUST_ACCOUNT           UACFlags = 0x2000
+	UF_DONT_EXPIRE_PASSWORD           UACFlags = 0x10000
+	UF_MNS_LOGON_ACCOUNT              UACFlags = 0x20000
+	UF_SMARTCARD_REQUIRED             UACFlags = 0x40000
+	UF_TRUSTED_FOR_DELEGATION         UACFlags = 0x80000
+	UF_NOT_DELEGATED                  UACFlags = 0x100000
+	UF_USE_DES_KEY_ONLY               UACFlags = 0x200000
+	UF_DONT_REQUIRE_PREAUTH           UACFlags = 0x400000
+	UF_PASSWORD_EXPIRED               UACFlags = 0x800000
+	UF_TRUSTED_TO_AUTH_FOR_DELEGATION UACFlags = 0x1000000
+	UF_NO_AUTH_DATA_REQUIRED          UACFlags = 0x2000000
+	UF_PARTIAL_SECRETS_ACCOUNT        UACFlags = 0x4000000
+	UF_USE_AES_KEYS                   UACFlags = 0x8000000
+)
+
+// uacNames is ordered by bit so String() output is stable
+var uacNames = []struct {
+	flag UACFlags
+	name string
+}{
+	{UF_SCRIPT, "SCRIPT"},
+	{UF_ACCOUNTDISABLE, "ACCOUNTDISABLE"},
+	{UF_HOMEDIR_REQUIRED, "HOMEDIR_REQUIRED"},
+	{UF_LOCKOUT, "LOCKOUT"},
+	{UF_PASSWD_NOTREQD, "PASSWD_NOTREQD"},
+	{UF_PASSWD_CANT_CHANGE, "PASSWD_CANT_CHANGE"},
+	{UF_ENCRYPTED_TEXT_PWD_ALLOWED, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+	{UF_TEMP_DUPLICATE_ACCOUNT, "TEMP_DUPLICATE_ACCOUNT"},
+	{UF_NORMAL_ACCOUNT, "NORMAL_ACCOUNT"},
+	{UF_INTERDOMAIN_TRUST_ACCOUNT, "INTERDOMAIN_TRUST_ACCOUNT"},
+	{UF_WORKSTATION_TRUST_ACCOUNT, "WORKSTATION_TRUST_ACCOUNT"},
+	{UF_SERVER_TRUST_ACCOUNT, "SERVER_TRUST_ACCOUNT"},
+	{UF_DONT_EXPIRE_PASSWORD, "DONT_EXPIRE_PASSWORD"},
+	{UF_MNS_LOGON_ACCOUNT, "MNS_LOGON_ACCOUNT"},
+	{UF_SMARTCARD_REQUIRED, "SMARTCARD_REQUIRED"},
+	{UF_TRUSTED_FOR_DELEGATION, "TRUSTED_FOR_DELEGATION"},
+	{UF_NOT_DELEGATED, "NOT_DELEGATED"},
+	{UF_USE_DES_KEY_ONLY, "USE_DES_KEY_ONLY"},
+	{UF_DONT_REQUIRE_PREAUTH, "DONT_REQ_PREAUTH"},
+	{UF_PASSWORD_EXPIRED, "PASSWORD_EXPIRED"},
+	{UF_TRUSTED_TO_AUTH_FOR_DELEGATION, "TRUSTED_TO_AUTH_FOR_DELEGATION"},
+	{UF_NO_AUTH_DATA_REQUIRED, "NO_AUTH_DATA_REQUIRED"},
+	{UF_PARTIAL_SECRETS_ACCOUNT, "PARTIAL_SECRETS_ACCOUNT"},
+	{UF_USE_AES_KEYS, "USE_AES_KEYS"},
+}
+
+// Has returns true if every bit in f is set
+func (u UACFlags) Has(f UACFlags) bool {
+	return u&f == f
+}
+
+// Names returns the names of the set bits. Undocumented bits are returned as hex.
+func (u UACFlags) Names() []string {
+	r := []string{}
+	rest := u
+	for _, n := range uacNames {
+		if u.Has(n.flag) {
+			r = append(r, n.name)
+			rest &^= n.flag
+		}
+	}
+	if rest != 0 {
+		r = append(r, fmt.Sprintf("0x%x", uint32(rest)))
+	}
+	return r
+}
+
+func (u UACFlags) String() string {
+	return strings.Join(u.Names(), "|")
+}
+
+// MarshalJSON encodes the flags as a list of flag names
+func (u UACFlags) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.Names())
+}
+
+// UnmarshalJSON accepts either a list of flag names or the raw integer value
+func (u *UACFlags) UnmarshalJSON(b []byte) error {
+	var raw uint32
+	if err := json.Unmarshal(b, &raw); err == nil {
+		*u = UACFlags(raw)
+		return nil
+	}
+	names := []string{}
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	r := UACFlags(0)
+	for _, name := range names {
+		found := false
+		for _, n := range uacNames {
+			if n.name == name {
+				r |= n.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			var v uint32
+			if _, err := fmt.Sscanf(name, "0x%x", &v); err != nil {
+				return fmt.Errorf("unknown userAccountControl flag %q", name)
+			}
+			r |= UACFlags(v)
+		}
+	}
+	*u = r
+	return nil
+}
+
+// ComputedUAC approximates msDS-User-Account-Control-Computed for records that don't carry it.
+// The attribute is constructed by the DC at query time, so offline databases normally only have the
+// inputs: a non-zero lockoutTime means the account was locked out (the lockout duration isn't known
+// without the domain object), and a zero pwdLastSet means the password must be changed at next logon.
+func ComputedUAC(uac UACFlags, lockoutTime, pwdLastSet int64, hasPwdLastSet bool) UACFlags {
+	r := UACFlags(0)
+	if lockoutTime > 0 {
+		r |= UF_LOCKOUT
+	}
+	if hasPwdLastSet && pwdLastSet == 0 && !uac.Has(UF_DONT_EXPIRE_PASSWORD) {
+		r |= UF_PASSWORD_EXPIRED
+	}
+	return r
+}
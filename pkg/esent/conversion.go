@@ -35,12 +35,18 @@ func (e *Esedb) tagToRecord(c *Cursor, tag []byte) (Esent_record, error) {
 			if val == nil {
 				val = record.GetRecord(column)
 			}
+			if int(fixedSizeOffset)+int(cRecord.Columns.SpaceUsage) > len(tag) {
+				return record, fmt.Errorf("%w: fixed column %s outside of record", ErrCorruptPage, column)
+			}
 			val.UpdateBytVal(tag[fixedSizeOffset:][:cRecord.Columns.SpaceUsage])
 			//record.UpdateBytVal(tag[fixedSizeOffset:][:cRecord.Columns.SpaceUsage], column)
 			fixedSizeOffset += cRecord.Columns.SpaceUsage
 		} else if 127 < cRecord.Fixed.Identifier && cRecord.Fixed.Identifier <= uint32(ddHeader.LastVariableDataType) {
 			//  # Variable data type
 			index := cRecord.Fixed.Identifier - 127 - 1
+			if int(vsOffset)+int(index)*2+2 > len(tag) {
+				return record, fmt.Errorf("%w: variable column %s outside of record", ErrCorruptPage, column)
+			}
 			itemLen := binary.LittleEndian.Uint16(tag[vsOffset+uint16(index)*2:][:2])
 			if itemLen&0x8000 != 0 {
 				//empty item
@@ -48,6 +54,9 @@ func (e *Esedb) tagToRecord(c *Cursor, tag []byte) (Esent_record, error) {
 				//record.Column = nil
 
 			} else {
+				if itemLen < prevItemLen || int(vsOffset)+int(vDataBytesProcessed)+int(itemLen-prevItemLen) > len(tag) {
+					return record, fmt.Errorf("%w: variable column %s outside of record", ErrCorruptPage, column)
+				}
 				if val == nil {
 					val = record.GetNilRecord(column)
 				}
@@ -60,7 +69,10 @@ func (e *Esedb) tagToRecord(c *Cursor, tag []byte) (Esent_record, error) {
 			var cRecordItem *tag_item
 			var ok bool
 			if !taggedItemsParsed && (uint16(vDataBytesProcessed)+vsOffset) < uint16(len(tag)) {
-				parseTaggedItems(vDataBytesProcessed, vsOffset, tag, e.dbHeader.Version, e.dbHeader.FileFormatRevision, pageSize, &taggedI, &taggedItemsParsed, uint16(cRecord.Fixed.Identifier), cRecordItem, &ok)
+				err := parseTaggedItems(vDataBytesProcessed, vsOffset, tag, e.dbHeader.Version, e.dbHeader.FileFormatRevision, pageSize, &taggedI, &taggedItemsParsed, uint16(cRecord.Fixed.Identifier), cRecordItem, &ok)
+				if err != nil {
+					return record, err
+				}
 			}
 			if !ok {
 				for i := 0; i < len(taggedI.O); i++ {
@@ -73,6 +85,9 @@ func (e *Esedb) tagToRecord(c *Cursor, tag []byte) (Esent_record, error) {
 			if ok && cRecordItem != nil {
 				//if cRecordItem, ok = taggedI.M[uint16(cRecord.Fixed.Identifier)]; ok {
 				offsetItem := uint16(vDataBytesProcessed) + vsOffset + cRecordItem.TaggedOffset
+				if int(uint16(vDataBytesProcessed))+int(vsOffset)+int(cRecordItem.TaggedOffset) >= len(tag) {
+					return record, fmt.Errorf("%w: tagged column %s outside of record", ErrCorruptPage, column)
+				}
 				itemSize := cRecordItem.TagLen
 				//if item has flags, skip for some reason?
 				itemFlag := int16(0)
@@ -108,7 +123,14 @@ func (e *Esedb) tagToRecord(c *Cursor, tag []byte) (Esent_record, error) {
 					}
 					//record.UpdateBytVal(tag[offsetItem:offsetItem+itemSize], column)
 
-					val.UpdateBytVal(tag[offsetItem:][:itemSize])
+					data := tag[offsetItem:][:itemSize]
+					if itemFlag&TAGGED_DATA_TYPE_STORED != 0 {
+						//too big for the record, data is the ID of a long value
+						if lv, ok := e.longValue(c.TableData, data); ok {
+							data = lv
+						}
+					}
+					val.UpdateBytVal(data)
 					//record.Column[column].UpdateBytVal(tag[offsetItem:][:itemSize])
 				}
 			}
@@ -123,11 +145,17 @@ func (e *Esedb) tagToRecord(c *Cursor, tag []byte) (Esent_record, error) {
 	return record, nil
 }
 
-func parseTaggedItems(vDataBytesProcessed uint8, vsOffset uint16, tag []byte, version, rev, pageSize uint32, taggedI *taggedItems, taggedItemsParsed *bool, ident uint16, crecordItem *tag_item, ok *bool) {
+func parseTaggedItems(vDataBytesProcessed uint8, vsOffset uint16, tag []byte, version, rev, pageSize uint32, taggedI *taggedItems, taggedItemsParsed *bool, ident uint16, crecordItem *tag_item, ok *bool) error {
 	index := uint16(vDataBytesProcessed) + vsOffset //start index of the items to parse
 	endOfVS := pageSize
+	if int(index)+4 > len(tag) {
+		return fmt.Errorf("%w: tagged items outside of record", ErrCorruptPage)
+	}
 	firstOffsetTag := (binary.LittleEndian.Uint16(tag[index+2:][:2]) & 0x3fff) + uint16(vDataBytesProcessed) + vsOffset
 	for {
+		if int(index)+4 > len(tag) {
+			return fmt.Errorf("%w: tagged items outside of record", ErrCorruptPage)
+		}
 		taggedIdent := binary.LittleEndian.Uint16(tag[index:][:2])
 		index += 2
 		taggedOffset := (binary.LittleEndian.Uint16(tag[index:][:2]) & 0x3fff)
@@ -165,4 +193,5 @@ func parseTaggedItems(vDataBytesProcessed uint8, vsOffset uint16, tag []byte, ve
 		}
 	}
 	*taggedItemsParsed = true
+	return nil
 }
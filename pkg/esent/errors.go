@@ -0,0 +1,15 @@
+package esent
+
+import "errors"
+
+var (
+	// ErrCorruptPage is returned when a page (or the record data on it) doesn't parse
+	ErrCorruptPage = errors.New("corrupt page")
+	// ErrNoMoreRecords is returned by GetNextRow at the end of a table. The message is kept as "ignore", which
+	// is what callers used to compare against.
+	ErrNoMoreRecords = errors.New("ignore")
+	// ErrTableNotFound is returned by OpenTable for a table that isn't in the catalog
+	ErrTableNotFound = errors.New("table not found")
+	// ErrBadColumn is returned when a column value can't be converted to its catalog type
+	ErrBadColumn = errors.New("bad column value")
+)
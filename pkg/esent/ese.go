@@ -1,12 +1,14 @@
 package esent
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+
+	"github.com/C-Sto/gosecretsdump/internal/corrupt"
 )
 
 //props to agsolino for doing the original impacket version of this. The file format is clearly a mindfuck, and it would not have been easy.
@@ -35,23 +37,63 @@ var stringCodePages = map[uint32]string{
 	1252:  "cp1252",
 } //standin for const lookup/enum thing
 
-func (e Esedb) Init(fn string) (Esedb, error) {
+func (e Esedb) Init(fn string) (r Esedb, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+	sts, err := f.Stat()
+	if err != nil {
+		return r, err
+	}
+	r, err = e.InitReader(f, sts.Size())
+	r.filename = fn
+	return r, err
+}
+
+// InitFS opens the database called name in fsys
+func (e Esedb) InitFS(fsys fs.FS, name string) (Esedb, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return Esedb{}, err
+	}
+	defer f.Close()
+	if ra, ok := f.(io.ReaderAt); ok {
+		if sts, err := f.Stat(); err == nil {
+			r, err := e.InitReader(ra, sts.Size())
+			r.filename = name
+			return r, err
+		}
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return Esedb{}, err
+	}
+	r, err := e.InitReader(bytes.NewReader(b), int64(len(b)))
+	r.filename = name
+	return r, err
+}
+
+// InitReader parses a database of size bytes from ra. Everything is read into memory, so ra isn't needed after
+// this returns.
+func (e Esedb) InitReader(ra io.ReaderAt, size int64) (r Esedb, err error) {
+	defer corrupt.Recover(&err, ErrCorruptPage)
 	//create the esedb structure
-	r := Esedb{
-		filename: fn,
+	r = Esedb{
 		pageSize: pageSize,
 		tables:   make(map[string]*table),
 		isRemote: false,
 	}
 
 	//'mount' the database (parse the file)
-	err := r.mountDb(fn)
+	err = r.mountDb(ra, size)
 	return r, err
 }
 
 // OpenTable opens a table, and returns a cursor pointing to the current parsing state
-func (e *Esedb) OpenTable(s string) (*Cursor, error) {
-	r := Cursor{} //this feels like it can be optimised
+func (e *Esedb) OpenTable(s string) (c *Cursor, err error) {
+	defer corrupt.Recover(&err, ErrCorruptPage)
 
 	//if the table actually exists
 	if v, ok := e.tables[s]; ok {
@@ -76,6 +118,9 @@ func (e *Esedb) OpenTable(s string) (*Cursor, error) {
 		var done = false
 		for !done {
 			page = e.getPage(pageNum)
+			if page == nil {
+				return nil, fmt.Errorf("%w: table %s page %d missing", ErrCorruptPage, s, pageNum)
+			}
 			if page.record.FirstAvailablePageTag <= 1 {
 				//no records
 				break
@@ -84,10 +129,12 @@ func (e *Esedb) OpenTable(s string) (*Cursor, error) {
 				if page.record.PageFlags&FLAGS_LEAF == 0 {
 					flags, data, err := page.getTag(int(i))
 					if err != nil {
-						//TODO: decide if we want to error, or die
-						fmt.Println(err)
+						return nil, err
+					}
+					branchEntry, err := esent_branch_entry{}.Init(flags, data)
+					if err != nil {
+						return nil, err
 					}
-					branchEntry := esent_branch_entry{}.Init(flags, data)
 					pageNum = branchEntry.ChildPageNumber
 					break
 				} else {
@@ -105,18 +152,18 @@ func (e *Esedb) OpenTable(s string) (*Cursor, error) {
 		return &cursor, nil
 	}
 
-	return &r, nil
+	return nil, fmt.Errorf("%w: %s", ErrTableNotFound, s)
 }
 
-func (e *Esedb) mountDb(filename string) (err error) {
+func (e *Esedb) mountDb(ra io.ReaderAt, size int64) (err error) {
 	//the first page is the dbheader
-	err = e.loadPages(filename)
+	err = e.loadPages(ra, size)
 	if err != nil {
 		return
 	}
 	// this was a gross way of working out how many pages the file has...
 	//this is where everything actually gets parsed out
-	e.parseCatalog(CATALOG_PAGE_NUMBER) //4  ?
+	err = e.parseCatalog(CATALOG_PAGE_NUMBER) //4  ?
 
 	return
 }
@@ -126,9 +173,14 @@ func (e *Esedb) parseCatalog(pagenum uint32) error {
 
 	//get the page
 	page := e.getPage(pagenum)
+	if page == nil {
+		return fmt.Errorf("%w: catalog page %d missing", ErrCorruptPage, pagenum)
+	}
 
 	//parse the page
-	e.parsePage(page)
+	if err := e.parsePage(page); err != nil {
+		return err
+	}
 
 	//Iterate over each tag in the branch
 	for i := 1; i < int(page.record.FirstAvailablePageTag); i++ {
@@ -140,9 +192,14 @@ func (e *Esedb) parseCatalog(pagenum uint32) error {
 		//if we are looking at a branch page
 		if page.record.PageFlags&FLAGS_LEAF == 0 {
 			//create the branch entry from the flags and data retreived
-			branchEntry := esent_branch_entry{}.Init(flags, data)
+			branchEntry, err := esent_branch_entry{}.Init(flags, data)
+			if err != nil {
+				return err
+			}
 			//walk along the branch, and parse any referenced pages
-			e.parseCatalog(branchEntry.ChildPageNumber)
+			if err := e.parseCatalog(branchEntry.ChildPageNumber); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -161,13 +218,17 @@ func (e *Esedb) parsePage(page *esent_page) error {
 		if err != nil {
 			return err
 		}
-		leafEntry := esent_leaf_entry{}.Init(flags, data)
+		leafEntry, err := esent_leaf_entry{}.Init(flags, data)
+		if err != nil {
+			return err
+		}
 		e.addLeaf(leafEntry)
 	}
 	return nil
 }
 
-func (e *Esedb) GetNextRow(c *Cursor) (Esent_record, error) {
+func (e *Esedb) GetNextRow(c *Cursor) (r Esent_record, err error) {
+	defer corrupt.Recover(&err, ErrCorruptPage)
 	c.CurrentTag++
 	// increment cursor pointer to look for 'next' tag
 
@@ -181,7 +242,7 @@ func (e *Esedb) GetNextRow(c *Cursor) (Esent_record, error) {
 				page.record.PageFlags&FLAGS_INDEX > 0 || page.record.PageFlags&FLAGS_LONG_VALUE > 0)) {
 
 		if page == nil || page.record.NextPageNumber == 0 { //no more pages :(
-			return Esent_record{}, errors.New("ignore")
+			return Esent_record{}, ErrNoMoreRecords
 		}
 
 		c.CurrentPageData = e.getPage(page.record.NextPageNumber)
@@ -193,7 +254,10 @@ func (e *Esedb) GetNextRow(c *Cursor) (Esent_record, error) {
 	if err != nil {
 		return Esent_record{}, err
 	}
-	tag := esent_leaf_entry{}.Init(flags, data)
+	tag, err := esent_leaf_entry{}.Init(flags, data)
+	if err != nil {
+		return Esent_record{}, err
+	}
 	return e.tagToRecord(c, tag.EntryData)
 }
 
@@ -253,20 +317,9 @@ func (e *Esedb) addLeaf(l esent_leaf_entry) error {
 		//e.tables[e.currentTable].Indexes.Add(string(itemName), l)
 
 	} else if catEntry.Fixed.Type == CATALOG_TYPE_LONG_VALUE {
-		/* seems to not be needed
-		if e.tables[e.currentTable].Columns == nil {
-			return
-		}
-
-		lvLen := binary.LittleEndian.Uint16(l.EntryData[ddHeader.VariableSizeOffset:][:2])
-		lvName := []byte{}
-
-		if len(l.EntryData[ddHeader.VariableSizeOffset:]) > 7 {
-			lvName = l.EntryData[ddHeader.VariableSizeOffset:][7:][:lvLen]
+		if t, ok := e.tables[e.currentTable]; ok {
+			t.LongValueRoot = catEntry.Other.FatherDataPageNumber
 		}
-		//e.tables[e.currentTable].Longvalues.Add(string(lvName), l)
-		//e.tables[e.currentTable].AddData(string(lvName), l)
-		*/
 	} else {
 		return fmt.Errorf("Reached code it shuldn't")
 	}
@@ -286,8 +339,15 @@ func (e *Esedb) parseItemName(l esent_leaf_entry) ([]byte, error) {
 	} else {
 		entries = ddHeader.LastVariableDataType
 	}
-	entryLen := binary.LittleEndian.Uint16(l.EntryData[ddHeader.VariableSizeOffset:][:2])
-	entryName := l.EntryData[ddHeader.VariableSizeOffset:][2*entries:][:entryLen]
+	start := int(ddHeader.VariableSizeOffset)
+	if start+2 > len(l.EntryData) {
+		return nil, fmt.Errorf("%w: catalog entry name outside of entry", ErrCorruptPage)
+	}
+	entryLen := int(binary.LittleEndian.Uint16(l.EntryData[start:]))
+	if start+2*int(entries)+entryLen > len(l.EntryData) {
+		return nil, fmt.Errorf("%w: catalog entry name outside of entry", ErrCorruptPage)
+	}
+	entryName := l.EntryData[start:][2*entries:][:entryLen]
 	return entryName, err
 }
 
@@ -298,41 +358,41 @@ func (e *Esedb) getMainHeader(data []byte) (esent_db_header, error) {
 	return dbhd, err
 }
 
-func (e *Esedb) loadPages(fn string) error {
-
-	f, err := os.Open(fn)
-	if err != nil {
-		return err
-	}
-	sts, _ := f.Stat()
+func (e *Esedb) loadPages(ra io.ReaderAt, size int64) error {
 	e.db = &fileInMem{}
-	fr := bufio.NewReader(f)
-	defer f.Close()
 	hdr := make([]byte, e.pageSize)
-	fr.Read(hdr)
+	if _, err := ra.ReadAt(hdr, 0); err != nil && err != io.EOF {
+		return err
+	}
+	hdrSize := int64(len(hdr))
+	var err error
 	e.dbHeader, err = e.getMainHeader(hdr)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: bad database header: %s", ErrCorruptPage, err)
 	}
 	e.pageSize = e.dbHeader.PageSize
+	if e.pageSize == 0 {
+		return fmt.Errorf("%w: database header has no page size", ErrCorruptPage)
+	}
 
-	pages := int(sts.Size()) / int(e.pageSize)
+	pages := int(size) / int(e.pageSize)
+	if pages < 3 {
+		return fmt.Errorf("%w: database too short (%d pages)", ErrCorruptPage, pages)
+	}
 	e.db.pages = make([]*esent_page, pages)
 	e.totalPages = uint32(pages - 2) //unsure why -2 at this stage, I assume first page is header and last page is tail?
 
 	for i := uint32(1); i < e.totalPages; i++ {
-		start := i * e.pageSize
-		if int(start) > int(sts.Size()) {
+		//pages follow straight on from the header
+		start := hdrSize + int64(i-1)*int64(e.pageSize)
+		if start > size {
 			return nil
 		}
-		end := start + e.pageSize
-		//r := make([]byte, count)
-		if int(end) > int(sts.Size()) {
-			end = uint32(sts.Size())
-		}
 		e.db.pages[i] = &esent_page{data: make([]byte, e.pageSize)}
 		r := e.db.pages[i]
-		fr.Read(r.data)
+		if _, err := ra.ReadAt(r.data, start); err != nil && err != io.EOF {
+			return err
+		}
 		r.dbHeader = e.dbHeader
 		if r.data != nil {
 			r.getHeader()
@@ -345,6 +405,9 @@ func (e *Esedb) loadPages(fn string) error {
 // retreives a page of data from the file?
 func (e *Esedb) getPage(pageNum uint32) *esent_page {
 	//check cache
+	if int(pageNum)+1 >= len(e.db.pages) {
+		return nil
+	}
 	r := e.db.pages[pageNum+1]
 	if r != nil {
 		e.db.pages[pageNum+1] = nil
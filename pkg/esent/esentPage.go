@@ -35,6 +35,9 @@ func (p *esent_page) getHeader() error {
 	//copy(data, inData)
 	p.record.Len = 40 //all record lengths are 40, except the extended
 	cursor := 0
+	if len(p.data) < int(p.record.Len) {
+		return fmt.Errorf("%w: page too short (%d bytes)", ErrCorruptPage, len(p.data))
+	}
 
 	if p.dbHeader.Version < 0x620 || (p.dbHeader.Version == 0x620 && p.dbHeader.FileFormatRevision < 0x0b) {
 		//make it xp
@@ -90,7 +93,7 @@ func (p *esent_page) getHeader() error {
 
 func (p *esent_page) getTag(i int) (pageFlags uint16, tagData []byte, err error) {
 	if int(p.record.FirstAvailablePageTag) < i {
-		return 0, nil, fmt.Errorf("trying to grab tag??? 0x%x", i)
+		return 0, nil, fmt.Errorf("%w: trying to grab tag??? 0x%x", ErrCorruptPage, i)
 	}
 	//len(self.record) calls __len()__ on a Structure object, which just returns len(self.data).
 	//I manually (print/echo debugging ftw) looked at the structures to work it out,
@@ -98,12 +101,18 @@ func (p *esent_page) getTag(i int) (pageFlags uint16, tagData []byte, err error)
 
 	//the tags are 4 bytes each, seek to the first avail pagetag and drop the data before the tag
 	startIndex := len(p.data) - int(4*(i+1))
+	if startIndex < int(p.record.Len) {
+		return 0, nil, fmt.Errorf("%w: tag 0x%x outside of page", ErrCorruptPage, i)
+	}
 	tag := p.data[startIndex : startIndex+4]
 
 	valsize := binary.LittleEndian.Uint16(tag[:2]) & 0x1fff
 	pageFlags = (binary.LittleEndian.Uint16(tag[2:]) & 0xe000) >> 13
 	valueOffset := binary.LittleEndian.Uint16(tag[2:]) & 0x1fff
 
+	if int(p.record.Len)+int(valueOffset)+int(valsize) > len(p.data) {
+		return 0, nil, fmt.Errorf("%w: tag 0x%x data outside of page", ErrCorruptPage, i)
+	}
 	tagData = p.data[p.record.Len+valueOffset:][:valsize]
 	//copy(tagData, p.data[p.record.Len+valueOffset:][:valsize])
 
@@ -0,0 +1,93 @@
+package esent
+
+import (
+	"encoding/binary"
+)
+
+/*
+Values too big to keep in a record (anything over about a kilobyte, which includes the replication metadata of most
+objects in a dit) are stored in the table's long value tree, and the record only holds the long value's ID (LID),
+little endian. The tree is keyed on the LID, big endian, for the long value's root:
+
+	refCount uint32
+	size     uint32
+
+and on the LID followed by the offset of the segment (also big endian) for each piece of the value.
+*/
+
+// longValue reads the long value lid out of the long value tree of t. False is returned if it isn't there, or not all
+// of it is.
+func (e *Esedb) longValue(t *table, lid []byte) ([]byte, bool) {
+	if t.longValues == nil {
+		t.longValues = e.loadLongValues(t.LongValueRoot)
+	}
+	key := make([]byte, len(lid))
+	for i, b := range lid {
+		key[len(lid)-1-i] = b
+	}
+	root, ok := t.longValues[string(key)]
+	if !ok || len(root) < 8 {
+		return nil, false
+	}
+	size := binary.LittleEndian.Uint32(root[4:8])
+	r := []byte{}
+	off := make([]byte, 4)
+	for uint32(len(r)) < size {
+		binary.BigEndian.PutUint32(off, uint32(len(r)))
+		seg, ok := t.longValues[string(key)+string(off)]
+		if !ok || len(seg) == 0 {
+			return nil, false
+		}
+		r = append(r, seg...)
+	}
+	return r[:size], true
+}
+
+// loadLongValues indexes the leaf entries of the long value tree rooted at page root by their full key. The entries
+// point into the pages rather than being copied.
+func (e *Esedb) loadLongValues(root uint32) map[string][]byte {
+	r := map[string][]byte{}
+	if root == 0 {
+		return r
+	}
+	//down the left of the tree to the first leaf
+	page := e.getPage(root)
+	for page != nil && page.record.PageFlags&FLAGS_LEAF == 0 {
+		flags, data, err := page.getTag(1)
+		if err != nil {
+			return r
+		}
+		branchEntry, err := esent_branch_entry{}.Init(flags, data)
+		if err != nil {
+			return r
+		}
+		page = e.getPage(branchEntry.ChildPageNumber)
+	}
+	//then along the leaves. Pages are only handed out once, so a corrupt next page can't send this round in circles.
+	for page != nil {
+		//the first tag holds the prefix shared by the keys on the page
+		_, prefix, err := page.getTag(0)
+		if err != nil {
+			return r
+		}
+		for i := 1; i < int(page.record.FirstAvailablePageTag); i++ {
+			flags, data, err := page.getTag(i)
+			if err != nil {
+				return r
+			}
+			if flags&TAG_DEFUNCT != 0 {
+				continue
+			}
+			leafEntry, err := esent_leaf_entry{}.Init(flags, data)
+			if err != nil || int(leafEntry.CommonPageKeySize) > len(prefix) {
+				continue
+			}
+			r[string(prefix[:leafEntry.CommonPageKeySize])+string(leafEntry.LocalPageKey)] = leafEntry.EntryData
+		}
+		if page.record.NextPageNumber == 0 {
+			break
+		}
+		page = e.getPage(page.record.NextPageNumber)
+	}
+	return r
+}
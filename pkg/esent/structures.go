@@ -21,6 +21,9 @@ type table struct {
 	Name       string
 	TableEntry esent_leaf_entry
 	Columns    *cat_entries //map[string]cat_entr
+	//first page of the long value tree, where values too big for a record are kept
+	LongValueRoot uint32
+	longValues    map[string][]byte
 	//Indexes    *OrderedMap_esent_leaf_entry //map[string]esent_leaf_entry
 	//Longvalues *OrderedMap_esent_leaf_entry //map[string]esent_leaf_entry
 	//data       map[string]interface{}
@@ -148,8 +151,11 @@ type esent_branch_entry struct {
 	ChildPageNumber  uint32
 }
 
-func (e esent_branch_entry) Init(flags uint16, data []byte) esent_branch_entry {
+func (e esent_branch_entry) Init(flags uint16, data []byte) (esent_branch_entry, error) {
 	r := esent_branch_entry{}
+	if len(data) < entryKeyEnd(flags, data)+4 {
+		return r, fmt.Errorf("%w: branch entry too short", ErrCorruptPage)
+	}
 	//zzzz
 	//data := make([]byte, len(ldata))
 	//copy(data, ldata)
@@ -171,7 +177,7 @@ func (e esent_branch_entry) Init(flags uint16, data []byte) esent_branch_entry {
 	//then we have the childpagenumber (this should be the rest of the data??)
 	r.ChildPageNumber = binary.LittleEndian.Uint32(data[curs:])
 
-	return r
+	return r, nil
 }
 
 type esent_leaf_entry struct {
@@ -183,8 +189,11 @@ type esent_leaf_entry struct {
 	EntryData    []byte // ":"
 }
 
-func (e esent_leaf_entry) Init(flags uint16, inData []byte) esent_leaf_entry {
+func (e esent_leaf_entry) Init(flags uint16, inData []byte) (esent_leaf_entry, error) {
 	r := esent_leaf_entry{}
+	if len(inData) < entryKeyEnd(flags, inData) {
+		return r, fmt.Errorf("%w: leaf entry too short", ErrCorruptPage)
+	}
 	curs := 0
 	//data := make([]byte, len(inData))
 
@@ -205,7 +214,20 @@ func (e esent_leaf_entry) Init(flags uint16, inData []byte) esent_leaf_entry {
 	//data = data[r.LocalPageKeySize:]
 	//then we have the data (this should be the rest of the data??)
 	r.EntryData = inData[curs:]
-	return r
+	return r, nil
+}
+
+// entryKeyEnd is where the local page key of a branch or leaf entry ends, which may be past the end of data if the
+// entry is corrupt
+func entryKeyEnd(flags uint16, data []byte) int {
+	curs := 0
+	if flags&TAG_COMMON > 0 {
+		curs += 2
+	}
+	if len(data) < curs+2 {
+		return curs + 2
+	}
+	return curs + 2 + int(binary.LittleEndian.Uint16(data[curs:]))
 }
 
 type esent_data_definition_header struct {
@@ -228,8 +250,11 @@ func (e esent_catalog_data_definition_entry) Init(inData []byte) (esent_catalog_
 	curs := 0
 	r := esent_catalog_data_definition_entry{}
 	//fill in fixed
-	buffer := bytes.NewBuffer(getAndMoveCursor(inData, &curs, 10))
-	err := binary.Read(buffer, binary.LittleEndian, &r.Fixed)
+	d, err := getAndMoveCursor(inData, &curs, 10)
+	if err != nil {
+		return r, err
+	}
+	err = binary.Read(bytes.NewBuffer(d), binary.LittleEndian, &r.Fixed)
 	if err != nil {
 		return r, err
 	}
@@ -238,28 +263,46 @@ func (e esent_catalog_data_definition_entry) Init(inData []byte) (esent_catalog_
 	if r.Fixed.Type == CATALOG_TYPE_COLUMN {
 		//only one with no 'other' section
 		//fill in column stuff
-		buffer := bytes.NewBuffer(getAndMoveCursor(inData, &curs, 16))
-		err := binary.Read(buffer, binary.LittleEndian, &r.Columns)
+		d, err := getAndMoveCursor(inData, &curs, 16)
+		if err != nil {
+			return r, err
+		}
+		err = binary.Read(bytes.NewBuffer(d), binary.LittleEndian, &r.Columns)
 		if err != nil {
 			return r, err
 		}
 	} else {
 
 		//fill in 'other'
-		r.Other.FatherDataPageNumber = binary.LittleEndian.Uint32(getAndMoveCursor(inData, &curs, 4))
+		d, err := getAndMoveCursor(inData, &curs, 4)
+		if err != nil {
+			return r, err
+		}
+		r.Other.FatherDataPageNumber = binary.LittleEndian.Uint32(d)
 
 		if r.Fixed.Type == CATALOG_TYPE_TABLE {
 			//do 'table stuff'
-			r.Table.SpaceUsage = binary.LittleEndian.Uint32(getAndMoveCursor(inData, &curs, 4))
+			d, err := getAndMoveCursor(inData, &curs, 4)
+			if err != nil {
+				return r, err
+			}
+			r.Table.SpaceUsage = binary.LittleEndian.Uint32(d)
 		} else if r.Fixed.Type == CATALOG_TYPE_INDEX {
 			//index stuff
-			buffer := bytes.NewBuffer(getAndMoveCursor(inData, &curs, 12))
-			err := binary.Read(buffer, binary.LittleEndian, &r.Index)
+			d, err := getAndMoveCursor(inData, &curs, 12)
+			if err != nil {
+				return r, err
+			}
+			err = binary.Read(bytes.NewBuffer(d), binary.LittleEndian, &r.Index)
 			if err != nil {
 				return r, err
 			}
 		} else if r.Fixed.Type == CATALOG_TYPE_LONG_VALUE {
-			r.LV.SpaceUsage = binary.LittleEndian.Uint32(getAndMoveCursor(inData, &curs, 4))
+			d, err := getAndMoveCursor(inData, &curs, 4)
+			if err != nil {
+				return r, err
+			}
+			r.LV.SpaceUsage = binary.LittleEndian.Uint32(d)
 		} else if r.Fixed.Type == CATALOG_TYPE_CALLBACK {
 			return r, fmt.Errorf("Catalog type callback unexpected")
 		} else {
@@ -272,10 +315,13 @@ func (e esent_catalog_data_definition_entry) Init(inData []byte) (esent_catalog_
 	return r, nil
 }
 
-func getAndMoveCursor(data []byte, curs *int, size int) []byte {
+func getAndMoveCursor(data []byte, curs *int, size int) ([]byte, error) {
+	if *curs+size > len(data) {
+		return nil, fmt.Errorf("%w: catalog entry too short", ErrCorruptPage)
+	}
 	d := data[*curs : *curs+size]
 	*curs += size
-	return d
+	return d, nil
 }
 
 type fixed_catalog_data_definition_entry struct {
@@ -401,7 +447,15 @@ func (e *Esent_record) GetLongVal(column string) (int32, bool) {
 }
 
 func (e esent_recordVal) Long() int32 {
-	return int32(binary.LittleEndian.Uint32(e.val))
+	return int32(binary.LittleEndian.Uint32(e.fixed(4)))
+}
+
+// fixed is the value as n bytes, zero padded if it's shorter, for reading as a fixed size type
+func (e esent_recordVal) fixed(n int) []byte {
+	if len(e.val) >= n {
+		return e.val
+	}
+	return append(append(make([]byte, 0, n), e.val...), make([]byte, n-len(e.val))...)
 }
 
 func (e *Esent_record) GetBytVal(column string) ([]byte, bool) {
@@ -2,7 +2,9 @@ package esent
 
 import (
 	"encoding/binary"
+	"fmt"
 
+	"github.com/C-Sto/gosecretsdump/internal/corrupt"
 	"github.com/charmbracelet/log"
 )
 
@@ -26,35 +28,35 @@ func (e *Esent_record) GetColumnType(column string) recordTyp {
 }
 
 func (e esent_recordVal) ValueAsUint16() uint16 {
-	return uint16(binary.LittleEndian.Uint16(e.val))
+	return uint16(binary.LittleEndian.Uint16(e.fixed(2)))
 }
 
 func (e esent_recordVal) ValueAsUint32() uint32 {
-	return uint32(binary.LittleEndian.Uint32(e.val))
+	return uint32(binary.LittleEndian.Uint32(e.fixed(4)))
 }
 
 func (e esent_recordVal) ValueAsUint64() uint64 {
-	return uint64(binary.LittleEndian.Uint64(e.val))
+	return uint64(binary.LittleEndian.Uint64(e.fixed(8)))
 }
 
 func (e esent_recordVal) ValueAsInt16() int16 {
-	return int16(binary.LittleEndian.Uint16(e.val))
+	return int16(binary.LittleEndian.Uint16(e.fixed(2)))
 }
 
 func (e esent_recordVal) ValueAsInt32() int32 {
-	return int32(binary.LittleEndian.Uint32(e.val))
+	return int32(binary.LittleEndian.Uint32(e.fixed(4)))
 }
 
 func (e esent_recordVal) ValueAsInt64() int64 {
-	return int64(binary.LittleEndian.Uint64(e.val))
+	return int64(binary.LittleEndian.Uint64(e.fixed(8)))
 }
 
 func (e esent_recordVal) ValueAsFloat32() float32 {
-	return Float32frombytes(e.val)
+	return Float32frombytes(e.fixed(4))
 }
 
 func (e esent_recordVal) ValueAsFloat64() float64 {
-	return Float64frombytes(e.val)
+	return Float64frombytes(e.fixed(8))
 }
 
 func (e *Esent_record) GetShortVal(column string) (int16, bool) {
@@ -146,18 +148,19 @@ func (e *Esent_record) GetUnsShrtVal(column string) (uint16, bool) {
 // guid       [16]byte
 // unsShrt    uint16
 // nils for binary, text, longbin, longtext and slv?
-func (e *Esent_record) ConvertValue(column string) interface{} {
+func (e *Esent_record) ConvertValue(column string) (v interface{}, err error) {
+	defer corrupt.Recover(&err, ErrCorruptPage)
 	switch typ := e.GetColumnType(column); typ {
 	case Byt:
 		// log.Infof("Type of column %s: %d (Byt)", column, typ)
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: May not want bytes
 	// NOTE: Haven't encountered yet
@@ -166,22 +169,22 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case Str:
 		// log.Infof("Type of column %s: %d (Str)", column, typ)
 
 		value, err := e.StrVal(column)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("%w: %s: %s", ErrBadColumn, column, err)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case Nil:
 		// log.Infof("Type of column %s: %d (Nil)", column, typ)
@@ -193,11 +196,11 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: Difference w/ Byt?
 	case UnsByt:
@@ -205,66 +208,66 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case Short:
 		// log.Infof("Type of column %s: %d (Short)", column, typ)
 
 		value, ok := e.GetShortVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetShortVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetShortVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case Long:
 		// log.Infof("Type of column %s: %d (Long)", column, typ)
 
 		value, ok := e.GetLongVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetLongVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetLongVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case Curr:
 		// log.Infof("Type of column %s: %d (Curr)", column, typ)
 
 		value, ok := e.GetCurrencyVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetCurrencyVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetCurrencyVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case IEEESingl:
 		// log.Infof("Type of column %s: %d (IEEESingl)", column, typ)
 
 		value, ok := e.GetIEEESinglVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetIEEESinglVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetIEEESinglVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case IEEEDoub:
 		// log.Infof("Type of column %s: %d (IEEEDoub)", column, typ)
 
 		value, ok := e.GetIEEEDoublVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetIEEEDoublVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetIEEEDoublVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// NOTE: Haven't encountered yet
 	case DateTim:
@@ -272,11 +275,11 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetDateTimeVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetDateTimeVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetDateTimeVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: May not want bytes
 	// NOTE: Haven't encountered yet
@@ -285,11 +288,11 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: May not want bytes (especially here!)
 	// NOTE: Haven't encountered yet
@@ -298,11 +301,11 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: Does this need to be decoded at all? Has stuff like objectSid and objectGUID
 	case LongBin:
@@ -310,11 +313,11 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: May not want bytes (especially here!)
 	// NOTE: Haven't encountered yet
@@ -323,11 +326,11 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: May not want bytes
 	// NOTE: Haven't encountered yet
@@ -336,33 +339,33 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case UnsLng:
 		// log.Infof("Type of column %s: %d (UnsLng)", column, typ)
 
 		value, ok := e.GetUnsLngVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetUnsLngVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetUnsLngVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case LngLng:
 		// log.Infof("Type of column %s: %d (LngLng)", column, typ)
 
 		value, ok := e.GetLngLngVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetLngLngVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetLngLngVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// NOTE: Haven't encountered yet
 	case Guid:
@@ -370,22 +373,22 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetGuidVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetGuidVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetGuidVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	case UnsShrt:
 		// log.Infof("Type of column %s: %d (UnsShrt)", column, typ)
 
 		value, ok := e.GetUnsShrtVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetUnsShrtVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetUnsShrtVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	// TODO: May not want bytes
 	// NOTE: Haven't encountered yet
@@ -394,23 +397,22 @@ func (e *Esent_record) ConvertValue(column string) interface{} {
 
 		value, ok := e.GetBytVal(column)
 		if !ok {
-			log.Fatalf("Failed to GetBytVal for %s", column)
+			return nil, fmt.Errorf("%w: failed to GetBytVal for %s", ErrBadColumn, column)
 		}
 
 		// log.Infof("Value of column %s: %v", column, value)
-		return value
+		return value, nil
 
 	default:
-		log.Fatalf("Unknown type for column %s: %d", column, typ)
-		return nil // for static analysis
+		return nil, fmt.Errorf("%w: unknown type for column %s: %d", ErrBadColumn, column, typ)
 	}
 
-	log.Fatalf("Failed to enter switch statement for column %s...?", column)
-	return nil // for static analysis
+	return nil, nil
 }
 
 // Main function for converting DB values to something usable
-func (e *Esent_record) ZachsRecordParse() map[string]interface{} {
+func (e *Esent_record) ZachsRecordParse() (r map[string]interface{}, err error) {
+	defer corrupt.Recover(&err, ErrCorruptPage)
 
 	log := GetLogger()
 
@@ -432,7 +434,10 @@ func (e *Esent_record) ZachsRecordParse() map[string]interface{} {
 		// 	log.Fatal(e.GetColumnType(column))
 		// }
 
-		value := e.ConvertValue(column)
+		value, err := e.ConvertValue(column)
+		if err != nil {
+			return nil, err
+		}
 		log.Infof("Type of column %s: %d (Byt)", column, e.GetColumnType(column))
 		log.Infof("Value of column %s: %v", column, value)
 		ditDump[column] = value
@@ -447,5 +452,5 @@ func (e *Esent_record) ZachsRecordParse() map[string]interface{} {
 
 	// log.Infof("JSON:%s", jsonString)
 
-	return ditDump
+	return ditDump, nil
 }
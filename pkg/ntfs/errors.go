@@ -0,0 +1,12 @@
+package ntfs
+
+import "errors"
+
+var (
+	// ErrNotNTFS is returned when the volume doesn't start with an NTFS boot sector
+	ErrNotNTFS = errors.New("not an NTFS volume")
+	// ErrCorruptVolume is returned when an MFT record, index or data run doesn't parse
+	ErrCorruptVolume = errors.New("corrupt NTFS volume")
+	// ErrUnsupported is returned when reading data stored in a way we don't handle (compressed or encrypted files)
+	ErrUnsupported = errors.New("unsupported NTFS feature")
+)
@@ -0,0 +1,312 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+/*
+Directories are B+ trees of FILE_NAME keys, in the $I30 index. The root node is the resident $INDEX_ROOT
+attribute, other nodes are INDX blocks in $INDEX_ALLOCATION.
+
+$INDEX_ROOT: Type uint32, CollationRule uint32, IndexBlockSize uint32, ClustersPerIndexBlock uint8, then a node
+header at 0x10. INDX blocks have the node header at 0x18.
+
+Node header: EntriesOffset uint32 (from the node header), TotalSize uint32, AllocatedSize uint32, Flags uint8.
+
+Entry: FileReference uint64, Length uint16, KeyLength uint16, Flags uint32 (1 = has a child node, 2 = last entry),
+then the key (a FILE_NAME attribute value), then the child node VCN in the last 8 bytes if there is one.
+
+FILE_NAME: ParentReference uint64, 4 FILETIMEs, AllocatedSize uint64 at 0x28, RealSize uint64 at 0x30, Flags uint32
+at 0x38, NameLength uint8 at 0x40, Namespace uint8 at 0x41, UTF-16 name at 0x42.
+*/
+
+const (
+	INDEX_ENTRY_NODE = 0x1
+	INDEX_ENTRY_END  = 0x2
+
+	NAMESPACE_DOS = 2
+
+	FILE_NAME_DIRECTORY = 0x10000000
+
+	maxIndexDepth = 64
+)
+
+// dirEntry is a single entry of a directory index
+type dirEntry struct {
+	record  uint64
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+	//dos is set for 8.3 names, which can be opened but aren't listed
+	dos bool
+}
+
+// Open opens the file or directory at name, a slash separated path from the root of the volume
+func (v *Volume) Open(name string) (f fs.File, err error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e := dirEntry{record: ROOT_RECORD, name: ".", dir: true}
+	if name != "." {
+		for _, part := range strings.Split(name, "/") {
+			if !e.dir {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entries, err := v.readDir(e.record)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			found := false
+			for _, c := range entries {
+				if strings.EqualFold(c.name, part) {
+					e, found = c, true
+					break
+				}
+			}
+			if !found {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+		}
+	}
+	file, err := v.openEntry(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
+
+// openEntry loads the attributes of a directory entry's file
+func (v *Volume) openEntry(e dirEntry) (*File, error) {
+	attrs, err := v.attributes(e.record)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{v: v, entry: e}
+	if si, ok := findAttr(attrs, ATTR_STANDARD_INFORMATION, ""); ok && len(si.value) >= 0x10 {
+		f.entry.modTime = winregistry.FiletimeToTime(binary.LittleEndian.Uint64(si.value[0x08:]))
+	}
+	if !e.dir {
+		//the size in the directory entry isn't always kept up to date, the data attribute is the real thing
+		f.data = v.newStream(filterAttrs(attrs, ATTR_DATA, ""))
+		f.entry.size = f.data.size
+	}
+	return f, nil
+}
+
+// readDir reads the index of the directory in MFT record n
+func (v *Volume) readDir(n uint64) ([]dirEntry, error) {
+	attrs, err := v.attributes(n)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := findAttr(attrs, ATTR_INDEX_ROOT, "$I30")
+	if !ok || len(root.value) < 0x20 {
+		return nil, fmt.Errorf("%w: MFT record %d is not a directory", ErrCorruptVolume, n)
+	}
+	blockSize := int64(binary.LittleEndian.Uint32(root.value[8:]))
+	if blockSize < 0x200 || blockSize > 64<<10 {
+		return nil, fmt.Errorf("%w: %d byte index blocks in MFT record %d", ErrCorruptVolume, blockSize, n)
+	}
+	alloc := v.newStream(filterAttrs(attrs, ATTR_INDEX_ALLOCATION, "$I30"))
+	//VCNs in the index are clusters, unless blocks are smaller than a cluster in which case they're 512 byte units
+	vcnSize := v.clusterSize
+	if blockSize < v.clusterSize {
+		vcnSize = 512
+	}
+
+	r := []dirEntry{}
+	seen := map[int64]bool{}
+	var walk func(node []byte, depth int) error
+	walk = func(node []byte, depth int) error {
+		if depth > maxIndexDepth {
+			return fmt.Errorf("%w: directory index too deep", ErrCorruptVolume)
+		}
+		if len(node) < 0x10 {
+			return fmt.Errorf("%w: short index node in MFT record %d", ErrCorruptVolume, n)
+		}
+		start := int(binary.LittleEndian.Uint32(node))
+		end := int(binary.LittleEndian.Uint32(node[4:]))
+		if end > len(node) {
+			end = len(node)
+		}
+		for off := start; off+0x10 <= end; {
+			length := int(binary.LittleEndian.Uint16(node[off+8:]))
+			keyLen := int(binary.LittleEndian.Uint16(node[off+0x0a:]))
+			flags := binary.LittleEndian.Uint32(node[off+0x0c:])
+			if length < 0x10 || off+length > len(node) {
+				return fmt.Errorf("%w: bad index entry in MFT record %d", ErrCorruptVolume, n)
+			}
+			entry := node[off : off+length]
+			if flags&INDEX_ENTRY_NODE != 0 {
+				vcn := int64(binary.LittleEndian.Uint64(entry[length-8:]))
+				if seen[vcn] {
+					return fmt.Errorf("%w: directory index loop in MFT record %d", ErrCorruptVolume, n)
+				}
+				seen[vcn] = true
+				block := make([]byte, blockSize)
+				if _, err := alloc.ReadAt(block, vcn*vcnSize); err != nil {
+					return fmt.Errorf("%w: reading index block: %v", ErrCorruptVolume, err)
+				}
+				if err := fixup(block, "INDX"); err != nil {
+					return err
+				}
+				if err := walk(block[0x18:], depth+1); err != nil {
+					return err
+				}
+			}
+			if flags&INDEX_ENTRY_END != 0 {
+				break
+			}
+			if keyLen >= 0x42 && 0x10+keyLen <= length && 0x42+int(entry[0x10+0x40])*2 <= keyLen {
+				key := entry[0x10 : 0x10+keyLen]
+				//the root directory lists itself as "."
+				if name := decodeName(key[0x42 : 0x42+int(key[0x40])*2]); name != "." {
+					r = append(r, dirEntry{
+						record:  binary.LittleEndian.Uint64(entry) & 0xffffffffffff,
+						name:    name,
+						dir:     binary.LittleEndian.Uint32(key[0x38:])&FILE_NAME_DIRECTORY != 0,
+						size:    int64(binary.LittleEndian.Uint64(key[0x30:])),
+						modTime: winregistry.FiletimeToTime(binary.LittleEndian.Uint64(key[0x10:])),
+						dos:     key[0x41] == NAMESPACE_DOS,
+					})
+				}
+			}
+			off += length
+		}
+		return nil
+	}
+	if err := walk(root.value[0x10:], 0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReadFile reads the whole of a file, implementing fs.ReadFileFS
+func (v *Volume) ReadFile(name string) ([]byte, error) {
+	f, err := v.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file := f.(*File)
+	if file.entry.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	//the size comes from the volume, so check it before allocating for it
+	if file.entry.size < 0 || file.entry.size > file.v.size {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fmt.Errorf("%w: file size %d, volume is %d bytes", ErrCorruptVolume, file.entry.size, file.v.size)}
+	}
+	b := make([]byte, file.entry.size)
+	if _, err := file.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return b, nil
+}
+
+// File is an open file or directory on the volume. Files can be read with ReadAt as well as Read.
+type File struct {
+	v     *Volume
+	entry dirEntry
+	data  stream
+	off   int64
+	//remaining directory entries, for ReadDir
+	dirEntries []dirEntry
+	dirRead    bool
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return fileInfo(f.entry), nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	if f.entry.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.entry.name, Err: errors.New("is a directory")}
+	}
+	n, err := f.data.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.entry.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.entry.name, Err: errors.New("is a directory")}
+	}
+	return f.data.ReadAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.entry.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+// ReadDir lists a directory, implementing fs.ReadDirFile. Sizes are from the directory index, so can be a little out
+// of date.
+func (f *File) ReadDir(n int) (r []fs.DirEntry, err error) {
+	if !f.entry.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: f.entry.name, Err: errors.New("not a directory")}
+	}
+	if !f.dirRead {
+		entries, err := f.v.readDir(f.entry.record)
+		if err != nil {
+			return nil, err
+		}
+		//DOS names are a second entry for the same file
+		for _, e := range entries {
+			if !e.dos {
+				f.dirEntries = append(f.dirEntries, e)
+			}
+		}
+		f.dirRead = true
+	}
+	entries := f.dirEntries
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	f.dirEntries = f.dirEntries[len(entries):]
+	for _, e := range entries {
+		r = append(r, fs.FileInfoToDirEntry(fileInfo(e)))
+	}
+	if n > 0 && len(r) == 0 {
+		return r, io.EOF
+	}
+	return r, nil
+}
+
+// fileInfo implements fs.FileInfo for a directory entry
+type fileInfo dirEntry
+
+func (i fileInfo) Name() string       { return path.Base(i.name) }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
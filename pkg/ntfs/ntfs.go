@@ -0,0 +1,478 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf16"
+)
+
+/*
+Boot sector:
+
+	0x03 OEM ID "NTFS    "
+	0x0b BytesPerSector uint16
+	0x0d SectorsPerCluster uint8 (above 0x80 it's a power of two, 1<<(256-x))
+	0x30 MFT cluster number uint64
+	0x40 MFT record size int8 (clusters, or 1<<-x bytes if negative)
+	0x44 Index block size int8 (same encoding)
+
+Everything on the volume is a file in the MFT, including the MFT itself (record 0) and the root directory (record 5).
+Records are protected by an update sequence: the last two bytes of each 512 byte stride are swapped out for a
+sequence number when written, and have to be put back (and checked) when read.
+*/
+
+const (
+	NTFS_OEM_ID = "NTFS    "
+
+	MFT_RECORD  = 0
+	ROOT_RECORD = 5
+
+	//attribute types
+	ATTR_STANDARD_INFORMATION = 0x10
+	ATTR_ATTRIBUTE_LIST       = 0x20
+	ATTR_FILE_NAME            = 0x30
+	ATTR_DATA                 = 0x80
+	ATTR_INDEX_ROOT           = 0x90
+	ATTR_INDEX_ALLOCATION     = 0xa0
+	ATTR_END                  = 0xffffffff
+
+	//attribute flags
+	ATTR_COMPRESSED = 0x0001
+	ATTR_ENCRYPTED  = 0x4000
+	ATTR_SPARSE     = 0x8000
+
+	//record flags
+	RECORD_IN_USE    = 0x1
+	RECORD_DIRECTORY = 0x2
+)
+
+// Volume is an NTFS volume. It implements fs.FS, with paths relative to the root of the volume (eg
+// Windows/System32/config/SYSTEM). Names are matched case insensitively, like Windows does.
+type Volume struct {
+	r           io.ReaderAt
+	clusterSize int64
+	recordSize  int64
+	indexSize   int64
+	//bytes in the volume, from the sector count in the boot sector (which leaves out the backup boot sector)
+	size int64
+	mft  stream
+}
+
+// New opens the NTFS volume read from r (usually a partition of a disk image)
+func New(r io.ReaderAt) (v *Volume, err error) {
+	boot := make([]byte, 512)
+	if _, err := r.ReadAt(boot, 0); err != nil {
+		return nil, err
+	}
+	if string(boot[3:11]) != NTFS_OEM_ID {
+		return nil, ErrNotNTFS
+	}
+	sectorSize := int64(binary.LittleEndian.Uint16(boot[0x0b:]))
+	spc := int64(boot[0x0d])
+	if spc > 0x80 {
+		spc = 1 << (256 - spc)
+	}
+	v = &Volume{r: r, clusterSize: sectorSize * spc}
+	v.size = (int64(binary.LittleEndian.Uint64(boot[0x28:])) + 1) * sectorSize
+	v.recordSize = v.sizeField(int8(boot[0x40]))
+	v.indexSize = v.sizeField(int8(boot[0x44]))
+	if sectorSize < 256 || sectorSize > 4096 || v.clusterSize < sectorSize || v.clusterSize > 2<<20 || v.recordSize < 512 || v.recordSize > 64<<10 {
+		return nil, fmt.Errorf("%w: bad geometry, %d byte clusters and %d byte records", ErrCorruptVolume, v.clusterSize, v.recordSize)
+	}
+
+	//the MFT describes itself, so read record 0 straight from the start of the MFT to find the rest of it
+	mftStart := int64(binary.LittleEndian.Uint64(boot[0x30:])) * v.clusterSize
+	rec := make([]byte, v.recordSize)
+	if _, err := r.ReadAt(rec, mftStart); err != nil {
+		return nil, err
+	}
+	attrs, err := v.parseRecord(rec, MFT_RECORD)
+	if err != nil {
+		return nil, err
+	}
+	first, ok := findAttr(attrs, ATTR_DATA, "")
+	if !ok {
+		return nil, fmt.Errorf("%w: $MFT has no data", ErrCorruptVolume)
+	}
+	v.mft = v.newStream([]attribute{first})
+	//a fragmented MFT has an attribute list, with the rest of its runs in other records (which can be read with the
+	//runs we have so far)
+	if _, ok := findAttr(attrs, ATTR_ATTRIBUTE_LIST, ""); ok {
+		all, err := v.attributes(MFT_RECORD)
+		if err != nil {
+			return nil, err
+		}
+		v.mft = v.newStream(filterAttrs(all, ATTR_DATA, ""))
+	}
+	return v, nil
+}
+
+// sizeField decodes the record and index block size fields of the boot sector
+func (v *Volume) sizeField(x int8) int64 {
+	if x < 0 {
+		if x < -30 {
+			return 0
+		}
+		return 1 << uint(-x)
+	}
+	return int64(x) * v.clusterSize
+}
+
+// record reads and fixes up MFT record n
+func (v *Volume) record(n uint64) ([]byte, error) {
+	rec := make([]byte, v.recordSize)
+	if _, err := v.mft.ReadAt(rec, int64(n)*v.recordSize); err != nil {
+		return nil, fmt.Errorf("%w: reading MFT record %d: %v", ErrCorruptVolume, n, err)
+	}
+	return rec, nil
+}
+
+// fixup checks and undoes the update sequence of a record or index block
+func fixup(b []byte, magic string) error {
+	if len(b) < 8 || string(b[:4]) != magic {
+		return fmt.Errorf("%w: expected %s record", ErrCorruptVolume, magic)
+	}
+	off := int(binary.LittleEndian.Uint16(b[4:]))
+	count := int(binary.LittleEndian.Uint16(b[6:]))
+	if count < 2 || off+count*2 > len(b) || len(b)%(count-1) != 0 {
+		return fmt.Errorf("%w: bad update sequence in %s record", ErrCorruptVolume, magic)
+	}
+	stride := len(b) / (count - 1)
+	if stride < 2 {
+		return fmt.Errorf("%w: bad update sequence in %s record", ErrCorruptVolume, magic)
+	}
+	for i := 1; i < count; i++ {
+		end := i*stride - 2
+		if b[end] != b[off] || b[end+1] != b[off+1] {
+			return fmt.Errorf("%w: torn %s record (update sequence mismatch)", ErrCorruptVolume, magic)
+		}
+		b[end], b[end+1] = b[off+i*2], b[off+i*2+1]
+	}
+	return nil
+}
+
+// attribute is a parsed attribute header, with the value for resident attributes and the runs for non resident ones
+type attribute struct {
+	typ         uint32
+	name        string
+	id          uint16
+	flags       uint16
+	nonResident bool
+	value       []byte
+	startVCN    int64
+	runs        []run
+	size        int64
+	initSize    int64
+}
+
+// run is a contiguous stretch of clusters. lcn is -1 for sparse runs.
+type run struct {
+	vcn, lcn, length int64
+}
+
+// parseRecord fixes up an MFT record and parses its attributes
+func (v *Volume) parseRecord(rec []byte, n uint64) ([]attribute, error) {
+	if err := fixup(rec, "FILE"); err != nil {
+		return nil, fmt.Errorf("MFT record %d: %w", n, err)
+	}
+	if binary.LittleEndian.Uint16(rec[0x16:])&RECORD_IN_USE == 0 {
+		return nil, fmt.Errorf("%w: MFT record %d not in use", ErrCorruptVolume, n)
+	}
+	r := []attribute{}
+	for off := int(binary.LittleEndian.Uint16(rec[0x14:])); off+8 <= len(rec); {
+		typ := binary.LittleEndian.Uint32(rec[off:])
+		if typ == ATTR_END {
+			break
+		}
+		length := int(binary.LittleEndian.Uint32(rec[off+4:]))
+		if length < 0x18 || off+length > len(rec) {
+			return nil, fmt.Errorf("%w: bad attribute length in MFT record %d", ErrCorruptVolume, n)
+		}
+		a, err := parseAttribute(rec[off : off+length])
+		if err != nil {
+			return nil, fmt.Errorf("MFT record %d: %w", n, err)
+		}
+		r = append(r, a)
+		off += length
+	}
+	return r, nil
+}
+
+func parseAttribute(b []byte) (attribute, error) {
+	a := attribute{
+		typ:         binary.LittleEndian.Uint32(b),
+		nonResident: b[8] != 0,
+		flags:       binary.LittleEndian.Uint16(b[0x0c:]),
+		id:          binary.LittleEndian.Uint16(b[0x0e:]),
+	}
+	if nameLen := int(b[9]); nameLen > 0 {
+		off := int(binary.LittleEndian.Uint16(b[0x0a:]))
+		if off+nameLen*2 > len(b) {
+			return a, fmt.Errorf("%w: attribute name outside of attribute", ErrCorruptVolume)
+		}
+		a.name = decodeName(b[off : off+nameLen*2])
+	}
+	if !a.nonResident {
+		length := int(binary.LittleEndian.Uint32(b[0x10:]))
+		off := int(binary.LittleEndian.Uint16(b[0x14:]))
+		if off+length > len(b) {
+			return a, fmt.Errorf("%w: resident value outside of attribute", ErrCorruptVolume)
+		}
+		a.value = b[off : off+length]
+		a.size = int64(length)
+		a.initSize = a.size
+		return a, nil
+	}
+	if len(b) < 0x40 {
+		return a, fmt.Errorf("%w: short non resident attribute", ErrCorruptVolume)
+	}
+	a.startVCN = int64(binary.LittleEndian.Uint64(b[0x10:]))
+	a.size = int64(binary.LittleEndian.Uint64(b[0x30:]))
+	a.initSize = int64(binary.LittleEndian.Uint64(b[0x38:]))
+	runs := int(binary.LittleEndian.Uint16(b[0x20:]))
+	if runs > len(b) {
+		return a, fmt.Errorf("%w: data runs outside of attribute", ErrCorruptVolume)
+	}
+	var err error
+	a.runs, err = decodeRuns(b[runs:], a.startVCN)
+	return a, err
+}
+
+// decodeRuns decodes a mapping pairs array. Each run starts with a byte holding the size of the length (low
+// nibble) and offset (high nibble) fields that follow it. Offsets are signed and relative to the previous run, a run
+// with no offset is sparse.
+func decodeRuns(b []byte, vcn int64) ([]run, error) {
+	r := []run{}
+	lcn := int64(0)
+	for len(b) > 0 && b[0] != 0 {
+		lenSize, offSize := int(b[0]&0xf), int(b[0]>>4)
+		if lenSize == 0 || lenSize > 8 || offSize > 8 || 1+lenSize+offSize > len(b) {
+			return nil, fmt.Errorf("%w: bad data run", ErrCorruptVolume)
+		}
+		length := int64(0)
+		for i := lenSize; i > 0; i-- {
+			length = length<<8 | int64(b[i])
+		}
+		rn := run{vcn: vcn, lcn: -1, length: length}
+		if offSize > 0 {
+			delta := int64(int8(b[lenSize+offSize])) //sign extend the top byte
+			for i := lenSize + offSize - 1; i > lenSize; i-- {
+				delta = delta<<8 | int64(b[i])
+			}
+			lcn += delta
+			if lcn < 0 {
+				return nil, fmt.Errorf("%w: data run before the start of the volume", ErrCorruptVolume)
+			}
+			rn.lcn = lcn
+		}
+		if length <= 0 {
+			return nil, fmt.Errorf("%w: empty data run", ErrCorruptVolume)
+		}
+		r = append(r, rn)
+		vcn += length
+		b = b[1+lenSize+offSize:]
+	}
+	return r, nil
+}
+
+func decodeName(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func findAttr(attrs []attribute, typ uint32, name string) (attribute, bool) {
+	for _, a := range attrs {
+		if a.typ == typ && a.name == name {
+			return a, true
+		}
+	}
+	return attribute{}, false
+}
+
+// filterAttrs returns all the pieces of an attribute, in VCN order
+func filterAttrs(attrs []attribute, typ uint32, name string) []attribute {
+	r := []attribute{}
+	for _, a := range attrs {
+		if a.typ == typ && a.name == name {
+			r = append(r, a)
+		}
+	}
+	sort.SliceStable(r, func(i, j int) bool { return r[i].startVCN < r[j].startVCN })
+	return r
+}
+
+// attributes returns every attribute of the file in MFT record n, following the attribute list into extension
+// records if there is one
+func (v *Volume) attributes(n uint64) ([]attribute, error) {
+	rec, err := v.record(n)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := v.parseRecord(rec, n)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := findAttr(attrs, ATTR_ATTRIBUTE_LIST, "")
+	if !ok {
+		return attrs, nil
+	}
+	data, err := v.readAll(v.newStream([]attribute{list}))
+	if err != nil {
+		return nil, err
+	}
+	/*
+		attribute list entry:
+			0x00 Type uint32
+			0x04 Length uint16
+			0x08 StartingVCN uint64
+			0x10 File reference uint64 (record number in the low 48 bits)
+			0x18 Attribute ID uint16
+	*/
+	r := []attribute{}
+	loaded := map[uint64][]attribute{n: attrs}
+	for off := 0; off+0x1a <= len(data); {
+		length := int(binary.LittleEndian.Uint16(data[off+4:]))
+		if length < 0x1a {
+			return nil, fmt.Errorf("%w: bad attribute list entry in MFT record %d", ErrCorruptVolume, n)
+		}
+		typ := binary.LittleEndian.Uint32(data[off:])
+		ref := binary.LittleEndian.Uint64(data[off+0x10:]) & 0xffffffffffff
+		id := binary.LittleEndian.Uint16(data[off+0x18:])
+		off += length
+		ext, ok := loaded[ref]
+		if !ok {
+			rec, err := v.record(ref)
+			if err != nil {
+				return nil, err
+			}
+			if ext, err = v.parseRecord(rec, ref); err != nil {
+				return nil, err
+			}
+			loaded[ref] = ext
+		}
+		for _, a := range ext {
+			if a.typ == typ && a.id == id {
+				r = append(r, a)
+				break
+			}
+		}
+	}
+	return r, nil
+}
+
+// readAll reads a whole (small) stream, like an attribute list or directory index
+func (v *Volume) readAll(s stream) ([]byte, error) {
+	if s.size > 256<<20 {
+		return nil, fmt.Errorf("%w: %d byte metadata stream", ErrCorruptVolume, s.size)
+	}
+	b := make([]byte, s.size)
+	if _, err := s.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+// stream is the content of an attribute, put back together from its pieces
+type stream struct {
+	v        *Volume
+	resident []byte
+	runs     []run
+	size     int64
+	initSize int64
+	flags    uint16
+}
+
+func (v *Volume) newStream(pieces []attribute) stream {
+	s := stream{v: v}
+	if len(pieces) == 0 {
+		return s
+	}
+	first := pieces[0]
+	s.flags = first.flags
+	s.size, s.initSize = first.size, first.initSize
+	if !first.nonResident {
+		s.resident = first.value
+		return s
+	}
+	for _, p := range pieces {
+		s.runs = append(s.runs, p.runs...)
+	}
+	return s
+}
+
+// ReadAt reads from the stream. Sparse runs and anything past the initialised size read as zeros.
+func (s stream) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	short := false
+	if int64(len(p)) > s.size-off {
+		p = p[:s.size-off]
+		short = true
+	}
+	if s.resident != nil {
+		n := copy(p, s.resident[off:])
+		if n < len(p) || short {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	if s.flags&(ATTR_COMPRESSED|ATTR_ENCRYPTED) != 0 {
+		return 0, fmt.Errorf("%w: compressed or encrypted data", ErrUnsupported)
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		chunk := p[n:]
+		if pos >= s.initSize {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+			n += len(chunk)
+			break
+		}
+		if int64(len(chunk)) > s.initSize-pos {
+			chunk = chunk[:s.initSize-pos]
+		}
+		vcn := pos / s.v.clusterSize
+		i := sort.Search(len(s.runs), func(i int) bool { return s.runs[i].vcn+s.runs[i].length > vcn })
+		if i == len(s.runs) || s.runs[i].vcn > vcn {
+			return n, fmt.Errorf("%w: no data run for cluster %d", ErrCorruptVolume, vcn)
+		}
+		rn := s.runs[i]
+		inRun := pos - rn.vcn*s.v.clusterSize
+		left := rn.length*s.v.clusterSize - inRun
+		if left <= 0 {
+			return n, fmt.Errorf("%w: bad data run for cluster %d", ErrCorruptVolume, vcn)
+		}
+		if int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		if rn.lcn < 0 {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else {
+			m, err := s.v.r.ReadAt(chunk, rn.lcn*s.v.clusterSize+inRun)
+			if m < len(chunk) {
+				if err == nil || err == io.EOF {
+					err = fmt.Errorf("%w: data run past the end of the volume", ErrCorruptVolume)
+				}
+				return n + m, err
+			}
+		}
+		n += len(chunk)
+	}
+	if short {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,11 @@
+package samreader
+
+import "errors"
+
+var (
+	// ErrUnsupportedRevision is returned for SAM key data revisions other than 2 (RC4) and 3 (AES)
+	ErrUnsupportedRevision = errors.New("unsupported SAM key revision")
+	// ErrBadChecksum is returned when the hashed bootkey doesn't match its checksum, which means the bootkey is
+	// wrong (or a syskey startup password/key is in use)
+	ErrBadChecksum = errors.New("hashed bootkey checksum failed")
+)
@@ -7,6 +7,8 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
 
@@ -17,47 +19,111 @@ import (
 	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
 )
 
+//Options changes how the SAM is read
+type Options struct {
+	//System overrides what's read from the SYSTEM hive: the control set, and the bootkey for syskey startup modes
+	System systemreader.Options
+	//IncludeDeleted also dumps users carved out of unallocated hive space (offline hives only)
+	IncludeDeleted bool
+}
+
 //New Creates a new dit dumper
-func New(system, sam string) (SamReader, error) {
-	r := SamReader{
-		noLMHash:           true,
-		remoteOps:          "",
-		samLoc:             sam,
-		systemHiveLocation: system,
-		//db:                 esent.Esedb{}.Init(ntds),
-		userData: make(chan ditreader.DumpedHash, 500),
+func New(system, sam string, opts ...Options) (SamReader, error) {
+	if system == "" {
+		return SamReader{}, fmt.Errorf("System hive empty")
 	}
-	var err error
-	r.registry, err = winregistry.InitOffline(sam)
-	if r.systemHiveLocation != "" {
-		ls, err := systemreader.New(r.systemHiveLocation)
-		if err != nil {
-			return r, err
-		}
-		r.bootKey = ls.BootKey()
-		r.noLMHash = ls.HasNoLMHashPolicy()
-	} else {
-		return r, fmt.Errorf("System hive empty")
+	samReg, err := winregistry.InitOffline(sam)
+	if err != nil {
+		return SamReader{}, err
 	}
+	ls, err := systemreader.New(system)
+	if err != nil {
+		return SamReader{}, err
+	}
+	r, err := fromHives(&ls, samReg, opts)
+	r.samLoc = sam
+	r.systemHiveLocation = system
 	return r, err
 }
 
-func NewLive() (SamReader, error) {
-	r := SamReader{
-		noLMHash:  true,
-		remoteOps: "",
-		userData:  make(chan ditreader.DumpedHash, 500),
+//NewReader creates a SamReader for SYSTEM and SAM hives read from io.ReaderAts (of the given sizes) rather than files
+func NewReader(system io.ReaderAt, systemSize int64, sam io.ReaderAt, samSize int64, opts ...Options) (SamReader, error) {
+	samReg, err := winregistry.InitReader(sam, samSize)
+	if err != nil {
+		return SamReader{}, err
 	}
-	var err error
-	r.registry, err = winregistry.InitLive("SAM")
+	ls, err := systemreader.NewReader(system, systemSize)
+	if err != nil {
+		return SamReader{}, err
+	}
+	return fromHives(&ls, samReg, opts)
+}
 
+//NewFS creates a SamReader for the SYSTEM and SAM hives called system and sam in fsys
+func NewFS(fsys fs.FS, system, sam string, opts ...Options) (SamReader, error) {
+	samReg, err := winregistry.InitFS(fsys, sam)
+	if err != nil {
+		return SamReader{}, err
+	}
+	ls, err := systemreader.NewFS(fsys, system)
+	if err != nil {
+		return SamReader{}, err
+	}
+	return fromHives(&ls, samReg, opts)
+}
+
+func NewLive(opts ...Options) (SamReader, error) {
+	sam, err := winregistry.InitLive("SAM")
+	if err != nil {
+		return SamReader{}, err
+	}
 	ls, err := systemreader.NewLive()
+	if err != nil {
+		return SamReader{}, err
+	}
+	return fromHives(&ls, sam, opts)
+}
+
+//fromHives sets up a SamReader once the hives are open, getting the bootkey and LM policy from SYSTEM
+func fromHives(ls *systemreader.SystemReader, sam winregistry.WinRegIF, opts []Options) (SamReader, error) {
+	r := SamReader{
+		noLMHash: true,
+		registry: sam,
+		system:   ls,
+		userData: make(chan ditreader.DumpedHash, 500),
+	}
+	if len(opts) > 0 {
+		if err := ls.Use(opts[0].System); err != nil {
+			return r, err
+		}
+	}
+	bk, err := ls.BootKey()
 	if err != nil {
 		return r, err
 	}
-	r.bootKey = ls.BootKey()
-	r.noLMHash = ls.HasNoLMHashPolicy()
-	return r, err
+	r.bootKey = bk
+	if r.noLMHash, err = ls.HasNoLMHashPolicy(); err != nil {
+		return r, err
+	}
+	if len(opts) > 0 {
+		r.includeDeleted = opts[0].IncludeDeleted
+	}
+	return r, nil
+}
+
+//FromRegistry creates a SamReader for an already opened SAM hive, using a known bootkey and LM policy. Only
+//IncludeDeleted is used from the options.
+func FromRegistry(sam winregistry.WinRegIF, bootKey []byte, noLMHash bool, opts ...Options) SamReader {
+	r := SamReader{
+		bootKey:  bootKey,
+		noLMHash: noLMHash,
+		registry: sam,
+		userData: make(chan ditreader.DumpedHash, 500),
+	}
+	if len(opts) > 0 {
+		r.includeDeleted = opts[0].IncludeDeleted
+	}
+	return r
 }
 
 type SamReader struct {
@@ -68,7 +134,9 @@ type SamReader struct {
 	samLoc             string
 	systemHiveLocation string
 	registry           winregistry.WinRegIF
+	system             *systemreader.SystemReader
 	userData           chan ditreader.DumpedHash
+	includeDeleted     bool
 }
 
 func (d *SamReader) dump() {
@@ -80,6 +148,15 @@ func (d SamReader) GetOutChan() <-chan ditreader.DumpedHash {
 	return d.userData
 }
 
+//Recoveries reports the hives that had transaction logs replayed when they were opened
+func (d SamReader) Recoveries() []winregistry.Recovery {
+	r := winregistry.Recoveries(d.registry)
+	if d.system != nil {
+		r = append(d.system.Recoveries(), r...)
+	}
+	return r
+}
+
 type SAMKeyData struct {
 	Revision uint32 //2
 	Length   uint32
@@ -139,49 +216,51 @@ func NewF(b []byte) Domain_Account_F {
 	return r
 }
 
-func (d SamReader) SysKey() []byte {
-	r := []byte{}
+//SysKey returns the hashed bootkey, which the SAM hashes are encrypted with
+func (d SamReader) SysKey() ([]byte, error) {
 	_, fraw, err := d.registry.GetVal("\\SAM\\Domains\\Account\\F")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	f := NewF(fraw)
 	if f.Revision == 3 {
 		aesStruct := SAMKeyDataAES{}
-		binary.Read(bytes.NewReader(f.Data), binary.LittleEndian, &aesStruct)
+		if err := binary.Read(bytes.NewReader(f.Data), binary.LittleEndian, &aesStruct); err != nil {
+			return nil, fmt.Errorf("Bad SAM key data: %s", err)
+		}
+		if aesStruct.DataLen < 16 || aesStruct.DataLen > uint32(len(aesStruct.Data)) || aesStruct.DataLen%16 != 0 {
+			return nil, fmt.Errorf("Bad SAM key data length %d", aesStruct.DataLen)
+		}
 		iv := aesStruct.Salt[:]
 		cipher := aesStruct.Data[:aesStruct.DataLen]
 		b, e := ditreader.DecryptAES(d.bootKey, cipher, iv)
 		if e != nil {
-			panic(e)
+			return nil, e
 		}
-		return b[:16]
+		return b[:16], nil
 	} else if f.Revision == 2 {
 		rc4struct := SAMKeyData{}
-		binary.Read(bytes.NewReader(f.Data), binary.LittleEndian, &rc4struct)
+		if err := binary.Read(bytes.NewReader(f.Data), binary.LittleEndian, &rc4struct); err != nil {
+			return nil, fmt.Errorf("Bad SAM key data: %s", err)
+		}
 		hashdata := append(rc4struct.Salt[:], qwertyconst...)
 		hashdata = append(hashdata, d.bootKey...)
 		hashdata = append(hashdata, digitconst...)
 		rc4Key := md5.Sum(hashdata)
 		rc4life, e := rc4.NewCipher(rc4Key[:])
 		if e != nil {
-			panic(e)
+			return nil, e
 		}
 		d := make([]byte, 32)
 		rc4life.XORKeyStream(d, append(rc4struct.Key[:], rc4struct.Checksum[:]...))
-		//todo do checksum
-		//impacket:
-		/*
-		   # Verify key with checksum
-		   checkSum = self.MD5( self.__hashedBootKey[:16] + DIGITS + self.__hashedBootKey[:16] + QWERTY)
-		   if checkSum != self.__hashedBootKey[16:]:
-		       raise Exception('hashedBootKey CheckSum failed, Syskey startup password probably in use! :(')
-		*/
-		return d[:16]
-	} else {
-		panic("not yet implemented")
+		//verify the key with the checksum, a wrong bootkey gives garbage rather than an error otherwise
+		checksum := md5.Sum(append(append(append(append([]byte{}, d[:16]...), digitconst...), d[:16]...), qwertyconst...))
+		if !bytes.Equal(checksum[:], d[16:]) {
+			return nil, fmt.Errorf("%w, wrong bootkey or a syskey startup password is in use", ErrBadChecksum)
+		}
+		return d[:16], nil
 	}
-	return r
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedRevision, f.Revision)
 }
 
 var qwertyconst = []byte("!@#$%^&*()qwertyUIOPAzxcvbnmQQQQQQQQQQQQ)(*@&%\x00")
@@ -194,12 +273,115 @@ func (d SamReader) parseV(i uint32) (User_Account_V, error) {
 	key := fmt.Sprintf("\\SAM\\Domains\\Account\\Users\\%s\\V", strings.ToUpper(hex.EncodeToString(b)))
 	_, vraw, err := d.registry.GetVal(key)
 	if err != nil {
-		return User_Account_V{}, fmt.Errorf("Bad result: %s (%s) %d", err, key, i)
+		return User_Account_V{}, fmt.Errorf("Bad result: %w (%s) %d", err, key, i)
 	}
 
 	return newV(vraw), nil
 }
 
+func (d SamReader) parseF(i uint32) (User_Account_F, error) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+	key := fmt.Sprintf("\\SAM\\Domains\\Account\\Users\\%s\\F", strings.ToUpper(hex.EncodeToString(b)))
+	_, fraw, err := d.registry.GetVal(key)
+	if err != nil {
+		return User_Account_F{}, fmt.Errorf("Bad result: %w (%s) %d", err, key, i)
+	}
+	return newUserF(fraw)
+}
+
+// User_Account_F is the fixed length part of a user (SAMP_V1_0A_FIXED_LENGTH_USER). Times are FILETIMEs.
+type User_Account_F struct {
+	Revision        uint32
+	_               uint32
+	LastLogon       uint64
+	LastLogoff      uint64
+	PwdLastSet      uint64
+	AccountExpires  uint64
+	LastBadPassword uint64
+	Rid             uint32
+	PrimaryGroupID  uint32
+	ACB             uint32
+	CountryCode     uint16
+	CodePage        uint16
+	BadPwdCount     uint16
+	LogonCount      uint16
+}
+
+func newUserF(d []byte) (User_Account_F, error) {
+	r := User_Account_F{}
+	if err := binary.Read(bytes.NewReader(d), binary.LittleEndian, &r); err != nil {
+		return r, fmt.Errorf("Bad user F value (%d bytes): %s", len(d), err)
+	}
+	return r, nil
+}
+
+// SAM account control bits (ACB_*), as stored in the F value
+const (
+	ACB_DISABLED                  = 0x1
+	ACB_HOMDIRREQ                 = 0x2
+	ACB_PWNOTREQ                  = 0x4
+	ACB_TEMPDUP                   = 0x8
+	ACB_NORMAL                    = 0x10
+	ACB_MNS                       = 0x20
+	ACB_DOMTRUST                  = 0x40
+	ACB_WSTRUST                   = 0x80
+	ACB_SVRTRUST                  = 0x100
+	ACB_PWNOEXP                   = 0x200
+	ACB_AUTOLOCK                  = 0x400
+	ACB_ENC_TXT_PWD_ALLOWED       = 0x800
+	ACB_SMARTCARD_REQUIRED        = 0x1000
+	ACB_TRUSTED_FOR_DELEGATION    = 0x2000
+	ACB_NOT_DELEGATED             = 0x4000
+	ACB_USE_DES_KEY_ONLY          = 0x8000
+	ACB_DONT_REQUIRE_PREAUTH      = 0x10000
+	ACB_PW_EXPIRED                = 0x20000
+	ACB_TRUSTED_TO_AUTH_FOR_DELEG = 0x40000
+	ACB_NO_AUTH_DATA_REQD         = 0x80000
+	ACB_PARTIAL_SECRETS_ACCOUNT   = 0x100000
+	ACB_USE_AES_KEYS              = 0x200000
+)
+
+// acbToUAC maps the ACB bits onto their userAccountControl equivalents, so SAM and NTDS output can be treated the same
+var acbToUAC = []struct {
+	acb uint32
+	uac ditreader.UACFlags
+}{
+	{ACB_DISABLED, ditreader.UF_ACCOUNTDISABLE},
+	{ACB_HOMDIRREQ, ditreader.UF_HOMEDIR_REQUIRED},
+	{ACB_PWNOTREQ, ditreader.UF_PASSWD_NOTREQD},
+	{ACB_TEMPDUP, ditreader.UF_TEMP_DUPLICATE_ACCOUNT},
+	{ACB_NORMAL, ditreader.UF_NORMAL_ACCOUNT},
+	{ACB_MNS, ditreader.UF_MNS_LOGON_ACCOUNT},
+	{ACB_DOMTRUST, ditreader.UF_INTERDOMAIN_TRUST_ACCOUNT},
+	{ACB_WSTRUST, ditreader.UF_WORKSTATION_TRUST_ACCOUNT},
+	{ACB_SVRTRUST, ditreader.UF_SERVER_TRUST_ACCOUNT},
+	{ACB_PWNOEXP, ditreader.UF_DONT_EXPIRE_PASSWORD},
+	{ACB_AUTOLOCK, ditreader.UF_LOCKOUT},
+	{ACB_ENC_TXT_PWD_ALLOWED, ditreader.UF_ENCRYPTED_TEXT_PWD_ALLOWED},
+	{ACB_SMARTCARD_REQUIRED, ditreader.UF_SMARTCARD_REQUIRED},
+	{ACB_TRUSTED_FOR_DELEGATION, ditreader.UF_TRUSTED_FOR_DELEGATION},
+	{ACB_NOT_DELEGATED, ditreader.UF_NOT_DELEGATED},
+	{ACB_USE_DES_KEY_ONLY, ditreader.UF_USE_DES_KEY_ONLY},
+	{ACB_DONT_REQUIRE_PREAUTH, ditreader.UF_DONT_REQUIRE_PREAUTH},
+	{ACB_PW_EXPIRED, ditreader.UF_PASSWORD_EXPIRED},
+	{ACB_TRUSTED_TO_AUTH_FOR_DELEG, ditreader.UF_TRUSTED_TO_AUTH_FOR_DELEGATION},
+	{ACB_NO_AUTH_DATA_REQD, ditreader.UF_NO_AUTH_DATA_REQUIRED},
+	{ACB_PARTIAL_SECRETS_ACCOUNT, ditreader.UF_PARTIAL_SECRETS_ACCOUNT},
+	{ACB_USE_AES_KEYS, ditreader.UF_USE_AES_KEYS},
+}
+
+// UAC returns the account control bits as userAccountControl flags
+func (f User_Account_F) UAC() ditreader.UACFlags {
+	r := ditreader.UACFlags(0)
+	for _, m := range acbToUAC {
+		if f.ACB&m.acb != 0 {
+			r |= m.uac
+		}
+	}
+	return r
+}
+
 type SAMEntry struct {
 	Offset uint32
 	Length uint32
@@ -207,6 +389,10 @@ type SAMEntry struct {
 }
 
 func (s SAMEntry) GetData(b []byte) []byte {
+	if uint64(s.Offset)+uint64(s.Length) > uint64(len(b)) {
+		//corrupt (or truncated) V value, treat it as empty rather than reading past the end
+		return nil
+	}
 	return b[s.Offset : s.Offset+s.Length]
 }
 
@@ -296,49 +482,211 @@ func NewSamHashAES(b []byte) SAMHashAES {
 }
 
 func (d SamReader) Dump() error {
-	boot := d.SysKey()
+	defer close(d.userData)
+	boot, err := d.SysKey()
+	if err != nil {
+		return err
+	}
 	rids, _ := d.GetRids()
 	for _, rid := range rids {
 		v, err := d.parseV(rid)
 		if err != nil {
 			return err
 		}
-		data := v.NTLMHash.GetData(v.Data)
-		if data[0] == 2 {
-			//new style (AES)
-			a := NewSamHashAES(data)
-			if len(a.Hash) > 0 {
-				raw, err := ditreader.DecryptAES(boot, a.Hash, a.Salt[:])
-				if err != nil {
-					return err
+		f, ferr := d.parseF(rid)
+		fp := &f
+		if ferr != nil {
+			fp = nil
+		}
+		dh, err := d.userHashes(rid, v, fp, boot)
+		if err != nil {
+			return err
+		}
+		d.userData <- dh
+	}
+	if d.includeDeleted {
+		if err := d.dumpDeleted(boot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//deletedKeyer is implemented by offline hives, which can carve deleted keys
+type deletedKeyer interface {
+	DeletedKeys() ([]winregistry.DeletedKey, error)
+}
+
+//dumpDeleted sends the users whose keys were carved out of unallocated hive space, flagged as deleted. Anything
+//that has been partially overwritten is skipped.
+func (d SamReader) dumpDeleted(boot []byte) error {
+	dk, ok := d.registry.(deletedKeyer)
+	if !ok {
+		//live registry, nothing to carve
+		return nil
+	}
+	keys, err := dk.DeletedKeys()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		b, err := hex.DecodeString(k.Name)
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		if k.Path != "" && !strings.EqualFold(k.Path, "\\SAM\\Domains\\Account\\Users\\"+k.Name) {
+			continue
+		}
+		var v *User_Account_V
+		var f *User_Account_F
+		for _, val := range k.Values {
+			switch {
+			case val.Name == "V" && val.Data != nil:
+				uv := newV(val.Data)
+				v = &uv
+			case val.Name == "F" && val.Data != nil:
+				if uf, err := newUserF(val.Data); err == nil {
+					f = &uf
 				}
-				data = raw[:16]
-			} else {
-				data = []byte{}
-			}
-		} else {
-			if v.NTLMHash.Length == 20 {
-				sh := SAMHash{}
-				binary.Read(bytes.NewReader(data), binary.LittleEndian, &sh)
-				data = sh.Hash[:]
-			} else {
-				data = []byte{}
 			}
 		}
-		ntlmplain := ditreader.EmptyNT
-		if len(data) > 0 {
-			ntlmplain, err = ditreader.RemoveDES(data, rid)
-			if err != nil {
-				return err
-			}
+		if v == nil {
+			continue
 		}
-		d.userData <- ditreader.DumpedHash{
-			Username: v.UsernameString(),
-			LMHash:   ditreader.EmptyLM,
-			NTHash:   ntlmplain,
-			Rid:      rid,
+		rid := binary.BigEndian.Uint32(b)
+		dh, err := d.userHashes(rid, *v, f, boot)
+		if err != nil {
+			continue
 		}
+		dh.Deleted = true
+		d.userData <- dh
 	}
-	close(d.userData)
 	return nil
 }
+
+//userHashes decrypts the hashes of a user from its V value, and fills in the account info from the F value if
+//there is one
+func (d SamReader) userHashes(rid uint32, v User_Account_V, f *User_Account_F, boot []byte) (ditreader.DumpedHash, error) {
+	var err error
+	dh := ditreader.DumpedHash{
+		Username: v.UsernameString(),
+		LMHash:   ditreader.EmptyLM,
+		NTHash:   ditreader.EmptyNT,
+		Rid:      rid,
+	}
+
+	//account flags and logon metadata. Not fatal if it's missing, the hashes are what we're here for
+	if f != nil {
+		dh.UAC = f.UAC()
+		dh.History.PwdLastSet = winregistry.FiletimeToTime(f.PwdLastSet)
+		dh.Logon = ditreader.LogonInfo{
+			LastLogon:       winregistry.FiletimeToTime(f.LastLogon),
+			LastBadPassword: winregistry.FiletimeToTime(f.LastBadPassword),
+			AccountExpires:  winregistry.FiletimeToTime(f.AccountExpires),
+			BadPwdCount:     uint32(f.BadPwdCount),
+			LogonCount:      uint32(f.LogonCount),
+		}
+	}
+
+	if h, err := d.decryptHash(v.NTLMHash.GetData(v.Data), boot, rid, ntpasswordconst); err != nil {
+		return dh, err
+	} else if h != nil {
+		dh.NTHash = h
+	}
+	if h, err := d.decryptHash(v.LMHash.GetData(v.Data), boot, rid, lmpasswordconst); err != nil {
+		return dh, err
+	} else if h != nil {
+		dh.LMHash = h
+	}
+
+	dh.History.NTHist, err = d.decryptHistory(v.NTLMHistory.GetData(v.Data), boot, rid, nthistoryconst)
+	if err != nil {
+		return dh, err
+	}
+	if !d.noLMHash {
+		dh.History.LmHist, err = d.decryptHistory(v.LMHistory.GetData(v.Data), boot, rid, lmhistoryconst)
+		if err != nil {
+			return dh, err
+		}
+	}
+	return dh, nil
+}
+
+var ntpasswordconst = []byte("NTPASSWORD\x00")
+var lmpasswordconst = []byte("LMPASSWORD\x00")
+var nthistoryconst = []byte("NTPASSWORDHISTORY\x00")
+var lmhistoryconst = []byte("LMPASSWORDHISTORY\x00")
+
+// decryptSAMEntry removes the hashed bootkey layer from a hash or history entry of the V value. Old style entries
+// (revision 1) are RC4 encrypted with a key derived from the RID and a constant, new style entries are AES
+// encrypted with the salt stored alongside them. The result is still DES encrypted with the RID.
+func (d SamReader) decryptSAMEntry(data, hashedBootKey []byte, rid uint32, constant []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, nil
+	}
+	if data[2] == 1 {
+		//old style, PekID + Revision then the hash(es)
+		enc := data[4:]
+		if len(enc) == 0 {
+			return nil, nil
+		}
+		rid4 := make([]byte, 4)
+		binary.LittleEndian.PutUint32(rid4, rid)
+		rc4Key := md5.Sum(append(append(append([]byte{}, hashedBootKey[:16]...), rid4...), constant...))
+		rc, err := rc4.NewCipher(rc4Key[:])
+		if err != nil {
+			return nil, err
+		}
+		plain := make([]byte, len(enc))
+		rc.XORKeyStream(plain, enc)
+		return plain, nil
+	}
+
+	//new style (AES)
+	if len(data) < 24 {
+		return nil, nil
+	}
+	a := NewSamHashAES(data)
+	if len(a.Hash) == 0 {
+		return nil, nil
+	}
+	if len(a.Hash)%16 != 0 {
+		return nil, fmt.Errorf("Bad AES SAM hash length %d", len(a.Hash))
+	}
+	plain, err := ditreader.DecryptAES(hashedBootKey[:16], a.Hash, a.Salt[:])
+	if err != nil {
+		return nil, err
+	}
+	//PKCS7 padding
+	if p := int(plain[len(plain)-1]); p > 0 && p <= 16 && bytes.Equal(plain[len(plain)-p:], bytes.Repeat([]byte{byte(p)}, p)) {
+		plain = plain[:len(plain)-p]
+	}
+	return plain, nil
+}
+
+// decryptHash decrypts the LM or NT hash from the V value. nil is returned if the user doesn't have one.
+func (d SamReader) decryptHash(data, hashedBootKey []byte, rid uint32, constant []byte) ([]byte, error) {
+	plain, err := d.decryptSAMEntry(data, hashedBootKey, rid, constant)
+	if err != nil || len(plain) < 16 {
+		return nil, err
+	}
+	return ditreader.RemoveDES(plain[:16], rid)
+}
+
+// decryptHistory decrypts a password history entry of the V value. The first entry is the current password, which
+// is already reported as the hash itself, so it is not returned (same as ditreader).
+func (d SamReader) decryptHistory(data, hashedBootKey []byte, rid uint32, constant []byte) ([][]byte, error) {
+	plain, err := d.decryptSAMEntry(data, hashedBootKey, rid, constant)
+	if err != nil {
+		return nil, err
+	}
+	r := [][]byte{}
+	for i := 16; i+16 <= len(plain); i += 16 {
+		h, err := ditreader.RemoveDES(plain[i:i+16], rid)
+		if err != nil {
+			return r, err
+		}
+		r = append(r, h)
+	}
+	return r, nil
+}
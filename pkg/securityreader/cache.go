@@ -0,0 +1,222 @@
+package securityreader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// DEFAULT_ITERATIONS is the PBKDF2 iteration count used for DCC2 when NL$IterationCount isn't set
+const DEFAULT_ITERATIONS = 10240
+
+// CachedCredential is a decrypted domain cached logon (Cache\NL$n)
+type CachedCredential struct {
+	Slot      int
+	Username  string
+	Domain    string
+	DNSDomain string
+	UserID    uint32
+	LastWrite time.Time
+	Hash      []byte
+	//false for pre Vista (mscash v1) entries
+	DCC2       bool
+	Iterations int
+}
+
+// String formats the entry the same way secretsdump.py does, ready to crack
+func (c CachedCredential) String() string {
+	if c.DCC2 {
+		return fmt.Sprintf("%s/%s:$DCC2$%d#%s#%s: (%s)", c.DNSDomain, c.Username, c.Iterations, c.Username, hex.EncodeToString(c.Hash), c.LastWrite.Format("2006-01-02 15:04:05"))
+	}
+	return fmt.Sprintf("%s/%s:%s:%s: (%s)", c.DNSDomain, c.Username, hex.EncodeToString(c.Hash), c.Username, c.LastWrite.Format("2006-01-02 15:04:05"))
+}
+
+// DumpedHash converts the entry into the output type shared by all the readers
+func (c CachedCredential) DumpedHash() ditreader.DumpedHash {
+	return ditreader.DumpedHash{
+		Username:   fmt.Sprintf("%s\\%s", c.Domain, c.Username),
+		Rid:        c.UserID,
+		CachedHash: c.String(),
+	}
+}
+
+/*
+NL_RECORD header, the encrypted data follows at offset 96:
+
+	0  UserLength, DomainNameLength, EffectiveNameLength, FullNameLength, LogonScriptName,
+	   ProfilePathLength, HomeDirectoryLength, HomeDirectoryDriveLength uint16
+	16 UserId, PrimaryGroupId, GroupCount uint32
+	28 logonDomainNameLength, unk0 uint16
+	32 LastWrite uint64 (FILETIME)
+	40 Revision, SidCount, Flags, unk1, LogonPackageLength uint32
+	60 DnsDomainNameLength, UPN uint16
+	64 IV [16]byte
+	80 CH [16]byte
+*/
+type nlRecord struct {
+	UserLength          uint16
+	DomainNameLength    uint16
+	UserID              uint32
+	LastWrite           uint64
+	Flags               uint32
+	DnsDomainNameLength uint16
+	IV                  []byte
+	EncryptedData       []byte
+}
+
+const nlRecordHeaderLen = 96
+
+func newNLRecord(b []byte) (nlRecord, error) {
+	r := nlRecord{}
+	if len(b) < nlRecordHeaderLen {
+		return r, fmt.Errorf("Bad NL_RECORD length. Expected x>=%d, got x=%d", nlRecordHeaderLen, len(b))
+	}
+	r.UserLength = binary.LittleEndian.Uint16(b[0:])
+	r.DomainNameLength = binary.LittleEndian.Uint16(b[2:])
+	r.UserID = binary.LittleEndian.Uint32(b[16:])
+	r.LastWrite = binary.LittleEndian.Uint64(b[32:])
+	r.Flags = binary.LittleEndian.Uint32(b[48:])
+	r.DnsDomainNameLength = binary.LittleEndian.Uint16(b[60:])
+	r.IV = b[64:80]
+	r.EncryptedData = b[nlRecordHeaderLen:]
+	return r, nil
+}
+
+// Iterations returns the DCC2 iteration count configured in Cache\NL$IterationCount
+func (d SecurityReader) Iterations() int {
+	_, v, err := d.registry.GetVal("\\Cache\\NL$IterationCount")
+	if err != nil || len(v) < 4 {
+		return DEFAULT_ITERATIONS
+	}
+	c := int(binary.LittleEndian.Uint32(v))
+	if c > 10240 {
+		return c & 0xfffffc00
+	}
+	return c * 1024
+}
+
+// CachedCredentials decrypts the domain cached logons in Cache\NL$1..NL$n using NL$KM
+func (d SecurityReader) CachedCredentials() ([]CachedCredential, error) {
+	if _, _, err := d.registry.GetVal("\\Cache\\NL$1"); errors.Is(err, winregistry.ErrNotFound) {
+		//nothing cached
+		return []CachedCredential{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lsaKey, vista, err := d.LSAKey()
+	if err != nil {
+		return nil, err
+	}
+	nlkm, err := d.secret("NL$KM", false, lsaKey, vista)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get NL$KM: %s", err)
+	}
+	if len(nlkm.Secret) < 32 {
+		return nil, fmt.Errorf("Bad NL$KM length %d", len(nlkm.Secret))
+	}
+	iterations := d.Iterations()
+
+	r := []CachedCredential{}
+	//there isn't a count anywhere, so keep going until an entry is missing
+	for i := 1; ; i++ {
+		_, v, err := d.registry.GetVal(fmt.Sprintf("\\Cache\\NL$%d", i))
+		if err != nil {
+			break
+		}
+		c, ok, err := decryptCacheEntry(v, nlkm.Secret, vista)
+		if err != nil {
+			return r, fmt.Errorf("NL$%d: %s", i, err)
+		}
+		if !ok {
+			continue
+		}
+		c.Slot = i
+		c.Iterations = iterations
+		r = append(r, c)
+	}
+	return r, nil
+}
+
+// decryptCacheEntry decrypts a single NL$n value. ok is false for unused slots.
+func decryptCacheEntry(v, nlkm []byte, vista bool) (c CachedCredential, ok bool, err error) {
+	rec, err := newNLRecord(v)
+	if err != nil {
+		return c, false, err
+	}
+	if bytes.Equal(rec.IV, make([]byte, 16)) {
+		//empty slot
+		return c, false, nil
+	}
+	if rec.Flags&1 == 0 {
+		//not encrypted, no idea what these are so skip them (same as impacket)
+		return c, false, nil
+	}
+
+	var plain []byte
+	if vista {
+		block, err := aes.NewCipher(nlkm[16:32])
+		if err != nil {
+			return c, false, err
+		}
+		ct := make([]byte, len(rec.EncryptedData)+(aes.BlockSize-len(rec.EncryptedData)%aes.BlockSize)%aes.BlockSize)
+		copy(ct, rec.EncryptedData)
+		plain = make([]byte, len(ct))
+		cipher.NewCBCDecrypter(block, rec.IV).CryptBlocks(plain, ct)
+	} else {
+		h := hmac.New(md5.New, nlkm)
+		h.Write(rec.IV)
+		rc, err := rc4.NewCipher(h.Sum(nil))
+		if err != nil {
+			return c, false, err
+		}
+		plain = make([]byte, len(rec.EncryptedData))
+		rc.XORKeyStream(plain, rec.EncryptedData)
+	}
+
+	//hash first, then the names from 0x48
+	if len(plain) < 0x48 {
+		return c, false, fmt.Errorf("Decrypted cache entry too short (%d bytes)", len(plain))
+	}
+	c.Hash = plain[:16]
+	c.DCC2 = vista
+	c.UserID = rec.UserID
+	c.LastWrite = winregistry.FiletimeToTime(rec.LastWrite)
+
+	names := plain[0x48:]
+	c.Username = utf16String(names, 0, int(rec.UserLength))
+	c.Domain = utf16String(names, pad(int(rec.UserLength)), int(rec.DomainNameLength))
+	c.DNSDomain = utf16String(names, pad(int(rec.UserLength))+pad(int(rec.DomainNameLength)), int(rec.DnsDomainNameLength))
+	return c, true, nil
+}
+
+// pad rounds a name length up to the 4 byte alignment used in NL_RECORD (names are UTF-16, so always even)
+func pad(l int) int {
+	return l + (l & 3)
+}
+
+func utf16String(b []byte, offset, length int) string {
+	if offset >= len(b) {
+		return ""
+	}
+	b = b[offset:]
+	if length < len(b) {
+		b = b[:length]
+	}
+	s, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().String(string(b))
+	if err != nil {
+		return string(b)
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package securityreader
+
+import (
+	"crypto/aes"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+// sha256Rounds is sha256(key + value*rounds), used to derive the AES keys for LSA secrets
+func sha256Rounds(key, value []byte) []byte {
+	h := sha256.New()
+	h.Write(key)
+	for i := 0; i < 1000; i++ {
+		h.Write(value)
+	}
+	return h.Sum(nil)
+}
+
+// rc4md5 decrypts data with RC4, keyed with md5(key + material*rounds)
+func rc4md5(key, material []byte, rounds int, data []byte) ([]byte, error) {
+	h := md5.New()
+	h.Write(key)
+	for i := 0; i < rounds; i++ {
+		h.Write(material)
+	}
+	rc, err := rc4.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	r := make([]byte, len(data))
+	rc.XORKeyStream(r, data)
+	return r, nil
+}
+
+// decryptAES decrypts LSA data. LSA uses a zero IV, and resets the IV for every block (so it's really ECB). The last
+// block is zero padded if it is short.
+func decryptAES(key, value []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	r := make([]byte, 0, len(value)+aes.BlockSize)
+	buf := make([]byte, aes.BlockSize)
+	for i := 0; i < len(value); i += aes.BlockSize {
+		ct := make([]byte, aes.BlockSize)
+		copy(ct, value[i:])
+		block.Decrypt(buf, ct)
+		r = append(r, buf...)
+	}
+	return r
+}
+
+// decryptSecretXP decrypts a pre Vista secret ([MS-LSAD] 5.1.2). Each 8 byte block is DES encrypted with the next 7
+// bytes of the LSA key, wrapping around when the key runs out.
+func decryptSecretXP(key, value []byte) ([]byte, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("Bad secret length %d", len(value))
+	}
+	size := int(binary.LittleEndian.Uint32(value[:4]))
+	if size > len(value) {
+		return nil, fmt.Errorf("Bad secret size %d for %d bytes of data", size, len(value))
+	}
+	value = value[len(value)-size:]
+
+	plain := []byte{}
+	key0 := key
+	for ; len(value) >= 8; value = value[8:] {
+		c, err := des.NewCipher(ditreader.TransformKey(key0[:7]))
+		if err != nil {
+			return nil, err
+		}
+		p := make([]byte, 8)
+		c.Decrypt(p, value[:8])
+		plain = append(plain, p...)
+		key0 = key0[7:]
+		if len(key0) < 7 {
+			key0 = key[len(key0):]
+		}
+	}
+
+	/*
+		LSA_SECRET_XP:
+			Length  uint32
+			Version uint32
+			Secret  [Length]byte
+	*/
+	if len(plain) < 8 {
+		return nil, fmt.Errorf("Decrypted secret too short (%d bytes)", len(plain))
+	}
+	l := int(binary.LittleEndian.Uint32(plain[:4]))
+	if 8+l > len(plain) {
+		return nil, fmt.Errorf("Bad decrypted secret length %d for %d bytes", l, len(plain))
+	}
+	return plain[8 : 8+l], nil
+}
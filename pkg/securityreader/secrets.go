@@ -0,0 +1,198 @@
+package securityreader
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"golang.org/x/text/encoding/unicode"
+)
+
+type SecretType int
+
+const (
+	SECRET_UNKNOWN SecretType = iota
+	SECRET_MACHINE_ACC
+	SECRET_DPAPI_SYSTEM
+	SECRET_NLKM
+	SECRET_SERVICE
+	SECRET_DEFAULT_PASSWORD
+)
+
+// UNKNOWN_USER is used when the account a password belongs to can't be worked out
+const UNKNOWN_USER = "(Unknown User)"
+
+func secretType(name string) SecretType {
+	n := strings.ToUpper(name)
+	switch {
+	case strings.HasPrefix(n, "$MACHINE.ACC"):
+		return SECRET_MACHINE_ACC
+	case strings.HasPrefix(n, "DPAPI_SYSTEM"):
+		return SECRET_DPAPI_SYSTEM
+	case strings.HasPrefix(n, "NL$KM"):
+		return SECRET_NLKM
+	case strings.HasPrefix(n, "_SC_"):
+		return SECRET_SERVICE
+	case strings.HasPrefix(n, "DEFAULTPASSWORD"):
+		return SECRET_DEFAULT_PASSWORD
+	}
+	return SECRET_UNKNOWN
+}
+
+// LSASecret is a single decrypted secret from Policy\Secrets
+type LSASecret struct {
+	Name string
+	//true if this is the previous value (OldVal) of the secret
+	History bool
+	Type    SecretType
+	//account a service password belongs to, if known
+	Account string
+	//hostname and DNS domain of the machine, used to name the machine account and salt its kerberos keys
+	Hostname string
+	Domain   string
+	Secret   []byte
+}
+
+// DisplayName is the name of the secret, with _history added for old values
+func (s LSASecret) DisplayName() string {
+	if s.History {
+		return s.Name + "_history"
+	}
+	return s.Name
+}
+
+// Password decodes the secret as a UTF-16 password (services, DefaultPassword, the machine account)
+func (s LSASecret) Password() (string, error) {
+	ud := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	return ud.String(string(s.Secret))
+}
+
+// DPAPIKeys returns the machine and user DPAPI keys from a DPAPI_SYSTEM secret
+func (s LSASecret) DPAPIKeys() (machineKey, userKey []byte, err error) {
+	/*
+		DPAPI_SYSTEM:
+			Version    uint32
+			MachineKey [20]byte
+			UserKey    [20]byte
+	*/
+	if len(s.Secret) < 44 {
+		return nil, nil, fmt.Errorf("Bad DPAPI_SYSTEM length. Expected x>=44, got x=%d", len(s.Secret))
+	}
+	if v := binary.LittleEndian.Uint32(s.Secret[:4]); v != 1 {
+		return nil, nil, fmt.Errorf("Unknown DPAPI_SYSTEM version %d", v)
+	}
+	return s.Secret[4:24], s.Secret[24:44], nil
+}
+
+// MachineAccount returns DOMAIN\HOST$ for the machine account secret, or $MACHINE.ACC if the names aren't known
+func (s LSASecret) MachineAccount() string {
+	if s.Hostname == "" || s.Domain == "" {
+		return "$MACHINE.ACC"
+	}
+	return fmt.Sprintf("%s\\%s$", s.Domain, s.Hostname)
+}
+
+// NTHash is the NT hash of the secret. The machine account password is raw bytes rather than a string, so the hash
+// is just MD4 of the secret.
+func (s LSASecret) NTHash() []byte {
+	return kdf.MD4(s.Secret)
+}
+
+// KerberosSalt is the salt used for machine account AES keys: DOMAIN.LOCALhosthostname.domain.local
+func (s LSASecret) KerberosSalt() string {
+	if s.Hostname == "" || s.Domain == "" {
+		return ""
+	}
+	return fmt.Sprintf("%shost%s.%s", strings.ToUpper(s.Domain), strings.ToLower(s.Hostname), strings.ToLower(s.Domain))
+}
+
+// AESKeys derives the aes256-cts-hmac-sha1-96 and aes128-cts-hmac-sha1-96 keys for the machine account. The password
+// is random UTF-16 (not always valid), which Windows converts to UTF-8 with replacement characters first.
+func (s LSASecret) AESKeys() (aes256, aes128 []byte, err error) {
+	salt := s.KerberosSalt()
+	if salt == "" {
+		return nil, nil, fmt.Errorf("Can't derive kerberos keys without the hostname and domain")
+	}
+	u := make([]uint16, len(s.Secret)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(s.Secret[i*2:])
+	}
+	password := string(utf16.Decode(u))
+	if len(s.Secret)%2 != 0 {
+		//a trailing half code unit is replaced too
+		password += "\uFFFD"
+	}
+	aes256, err = kdf.AESStringToKey([]byte(password), []byte(salt), kdf.AES_ITERATIONS, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	aes128, err = kdf.AESStringToKey([]byte(password), []byte(salt), kdf.AES_ITERATIONS, 16)
+	return aes256, aes128, err
+}
+
+// kerbKeys formats the machine account kerberos keys the same way ditreader does for users
+func (s LSASecret) kerbKeys() []string {
+	aes256, aes128, err := s.AESKeys()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("%s:aes256-cts-hmac-sha1-96:%s", s.MachineAccount(), hex.EncodeToString(aes256)),
+		fmt.Sprintf("%s:aes128-cts-hmac-sha1-96:%s", s.MachineAccount(), hex.EncodeToString(aes128)),
+	}
+}
+
+// String formats the secret the same way secretsdump.py does
+func (s LSASecret) String() string {
+	switch s.Type {
+	case SECRET_SERVICE, SECRET_DEFAULT_PASSWORD:
+		if p, err := s.Password(); err == nil {
+			account := s.Account
+			if account == "" {
+				account = UNKNOWN_USER
+			}
+			return fmt.Sprintf("%s:%s", account, p)
+		}
+	case SECRET_DPAPI_SYSTEM:
+		if m, u, err := s.DPAPIKeys(); err == nil {
+			return fmt.Sprintf("dpapi_machinekey:0x%s\ndpapi_userkey:0x%s", hex.EncodeToString(m), hex.EncodeToString(u))
+		}
+	case SECRET_NLKM:
+		return fmt.Sprintf("NL$KM:%s", hex.EncodeToString(s.Secret))
+	case SECRET_MACHINE_ACC:
+		lines := []string{fmt.Sprintf("%s:%s:%s:::", s.MachineAccount(), hex.EncodeToString(ditreader.EmptyLM), hex.EncodeToString(s.NTHash()))}
+		lines = append(lines, s.kerbKeys()...)
+		lines = append(lines, fmt.Sprintf("%s:plain_password_hex:%s", s.MachineAccount(), hex.EncodeToString(s.Secret)))
+		return strings.Join(lines, "\n")
+	}
+	//no idea what it is, just print it
+	return fmt.Sprintf("%s:%s", s.DisplayName(), hex.EncodeToString(s.Secret))
+}
+
+// DumpedHash converts the secret into the output type shared by all the readers. Passwords are put in Supp, the
+// formatted secret is in Secret.
+func (s LSASecret) DumpedHash() ditreader.DumpedHash {
+	dh := ditreader.DumpedHash{
+		Username: s.DisplayName(),
+		Secret:   fmt.Sprintf("[%s]\n%s", s.DisplayName(), s.String()),
+	}
+	switch s.Type {
+	case SECRET_SERVICE, SECRET_DEFAULT_PASSWORD:
+		if p, err := s.Password(); err == nil {
+			dh.Username = s.Account
+			dh.LMHash = ditreader.EmptyLM
+			dh.NTHash = kdf.NTHash(p)
+			dh.Supp = ditreader.SuppInfo{Username: s.Account, ClearPassword: p}
+		}
+	case SECRET_MACHINE_ACC:
+		dh.Username = s.MachineAccount()
+		dh.LMHash = ditreader.EmptyLM
+		dh.NTHash = s.NTHash()
+		dh.Supp = ditreader.SuppInfo{Username: s.MachineAccount(), KerbKeys: s.kerbKeys()}
+	}
+	return dh
+}
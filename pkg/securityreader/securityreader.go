@@ -0,0 +1,318 @@
+package securityreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// Options changes how the SECURITY hive is read
+type Options struct {
+	//System overrides what's read from the SYSTEM hive: the control set, and the bootkey for syskey startup modes
+	System systemreader.Options
+}
+
+// New creates a SecurityReader for offline SYSTEM and SECURITY hives
+func New(system, security string, opts ...Options) (SecurityReader, error) {
+	if system == "" {
+		return SecurityReader{}, fmt.Errorf("System hive empty")
+	}
+	reg, err := winregistry.InitOffline(security)
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	ls, err := systemreader.New(system)
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	return fromHives(&ls, reg, opts)
+}
+
+// NewReader creates a SecurityReader for SYSTEM and SECURITY hives read from io.ReaderAts (of the given sizes)
+func NewReader(system io.ReaderAt, systemSize int64, security io.ReaderAt, securitySize int64, opts ...Options) (SecurityReader, error) {
+	reg, err := winregistry.InitReader(security, securitySize)
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	ls, err := systemreader.NewReader(system, systemSize)
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	return fromHives(&ls, reg, opts)
+}
+
+// NewFS creates a SecurityReader for the SYSTEM and SECURITY hives called system and security in fsys
+func NewFS(fsys fs.FS, system, security string, opts ...Options) (SecurityReader, error) {
+	reg, err := winregistry.InitFS(fsys, security)
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	ls, err := systemreader.NewFS(fsys, system)
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	return fromHives(&ls, reg, opts)
+}
+
+// NewLive creates a SecurityReader for the hives of the machine it is running on (needs SYSTEM privs)
+func NewLive(opts ...Options) (SecurityReader, error) {
+	reg, err := winregistry.InitLive("SECURITY")
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	ls, err := systemreader.NewLive()
+	if err != nil {
+		return SecurityReader{}, err
+	}
+	return fromHives(&ls, reg, opts)
+}
+
+// fromHives sets up a SecurityReader once the hives are open. Only the first of opts is used.
+func fromHives(ls *systemreader.SystemReader, security winregistry.WinRegIF, opts []Options) (SecurityReader, error) {
+	r := SecurityReader{
+		registry: security,
+		system:   ls,
+		userData: make(chan ditreader.DumpedHash, 500),
+	}
+	if len(opts) > 0 {
+		if err := ls.Use(opts[0].System); err != nil {
+			return r, err
+		}
+	}
+	var err error
+	r.bootKey, err = ls.BootKey()
+	return r, err
+}
+
+// FromRegistry creates a SecurityReader for an already opened SECURITY hive, using a known bootkey. Service
+// accounts can't be resolved without the SYSTEM hive, so they are reported as (Unknown User).
+func FromRegistry(security winregistry.WinRegIF, bootKey []byte) SecurityReader {
+	return SecurityReader{
+		bootKey:  bootKey,
+		registry: security,
+		userData: make(chan ditreader.DumpedHash, 500),
+	}
+}
+
+type SecurityReader struct {
+	bootKey    []byte
+	lsaKey     []byte
+	vistaStyle bool
+	registry   winregistry.WinRegIF
+	system     *systemreader.SystemReader
+	userData   chan ditreader.DumpedHash
+}
+
+// GetOutChan returns a reference to the objects output channel for read only operations
+func (d SecurityReader) GetOutChan() <-chan ditreader.DumpedHash {
+	return d.userData
+}
+
+// Recoveries reports the hives that had transaction logs replayed when they were opened
+func (d SecurityReader) Recoveries() []winregistry.Recovery {
+	r := winregistry.Recoveries(d.registry)
+	if d.system != nil {
+		r = append(d.system.Recoveries(), r...)
+	}
+	return r
+}
+
+// Dump decrypts every LSA secret and cached domain logon and sends them to the output channel
+func (d SecurityReader) Dump() error {
+	defer close(d.userData)
+	secrets, err := d.LSASecrets()
+	if err != nil {
+		return err
+	}
+	for _, s := range secrets {
+		d.userData <- s.DumpedHash()
+	}
+
+	//the secrets have already been sent, so a problem with the cache doesn't lose them
+	cached, err := d.CachedCredentials()
+	for _, c := range cached {
+		d.userData <- c.DumpedHash()
+	}
+	if err != nil {
+		return ditreader.Incomplete([]error{fmt.Errorf("couldn't get cached credentials: %w", err)})
+	}
+	return nil
+}
+
+// LSAKey returns the decrypted LSA key, and whether it is a Vista+ (AES) key
+func (d *SecurityReader) LSAKey() ([]byte, bool, error) {
+	if d.lsaKey != nil {
+		return d.lsaKey, d.vistaStyle, nil
+	}
+	//post XP
+	_, val, err := d.registry.GetVal("\\Policy\\PolEKList\\default")
+	if err == nil {
+		rec, err := newLSASecret(val)
+		if err != nil {
+			return nil, false, err
+		}
+		plain := decryptAES(sha256Rounds(d.bootKey, rec.EncryptedData[:32]), rec.EncryptedData[32:])
+		blob := newLSASecretBlob(plain)
+		if len(blob.Secret) < 52+32 {
+			return nil, false, fmt.Errorf("Bad LSA key blob length %d", len(blob.Secret))
+		}
+		d.lsaKey = blob.Secret[52:][:32]
+		d.vistaStyle = true
+		return d.lsaKey, true, nil
+	}
+
+	//second chance, XP and older
+	_, val, err = d.registry.GetVal("\\Policy\\PolSecretEncryptionKey\\default")
+	if err != nil {
+		return nil, false, fmt.Errorf("No LSA key found (PolEKList or PolSecretEncryptionKey): %s", err)
+	}
+	if len(val) < 76 {
+		return nil, false, fmt.Errorf("Bad PolSecretEncryptionKey length %d", len(val))
+	}
+	plain, err := rc4md5(d.bootKey, val[60:76], 1000, val[12:60])
+	if err != nil {
+		return nil, false, err
+	}
+	d.lsaKey = plain[0x10:0x20]
+	d.vistaStyle = false
+	return d.lsaKey, false, nil
+}
+
+// LSASecrets decrypts every secret under Policy\Secrets. Both the current and old values are returned, old values
+// have History set.
+func (d SecurityReader) LSASecrets() ([]LSASecret, error) {
+	keys, err := d.registry.EnumKeys("\\Policy\\Secrets")
+	if err != nil {
+		return nil, err
+	}
+	lsaKey, vista, err := d.LSAKey()
+	if err != nil {
+		return nil, err
+	}
+
+	r := []LSASecret{}
+	for _, k := range keys {
+		for _, valType := range []string{"CurrVal", "OldVal"} {
+			s, err := d.secret(k, valType == "OldVal", lsaKey, vista)
+			if err != nil {
+				//missing values are normal (no OldVal yet etc)
+				continue
+			}
+			if len(s.Secret) == 0 || bytes.Equal(s.Secret, make([]byte, len(s.Secret))) {
+				//NULL secrets aren't interesting
+				continue
+			}
+			r = append(r, s)
+		}
+	}
+	return r, nil
+}
+
+// Secret decrypts a single LSA secret by name. Set old to get the previous value (OldVal) rather than the current one.
+func (d SecurityReader) Secret(name string, old bool) (LSASecret, error) {
+	lsaKey, vista, err := d.LSAKey()
+	if err != nil {
+		return LSASecret{Name: name, History: old, Type: secretType(name)}, err
+	}
+	return d.secret(name, old, lsaKey, vista)
+}
+
+func (d SecurityReader) secret(name string, old bool, lsaKey []byte, vista bool) (LSASecret, error) {
+	r := LSASecret{Name: name, History: old, Type: secretType(name)}
+	valType := "CurrVal"
+	if old {
+		valType = "OldVal"
+	}
+	_, val, err := d.registry.GetVal(fmt.Sprintf("\\Policy\\Secrets\\%s\\%s\\default", name, valType))
+	if err != nil {
+		return r, err
+	}
+	if len(val) == 0 {
+		return r, nil
+	}
+
+	if vista {
+		rec, err := newLSASecret(val)
+		if err != nil {
+			return r, err
+		}
+		plain := decryptAES(sha256Rounds(lsaKey, rec.EncryptedData[:32]), rec.EncryptedData[32:])
+		r.Secret = newLSASecretBlob(plain).Secret
+	} else {
+		r.Secret, err = decryptSecretXP(lsaKey, val)
+		if err != nil {
+			return r, err
+		}
+	}
+
+	if r.Type == SECRET_SERVICE && d.system != nil {
+		r.Account, _ = d.system.ServiceAccount(name[4:])
+	}
+	if r.Type == SECRET_MACHINE_ACC && d.system != nil {
+		r.Hostname, r.Domain, _ = d.system.TcpipParameters()
+	}
+	return r, nil
+}
+
+/*
+LSA_SECRET:
+
+	Version       uint32
+	EncKeyID      [16]byte
+	EncAlgorithm  uint32
+	Flags         uint32
+	EncryptedData []byte
+*/
+type lsaSecret struct {
+	Version       uint32
+	EncKeyID      [16]byte
+	EncAlgorithm  uint32
+	Flags         uint32
+	EncryptedData []byte
+}
+
+func newLSASecret(b []byte) (lsaSecret, error) {
+	r := lsaSecret{}
+	//header, then at least the 32 bytes used for key derivation
+	if len(b) < 28+32 {
+		return r, fmt.Errorf("Bad LSA_SECRET length. Expected x>=60, got x=%d", len(b))
+	}
+	r.Version = binary.LittleEndian.Uint32(b[:4])
+	copy(r.EncKeyID[:], b[4:20])
+	r.EncAlgorithm = binary.LittleEndian.Uint32(b[20:24])
+	r.Flags = binary.LittleEndian.Uint32(b[24:28])
+	r.EncryptedData = b[28:]
+	return r, nil
+}
+
+/*
+LSA_SECRET_BLOB:
+
+	Length  uint32
+	Unknown [12]byte
+	Secret  [Length]byte
+*/
+type lsaSecretBlob struct {
+	Length uint32
+	Secret []byte
+}
+
+func newLSASecretBlob(b []byte) lsaSecretBlob {
+	r := lsaSecretBlob{}
+	if len(b) < 16 {
+		return r
+	}
+	r.Length = binary.LittleEndian.Uint32(b[:4])
+	end := 16 + int(r.Length)
+	if end > len(b) || end < 16 {
+		end = len(b)
+	}
+	r.Secret = b[16:end]
+	return r
+}
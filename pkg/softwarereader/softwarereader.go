@@ -0,0 +1,245 @@
+package softwarereader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// The SOFTWARE hive doesn't need the bootkey for anything, so unlike the other readers there's no SYSTEM hive here.
+// Everything found is stored in cleartext (or with a fixed, public key) by whatever put it there.
+
+// New creates a SoftwareReader for an offline SOFTWARE hive
+func New(software string) (SoftwareReader, error) {
+	reg, err := winregistry.InitOffline(software)
+	if err != nil {
+		return SoftwareReader{}, err
+	}
+	return FromRegistry(reg), nil
+}
+
+// NewReader creates a SoftwareReader for a hive of size bytes read from r
+func NewReader(r io.ReaderAt, size int64) (SoftwareReader, error) {
+	reg, err := winregistry.InitReader(r, size)
+	if err != nil {
+		return SoftwareReader{}, err
+	}
+	return FromRegistry(reg), nil
+}
+
+// NewFS creates a SoftwareReader for the hive called name in fsys
+func NewFS(fsys fs.FS, name string) (SoftwareReader, error) {
+	reg, err := winregistry.InitFS(fsys, name)
+	if err != nil {
+		return SoftwareReader{}, err
+	}
+	return FromRegistry(reg), nil
+}
+
+// NewLive creates a SoftwareReader for the SOFTWARE hive of the machine it is running on
+func NewLive() (SoftwareReader, error) {
+	reg, err := winregistry.InitLive("SOFTWARE")
+	if err != nil {
+		return SoftwareReader{}, err
+	}
+	return FromRegistry(reg), nil
+}
+
+// FromRegistry creates a SoftwareReader for an already opened SOFTWARE hive
+func FromRegistry(software winregistry.WinRegIF) SoftwareReader {
+	return SoftwareReader{
+		registry: software,
+		userData: make(chan ditreader.DumpedHash, 500),
+	}
+}
+
+type SoftwareReader struct {
+	registry winregistry.WinRegIF
+	userData chan ditreader.DumpedHash
+}
+
+// GetOutChan returns a reference to the objects output channel for read only operations
+func (d SoftwareReader) GetOutChan() <-chan ditreader.DumpedHash {
+	return d.userData
+}
+
+// Recoveries reports the hive if transaction logs had to be replayed when it was opened
+func (d SoftwareReader) Recoveries() []winregistry.Recovery {
+	return winregistry.Recoveries(d.registry)
+}
+
+// Dump sends every credential found in the hive to the output channel
+func (d SoftwareReader) Dump() error {
+	defer close(d.userData)
+	creds, err := d.Credentials()
+	if err != nil {
+		return err
+	}
+	for _, c := range creds {
+		d.userData <- c.DumpedHash()
+	}
+	return nil
+}
+
+// Credentials returns the cleartext credentials found in all the known locations: Winlogon autologon (default and
+// alternate) and VNC server passwords.
+func (d SoftwareReader) Credentials() ([]Credential, error) {
+	r := []Credential{}
+	al, err := d.Autologon()
+	if err != nil {
+		return nil, err
+	}
+	r = append(r, al.Credentials()...)
+	vnc, err := d.VNCPasswords()
+	if err != nil {
+		return nil, err
+	}
+	return append(r, vnc...), nil
+}
+
+// WINLOGON_KEY holds the autologon settings
+const WINLOGON_KEY = "\\Microsoft\\Windows NT\\CurrentVersion\\Winlogon"
+
+// Autologon is the autologon configuration in the Winlogon key. When autologon is set up properly (with sysinternals
+// autologon, or netplwiz) the password is an LSA secret (DefaultPassword, see securityreader) rather than a value
+// here, so an enabled autologon with no password found is worth checking the SECURITY hive for.
+type Autologon struct {
+	//AutoAdminLogon is 1
+	Enabled bool
+	//ForceAutoLogon is 1, the user is logged back on after logging off
+	Forced   bool
+	Domain   string
+	Username string
+	Password string
+	//DefaultPassword exists (it can be set to an empty string)
+	HasPassword bool
+	//AltDefault* values, used by some autologon tools alongside the default ones
+	AltDomain      string
+	AltUsername    string
+	AltPassword    string
+	HasAltPassword bool
+}
+
+// Autologon reads the Winlogon autologon settings. A missing Winlogon key is not an error, there's just nothing set.
+func (d SoftwareReader) Autologon() (Autologon, error) {
+	r := Autologon{}
+	enabled, forced := "", ""
+	for _, v := range []struct {
+		name string
+		dst  *string
+		set  *bool
+	}{
+		{"AutoAdminLogon", &enabled, nil},
+		{"ForceAutoLogon", &forced, nil},
+		{"DefaultDomainName", &r.Domain, nil},
+		{"DefaultUserName", &r.Username, nil},
+		{"DefaultPassword", &r.Password, &r.HasPassword},
+		{"AltDefaultDomainName", &r.AltDomain, nil},
+		{"AltDefaultUserName", &r.AltUsername, nil},
+		{"AltDefaultPassword", &r.AltPassword, &r.HasAltPassword},
+	} {
+		s, err := d.regString(WINLOGON_KEY + "\\" + v.name)
+		if errors.Is(err, winregistry.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return r, err
+		}
+		*v.dst = s
+		if v.set != nil {
+			*v.set = true
+		}
+	}
+	r.Enabled = strings.TrimSpace(enabled) == "1"
+	r.Forced = strings.TrimSpace(forced) == "1"
+	return r, nil
+}
+
+// Credentials returns the stored passwords, if any
+func (a Autologon) Credentials() []Credential {
+	r := []Credential{}
+	if a.HasPassword {
+		r = append(r, Credential{
+			Source:   "Winlogon",
+			Path:     WINLOGON_KEY + "\\DefaultPassword",
+			Domain:   a.Domain,
+			Username: a.Username,
+			Password: a.Password,
+		})
+	}
+	if a.HasAltPassword {
+		r = append(r, Credential{
+			Source:   "Winlogon",
+			Path:     WINLOGON_KEY + "\\AltDefaultPassword",
+			Domain:   a.AltDomain,
+			Username: a.AltUsername,
+			Password: a.AltPassword,
+		})
+	}
+	return r
+}
+
+// UNKNOWN_USER is used when there's no account stored alongside a password
+const UNKNOWN_USER = "(Unknown User)"
+
+// Credential is a cleartext password found in the hive
+type Credential struct {
+	//what the password is for (Winlogon, TightVNC etc)
+	Source string
+	//registry value the password was read from
+	Path     string
+	Domain   string
+	Username string
+	Password string
+}
+
+// Account is DOMAIN\user, just the user if there's no domain, or UNKNOWN_USER if there's neither
+func (c Credential) Account() string {
+	switch {
+	case c.Username == "":
+		return UNKNOWN_USER
+	case c.Domain == "":
+		return c.Username
+	}
+	return c.Domain + "\\" + c.Username
+}
+
+func (c Credential) String() string {
+	return fmt.Sprintf("%s:%s", c.Account(), c.Password)
+}
+
+// DumpedHash converts the credential into the output type shared by all the readers, the same way LSA secret
+// passwords are. Passwords tied to an account are put in Supp, the formatted credential is in Secret.
+func (c Credential) DumpedHash() ditreader.DumpedHash {
+	dh := ditreader.DumpedHash{
+		Username: c.Account(),
+		Secret:   fmt.Sprintf("[%s %s]\n%s", c.Source, c.Path, c.String()),
+	}
+	if c.Username != "" {
+		dh.LMHash = ditreader.EmptyLM
+		dh.NTHash = kdf.NTHash(c.Password)
+		dh.Supp = ditreader.SuppInfo{Username: c.Account(), ClearPassword: c.Password}
+	}
+	return dh
+}
+
+// regString reads a REG_SZ value, dropping the null terminator
+func (d SoftwareReader) regString(path string) (string, error) {
+	_, b, err := d.registry.GetVal(path)
+	if err != nil {
+		return "", err
+	}
+	ud := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	s, err := ud.String(string(b))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s, "\x00"), nil
+}
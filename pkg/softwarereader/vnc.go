@@ -0,0 +1,88 @@
+package softwarereader
+
+import (
+	"bytes"
+	"crypto/des"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// VNC servers store their passwords DES encrypted with a key that's the same everywhere (the key below already has
+// the bits of each byte reversed, the way VNC feeds it to DES).
+var vncKey = []byte{0xe8, 0x4a, 0xd6, 0x60, 0xc4, 0x72, 0x1a, 0xe0}
+
+// vncPasswords are the values VNC servers keep their passwords in, relative to the root of the hive. 32 bit installs
+// on 64 bit machines end up under Wow6432Node.
+var vncPasswords = []struct {
+	source string
+	path   string
+}{
+	{"RealVNC", "\\RealVNC\\WinVNC4\\Password"},
+	{"TigerVNC", "\\TigerVNC\\WinVNC4\\Password"},
+	{"TightVNC", "\\TightVNC\\Server\\Password"},
+	{"TightVNC", "\\TightVNC\\Server\\PasswordViewOnly"},
+	{"TightVNC", "\\TightVNC\\Server\\ControlPassword"},
+	{"WinVNC3", "\\ORL\\WinVNC3\\Password"},
+}
+
+// VNCPasswords decrypts the passwords of any VNC servers installed
+func (d SoftwareReader) VNCPasswords() ([]Credential, error) {
+	r := []Credential{}
+	for _, prefix := range []string{"", "\\Wow6432Node"} {
+		for _, v := range vncPasswords {
+			path := prefix + v.path
+			_, b, err := d.registry.GetVal(path)
+			if errors.Is(err, winregistry.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			p, err := DecryptVNC(b)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			r = append(r, Credential{Source: v.source, Path: path, Password: p})
+		}
+	}
+	return r, nil
+}
+
+// DecryptVNC decrypts a stored VNC password. Most servers store the 8 encrypted bytes as REG_BINARY, some as a hex
+// string.
+func DecryptVNC(b []byte) (string, error) {
+	if len(b) != 8 {
+		s, err := decodeHexString(b)
+		if err != nil || len(s) < 8 {
+			return "", fmt.Errorf("Bad VNC password length. Expected x=8, got x=%d", len(b))
+		}
+		b = s
+	}
+	c, err := des.NewCipher(vncKey)
+	if err != nil {
+		return "", err
+	}
+	plain := make([]byte, 8)
+	c.Decrypt(plain, b[:8])
+	//passwords are null padded, and only the first 8 characters are ever used
+	if i := bytes.IndexByte(plain, 0); i >= 0 {
+		plain = plain[:i]
+	}
+	return string(plain), nil
+}
+
+// decodeHexString decodes a REG_SZ holding hex
+func decodeHexString(b []byte) ([]byte, error) {
+	s := strings.Builder{}
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i+1] != 0 || b[i] == 0 {
+			break
+		}
+		s.WriteByte(b[i])
+	}
+	return hex.DecodeString(s.String())
+}
@@ -0,0 +1,205 @@
+package systemreader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// ProductOptions\ProductType values
+const (
+	PRODUCT_WORKSTATION = "WinNT"    //client OS
+	PRODUCT_SERVER      = "ServerNT" //member or standalone server
+	PRODUCT_DC          = "LanmanNT" //domain controller
+)
+
+// ControlSets are the values of the \Select key. 0 means the value wasn't set.
+type ControlSets struct {
+	Current       uint32
+	Default       uint32
+	Failed        uint32
+	LastKnownGood uint32
+}
+
+// OSVersion is the version information in the SOFTWARE hive (Microsoft\Windows NT\CurrentVersion)
+type OSVersion struct {
+	ProductName    string //eg Windows Server 2019 Standard
+	EditionID      string
+	DisplayVersion string //eg 21H2, falls back to ReleaseId on older builds
+	CurrentVersion string //eg 6.3
+	CurrentBuild   string
+	UBR            uint32 //update build revision
+}
+
+// Build returns the full build number (eg 17763.1879)
+func (o OSVersion) Build() string {
+	if o.UBR == 0 {
+		return o.CurrentBuild
+	}
+	return o.CurrentBuild + "." + strconv.FormatUint(uint64(o.UBR), 10)
+}
+
+// HostInfo is a profile of the machine the hives came from. Values missing from the hives are left empty.
+type HostInfo struct {
+	ComputerName string //NetBIOS name
+	Hostname     string //DNS hostname
+	Domain       string //DNS domain, empty for workgroup machines
+	ProductType  string //one of the PRODUCT_ constants
+	TimeZone     string
+	LastShutdown time.Time
+	ControlSet   string //control set the values were read from (ControlSet001 etc)
+	ControlSets  ControlSets
+	OS           OSVersion //only set if a SOFTWARE hive was supplied
+}
+
+// Role describes the ProductType
+func (h HostInfo) Role() string {
+	switch h.ProductType {
+	case PRODUCT_WORKSTATION:
+		return "workstation"
+	case PRODUCT_SERVER:
+		return "member server"
+	case PRODUCT_DC:
+		return "domain controller"
+	}
+	return "unknown"
+}
+
+// UseControlSet overrides the control set used for the bootkey and everything else read from the hive, instead of the
+// one in \Select\Current. Passing 0 goes back to \Select\Current.
+func (l *SystemReader) UseControlSet(n uint32) error {
+	if n != 0 {
+		if _, err := l.registry.KeyInfo(fmt.Sprintf("\\ControlSet%03d", n)); err != nil {
+			return fmt.Errorf("control set %d: %w", n, err)
+		}
+	}
+	l.controlSet = n
+	l.bootKey = nil
+	return nil
+}
+
+// ControlSets returns the control sets listed under \Select
+func (l SystemReader) ControlSets() (ControlSets, error) {
+	r := ControlSets{}
+	for name, dst := range map[string]*uint32{
+		"Current":       &r.Current,
+		"Default":       &r.Default,
+		"Failed":        &r.Failed,
+		"LastKnownGood": &r.LastKnownGood,
+	} {
+		v, err := l.regDword("\\Select\\" + name)
+		if err != nil && !errors.Is(err, winregistry.ErrNotFound) {
+			return r, err
+		}
+		*dst = v
+	}
+	return r, nil
+}
+
+// HostInfo profiles the machine. software is the SOFTWARE hive of the same machine, and can be nil if the OS version
+// isn't needed.
+func (l SystemReader) HostInfo(software winregistry.WinRegIF) (HostInfo, error) {
+	r := HostInfo{}
+	var err error
+	if r.ControlSets, err = l.ControlSets(); err != nil {
+		return r, err
+	}
+	if r.ControlSet, err = l.currentControlSet(); err != nil {
+		return r, err
+	}
+	ctrl := "\\" + r.ControlSet + "\\Control\\"
+
+	//later entries win, so TimeZoneKeyName (Vista+) is preferred over the display name
+	if err := readStrings(l.registry, []strVal{
+		{ctrl + "ComputerName\\ComputerName\\ComputerName", &r.ComputerName},
+		{ctrl + "ProductOptions\\ProductType", &r.ProductType},
+		{ctrl + "TimeZoneInformation\\StandardName", &r.TimeZone},
+		{ctrl + "TimeZoneInformation\\TimeZoneKeyName", &r.TimeZone},
+		{"\\" + r.ControlSet + "\\Services\\Tcpip\\Parameters\\Hostname", &r.Hostname},
+		{"\\" + r.ControlSet + "\\Services\\Tcpip\\Parameters\\Domain", &r.Domain},
+	}); err != nil {
+		return r, err
+	}
+
+	_, b, err := l.registry.GetVal(ctrl + "Windows\\ShutdownTime")
+	if err == nil && len(b) >= 8 {
+		r.LastShutdown = winregistry.FiletimeToTime(binary.LittleEndian.Uint64(b))
+	} else if err != nil && !errors.Is(err, winregistry.ErrNotFound) {
+		return r, err
+	}
+
+	if software != nil {
+		if r.OS, err = readOSVersion(software); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// readOSVersion reads Microsoft\Windows NT\CurrentVersion out of a SOFTWARE hive
+func readOSVersion(software winregistry.WinRegIF) (OSVersion, error) {
+	const cv = "\\Microsoft\\Windows NT\\CurrentVersion\\"
+	r := OSVersion{}
+	//ReleaseId was replaced by DisplayVersion in 20H2
+	if err := readStrings(software, []strVal{
+		{cv + "ProductName", &r.ProductName},
+		{cv + "EditionID", &r.EditionID},
+		{cv + "ReleaseId", &r.DisplayVersion},
+		{cv + "DisplayVersion", &r.DisplayVersion},
+		{cv + "CurrentVersion", &r.CurrentVersion},
+		{cv + "CurrentBuild", &r.CurrentBuild},
+		{cv + "CurrentBuildNumber", &r.CurrentBuild},
+	}); err != nil {
+		return r, err
+	}
+	_, b, err := software.GetVal(cv + "UBR")
+	if err == nil && len(b) >= 4 {
+		r.UBR = binary.LittleEndian.Uint32(b)
+	} else if err != nil && !errors.Is(err, winregistry.ErrNotFound) {
+		return r, err
+	}
+	return r, nil
+}
+
+type strVal struct {
+	path string
+	dst  *string
+}
+
+// readStrings reads REG_SZ values into their destinations. Missing or empty values are skipped, so when two entries
+// share a destination the last one present wins.
+func readStrings(reg winregistry.WinRegIF, vals []strVal) error {
+	for _, v := range vals {
+		_, b, err := reg.GetVal(v.path)
+		if errors.Is(err, winregistry.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s, err := regString(b)
+		if err != nil {
+			return err
+		}
+		if s != "" {
+			*v.dst = s
+		}
+	}
+	return nil
+}
+
+// regDword reads a REG_DWORD value out of the SYSTEM hive
+func (l SystemReader) regDword(path string) (uint32, error) {
+	_, b, err := l.registry.GetVal(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) < 4 {
+		return 0, fmt.Errorf("Bad %s value: %x", path, b)
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
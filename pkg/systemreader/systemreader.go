@@ -1,19 +1,72 @@
 package systemreader
 
 import (
+	"crypto/md5"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"strings"
 
 	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
 	"golang.org/x/text/encoding/unicode"
 )
 
+//ErrNoBootKey is returned when the bootkey can't be read out of the SYSTEM hive
+var ErrNoBootKey = errors.New("no bootkey found")
+
 //SystemReader provides an interface to get goodies from a SYSTEM file.
 type SystemReader struct {
 	systemLoc string
 	bootKey   []byte
 	registry  winregistry.WinRegIF
+	//control set override, 0 to use \Select\Current
+	controlSet uint32
+	//bootkey override, for syskey startup modes
+	startupKey []byte
+}
+
+//Options override what's read from the SYSTEM hive. The bootkey is only needed for machines using syskey startup
+//modes (SecureBoot 2 or 3), where it isn't stored in the hive.
+type Options struct {
+	//StartupPassword is the syskey startup password (SecureBoot mode 2)
+	StartupPassword string
+	//StartupKey is the contents of the StartupKey.Key file from the syskey floppy (SecureBoot mode 3)
+	StartupKey []byte
+	//ControlSet is the control set to read (eg LastKnownGood), 0 for \Select\Current
+	ControlSet uint32
+}
+
+//Use applies the overrides in o
+func (l *SystemReader) Use(o Options) error {
+	if o.ControlSet != 0 {
+		if err := l.UseControlSet(o.ControlSet); err != nil {
+			return err
+		}
+	}
+	if o.StartupPassword != "" {
+		return l.UseBootKey(StartupPasswordKey(o.StartupPassword))
+	}
+	if len(o.StartupKey) > 0 {
+		return l.UseBootKey(o.StartupKey)
+	}
+	return nil
+}
+
+//UseBootKey overrides the bootkey returned by BootKey, for when it can't be read from the hive. Only the first 16
+//bytes are used. Passing nil goes back to reading it from the hive.
+func (l *SystemReader) UseBootKey(bk []byte) error {
+	if bk == nil {
+		l.startupKey = nil
+		return nil
+	}
+	if len(bk) < 16 {
+		return fmt.Errorf("Startup key too short. Expected x>=16, got x=%d", len(bk))
+	}
+	l.startupKey = bk[:16]
+	return nil
 }
 
 //New creates a new SystemReader pointing at the specified file.
@@ -24,6 +77,23 @@ func New(s string) (SystemReader, error) {
 	return r, err
 }
 
+//NewReader creates a SystemReader for a hive of size bytes read from r
+func NewReader(r io.ReaderAt, size int64) (SystemReader, error) {
+	reg, err := winregistry.InitReader(r, size)
+	return SystemReader{registry: reg}, err
+}
+
+//NewFS creates a SystemReader for the hive called name in fsys
+func NewFS(fsys fs.FS, name string) (SystemReader, error) {
+	reg, err := winregistry.InitFS(fsys, name)
+	return SystemReader{systemLoc: name, registry: reg}, err
+}
+
+//FromRegistry creates a SystemReader for an already opened SYSTEM hive
+func FromRegistry(system winregistry.WinRegIF) SystemReader {
+	return SystemReader{registry: system}
+}
+
 func NewLive() (SystemReader, error) {
 	r := SystemReader{}
 	var err error
@@ -34,26 +104,32 @@ func NewLive() (SystemReader, error) {
 	return r, err
 }
 
-//BootKey returns the bootkey extracted from the SYSTEM file
-func (l *SystemReader) BootKey() []byte {
+//BootKey returns the bootkey extracted from the SYSTEM file, or the one set with UseBootKey. Errors wrap ErrNoBootKey.
+func (l *SystemReader) BootKey() ([]byte, error) {
+	if l.startupKey != nil {
+		return l.startupKey, nil
+	}
+	//the scrambled key in the hive is junk when a startup password or floppy is in use
+	if mode, err := l.SecureBoot(); err == nil && mode != SECUREBOOT_REGISTRY {
+		return nil, fmt.Errorf("%w: syskey startup mode %d in use, the startup password or key file is needed", ErrNoBootKey, mode)
+	}
 	b, e := l.getBootKey()
 	if e != nil {
-		panic(e)
+		return nil, fmt.Errorf("%w: %w", ErrNoBootKey, e)
 	}
-	if len(b) == 0 {
-		panic("NO BOOTKEY?")
+	if len(b) != 16 {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrNoBootKey, len(b))
 	}
-	return b
+	return b, nil
 }
 
 func (l *SystemReader) getBootKey() (bk []byte, err error) {
 	tmpKey := ""
 	//get control set
-	_, bcurrentControlset, err := l.registry.GetVal("\\Select\\Current")
+	currentControlset, err := l.currentControlSet()
 	if err != nil {
 		return nil, err
 	}
-	currentControlset := fmt.Sprintf("ControlSet%03d", binary.LittleEndian.Uint32(bcurrentControlset))
 	for _, k := range []string{"JD", "Skew1", "GBG", "Data"} {
 		ans, e := l.registry.GetClass(fmt.Sprintf("\\%s\\Control\\Lsa\\%s", currentControlset, k))
 		if e != nil {
@@ -70,6 +146,9 @@ func (l *SystemReader) getBootKey() (bk []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(unhexedKey) != len(transforms) {
+		return nil, fmt.Errorf("scrambled key is %d bytes, expected %d", len(unhexedKey), len(transforms))
+	}
 	for i := 0; i < len(unhexedKey); i++ {
 		bk = append(bk, unhexedKey[transforms[i]])
 	}
@@ -77,18 +156,111 @@ func (l *SystemReader) getBootKey() (bk []byte, err error) {
 	return bk, nil
 }
 
+// Syskey modes (the Lsa\SecureBoot value)
+const (
+	SECUREBOOT_REGISTRY = 1 //bootkey is stored (scrambled) in the SYSTEM hive, the default
+	SECUREBOOT_PASSWORD = 2 //bootkey is derived from a password entered at startup
+	SECUREBOOT_FLOPPY   = 3 //bootkey is read from StartupKey.Key on a floppy at startup
+)
+
+//SecureBoot returns the syskey mode of the machine. Missing values are treated as SECUREBOOT_REGISTRY.
+func (l SystemReader) SecureBoot() (uint32, error) {
+	ccs, err := l.currentControlSet()
+	if err != nil {
+		return 0, err
+	}
+	_, b, err := l.registry.GetVal(fmt.Sprintf("\\%s\\Control\\Lsa\\SecureBoot", ccs))
+	if err != nil {
+		if errors.Is(err, winregistry.ErrNotFound) {
+			return SECUREBOOT_REGISTRY, nil
+		}
+		return 0, err
+	}
+	if len(b) < 4 {
+		return 0, fmt.Errorf("Bad SecureBoot value: %x", b)
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+//StartupPasswordKey derives the bootkey from a syskey startup password (SecureBoot mode 2)
+func StartupPasswordKey(password string) []byte {
+	ue := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
+	b, _ := ue.Bytes([]byte(password))
+	h := md5.Sum(b)
+	return h[:]
+}
+
+//Recoveries reports the SYSTEM hive if transaction logs had to be replayed when it was opened
+func (l SystemReader) Recoveries() []winregistry.Recovery {
+	return winregistry.Recoveries(l.registry)
+}
+
 //HasNoLMHashPolicy returns true if no LM hashes are allowed per the SYSTEM file. A False response indicates that LM hashes may exist within the domain/machine.
-func (l SystemReader) HasNoLMHashPolicy() bool {
+func (l SystemReader) HasNoLMHashPolicy() (bool, error) {
 	//winreg := winregistry.WinregRegistry{}.Init(l.systemLoc, false)
-	_, bcurrentControlSet, err := l.registry.GetVal("\\Select\\Current")
+	currentControlSet, err := l.currentControlSet()
 	if err != nil {
-		fmt.Println("ERROR GETTING CONTROL SET FOR LM HASH", err)
+		return true, err
 	}
-	currentControlSet := fmt.Sprintf("ControlSet%03d", binary.LittleEndian.Uint32(bcurrentControlSet))
 	_, _, err = l.registry.GetVal(fmt.Sprintf("\\%s\\Control\\Lsa\\NoLmHash", currentControlSet))
-	if err != nil && err.Error() == winregistry.NONE {
+	if errors.Is(err, winregistry.ErrNotFound) {
 		//yee got some LM HASHES life is gonna be GOOD
-		return false
+		return false, nil
+	}
+	return true, nil
+}
+
+//currentControlSet returns the name of the control set in use (ControlSet001 etc), honouring UseControlSet
+func (l SystemReader) currentControlSet() (string, error) {
+	if l.controlSet != 0 {
+		return fmt.Sprintf("ControlSet%03d", l.controlSet), nil
+	}
+	n, err := l.regDword("\\Select\\Current")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ControlSet%03d", n), nil
+}
+
+//ServiceAccount returns the account a service runs as (the ObjectName value of the service key)
+func (l SystemReader) ServiceAccount(service string) (string, error) {
+	ccs, err := l.currentControlSet()
+	if err != nil {
+		return "", err
+	}
+	_, b, err := l.registry.GetVal(fmt.Sprintf("\\%s\\Services\\%s\\ObjectName", ccs, service))
+	if err != nil {
+		return "", err
+	}
+	return regString(b)
+}
+
+//regString decodes a REG_SZ value, dropping the null terminator
+func regString(b []byte) (string, error) {
+	ud := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	s, err := ud.String(string(b))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s, "\x00"), nil
+}
+
+//TcpipParameters returns the hostname and (DNS) domain of the machine, from Services\Tcpip\Parameters. The domain
+//is empty for workgroup machines.
+func (l SystemReader) TcpipParameters() (hostname, domain string, err error) {
+	ccs, err := l.currentControlSet()
+	if err != nil {
+		return "", "", err
+	}
+	_, b, err := l.registry.GetVal(fmt.Sprintf("\\%s\\Services\\Tcpip\\Parameters\\Hostname", ccs))
+	if err != nil {
+		return "", "", err
+	}
+	if hostname, err = regString(b); err != nil {
+		return "", "", err
+	}
+	if _, b, err := l.registry.GetVal(fmt.Sprintf("\\%s\\Services\\Tcpip\\Parameters\\Domain", ccs)); err == nil {
+		domain, _ = regString(b)
 	}
-	return true
+	return hostname, domain, nil
 }
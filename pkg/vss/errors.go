@@ -0,0 +1,10 @@
+package vss
+
+import "errors"
+
+var (
+	// ErrNoSnapshots is returned for volumes without a VSS header, or with one but no shadow copies in the catalog
+	ErrNoSnapshots = errors.New("no volume shadow copies")
+	// ErrCorruptStore is returned when the catalog or a store doesn't parse
+	ErrCorruptStore = errors.New("corrupt shadow copy store")
+)
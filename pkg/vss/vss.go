@@ -0,0 +1,338 @@
+package vss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+/*
+Volume Shadow Copy (VSS) stores, as they are laid out on an NTFS volume. Everything is little endian, in 16K blocks
+that start with a 128 byte header:
+
+	0x00 VSS identifier GUID {3808876b-c176-4e48-b7ae-04046e6cc752}
+	0x10 Version uint32 (1)
+	0x14 RecordType uint32
+	0x18 RelativeOffset uint64
+	0x20 CurrentOffset uint64
+	0x28 NextOffset uint64 (the next block of a list, 0 at the end)
+
+The volume header (record type 1, not a full block) is at 0x1e00, with the catalog offset at 0x30.
+
+The catalog (type 2) is a list of 128 byte entries, two for each snapshot. Type 2 entries have the volume size at
+0x08, the store GUID at 0x10 and the creation FILETIME at 0x30. Type 3 entries have the store block list offset at
+0x08, the store GUID at 0x10 and the store header offset at 0x20.
+
+The store block list (type 3) is a list of 32 byte descriptors: OriginalOffset uint64, RelativeOffset uint64,
+StoreOffset uint64, Flags uint32, AllocationBitmap uint32. Each one is a 16K block of the volume that was about to be
+overwritten after the snapshot, and where its old contents were copied to. Forwarders say the block's old contents
+are now at RelativeOffset, overlays replace the 512 byte sectors set in the bitmap.
+
+The store header (type 4) has the shadow copy GUID 0x10 bytes into the store information, after the block header.
+
+A snapshot is read by looking for each block in its own store, then in every newer store, and finally on the volume
+itself: a block that hasn't been copied anywhere hasn't changed since the snapshot was taken.
+*/
+
+const (
+	BLOCK_SIZE    = 0x4000
+	HEADER_OFFSET = 0x1e00
+
+	RECORD_VOLUME_HEADER = 1
+	RECORD_CATALOG       = 2
+	RECORD_BLOCK_LIST    = 3
+	RECORD_STORE_HEADER  = 4
+
+	CATALOG_SNAPSHOT = 2
+	CATALOG_STORE    = 3
+
+	BLOCK_FORWARDER = 0x1
+	BLOCK_OVERLAY   = 0x2
+	BLOCK_NOT_USED  = 0x4
+
+	blockHeaderSize = 0x80
+	sectorSize      = 512
+	//upper bound on the blocks in a list, so a corrupt one that loops still ends
+	maxListBlocks = 1 << 20
+)
+
+// VSS_IDENTIFIER starts every VSS block, {3808876b-c176-4e48-b7ae-04046e6cc752} in its on disk form
+var VSS_IDENTIFIER = []byte{0x6b, 0x87, 0x08, 0x38, 0x76, 0xc1, 0x48, 0x4e, 0xb7, 0xae, 0x04, 0x04, 0x6e, 0x6c, 0xc7, 0x52}
+
+// Snapshot is a shadow copy of a volume. It reads as the volume did when the snapshot was taken, so can be opened
+// with ntfs.New.
+type Snapshot struct {
+	//ID is the shadow copy GUID, as vssadmin shows it
+	ID      string
+	Created time.Time
+	size    int64
+	vol     io.ReaderAt
+	//this snapshot's store, followed by the newer ones
+	stores []*store
+}
+
+type store struct {
+	blocks   map[int64]descriptor
+	overlays map[int64][]descriptor
+}
+
+type descriptor struct {
+	relative int64
+	offset   int64
+	flags    uint32
+	bitmap   uint32
+}
+
+// Snapshots reads the shadow copy catalog of the volume read from r, returning the snapshots oldest first
+func Snapshots(r io.ReaderAt) (snaps []*Snapshot, err error) {
+	hdr := make([]byte, blockHeaderSize)
+	if n, _ := r.ReadAt(hdr, HEADER_OFFSET); n != len(hdr) || !bytes.Equal(hdr[:16], VSS_IDENTIFIER) ||
+		binary.LittleEndian.Uint32(hdr[0x14:]) != RECORD_VOLUME_HEADER {
+		return nil, ErrNoSnapshots
+	}
+	catalog := int64(binary.LittleEndian.Uint64(hdr[0x30:]))
+	if catalog == 0 {
+		return nil, ErrNoSnapshots
+	}
+
+	type catalogEntry struct {
+		size, blockList, header int64
+		created                 time.Time
+		hasSnapshot, hasStore   bool
+	}
+	entries := map[string]*catalogEntry{}
+	order := []string{}
+	err = readList(r, catalog, RECORD_CATALOG, 128, func(e []byte) error {
+		typ := binary.LittleEndian.Uint64(e)
+		if typ != CATALOG_SNAPSHOT && typ != CATALOG_STORE {
+			return nil
+		}
+		id := string(e[0x10:0x20])
+		ce, ok := entries[id]
+		if !ok {
+			ce = &catalogEntry{}
+			entries[id] = ce
+			order = append(order, id)
+		}
+		if typ == CATALOG_SNAPSHOT {
+			ce.size = int64(binary.LittleEndian.Uint64(e[0x08:]))
+			ce.created = winregistry.FiletimeToTime(binary.LittleEndian.Uint64(e[0x30:]))
+			ce.hasSnapshot = true
+		} else {
+			ce.blockList = int64(binary.LittleEndian.Uint64(e[0x08:]))
+			ce.header = int64(binary.LittleEndian.Uint64(e[0x20:]))
+			ce.hasStore = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stores := []*store{}
+	for _, id := range order {
+		ce := entries[id]
+		if !ce.hasSnapshot || !ce.hasStore {
+			continue
+		}
+		st, err := readStore(r, ce.blockList)
+		if err != nil {
+			return nil, err
+		}
+		snap := &Snapshot{ID: guidString([]byte(id)), Created: ce.created, size: ce.size, vol: r}
+		//the shadow copy ID is what people will recognise, but the store GUID will do if the header is gone
+		if b, err := readBlock(r, ce.header, RECORD_STORE_HEADER); err == nil {
+			snap.ID = guidString(b[blockHeaderSize+0x10:])
+		}
+		snaps = append(snaps, snap)
+		stores = append(stores, st)
+	}
+	if len(snaps) == 0 {
+		return nil, ErrNoSnapshots
+	}
+
+	//the catalog should already be in order, but the stores have to be chained oldest to newest so make sure
+	idx := make([]int, len(snaps))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return snaps[idx[i]].Created.Before(snaps[idx[j]].Created) })
+	sorted := make([]*Snapshot, len(snaps))
+	chain := make([]*store, len(snaps))
+	for i, j := range idx {
+		sorted[i], chain[i] = snaps[j], stores[j]
+	}
+	for i, s := range sorted {
+		s.stores = chain[i:]
+	}
+	return sorted, nil
+}
+
+// readStore reads the block list of a store
+func readStore(r io.ReaderAt, blockList int64) (*store, error) {
+	st := &store{blocks: map[int64]descriptor{}, overlays: map[int64][]descriptor{}}
+	err := readList(r, blockList, RECORD_BLOCK_LIST, 32, func(e []byte) error {
+		orig := int64(binary.LittleEndian.Uint64(e))
+		d := descriptor{
+			relative: int64(binary.LittleEndian.Uint64(e[0x08:])),
+			offset:   int64(binary.LittleEndian.Uint64(e[0x10:])),
+			flags:    binary.LittleEndian.Uint32(e[0x18:]),
+			bitmap:   binary.LittleEndian.Uint32(e[0x1c:]),
+		}
+		switch {
+		case d.offset == 0 && d.relative == 0 && d.flags == 0, d.flags&BLOCK_NOT_USED != 0:
+			//empty
+		case orig%BLOCK_SIZE != 0:
+			return fmt.Errorf("%w: unaligned block 0x%x in store", ErrCorruptStore, orig)
+		case d.flags&BLOCK_OVERLAY != 0:
+			st.overlays[orig] = append(st.overlays[orig], d)
+		default:
+			//only the first copy of a block is from before the snapshot
+			if _, ok := st.blocks[orig]; !ok {
+				st.blocks[orig] = d
+			}
+		}
+		return nil
+	})
+	return st, err
+}
+
+// readBlock reads the 16K block at off, checking its header
+func readBlock(r io.ReaderAt, off int64, recordType uint32) ([]byte, error) {
+	b := make([]byte, BLOCK_SIZE)
+	if off <= 0 || off%sectorSize != 0 {
+		return nil, fmt.Errorf("%w: bad block offset 0x%x", ErrCorruptStore, off)
+	}
+	if n, err := r.ReadAt(b, off); n != len(b) {
+		return nil, fmt.Errorf("%w: reading block at 0x%x: %v", ErrCorruptStore, off, err)
+	}
+	if !bytes.Equal(b[:16], VSS_IDENTIFIER) || binary.LittleEndian.Uint32(b[0x14:]) != recordType {
+		return nil, fmt.Errorf("%w: expected a type %d block at 0x%x", ErrCorruptStore, recordType, off)
+	}
+	return b, nil
+}
+
+// readList calls fn for each entry of a list of blocks starting at off
+func readList(r io.ReaderAt, off int64, recordType uint32, entrySize int, fn func([]byte) error) error {
+	seen := map[int64]bool{}
+	for off != 0 {
+		if seen[off] || len(seen) > maxListBlocks {
+			return fmt.Errorf("%w: block list loops at 0x%x", ErrCorruptStore, off)
+		}
+		seen[off] = true
+		b, err := readBlock(r, off, recordType)
+		if err != nil {
+			return err
+		}
+		for e := blockHeaderSize; e+entrySize <= len(b); e += entrySize {
+			if err := fn(b[e : e+entrySize]); err != nil {
+				return err
+			}
+		}
+		off = int64(binary.LittleEndian.Uint64(b[0x28:]))
+	}
+	return nil
+}
+
+// Size is the size of the volume when the snapshot was taken
+func (s *Snapshot) Size() int64 {
+	return s.size
+}
+
+// ReadAt reads from the volume as it was when the snapshot was taken
+func (s *Snapshot) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	short := false
+	if int64(len(p)) > s.size-off {
+		p = p[:s.size-off]
+		short = true
+	}
+	for n < len(p) {
+		block := (off + int64(n)) &^ (BLOCK_SIZE - 1)
+		inBlock := off + int64(n) - block
+		chunk := p[n:]
+		if int64(len(chunk)) > BLOCK_SIZE-inBlock {
+			chunk = chunk[:BLOCK_SIZE-inBlock]
+		}
+		if err := s.read(chunk, block, inBlock, 0); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	if short {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// read fills p from inBlock bytes into block, as of store i
+func (s *Snapshot) read(p []byte, block, inBlock int64, i int) error {
+	for ; i < len(s.stores); i++ {
+		st := s.stores[i]
+		d, ok := st.blocks[block]
+		if ok && d.flags&BLOCK_FORWARDER != 0 {
+			//the old contents moved, carry on looking for wherever they went
+			block = d.relative
+			continue
+		}
+		overlays := st.overlays[block]
+		if !ok && len(overlays) == 0 {
+			continue
+		}
+		if ok {
+			if err := readFull(s.vol, p, d.offset+inBlock); err != nil {
+				return err
+			}
+		} else if err := s.read(p, block, inBlock, i+1); err != nil {
+			return err
+		}
+		for _, o := range overlays {
+			for sector := int64(0); sector < BLOCK_SIZE/sectorSize; sector++ {
+				if o.bitmap&(1<<uint(sector)) == 0 {
+					continue
+				}
+				start, end := sector*sectorSize, (sector+1)*sectorSize
+				if start < inBlock {
+					start = inBlock
+				}
+				if end > inBlock+int64(len(p)) {
+					end = inBlock + int64(len(p))
+				}
+				if start >= end {
+					continue
+				}
+				if err := readFull(s.vol, p[start-inBlock:end-inBlock], o.offset+start); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return readFull(s.vol, p, block+inBlock)
+}
+
+// readFull reads len(p) bytes at off
+func readFull(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	return fmt.Errorf("%w: short read of %d bytes at 0x%x: %v", ErrCorruptStore, len(p), off, err)
+}
+
+// guidString formats an on disk GUID, with braces like vssadmin does
+func guidString(b []byte) string {
+	return strings.ToLower(fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]),
+		binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16]))
+}
@@ -0,0 +1,183 @@
+package winregistry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/internal/corrupt"
+)
+
+// Deleting a key or value only marks its cells as free, so until the space is reused the records are still there.
+// Carving walks every hive bin looking at unallocated cells for intact nk/vk records.
+
+const KEY_HIVE_ENTRY = 0x4 //nk flag for the root key
+
+// DeletedKey is a key record recovered from unallocated space
+type DeletedKey struct {
+	Path        string //full path (as used by GetVal), empty if the parent chain couldn't be resolved
+	Name        string
+	Offset      uint32 //cell offset, relative to the start of the hive bins
+	Parent      uint32 //offset of the parent key
+	LastWritten time.Time
+	Values      []DeletedValue
+}
+
+// DeletedValue is a value record recovered from unallocated space. Data is nil if it was overwritten.
+type DeletedValue struct {
+	Value
+	Offset uint32
+}
+
+// carved is everything recovered by a carving pass
+type carved struct {
+	keys   []DeletedKey
+	values []DeletedValue //values not belonging to any carved key
+}
+
+// DeletedKeys returns the keys found in unallocated cells, with whatever values could still be read
+func (w WinregRegistry) DeletedKeys() (r []DeletedKey, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
+	c, err := w.carve()
+	return c.keys, err
+}
+
+// DeletedValues returns the values found in unallocated cells that don't belong to a deleted key (ie, values removed
+// from a key that still exists)
+func (w WinregRegistry) DeletedValues() (r []DeletedValue, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
+	c, err := w.carve()
+	return c.values, err
+}
+
+func (w WinregRegistry) carve() (carved, error) {
+	r := carved{}
+	nks := []uint32{}
+	vks := []uint32{}
+	for off := BASE_BLOCK_SIZE; off+0x20 <= w.fd.Len(); {
+		hbin, err := regHbin{}.Init(w.fd.Read(off, 0x20))
+		size := int(hbin.OffsetNextHBin)
+		if err != nil || size < 4096 || size%4096 != 0 || off+size > w.fd.Len() {
+			off += 4096
+			continue
+		}
+		for cell := off + 0x20; cell+4 <= off+size; {
+			cellSize := int(int32(binary.LittleEndian.Uint32(w.fd.Read(cell, 4))))
+			free := cellSize > 0
+			if cellSize < 0 {
+				cellSize = -cellSize
+			}
+			if cellSize < 8 || cell+cellSize > off+size {
+				break
+			}
+			if free {
+				//a free cell can be several old cells merged together, so look at every (8 byte aligned) cell start
+				for p := cell; p+6 <= cell+cellSize; p += 8 {
+					switch string(w.fd.Read(p+4, 2)) {
+					case "nk":
+						nks = append(nks, uint32(p-BASE_BLOCK_SIZE))
+					case "vk":
+						vks = append(vks, uint32(p-BASE_BLOCK_SIZE))
+					}
+				}
+			}
+			cell += cellSize
+		}
+		off += size
+	}
+
+	owned := map[uint32]bool{}
+	for _, off := range nks {
+		nk, err := w.rawRecord(off, "nk")
+		if err != nil {
+			continue
+		}
+		dk := DeletedKey{
+			Name:        nk.name(),
+			Offset:      off,
+			Parent:      nk.OffsetParent,
+			LastWritten: FiletimeToTime(nk.lastChange),
+		}
+		if parent, err := w.keyPath(nk.OffsetParent, 0); err == nil {
+			dk.Path = parent + "\\" + dk.Name
+		}
+		for _, voff := range w.rawValueList(nk) {
+			if v, ok := w.carveValue(voff); ok {
+				dk.Values = append(dk.Values, v)
+				owned[voff] = true
+			}
+		}
+		r.keys = append(r.keys, dk)
+	}
+	for _, off := range vks {
+		if owned[off] {
+			continue
+		}
+		if v, ok := w.carveValue(off); ok {
+			r.values = append(r.values, v)
+		}
+	}
+	return r, nil
+}
+
+// rawRecord parses the nk or vk record in the cell at off, whether or not the cell is allocated
+func (w WinregRegistry) rawRecord(off uint32, magic string) (reg_blockStruct, error) {
+	d, err := w.readCell(off, true)
+	if err != nil {
+		return reg_blockStruct{}, err
+	}
+	if len(d) < 2 || string(d[:2]) != magic {
+		return reg_blockStruct{}, fmt.Errorf("%w: expected %s record at 0x%x", ErrCorruptHive, magic, off)
+	}
+	return reg_blockStruct{}.Init(d)
+}
+
+// keyPath builds the path of the key at off by following parent offsets up to the root
+func (w WinregRegistry) keyPath(off uint32, depth int) (string, error) {
+	if depth > 512 {
+		return "", fmt.Errorf("%w: key parent loop at 0x%x", ErrCorruptHive, off)
+	}
+	nk, err := w.rawRecord(off, "nk")
+	if err != nil {
+		return "", err
+	}
+	if nk.Type&KEY_HIVE_ENTRY != 0 {
+		return "", nil
+	}
+	parent, err := w.keyPath(nk.OffsetParent, depth+1)
+	if err != nil {
+		return "", err
+	}
+	return parent + "\\" + nk.name(), nil
+}
+
+// rawValueList reads the value offsets of a key, even if the list has been freed
+func (w WinregRegistry) rawValueList(nk reg_blockStruct) []uint32 {
+	if nk.NumValues == 0 || nk.OffsetValueList == 0xffffffff {
+		return nil
+	}
+	list, err := w.readCell(nk.OffsetValueList, true)
+	if err != nil || uint64(len(list)) < uint64(nk.NumValues)*4 {
+		return nil
+	}
+	r := make([]uint32, nk.NumValues)
+	for i := range r {
+		r[i] = binary.LittleEndian.Uint32(list[i*4:])
+	}
+	return r
+}
+
+func (w WinregRegistry) carveValue(off uint32) (DeletedValue, bool) {
+	vk, err := w.rawRecord(off, "vk")
+	if err != nil {
+		return DeletedValue{}, false
+	}
+	v := DeletedValue{Offset: off}
+	v.Name = vk.valueName()
+	v.Type = vk.ValueType
+	//the data cell may have been reused, in which case there's nothing to return
+	if d, err := w.valData(vk, true); err == nil {
+		v.Data = d
+	}
+	return v, true
+}
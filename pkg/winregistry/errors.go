@@ -0,0 +1,15 @@
+package winregistry
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when a key or value doesn't exist. The message is NONE, so code comparing
+	// err.Error() with NONE keeps working.
+	ErrNotFound = errors.New(NONE)
+	// ErrCorruptHive is returned when the hive (or a cell in it) doesn't parse
+	ErrCorruptHive = errors.New("corrupt registry hive")
+	// ErrNotImplemented is returned for registry structures we don't handle yet
+	ErrNotImplemented = errors.New("not implemented")
+	// ErrBadValue is returned when value data is too short for its type
+	ErrBadValue = errors.New("bad registry value data")
+)
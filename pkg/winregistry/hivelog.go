@@ -0,0 +1,293 @@
+package winregistry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// Hives that weren't cleanly unloaded (pulled from a disk image, crash etc) have their recent changes in the
+// transaction logs next to them. The base block of a dirty hive has mismatched sequence numbers, and the
+// changes are replayed from either new format (HvLE, 8.1+) log entries or a legacy dirty vector (DIRT).
+// https://github.com/msuhanov/regf/blob/master/Windows%20registry%20file%20format%20specification.md
+
+const (
+	BASE_BLOCK_SIZE = 4096
+	LOG_DATA_OFFSET = 512 //log entries/dirty vector start after the (partial) base block in a log
+	SECTOR_SIZE     = 512
+
+	MARVIN_SEED = 0x82EF4D887A4E55C5 //seed used for the log entry hashes
+)
+
+// logSuffixes are the transaction log names checked for next to a hive
+var logSuffixes = []string{".LOG1", ".LOG2", ".LOG", ".log1", ".log2", ".log"}
+
+// Recovery describes what was replayed from the transaction logs when a dirty hive was opened
+type Recovery struct {
+	Name    string   //name the hive was opened as
+	Dirty   bool     //base block sequence numbers didn't match
+	Logs    []string //logs that had something applied from them
+	Entries int      //log entries (or legacy dirty vectors) applied
+	Pages   int      //dirty pages (or sectors) written back into the hive
+}
+
+func (r Recovery) String() string {
+	if !r.Dirty {
+		return "hive is clean"
+	}
+	if r.Entries == 0 {
+		return "hive is dirty, but no usable transaction log entries were found. Recent changes may be missing"
+	}
+	return fmt.Sprintf("hive is dirty, recovered %d page(s) from %d log entries (%s)", r.Pages, r.Entries, strings.Join(r.Logs, ", "))
+}
+
+type dirtyPage struct {
+	offset uint32 //relative to the start of the hive bins
+	data   []byte
+}
+
+type logEntry struct {
+	log      string
+	seq      uint32
+	binsSize uint32
+	pages    []dirtyPage
+}
+
+// baseBlockChecksum is the XOR of the first 508 bytes of a base block as dwords
+func baseBlockChecksum(b []byte) uint32 {
+	x := uint32(0)
+	for i := 0; i < 0x1fc; i += 4 {
+		x ^= binary.LittleEndian.Uint32(b[i:])
+	}
+	if x == 0 {
+		return 1
+	}
+	if x == 0xffffffff {
+		return 0xfffffffe
+	}
+	return x
+}
+
+// validBaseBlock checks the magic and checksum of a base block
+func validBaseBlock(b []byte) bool {
+	return len(b) >= LOG_DATA_OFFSET && bytes.Equal(b[:4], []byte("regf")) &&
+		binary.LittleEndian.Uint32(b[0x1fc:]) == baseBlockChecksum(b)
+}
+
+// Marvin32 is the hash used to validate new format log entries
+func Marvin32(b []byte, seed uint64) uint64 {
+	lo, hi := uint32(seed), uint32(seed>>32)
+	block := func() {
+		hi ^= lo
+		lo = bits.RotateLeft32(lo, 20)
+		lo += hi
+		hi = bits.RotateLeft32(hi, 9)
+		hi ^= lo
+		lo = bits.RotateLeft32(lo, 27)
+		lo += hi
+		hi = bits.RotateLeft32(hi, 19)
+	}
+	for ; len(b) >= 4; b = b[4:] {
+		lo += binary.LittleEndian.Uint32(b)
+		block()
+	}
+	final := uint32(0x80)
+	for i := len(b) - 1; i >= 0; i-- {
+		final = final<<8 | uint32(b[i])
+	}
+	lo += final
+	block()
+	block()
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+// parseLogEntries reads the valid HvLE entries of a new format log, stopping at the first bad one
+func parseLogEntries(name string, log []byte) []logEntry {
+	r := []logEntry{}
+	for off := LOG_DATA_OFFSET; off+0x28 <= len(log); {
+		e := log[off:]
+		if !bytes.Equal(e[:4], []byte("HvLE")) {
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(e[4:]))
+		if size < 0x28 || size%SECTOR_SIZE != 0 || off+size > len(log) {
+			break
+		}
+		e = e[:size]
+		if Marvin32(e[0x28:], MARVIN_SEED) != binary.LittleEndian.Uint64(e[0x18:]) ||
+			Marvin32(e[:0x20], MARVIN_SEED) != binary.LittleEndian.Uint64(e[0x20:]) {
+			break
+		}
+		entry := logEntry{
+			log:      name,
+			seq:      binary.LittleEndian.Uint32(e[0xc:]),
+			binsSize: binary.LittleEndian.Uint32(e[0x10:]),
+		}
+		count := int(binary.LittleEndian.Uint32(e[0x14:]))
+		refs := e[0x28:]
+		if count*8 > len(refs) {
+			break
+		}
+		data := refs[count*8:]
+		ok := true
+		for i := 0; i < count; i++ {
+			pOff := binary.LittleEndian.Uint32(refs[i*8:])
+			pSize := int(binary.LittleEndian.Uint32(refs[i*8+4:]))
+			if pSize > len(data) {
+				ok = false
+				break
+			}
+			entry.pages = append(entry.pages, dirtyPage{offset: pOff, data: data[:pSize]})
+			data = data[pSize:]
+		}
+		if !ok {
+			break
+		}
+		r = append(r, entry)
+		off += size
+	}
+	return r
+}
+
+// parseDirtyVector reads a legacy log: a bitmap of dirty sectors followed by the sectors themselves
+func parseDirtyVector(name string, log []byte) (logEntry, bool) {
+	binsSize := binary.LittleEndian.Uint32(log[0x28:])
+	bitmapLen := int(binsSize / SECTOR_SIZE / 8)
+	start := LOG_DATA_OFFSET + 4
+	if start+bitmapLen > len(log) {
+		return logEntry{}, false
+	}
+	bitmap := log[start : start+bitmapLen]
+	data := log[(start+bitmapLen+SECTOR_SIZE-1)/SECTOR_SIZE*SECTOR_SIZE:]
+	entry := logEntry{log: name, seq: binary.LittleEndian.Uint32(log[4:]), binsSize: binsSize}
+	for i := 0; i < bitmapLen*8; i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if len(data) < SECTOR_SIZE {
+			return logEntry{}, false
+		}
+		entry.pages = append(entry.pages, dirtyPage{offset: uint32(i * SECTOR_SIZE), data: data[:SECTOR_SIZE]})
+		data = data[SECTOR_SIZE:]
+	}
+	return entry, true
+}
+
+// isDirty returns true if the primary and secondary sequence numbers of a base block don't match
+func isDirty(b []byte) bool {
+	return binary.LittleEndian.Uint32(b[4:]) != binary.LittleEndian.Uint32(b[8:])
+}
+
+// Recovery reports what (if anything) was replayed from the transaction logs when the hive was opened
+func (w WinregRegistry) Recovery() Recovery {
+	return w.recovery
+}
+
+// Recoveries is the recovery of each of regs that was dirty when it was opened, so callers can tell the user their
+// hives may be missing changes. Live registries are never dirty.
+func Recoveries(regs ...WinRegIF) []Recovery {
+	r := []Recovery{}
+	for _, reg := range regs {
+		if rr, ok := reg.(interface{ Recovery() Recovery }); ok && rr.Recovery().Dirty {
+			r = append(r, rr.Recovery())
+		}
+	}
+	return r
+}
+
+// replayLogs applies the log entries that are newer than the last clean write of the primary file. Entries are
+// applied in sequence order, and replay stops at the first gap.
+func replayLogs(primary []byte, logs map[string][]byte) ([]byte, Recovery) {
+	seq1 := binary.LittleEndian.Uint32(primary[4:])
+	seq2 := binary.LittleEndian.Uint32(primary[8:])
+	rec := Recovery{Dirty: seq1 != seq2}
+	if !rec.Dirty {
+		return primary, rec
+	}
+
+	names := []string{}
+	for name := range logs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := []logEntry{}
+	for _, name := range names {
+		log := logs[name]
+		if !validBaseBlock(log) {
+			continue
+		}
+		if len(log) >= LOG_DATA_OFFSET+4 && bytes.Equal(log[LOG_DATA_OFFSET:LOG_DATA_OFFSET+4], []byte("DIRT")) {
+			if e, ok := parseDirtyVector(name, log); ok {
+				entries = append(entries, e)
+			}
+			continue
+		}
+		entries = append(entries, parseLogEntries(name, log)...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	out := append([]byte{}, primary...)
+	logsUsed := map[string]bool{}
+	next := seq2
+	started := false
+	for _, e := range entries {
+		if e.seq < seq2 || (started && e.seq != next) {
+			if started && e.seq > next {
+				break
+			}
+			continue
+		}
+		started = true
+		next = e.seq + 1
+		for _, p := range e.pages {
+			//pages can only be inside the hive bins, which stops a bad offset growing the hive to gigabytes
+			if e.binsSize > 0 && int64(p.offset)+int64(len(p.data)) > int64(e.binsSize) {
+				continue
+			}
+			end := BASE_BLOCK_SIZE + int(p.offset) + len(p.data)
+			if end > len(out) {
+				out = append(out, make([]byte, end-len(out))...)
+			}
+			copy(out[BASE_BLOCK_SIZE+int(p.offset):], p.data)
+			rec.Pages++
+		}
+		if e.binsSize > 0 {
+			binary.LittleEndian.PutUint32(out[0x28:], e.binsSize)
+		}
+		rec.Entries++
+		if !logsUsed[e.log] {
+			logsUsed[e.log] = true
+			rec.Logs = append(rec.Logs, e.log)
+		}
+	}
+
+	if rec.Entries > 0 {
+		//mark the recovered copy clean
+		binary.LittleEndian.PutUint32(out[4:], next)
+		binary.LittleEndian.PutUint32(out[8:], next)
+		binary.LittleEndian.PutUint32(out[0x1fc:], baseBlockChecksum(out))
+	}
+	return out, rec
+}
+
+// readLogs reads any transaction logs sitting next to the hive at path, using read to get at the files
+func readLogs(path string, read func(string) ([]byte, error)) map[string][]byte {
+	r := map[string][]byte{}
+	seen := map[string]bool{}
+	for _, suffix := range logSuffixes {
+		name := path + suffix
+		//case insensitive filesystems will find the same file twice
+		key := strings.ToUpper(name)
+		if seen[key] {
+			continue
+		}
+		if b, err := read(name); err == nil {
+			seen[key] = true
+			r[name] = b
+		}
+	}
+	return r
+}
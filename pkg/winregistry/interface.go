@@ -4,4 +4,6 @@ type WinRegIF interface {
 	GetVal(path string) (regtype uint32, val []byte, err error)
 	GetClass(path string) (b []byte, err error)
 	EnumKeys(path string) (subkeys []string, err error)
+	EnumValues(path string) (values []Value, err error)
+	KeyInfo(path string) (info KeyInfo, err error)
 }
@@ -0,0 +1,110 @@
+package winregistry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Key and value names are either 'compressed' (one byte per character, Latin-1) or UTF-16LE, depending on a flag in
+// the record. Lookups are case insensitive, comparing upper cased names the way Windows does.
+
+const (
+	KEY_COMP_NAME   = 0x20 //nk flag, name is compressed
+	VALUE_COMP_NAME = 0x1  //vk flag, name is compressed
+)
+
+// decodeName decodes a key or value name
+func decodeName(b []byte, compressed bool) string {
+	if !compressed {
+		return string(utf16.Decode(toUint16s(b)))
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// name returns the decoded name of an nk record
+func (b reg_blockStruct) name() string {
+	return decodeName(b.KeyName, b.Type&KEY_COMP_NAME != 0)
+}
+
+// valueName returns the decoded name of a vk record
+func (b reg_blockStruct) valueName() string {
+	return decodeName(b.Name[:b.NameLength], b.Flag&VALUE_COMP_NAME != 0)
+}
+
+// upcase upper cases a name one character at a time (no special casing, so ß stays ß like it does on Windows)
+func upcase(s string) string {
+	r := []rune(s)
+	for i, c := range r {
+		r[i] = unicode.ToUpper(c)
+	}
+	return string(r)
+}
+
+// nameEqual compares two key or value names case insensitively
+func nameEqual(a, b string) bool {
+	return a == b || upcase(a) == upcase(b)
+}
+
+// subkeyRef is an entry in a subkey index
+type subkeyRef struct {
+	offset uint32
+	hash   uint32 //lh only
+	hashed bool
+}
+
+// subkeyRefs flattens a subkey index into the keys it points to. lf and lh lists have a hint or hash next to each
+// offset, li lists are just offsets, and ri lists point to other (non ri) lists.
+func (w WinregRegistry) subkeyRefs(offset uint32, nested bool) ([]subkeyRef, error) {
+	d, err := w.getCell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) < 4 {
+		return nil, fmt.Errorf("%w: subkey index at 0x%x too short", ErrCorruptHive, offset)
+	}
+	magic := string(d[:2])
+	count := int(binary.LittleEndian.Uint16(d[2:]))
+	d = d[4:]
+	r := []subkeyRef{}
+	switch magic {
+	case "lf", "lh":
+		if len(d) < count*8 {
+			return nil, fmt.Errorf("%w: %s index at 0x%x too short", ErrCorruptHive, magic, offset)
+		}
+		for i := 0; i < count; i++ {
+			r = append(r, subkeyRef{
+				offset: binary.LittleEndian.Uint32(d[i*8:]),
+				hash:   binary.LittleEndian.Uint32(d[i*8+4:]),
+				hashed: magic == "lh",
+			})
+		}
+	case "li", "ri":
+		if len(d) < count*4 {
+			return nil, fmt.Errorf("%w: %s index at 0x%x too short", ErrCorruptHive, magic, offset)
+		}
+		for i := 0; i < count; i++ {
+			off := binary.LittleEndian.Uint32(d[i*4:])
+			if magic == "li" {
+				r = append(r, subkeyRef{offset: off})
+				continue
+			}
+			if nested {
+				return nil, fmt.Errorf("%w: nested ri index at 0x%x", ErrCorruptHive, offset)
+			}
+			sub, err := w.subkeyRefs(off, true)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, sub...)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown subkey index %q at 0x%x", ErrCorruptHive, magic, offset)
+	}
+	return r, nil
+}
@@ -0,0 +1,132 @@
+package winregistry
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Value is a single registry value, as returned by EnumValues. The default (unnamed) value has an empty Name.
+type Value struct {
+	Name string
+	Type uint32
+	Data []byte
+}
+
+// KeyInfo is the metadata of a registry key
+type KeyInfo struct {
+	Name        string
+	LastWritten time.Time
+	FileTime    uint64 //raw last written FILETIME
+	SubKeys     uint32
+	Values      uint32
+	Class       string
+	//self relative security descriptor, if it could be read
+	SecurityDescriptor []byte
+}
+
+// FILETIME is 100ns intervals since 1601
+const filetimeEpochDiff = 116444736000000000
+
+// FiletimeToTime converts a FILETIME to a time.Time. Zero, pre 1970 and 'never' (0x7fffffffffffffff) values are
+// returned as the zero time.
+func FiletimeToTime(ft uint64) time.Time {
+	if ft < filetimeEpochDiff || ft >= 0x7fffffffffffffff {
+		return time.Time{}
+	}
+	ft -= filetimeEpochDiff
+	return time.Unix(int64(ft/10000000), int64(ft%10000000)*100).UTC()
+}
+
+func timeToFiletime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100) + filetimeEpochDiff
+}
+
+// decodeUTF16 decodes little endian UTF-16, dropping anything after the first null
+func decodeUTF16(b []byte) string {
+	u := toUint16s(b)
+	for i, c := range u {
+		if c == 0 {
+			u = u[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// Decode returns the value data as a Go type: string for REG_SZ and REG_EXPAND_SZ, []string for REG_MULTI_SZ,
+// uint32 for REG_DWORD, uint64 for REG_QWORD, and the raw bytes for anything else.
+func (v Value) Decode() (interface{}, error) {
+	switch v.Type {
+	case REG_SZ, REG_EXPAND_SZ:
+		return decodeUTF16(v.Data), nil
+	case REG_MULTISZ:
+		r := []string{}
+		for _, s := range strings.Split(string(utf16.Decode(toUint16s(v.Data))), "\x00") {
+			if s == "" {
+				//list is terminated by an empty string
+				break
+			}
+			r = append(r, s)
+		}
+		return r, nil
+	case REG_DWORD:
+		if len(v.Data) < 4 {
+			return nil, fmt.Errorf("%w: REG_DWORD %s has %d bytes", ErrBadValue, v.Name, len(v.Data))
+		}
+		return binary.LittleEndian.Uint32(v.Data), nil
+	case REG_QWORD:
+		if len(v.Data) < 8 {
+			return nil, fmt.Errorf("%w: REG_QWORD %s has %d bytes", ErrBadValue, v.Name, len(v.Data))
+		}
+		return binary.LittleEndian.Uint64(v.Data), nil
+	}
+	return v.Data, nil
+}
+
+func toUint16s(b []byte) []uint16 {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return u
+}
+
+// String formats the decoded value data, falling back to hex for binary (or undecodable) data
+func (v Value) String() string {
+	d, err := v.Decode()
+	if err != nil {
+		return hex.EncodeToString(v.Data)
+	}
+	switch d := d.(type) {
+	case []byte:
+		return hex.EncodeToString(d)
+	case []string:
+		return strings.Join(d, "\n")
+	}
+	return fmt.Sprint(d)
+}
+
+// TypeName is the REG_* name of the value type
+func (v Value) TypeName() string {
+	if n, ok := regTypeNames[v.Type]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%x", v.Type)
+}
+
+var regTypeNames = map[uint32]string{
+	REG_NONE:      "REG_NONE",
+	REG_SZ:        "REG_SZ",
+	REG_EXPAND_SZ: "REG_EXPAND_SZ",
+	REG_BINARY:    "REG_BINARY",
+	REG_DWORD:     "REG_DWORD",
+	REG_MULTISZ:   "REG_MULTI_SZ",
+	REG_QWORD:     "REG_QWORD",
+}
@@ -3,11 +3,14 @@ package winregistry
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
+	"unicode/utf16"
+
+	"github.com/C-Sto/gosecretsdump/internal/corrupt"
 )
 
 const NONE = "NoneReturn"
@@ -39,6 +42,9 @@ const REG_DWORD = 0x04
 const REG_MULTISZ = 0x07
 const REG_QWORD = 0x0b
 
+// values bigger than this are stored as a big data (db) record on hive version 1.4+
+const BIG_DATA_SEGMENT_SIZE = 16344
+
 func (w winregF) Init(ind []byte) (winregF, error) {
 	r := winregF{}
 	d := make([]byte, len(ind))
@@ -51,7 +57,7 @@ func (w winregF) Init(ind []byte) (winregF, error) {
 
 	//do integrity stuff I guess?
 	if bytes.Compare(r.Magic[:], []byte("regf")) != 0 {
-		return r, fmt.Errorf("magic header on registry key failure")
+		return r, fmt.Errorf("%w: magic header on registry key failure", ErrCorruptHive)
 	}
 	return r, nil
 }
@@ -62,10 +68,11 @@ type fileInMem struct {
 
 type WinregRegistry struct {
 	//fd        *os.File
-	fd      fileInMem
-	regF    winregF
-	ident   string
-	rootKey reg_blockStruct
+	fd       fileInMem
+	regF     winregF
+	ident    string
+	rootKey  reg_blockStruct
+	recovery Recovery
 }
 
 type regdatablock struct {
@@ -79,6 +86,9 @@ type regHbinBlock struct {
 
 func (r regHbinBlock) Init(inData []byte) regHbinBlock {
 	rv := regHbinBlock{}
+	if len(inData) < 4 {
+		return rv
+	}
 	rv.DataBlockSize = int32(binary.LittleEndian.Uint32(inData[:4]))
 	x := rv.DataBlockSize
 	if rv.DataBlockSize < 0 {
@@ -110,7 +120,7 @@ func (r regHbin) Init(inData []byte) (regHbin, error) {
 	}
 
 	if bytes.Compare(hbin.Magic[:], []byte("hbin")) != 0 {
-		return hbin, errors.New("Bad Magic")
+		return hbin, fmt.Errorf("%w: bad hbin magic", ErrCorruptHive)
 	}
 	return hbin, nil
 }
@@ -129,16 +139,18 @@ func (w WinregRegistry) findRootKey() (reg_blockStruct, error) {
 		//When size is omitted or negative, the entire contents of the file will be read and returned
 		//fucking python
 		newData := w.fd.Read(0, w.fd.Len())
-		if hbin.OffsetNextHBin-4096 > 0 {
+		if hbin.OffsetNextHBin > 4096 {
 			newData = w.fd.Read(fp, int(hbin.OffsetNextHBin-4096)) // w.fileInMem[fp : fp+int(hbin.OffsetNextHBin-4096)]
 		}
-		fp += int(hbin.OffsetNextHBin - 4096)
+		if hbin.OffsetNextHBin > 4096 {
+			fp += int(hbin.OffsetNextHBin - 4096)
+		}
 		data = append(data, newData...)
 		data = data[0x20:]
 		//modification from impacket version. all this does is work out which one is the root key
-		for len(data) > 0 {
+		for len(data) >= 4 {
 			block := regHbinBlock{}.Init(data[:])
-			if string(block.Data[:2]) == "nk" { //don't care if it's not the nk block
+			if len(block.Data) >= 2 && string(block.Data[:2]) == "nk" { //don't care if it's not the nk block
 				//cat to block
 				nkBlock, _ := reg_blockStruct{}.Init(block.Data)
 				//if it's not the root, don't care
@@ -151,24 +163,102 @@ func (w WinregRegistry) findRootKey() (reg_blockStruct, error) {
 			data = data[4+len(block.Data):]
 		}
 	}
-	return reg_blockStruct{}, errors.New("Couldn't Find Root NK")
+	return reg_blockStruct{}, fmt.Errorf("%w: couldn't find root NK", ErrCorruptHive)
 }
 
 type WinRegLive struct {
 	BaseKey string
 }
 
-func InitOffline(s string) (WinRegIF, error) {
-	f, err := os.Open(s)
+func InitOffline(s string) (reg WinRegIF, err error) {
+	data, err := os.ReadFile(s)
 	if err != nil {
 		return WinregRegistry{}, err
 	}
-	r := WinregRegistry{}
-	data, err := ioutil.ReadAll(f)
+	return initHive(s, data, func() map[string][]byte { return readLogs(s, os.ReadFile) })
+}
+
+// InitFS opens the hive called name in fsys. Transaction logs are looked for next to it in fsys.
+func InitFS(fsys fs.FS, name string) (WinRegIF, error) {
+	data, err := fs.ReadFile(fsys, name)
 	if err != nil {
-		return r, err
+		return WinregRegistry{}, err
+	}
+	return initHive(name, data, func() map[string][]byte {
+		return readLogs(name, func(n string) ([]byte, error) { return fs.ReadFile(fsys, n) })
+	})
+}
+
+// Log is a transaction log of size bytes, read from R
+type Log struct {
+	Name string
+	R    io.ReaderAt
+	Size int64
+}
+
+// Options are the extras InitReader can be given
+type Options struct {
+	//Name the hive is reported as in its Recovery, "hive" if empty
+	Name string
+	//transaction logs to replay if the hive is dirty
+	Logs []Log
+}
+
+// InitReader reads a hive of size bytes from r. The whole hive (and any logs) is read into memory, so r isn't needed
+// after this returns. Without logs in the options, a dirty hive may be missing recent changes. Only the first of
+// opts is used.
+func InitReader(r io.ReaderAt, size int64, opts ...Options) (WinRegIF, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return WinregRegistry{}, err
+	}
+	o := Options{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	logs := map[string][]byte{}
+	for _, l := range o.Logs {
+		b, err := readAll(l.R, l.Size)
+		if err != nil {
+			return WinregRegistry{}, fmt.Errorf("%s: %w", l.Name, err)
+		}
+		logs[l.Name] = b
+	}
+	if o.Name == "" {
+		o.Name = "hive"
+	}
+	return initHive(o.Name, data, func() map[string][]byte { return logs })
+}
+
+// readAll reads size bytes from the start of r
+func readAll(r io.ReaderAt, size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("bad size %d", size)
+	}
+	data := make([]byte, size)
+	if n, err := r.ReadAt(data, 0); err != nil && !(err == io.EOF && int64(n) == size) {
+		return nil, err
+	}
+	return data, nil
+}
+
+// initHive parses a hive that has been read into memory. logs returns the transaction logs to replay if the hive is
+// dirty, and may be nil.
+func initHive(name string, data []byte, logs func() map[string][]byte) (reg WinRegIF, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
+	r := WinregRegistry{}
+	if len(data) < BASE_BLOCK_SIZE {
+		return r, fmt.Errorf("%w: file too short (%d bytes)", ErrCorruptHive, len(data))
+	}
+	//dirty hives need the transaction logs replayed, or recent changes (bootkey, new accounts) are missing
+	if isDirty(data) {
+		found := map[string][]byte{}
+		if logs != nil {
+			found = logs()
+		}
+		data, r.recovery = replayLogs(data, found)
+		r.recovery.Name = name
 	}
-	f.Close()
 	r.fd = fileInMem{data}
 	r.regF, err = winregF{}.Init(r.fd.Read(0, 4096)) // data[:4096])
 	if err != nil {
@@ -178,16 +268,26 @@ func InitOffline(s string) (WinRegIF, error) {
 	r.rootKey, err = r.findRootKey()
 
 	if err != nil {
-		return r, fmt.Errorf("Could not find root key: %s", err.Error())
+		return r, fmt.Errorf("Could not find root key: %w", err)
 	} else if r.regF.MajorVersion != 1 && r.regF.MinorVersion > 5 {
 		return r, fmt.Errorf("Unsupported version, unexpected value. Wanted major 1 and minor over 5 got major %d minor %x", r.regF.MajorVersion, r.regF.MinorVersion)
 	}
 	return r, nil
 }
 
+// Read returns count bytes from start. Anything past the end of the hive reads as zeros, and count is capped at the
+// size of the hive, so offsets and sizes from a corrupt hive can't panic or allocate more than the hive itself.
 func (f fileInMem) Read(start, count int) []byte {
+	if count < 0 {
+		count = 0
+	}
+	if count > len(f.data) {
+		count = len(f.data)
+	}
 	r := make([]byte, count)
-	copy(r, f.data[start:start+count])
+	if start >= 0 && start < len(f.data) {
+		copy(r, f.data[start:])
+	}
 	return r
 }
 
@@ -201,23 +301,15 @@ type RegVal struct {
 }
 
 func (w WinregRegistry) getBlock(t uint32) (val reg_blockStruct, err error) {
-	var block reg_hbinblock
-	sizeBytes := w.fd.Read(int(t+4096), 4) // w.fileInMem[4096 : 4096+4]
-
-	size := int32(binary.LittleEndian.Uint32(sizeBytes))*-1 - 4
-	data := append(sizeBytes, w.fd.Read(int(t+4096+4), int(size))...)
-	if len(data) == 0 {
-		return reg_blockStruct{}, errors.New("No Data")
+	data, err := w.getCell(t)
+	if err != nil {
+		return reg_blockStruct{}, err
 	}
-
-	block = reg_hbinblock{}.Init(data)
-	ret, err := reg_blockStruct{}.Init(block.Data)
-
-	if err == nil {
-		return ret, nil
+	ret, err := reg_blockStruct{}.Init(data)
+	if err != nil {
+		return reg_blockStruct{}, fmt.Errorf("%w at offset 0x%x", err, t)
 	}
-
-	return reg_blockStruct{}, errors.New("Couldn't find blocktype")
+	return ret, nil
 }
 
 type reg_blockStruct struct {
@@ -267,8 +359,25 @@ type reg_hbinblock struct {
 func (b reg_blockStruct) Init(data []byte) (reg_blockStruct, error) {
 
 	ret := reg_blockStruct{}
+	if len(data) < 2 {
+		return ret, fmt.Errorf("%w: empty cell", ErrCorruptHive)
+	}
 
 	copy(ret.Magic[:], data[:2]) //ret.Magic = data[:2]
+	switch string(ret.Magic[:]) {
+	case "nk":
+		if len(data) < 0x4c || 0x4c+int(binary.LittleEndian.Uint16(data[0x48:])) > len(data) {
+			return ret, fmt.Errorf("%w: truncated key record", ErrCorruptHive)
+		}
+	case "vk":
+		if len(data) < 0x14 || 0x14+int(binary.LittleEndian.Uint16(data[2:])) > len(data) {
+			return ret, fmt.Errorf("%w: truncated value record", ErrCorruptHive)
+		}
+	case "lf", "lh":
+		if len(data) < 4 {
+			return ret, fmt.Errorf("%w: truncated subkey index", ErrCorruptHive)
+		}
+	}
 	data = data[2:]
 	switch string(ret.Magic[:]) {
 	case "nk":
@@ -339,85 +448,43 @@ func (b reg_hbinblock) Init(ind []byte) reg_hbinblock {
 	d := make([]byte, len(ind))
 	copy(d, ind)
 	r := reg_hbinblock{}
+	if len(d) < 4 {
+		return r
+	}
 	r.DataBlockSize = int32(binary.LittleEndian.Uint32(d[:4]))
 	r.Data = d[4:]
 	return r
 }
 
-func (w WinregRegistry) compareHash(magic [2]byte, hashData []byte, key string) (uint32, error) {
-	if string(magic[:]) == "ri" {
-		//offset := binary.LittleEndian.Uint32(hashData[:4])
-		//nk, _ := w.getBlock(offset)
-		return 0, fmt.Errorf("Not implemented: registry RI")
-	}
-
-	hashRec := reg_hash{}
-	buffer := bytes.NewBuffer(hashData)
-	err := binary.Read(buffer, binary.LittleEndian, &hashRec)
-	if err != nil {
-		return 0, err
-	}
-	switch string(magic[:]) {
-	case "lf":
-		for len(key) < 4 {
-			key = key + "\x00"
-		}
-		if string(hashRec.KeyName[:4]) == key[:4] { //strip \x00?
-			return hashRec.OffsetNk, nil
-		}
-	case "lh": //ZZZZZ GETLHHASH IS WRONG?
-		if binary.LittleEndian.Uint32(hashRec.KeyName[:]) == w.getLhHash(key) {
-			return hashRec.OffsetNk, nil
-		}
-	}
-
-	return 0, errors.New("Not Found")
-}
-
+// getLhHash is the hash stored next to each entry of an lh list: the upper cased name as UTF-16, res*37+char
 func (w WinregRegistry) getLhHash(key string) uint32 {
-	res := 0
-	for i := 0; i < len(key); i++ {
-		b := strings.ToUpper(key)[i]
-		res *= 37
-		res += int(b)
+	res := uint32(0)
+	for _, c := range utf16.Encode([]rune(upcase(key))) {
+		res = res*37 + uint32(c)
 	}
-
-	return uint32(int64(res) % 0x100000000)
-}
-
-type reg_hash struct {
-	OffsetNk uint32
-	KeyName  [4]byte
+	return res
 }
 
 func (w WinregRegistry) enumKey(parent reg_blockStruct) (r []string, err error) {
 	if parent.NumSubKeys < 1 {
 		return
 	}
-
-	lf, err := w.getBlock(parent.OffsetSubKeyLf)
-	data := lf.HashRecords
-
-	if bytes.Compare(lf.Magic[:], []byte("ri")) == 0 {
-		return r, fmt.Errorf("Not yet implemented: RI registry")
+	refs, err := w.subkeyRefs(parent.OffsetSubKeyLf, false)
+	if err != nil {
+		return r, err
 	}
-	for i := uint32(0); i < parent.NumSubKeys; i++ {
-		hashRec := reg_hash{}
-		err := binary.Read(bytes.NewBuffer(data[:8]), binary.LittleEndian, &hashRec)
-		if err != nil {
-			panic(err)
-		}
-		nk, err := w.getBlock(hashRec.OffsetNk)
+	for _, ref := range refs {
+		nk, err := w.getBlock(ref.offset)
 		if err != nil {
 			return r, err
 		}
-		r = append(r, string(nk.KeyName))
-		data = data[8:]
+		r = append(r, nk.name())
 	}
 	return
 }
 
 func (w WinregRegistry) EnumKeys(s string) (r []string, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
 	f, err := w.findKey(s)
 	if err != nil {
 		return r, err
@@ -426,30 +493,28 @@ func (w WinregRegistry) EnumKeys(s string) (r []string, err error) {
 }
 
 func (w WinregRegistry) findSubKey(parKey reg_blockStruct, subkey string) (reg_blockStruct, error) {
-	lf, err := w.getBlock(parKey.OffsetSubKeyLf)
-
+	if parKey.NumSubKeys == 0 {
+		return reg_blockStruct{}, ErrNotFound
+	}
+	refs, err := w.subkeyRefs(parKey.OffsetSubKeyLf, false)
 	if err != nil {
 		return reg_blockStruct{}, err
 	}
-	data := make([]byte, len(lf.HashRecords))
-	copy(data, lf.HashRecords)
-	if string(lf.Magic[:]) == "ri" {
-		return reg_blockStruct{}, fmt.Errorf("Not implemented: registry RI subkey")
-	}
-	for record := uint32(0); record < parKey.NumSubKeys; record++ {
-		hashrec := make([]byte, 8)
-		copy(hashrec, data[:8])
-		res, err := w.compareHash(lf.Magic, hashrec, subkey)
-		if res != 0 && err == nil {
-			nk, _ := w.getBlock(res)
-			if strings.Replace(string(nk.KeyName), "\x00", "", -1) == subkey {
-				//if string(nk.KeyName) == subkey {
-				return nk, nil
-			}
+	hash := w.getLhHash(subkey)
+	for _, ref := range refs {
+		//lh hashes are a cheap way to skip most of the list, everything else needs the name compared
+		if ref.hashed && ref.hash != hash {
+			continue
+		}
+		nk, err := w.getBlock(ref.offset)
+		if err != nil {
+			return reg_blockStruct{}, err
+		}
+		if nameEqual(nk.name(), subkey) {
+			return nk, nil
 		}
-		data = data[8:]
 	}
-	return reg_blockStruct{}, errors.New(NONE) //lf, nil
+	return reg_blockStruct{}, ErrNotFound
 }
 
 func (w WinregRegistry) findKey(s string) (reg_blockStruct, error) {
@@ -474,84 +539,249 @@ func (w WinregRegistry) findKey(s string) (reg_blockStruct, error) {
 //(no trailing slash on key, no starting/trailing slash on val)
 func getKVFromPath(s string) (string, string) {
 	lastSlash := strings.LastIndex(s, "\\")
+	if lastSlash < 0 {
+		return "", s
+	}
 	return s[:lastSlash], s[lastSlash+1:]
 }
 
-func (w WinregRegistry) getValBlocks(offset, count uint32) []reg_blockStruct {
-	valList := []int32{}
+func (w WinregRegistry) getValBlocks(offset, count uint32) ([]reg_blockStruct, error) {
 	res := []reg_blockStruct{}
-
-	ptr := int(4096 + offset)
-
-	for i := uint32(0); i < count; i++ {
-		valList = append(valList, int32(binary.LittleEndian.Uint32(w.fd.Read(ptr, 4))))
-		ptr = ptr + 4
+	list, err := w.getCell(offset)
+	if err != nil {
+		return res, err
+	}
+	if uint64(len(list)) < uint64(count)*4 {
+		return res, fmt.Errorf("%w: value list at 0x%x too short for %d values", ErrCorruptHive, offset, count)
 	}
 
-	for _, valOff := range valList {
+	for i := uint32(0); i < count; i++ {
+		valOff := int32(binary.LittleEndian.Uint32(list[i*4:]))
 		if valOff > 0 {
 			block, err := w.getBlock(uint32(valOff))
 			if err != nil {
-				fmt.Println("UNDEFINED BEHAVIOUR???? PLS CHECK GETVALBLOCKS OK", valOff, err.Error())
-				continue
+				return res, err
 			}
 			res = append(res, block)
 		}
 	}
-	return res
+	return res, nil
 }
 
-func (w WinregRegistry) GetVal(s string) (uint32, []byte, error) {
+func (w WinregRegistry) GetVal(s string) (t uint32, b []byte, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
 	regKey, regValue := getKVFromPath(s)
 
 	key, err := w.findKey(regKey)
-
-	if err != nil || key.NumValues < 1 {
-
-		return 0, nil, errors.New(NONE)
+	if err != nil {
+		return 0, nil, err
+	}
+	if key.NumValues < 1 {
+		return 0, nil, ErrNotFound
 	}
 
 	//we are here in py version
 	//        if key['NumValues'] > 0:
 
-	valueList := w.getValBlocks(key.OffsetValueList, key.NumValues+1)
+	valueList, err := w.getValBlocks(key.OffsetValueList, key.NumValues)
+	if err != nil {
+		return 0, nil, err
+	}
 	for _, val := range valueList {
-		name := string(val.Name[:val.NameLength])
-		if name == regValue {
-			return val.ValueType, w.getValData(val), nil
-		} else if regValue == "default" && val.Flag <= 0 {
-			return val.ValueType, w.getValData(val), nil
+		if nameEqual(val.valueName(), regValue) || (regValue == "default" && val.Flag <= 0) {
+			b, err := w.getValData(val)
+			return val.ValueType, b, err
+		}
+	}
+	return 0, nil, ErrNotFound
+}
+
+// EnumValues returns every value of the key at s, with its type and data
+func (w WinregRegistry) EnumValues(s string) (r []Value, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
+	key, err := w.findKey(s)
+	if err != nil {
+		return r, err
+	}
+	if key.NumValues < 1 {
+		return r, nil
+	}
+	valueList, err := w.getValBlocks(key.OffsetValueList, key.NumValues)
+	if err != nil {
+		return r, err
+	}
+	for _, val := range valueList {
+		if string(val.Magic[:]) != "vk" {
+			return r, fmt.Errorf("%w: expected value record in value list of %s", ErrCorruptHive, s)
+		}
+		b, err := w.getValData(val)
+		if err != nil {
+			return r, err
+		}
+		r = append(r, Value{Name: val.valueName(), Type: val.ValueType, Data: b})
+	}
+	return r, nil
+}
+
+// KeyInfo returns the metadata of the key at s: last written time, subkey/value counts, class name and security
+// descriptor
+func (w WinregRegistry) KeyInfo(s string) (ki KeyInfo, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
+	key, err := w.findKey(s)
+	if err != nil {
+		return ki, err
+	}
+	ki = KeyInfo{
+		Name:        key.name(),
+		FileTime:    key.lastChange,
+		LastWritten: FiletimeToTime(key.lastChange),
+		SubKeys:     key.NumSubKeys,
+		Values:      key.NumValues,
+	}
+	if key.ClassNameLength > 0 && key.OffsetClassName != 0xffffffff {
+		class, err := w.getCell(key.OffsetClassName)
+		if err != nil {
+			return ki, err
+		}
+		if len(class) < int(key.ClassNameLength) {
+			return ki, fmt.Errorf("%w: class name of %s too short", ErrCorruptHive, s)
 		}
+		ki.Class = decodeUTF16(class[:key.ClassNameLength])
 	}
-	return 0, nil, errors.New(NONE)
+	if key.OffsetSkRecord != 0xffffffff {
+		//sk: magic, reserved, flink, blink, reference count, descriptor size, descriptor
+		sk, err := w.getCell(key.OffsetSkRecord)
+		if err != nil {
+			return ki, err
+		}
+		if len(sk) < 0x14 || string(sk[:2]) != "sk" {
+			return ki, fmt.Errorf("%w: expected security record for %s", ErrCorruptHive, s)
+		}
+		size := binary.LittleEndian.Uint32(sk[0x10:])
+		if uint32(len(sk)-0x14) < size {
+			return ki, fmt.Errorf("%w: security descriptor of %s too short", ErrCorruptHive, s)
+		}
+		ki.SecurityDescriptor = sk[0x14 : 0x14+size]
+	}
+	return ki, nil
+}
+
+func (w WinregRegistry) getValData(val reg_blockStruct) ([]byte, error) {
+	return w.valData(val, false)
 }
 
-func (w WinregRegistry) getValData(val reg_blockStruct) []byte {
-	//something about a VK record?
-	if val.DataLen == 0 {
-		return []byte{}
+// valData reads the data of a value. free allows the data to be in unallocated cells, for carved values.
+func (w WinregRegistry) valData(val reg_blockStruct, free bool) ([]byte, error) {
+	length := uint32(val.DataLen) & 0x7fffffff
+	if length == 0 {
+		return []byte{}, nil
 	}
-	if val.DataLen < 5 { //possibly a bug, the comment says 'below 5', but the code is below 0
+	if val.DataLen < 0 { //high bit set means the data is resident, stored in the offset field
 		d := make([]byte, 4)
 		binary.LittleEndian.PutUint32(d, val.OffsetData)
-		return d
+		if length > 4 {
+			length = 4
+		}
+		return d[:length], nil
+	}
+	//hive version 1.4+ splits anything too big for one cell into a big data record
+	if length > BIG_DATA_SEGMENT_SIZE && w.regF.MinorVersion > 3 {
+		return w.getBigData(val.OffsetData, length, free)
 	}
-	return w.getData(int32(val.OffsetData), val.DataLen+4)
+	if free {
+		d, err := w.readCell(val.OffsetData, true)
+		if err != nil {
+			return nil, err
+		}
+		if uint32(len(d)) < length {
+			return nil, fmt.Errorf("%w: value data cell at 0x%x too short", ErrCorruptHive, val.OffsetData)
+		}
+		return d[:length], nil
+	}
+	d, err := w.getCell(val.OffsetData)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(d)) < length {
+		return nil, fmt.Errorf("%w: value data cell at 0x%x too short", ErrCorruptHive, val.OffsetData)
+	}
+	return d[:length], nil
 }
 
-func (w WinregRegistry) getData(offset, len int32) []byte {
-	d := w.fd.Read(int(4096+offset), int(len))
-	return d[4:] //not entirely sure why dropping the first 4 bytes, but ok
+// getCell returns the data of the allocated cell at offset (without the size)
+func (w WinregRegistry) getCell(offset uint32) ([]byte, error) {
+	return w.readCell(offset, false)
 }
 
-func (w WinregRegistry) GetClass(s string) ([]byte, error) {
+// readCell returns the data of the cell at offset. free allows unallocated cells.
+func (w WinregRegistry) readCell(offset uint32, free bool) ([]byte, error) {
+	start := int(offset) + 4096
+	if start < 4096 || start+4 > w.fd.Len() {
+		return nil, fmt.Errorf("%w: cell offset 0x%x out of range", ErrCorruptHive, offset)
+	}
+	size := int32(binary.LittleEndian.Uint32(w.fd.Read(start, 4)))
+	if size > 0 && free {
+		size = -size
+	}
+	if size >= -4 || start+int(-size) > w.fd.Len() {
+		return nil, fmt.Errorf("%w: no allocated cell at offset 0x%x", ErrCorruptHive, offset)
+	}
+	return w.fd.Read(start+4, int(-size-4)), nil
+}
+
+// getBigData follows a db record's segment list, joining the segments back into length bytes of value data
+func (w WinregRegistry) getBigData(offset, length uint32, free bool) ([]byte, error) {
+	db, err := w.readCell(offset, free)
+	if err != nil {
+		return nil, err
+	}
+	if len(db) < 8 || string(db[:2]) != "db" {
+		return nil, fmt.Errorf("%w: expected big data record at offset 0x%x", ErrCorruptHive, offset)
+	}
+	numSegments := int(binary.LittleEndian.Uint16(db[2:]))
+	list, err := w.readCell(binary.LittleEndian.Uint32(db[4:]), free)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) < numSegments*4 {
+		return nil, fmt.Errorf("%w: big data segment list too short", ErrCorruptHive)
+	}
+	r := make([]byte, 0, length)
+	for i := 0; i < numSegments && uint32(len(r)) < length; i++ {
+		seg, err := w.readCell(binary.LittleEndian.Uint32(list[i*4:]), free)
+		if err != nil {
+			return nil, err
+		}
+		want := length - uint32(len(r))
+		if want > BIG_DATA_SEGMENT_SIZE {
+			want = BIG_DATA_SEGMENT_SIZE
+		}
+		if uint32(len(seg)) < want {
+			return nil, fmt.Errorf("%w: big data segment %d too short", ErrCorruptHive, i)
+		}
+		r = append(r, seg[:want]...)
+	}
+	if uint32(len(r)) != length {
+		return nil, fmt.Errorf("%w: big data record has %d of %d bytes", ErrCorruptHive, len(r), length)
+	}
+	return r, nil
+}
+
+func (w WinregRegistry) GetClass(s string) (b []byte, err error) {
+	defer corrupt.Recover(&err, ErrCorruptHive)
 	key, err := w.findKey(s)
 	if err != nil {
 		return []byte{}, err
 	}
 	if key.OffsetClassName > 0 {
-		val, _ := w.getBlock(key.OffsetClassName)
-		return val.Data[:key.ClassNameLength], nil
+		class, err := w.getCell(key.OffsetClassName)
+		if err != nil {
+			return []byte{}, err
+		}
+		if len(class) < int(key.ClassNameLength) {
+			return []byte{}, fmt.Errorf("%w: class name of %s too short", ErrCorruptHive, s)
+		}
+		return class[:key.ClassNameLength], nil
 	}
-	return []byte{}, fmt.Errorf("Class name not found?")
+	return []byte{}, fmt.Errorf("%w: class name of %s", ErrNotFound, s)
 }
@@ -1,9 +1,12 @@
 package winregistry
 
 import (
+	"fmt"
 	"strings"
 	"syscall"
+	"unsafe"
 
+	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/registry"
 )
 
@@ -20,23 +23,50 @@ func InitLive(s string) (WinRegIF, error) {
 func (l LiveReg) GetVal(path string) (regtype uint32, val []byte, err error) {
 	splits := strings.Split(path, `\`)
 	key := splits[len(splits)-1]
+	if key == "default" {
+		//the default value has no name
+		key = ""
+	}
 	joinpath := strings.Join(splits[:len(splits)-1], `\`)
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, l.BasePath+joinpath, registry.QUERY_VALUE)
 	if err != nil {
-		return
+		return 0, nil, liveErr(err)
 	}
 	defer k.Close()
-	buff := make([]byte, 1024)
-	n, regtype, err := k.GetValue(key, buff)
-	buff = buff[:n]
-	return regtype, buff, err
+	buff, regtype, err := readValue(k, key)
+	if err != nil {
+		return 0, nil, liveErr(err)
+	}
+	return regtype, buff, nil
+}
+
+// readValue reads a whole value, asking for its size first
+func readValue(k registry.Key, name string) ([]byte, uint32, error) {
+	n, _, err := k.GetValue(name, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	buff := make([]byte, n)
+	n, regtype, err := k.GetValue(name, buff)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buff[:n], regtype, nil
+}
+
+// liveErr maps 'not found' errors from the registry API onto ErrNotFound, so callers can treat live and offline the same
+func liveErr(err error) error {
+	if err == registry.ErrNotExist {
+		return fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+	return err
 }
 
 func (l LiveReg) GetClass(path string) (r []byte, err error) {
 	//welp
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, l.BasePath+path, 0x19)
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, liveErr(err)
 	}
 	defer k.Close()
 
@@ -68,8 +98,58 @@ func (l LiveReg) GetClass(path string) (r []byte, err error) {
 func (l LiveReg) EnumKeys(path string) (subkeys []string, err error) {
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, l.BasePath+path+`\`, registry.ENUMERATE_SUB_KEYS)
 	if err != nil {
-		return subkeys, err
+		return subkeys, liveErr(err)
 	}
 	defer k.Close()
 	return k.ReadSubKeyNames(0)
 }
+
+func (l LiveReg) EnumValues(path string) (values []Value, err error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, l.BasePath+path, registry.QUERY_VALUE)
+	if err != nil {
+		return values, liveErr(err)
+	}
+	defer k.Close()
+	names, err := k.ReadValueNames(0)
+	if err != nil {
+		return values, err
+	}
+	for _, name := range names {
+		b, regtype, err := readValue(k, name)
+		if err != nil {
+			return values, liveErr(err)
+		}
+		values = append(values, Value{Name: name, Type: regtype, Data: b})
+	}
+	return values, nil
+}
+
+func (l LiveReg) KeyInfo(path string) (info KeyInfo, err error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, l.BasePath+path, registry.READ)
+	if err != nil {
+		return info, liveErr(err)
+	}
+	defer k.Close()
+	st, err := k.Stat()
+	if err != nil {
+		return info, err
+	}
+	splits := strings.Split(strings.TrimRight(l.BasePath+path, `\`), `\`)
+	info = KeyInfo{
+		Name:        splits[len(splits)-1],
+		LastWritten: st.ModTime().UTC(),
+		FileTime:    timeToFiletime(st.ModTime()),
+		SubKeys:     st.SubKeyCount,
+		Values:      st.ValueCount,
+	}
+	if class, err := l.GetClass(path); err == nil {
+		info.Class = string(class)
+	}
+	//SACL needs SeSecurityPrivilege, so only owner, group and DACL
+	sd, err := windows.GetSecurityInfo(windows.Handle(k), windows.SE_REGISTRY_KEY,
+		windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err == nil {
+		info.SecurityDescriptor = append([]byte{}, unsafe.Slice((*byte)(unsafe.Pointer(sd)), sd.Length())...)
+	}
+	return info, nil
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/cmd"
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+// crackHashes is a domain user with everything crackable, a computer account with a kerberos key, an account sharing
+// a password with the first, and an account with no password
+func crackHashes() []ditreader.DumpedHash {
+	lm, _ := hex.DecodeString("e52cac67419a9a224a3b108f3fa6cb6d")
+	return []ditreader.DumpedHash{
+		{
+			Username: "corp.local\\alice",
+			Rid:      1104,
+			LMHash:   lm,
+			NTHash:   kdf.NTHash("password"),
+			Supp: ditreader.SuppInfo{
+				Username: "corp.local\\alice",
+				KerbKeys: []string{
+					"corp.local\\alice:aes256-cts-hmac-sha1-96:" + hex.EncodeToString(make([]byte, 32)),
+					"corp.local\\alice:aes128-cts-hmac-sha1-96:" + hex.EncodeToString(make([]byte, 16)),
+					"corp.local\\alice:des-cbc-md5:0011223344556677",
+				},
+				KerbSalt: "CORP.LOCALalice",
+			},
+			History: ditreader.PwdHistory{NTHist: [][]byte{kdf.NTHash("Password0"), ditreader.EmptyNT}},
+		},
+		{
+			Username: "WS01$",
+			Rid:      1105,
+			LMHash:   ditreader.EmptyLM,
+			NTHash:   kdf.NTHash("machine"),
+			Supp: ditreader.SuppInfo{
+				KerbKeys: []string{"WS01$:aes256-cts-hmac-sha1-96:" + hex.EncodeToString(make([]byte, 32))},
+				KerbSalt: "CORP.LOCALhostws01.corp.local",
+			},
+		},
+		{Username: "bob", Rid: 1106, LMHash: ditreader.EmptyLM, NTHash: kdf.NTHash("password")},
+		{Username: "guest", Rid: 501, LMHash: ditreader.EmptyLM, NTHash: ditreader.EmptyNT},
+		{Secret: "[*] DefaultPassword\n(Unknown User):hunter2"},
+		{Username: "CORP\\carol", CachedHash: "corp.local/carol:$DCC2$10240#carol#00112233445566778899aabbccddeeff: (2024-01-02 03:04:05)"},
+		//mscash v1, not output
+		{Username: "CORP\\dave", CachedHash: "corp.local/dave:00112233445566778899aabbccddeeff:dave: (2024-01-02 03:04:05)"},
+	}
+}
+
+func formatAll(f cmd.Format) []cmd.Line {
+	r := []cmd.Line{}
+	for _, dh := range crackHashes() {
+		r = append(r, f(dh)...)
+	}
+	return r
+}
+
+func TestHashcatFormat(t *testing.T) {
+	nt := hex.EncodeToString(kdf.NTHash("password"))
+	key256, key128 := hex.EncodeToString(make([]byte, 32)), hex.EncodeToString(make([]byte, 16))
+	got := formatAll(cmd.HashcatFormat(cmd.CrackOptions{History: true}))
+	want := []cmd.Line{
+		{Kind: ".nt.hashcat-1000", Text: "corp.local\\alice:" + nt},
+		{Kind: ".lm.hashcat-3000", Text: "corp.local\\alice:e52cac67419a9a22"},
+		{Kind: ".lm.hashcat-3000", Text: "corp.local\\alice:4a3b108f3fa6cb6d"},
+		{Kind: ".nt.hashcat-1000", Text: "corp.local\\alice_history0:" + hex.EncodeToString(kdf.NTHash("Password0"))},
+		{Kind: ".aes256.hashcat-28900", Text: "corp.local\\alice:$krb5db$18$alice$CORP.LOCAL$" + key256},
+		{Kind: ".aes128.hashcat-28800", Text: "corp.local\\alice:$krb5db$17$alice$CORP.LOCAL$" + key128},
+		{Kind: ".nt.hashcat-1000", Text: "WS01$:" + hex.EncodeToString(kdf.NTHash("machine"))},
+		{Kind: ".aes256.hashcat-28900", Text: "WS01$:$krb5db$18$hostws01.corp.local$CORP.LOCAL$" + key256},
+		{Kind: ".nt.hashcat-1000", Text: "bob:" + nt},
+		{Kind: ".dcc2.hashcat-2100", Text: "CORP\\carol:$DCC2$10240#carol#00112233445566778899aabbccddeeff"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bad lines\n%q\nexpected\n%q", got, want)
+	}
+
+	//bob has the same password as alice, and the empty hashes are back (the empty LM on WS01$, which had it first)
+	got = formatAll(cmd.HashcatFormat(cmd.CrackOptions{Dedup: true, KeepEmpty: true}))
+	lines := map[string]bool{}
+	for _, l := range got {
+		lines[l.Text] = true
+	}
+	emptyLM := hex.EncodeToString(ditreader.EmptyLM[:8])
+	if lines["bob:"+nt] || !lines["guest:"+hex.EncodeToString(ditreader.EmptyNT)] || !lines["WS01$:"+emptyLM] || lines["guest:"+emptyLM] {
+		t.Errorf("bad dedup/empty handling %q", got)
+	}
+}
+
+func TestJohnFormat(t *testing.T) {
+	got := formatAll(cmd.JohnFormat(cmd.CrackOptions{}))
+	want := []cmd.Line{
+		{Kind: ".nt.john-NT", Text: "corp.local\\alice:$NT$" + hex.EncodeToString(kdf.NTHash("password"))},
+		{Kind: ".lm.john-LM", Text: "corp.local\\alice:$LM$e52cac67419a9a22"},
+		{Kind: ".lm.john-LM", Text: "corp.local\\alice:$LM$4a3b108f3fa6cb6d"},
+		{Kind: ".aes256.john-krb5-18", Text: "corp.local\\alice:$krb18$CORP.LOCALalice$" + hex.EncodeToString(make([]byte, 32))},
+		{Kind: ".aes128.john-krb5-17", Text: "corp.local\\alice:$krb17$CORP.LOCALalice$" + hex.EncodeToString(make([]byte, 16))},
+		{Kind: ".nt.john-NT", Text: "WS01$:$NT$" + hex.EncodeToString(kdf.NTHash("machine"))},
+		{Kind: ".aes256.john-krb5-18", Text: "WS01$:$krb18$CORP.LOCALhostws01.corp.local$" + hex.EncodeToString(make([]byte, 32))},
+		{Kind: ".nt.john-NT", Text: "bob:$NT$" + hex.EncodeToString(kdf.NTHash("password"))},
+		{Kind: ".dcc2.john-mscash2", Text: "CORP\\carol:$DCC2$10240#carol#00112233445566778899aabbccddeeff"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bad lines\n%q\nexpected\n%q", got, want)
+	}
+
+	//a short password's LM hash has a blank second half
+	short := ditreader.DumpedHash{Username: "eve", LMHash: append(append([]byte{}, 1, 2, 3, 4, 5, 6, 7, 8), ditreader.EmptyLM[8:]...)}
+	if got := cmd.JohnFormat(cmd.CrackOptions{})(short); len(got) != 1 || got[0].Text != "eve:$LM$0102030405060708" {
+		t.Errorf("bad short LM %q", got)
+	}
+	if got := cmd.HashcatFormat(cmd.CrackOptions{})(short); len(got) != 1 || got[0].Text != "eve:0102030405060708" {
+		t.Errorf("bad short LM %q", got)
+	}
+}
+
+func TestCrackFiles(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "out")
+	//an earlier secretsdump run to the same place
+	os.WriteFile(base+cmd.OUT_CLEARTEXT, []byte("stale\n"), 0644)
+	s, err := cmd.NewFileSink(base, cmd.HashcatFormat(cmd.CrackOptions{Dedup: true}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dh := range crackHashes() {
+		s.Write(dh)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(base + ".nt.hashcat-1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "corp.local\\alice:" + hex.EncodeToString(kdf.NTHash("password")) + "\n" +
+		"WS01$:" + hex.EncodeToString(kdf.NTHash("machine")) + "\n"
+	if string(b) != want {
+		t.Errorf("bad nt file %q", b)
+	}
+	for _, f := range []string{".lm.hashcat-3000", ".dcc2.hashcat-2100", ".aes128.hashcat-28800", ".aes256.hashcat-28900"} {
+		if _, err := os.Stat(base + f); err != nil {
+			t.Error(err)
+		}
+	}
+	//nothing that isn't a hash
+	for _, f := range []string{base, base + cmd.OUT_CLEARTEXT} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s shouldn't exist: %v", f, err)
+		}
+	}
+}
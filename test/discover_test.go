@@ -0,0 +1,187 @@
+package test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/C-Sto/gosecretsdump/pkg/discover"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/klauspost/compress/zstd"
+)
+
+// eseFile is just enough of an ESE database to be recognised
+func eseFile(tables ...string) []byte {
+	b := make([]byte, 4*8192)
+	copy(b[4:], discover.ESE_MAGIC)
+	off := 4 * 4096
+	for _, t := range tables {
+		off += copy(b[off:], t) + 16
+	}
+	return b
+}
+
+// collection is an IFM dump next to a backup of another machine's config directory, with some noise
+func collection(t *testing.T) map[string][]byte {
+	samBootKey = []byte{0x13, 0xd2, 0x09, 0x76, 0xd6, 0x3e, 0xa5, 0xe8, 0x36, 0x03, 0x6e, 0xc8, 0xbc, 0x68, 0xd6, 0xeb}
+	defer func() { samBootKey = []byte("fedcba9876543210") }()
+	system, err := os.ReadFile("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sam := hiveFromFake(samRegistry(t, true))
+	log := append([]byte{}, sam...)
+	log[0x1c] = 1
+	return map[string][]byte{
+		"ifm/Active Directory/ntds.dit": eseFile("MSysObjects", "datatable", "link_table"),
+		"ifm/registry/SYSTEM":           system,
+		"ifm/registry/SECURITY":         hiveFromFake(fakeRegistry{vals: map[string][]byte{"\\Policy\\PolEKList\\default": {1}}}),
+		"host2/config/SYSTEM":           system,
+		"host2/config/SAM":              sam,
+		"host2/config/SAM.LOG1":         log,
+		"host2/config/SOFTWARE":         hiveFromFake(fakeRegistry{vals: map[string][]byte{"\\Microsoft\\Windows NT\\CurrentVersion\\ProductName": utf16le("Windows 10 Pro")}}),
+		"host2/config/COMPONENTS":       hiveFromFake(fakeRegistry{vals: map[string][]byte{"\\CanonicalData\\Catalogs\\x": {1}}}),
+		"host2/srudb.dat":               eseFile("MSysObjects", "SruDbIdMapTable"),
+		"notes.txt":                     []byte("found these on the share"),
+	}
+}
+
+var collectionFound = []discover.Found{
+	{Path: "host2/config/SAM", Kind: discover.KIND_SAM},
+	{Path: "host2/config/SOFTWARE", Kind: discover.KIND_SOFTWARE},
+	{Path: "host2/config/SYSTEM", Kind: discover.KIND_SYSTEM},
+	{Path: "ifm/Active Directory/ntds.dit", Kind: discover.KIND_NTDS},
+	{Path: "ifm/registry/SECURITY", Kind: discover.KIND_SECURITY},
+	{Path: "ifm/registry/SYSTEM", Kind: discover.KIND_SYSTEM},
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := []string{}
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeTar(t *testing.T, w io.Writer, files map[string][]byte) {
+	tw := tar.NewWriter(w)
+	for _, n := range sortedNames(files) {
+		if err := tw.WriteHeader(&tar.Header{Name: n, Mode: 0644, Size: int64(len(files[n])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(files[n])
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// archives writes the files out as a directory and each kind of archive, returning their paths
+func archives(t *testing.T, files map[string][]byte) map[string]string {
+	dir := t.TempDir()
+	r := map[string]string{"dir": filepath.Join(dir, "dir")}
+	for n, b := range files {
+		p := filepath.Join(r["dir"], filepath.FromSlash(n))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zb := &bytes.Buffer{}
+	zw := zip.NewWriter(zb)
+	for _, n := range sortedNames(files) {
+		w, _ := zw.Create(n)
+		w.Write(files[n])
+	}
+	zw.Close()
+
+	tb, gb, sb := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	writeTar(t, tb, files)
+	gw := gzip.NewWriter(gb)
+	gw.Write(tb.Bytes())
+	gw.Close()
+	sw, _ := zstd.NewWriter(sb)
+	sw.Write(tb.Bytes())
+	sw.Close()
+
+	//extensions are deliberately wrong, the contents are what counts
+	for name, b := range map[string][]byte{"zip": zb.Bytes(), "tar": tb.Bytes(), "tar.gz": gb.Bytes(), "tar.zst": sb.Bytes()} {
+		r[name] = filepath.Join(dir, "collection."+name+".bin")
+		if err := os.WriteFile(r[name], b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestDiscoverScan(t *testing.T) {
+	files := collection(t)
+	for kind, p := range archives(t, files) {
+		fsys, closer, err := discover.Open(p)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		found, err := discover.Scan(fsys)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if !reflect.DeepEqual(found, collectionFound) {
+			t.Errorf("%s: found %v", kind, found)
+		}
+		//the paired up SAM dumps
+		sr, err := samreader.NewFS(fsys, "host2/config/SYSTEM", "host2/config/SAM")
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if dh := dumpSAM(t, sr); len(dh) != 1 || dh[0].Username != "labuser" {
+			t.Errorf("%s: unexpected users %+v", kind, dh)
+		}
+		closer.Close()
+	}
+}
+
+func TestDiscoverPair(t *testing.T) {
+	sets := discover.Pair(collectionFound)
+	want := []discover.Set{
+		{System: "host2/config/SYSTEM", SAM: []string{"host2/config/SAM"}, Software: []string{"host2/config/SOFTWARE"}},
+		{System: "ifm/registry/SYSTEM", NTDS: []string{"ifm/Active Directory/ntds.dit"}, Security: []string{"ifm/registry/SECURITY"}},
+	}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("bad pairing %+v", sets)
+	}
+
+	//without a SYSTEM only SOFTWARE can be dumped
+	sets = discover.Pair([]discover.Found{
+		{Path: "a/SAM", Kind: discover.KIND_SAM},
+		{Path: "a/SOFTWARE", Kind: discover.KIND_SOFTWARE},
+	})
+	if !reflect.DeepEqual(sets, []discover.Set{{Software: []string{"a/SOFTWARE"}}}) {
+		t.Errorf("bad pairing %+v", sets)
+	}
+}
+
+func TestDiscoverErrors(t *testing.T) {
+	dir := t.TempDir()
+	junkFile := filepath.Join(dir, "junk")
+	os.WriteFile(junkFile, junk(1024), 0644)
+	if _, _, err := discover.Open(junkFile); !errors.Is(err, discover.ErrUnknownArchive) {
+		t.Errorf("expected unknown archive, got %v", err)
+	}
+	if _, _, err := discover.Open(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, err := discover.Scan(fstest.MapFS{"notes.txt": {Data: []byte("nothing here")}}); !errors.Is(err, discover.ErrNothingFound) {
+		t.Errorf("expected nothing found, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/esent"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// writeTemp writes b to a file in a temp dir and returns the path
+func writeTemp(t *testing.T, name string, b []byte) string {
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func junk(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func TestHiveNotFound(t *testing.T) {
+	r, err := winregistry.InitOffline("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"\\Select\\NotAValue", "\\NotAKey\\Value", "\\Select\\NotAKey\\Value"} {
+		if _, _, err := r.GetVal(p); !errors.Is(err, winregistry.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", p, err)
+		}
+	}
+}
+
+func TestCorruptHive(t *testing.T) {
+	if _, err := winregistry.InitOffline(writeTemp(t, "junk", junk(0x10000))); !errors.Is(err, winregistry.ErrCorruptHive) {
+		t.Errorf("expected ErrCorruptHive for a junk file, got %v", err)
+	}
+
+	//a truncated hive opens (the root key is near the start) but lookups run off the end
+	b, err := os.ReadFile("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trunc := writeTemp(t, "system", b[:0x10000])
+	r, err := winregistry.InitOffline(trunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.GetVal("\\Select\\Current"); !errors.Is(err, winregistry.ErrCorruptHive) {
+		t.Errorf("expected ErrCorruptHive, got %v", err)
+	}
+
+	s, err := systemreader.New(trunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.BootKey()
+	if !errors.Is(err, systemreader.ErrNoBootKey) || !errors.Is(err, winregistry.ErrCorruptHive) {
+		t.Errorf("expected ErrNoBootKey wrapping ErrCorruptHive, got %v", err)
+	}
+}
+
+func TestCorruptESE(t *testing.T) {
+	for name, b := range map[string][]byte{"zeros": make([]byte, 0x10000), "junk": junk(0x10000)} {
+		p := writeTemp(t, name, b)
+		if _, err := (esent.Esedb{}).Init(p); !errors.Is(err, esent.ErrCorruptPage) {
+			t.Errorf("%s: expected ErrCorruptPage, got %v", name, err)
+		}
+		if _, err := ditreader.New("system", p); !errors.Is(err, esent.ErrCorruptPage) {
+			t.Errorf("%s: expected ErrCorruptPage from ditreader, got %v", name, err)
+		}
+	}
+}
+
+func TestSAMErrors(t *testing.T) {
+	reg := samRegistry(t, false)
+	if _, err := samreader.FromRegistry(reg, []byte("0123456789abcdef"), true).SysKey(); !errors.Is(err, samreader.ErrBadChecksum) {
+		t.Errorf("expected ErrBadChecksum, got %v", err)
+	}
+
+	f := samF(t, false)
+	f[0] = 5
+	reg.vals["\\SAM\\Domains\\Account\\F"] = f
+	if _, err := samreader.FromRegistry(reg, samBootKey, true).SysKey(); !errors.Is(err, samreader.ErrUnsupportedRevision) {
+		t.Errorf("expected ErrUnsupportedRevision, got %v", err)
+	}
+
+	delete(reg.vals, "\\SAM\\Domains\\Account\\F")
+	if err := samreader.FromRegistry(reg, samBootKey, true).Dump(); !errors.Is(err, winregistry.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPEKErrors(t *testing.T) {
+	peks := ditreader.PEKList{fixturePEK}
+	if _, err := peks.DecryptHistory(aesHistoryBlob(t, 1), fixtureRid); !errors.Is(err, ditreader.ErrBadPEK) {
+		t.Errorf("expected ErrBadPEK, got %v", err)
+	}
+	if _, err := peks.DecryptHash([]byte{0x11, 0, 0, 0}, fixtureRid); !errors.Is(err, ditreader.ErrBadHash) {
+		t.Errorf("expected ErrBadHash, got %v", err)
+	}
+	//AES blob with a ciphertext that isn't a whole number of blocks
+	blob := aesHistoryBlob(t, 0)
+	if _, err := peks.DecryptHistory(blob[:len(blob)-3], fixtureRid); !errors.Is(err, ditreader.ErrBadHash) {
+		t.Errorf("expected ErrBadHash, got %v", err)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	if err := ditreader.Incomplete(nil); err != nil {
+		t.Errorf("nothing skipped, got %v", err)
+	}
+	errs := []error{}
+	for i := 0; i < 15; i++ {
+		errs = append(errs, ditreader.ErrBadHash)
+	}
+	err := ditreader.Incomplete(errs)
+	if !errors.Is(err, ditreader.ErrIncomplete) || !errors.Is(err, ditreader.ErrBadHash) {
+		t.Errorf("expected ErrIncomplete wrapping ErrBadHash, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "15 skipped") || !strings.HasSuffix(err.Error(), "and 5 more") {
+		t.Errorf("bad error %q", err)
+	}
+}
@@ -0,0 +1,719 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// hiveKey and hiveValue describe a key tree for buildHive
+type hiveKey struct {
+	name      string
+	class     string
+	lastWrite uint64 //FILETIME
+	sd        []byte //security descriptor
+	deleted   bool   //written to free cells, and left out of the parent's subkey list
+	index     string //subkey index type: lf, lh (default), li or ri
+	values    []hiveValue
+	subkeys   []*hiveKey
+}
+
+type hiveValue struct {
+	name    string
+	typ     uint32
+	data    []byte
+	deleted bool
+}
+
+// hiveBuilder lays out cells in the hive bins data. Offsets are relative to the start of the hive bins, which is
+// 0x1000 into the file.
+type hiveBuilder struct {
+	bins []byte
+}
+
+// alloc adds an allocated cell big enough for n bytes of data and returns its offset
+func (h *hiveBuilder) alloc(n int) uint32 {
+	size := (4 + n + 7) &^ 7
+	off := uint32(len(h.bins))
+	cell := make([]byte, size)
+	binary.LittleEndian.PutUint32(cell, uint32(-int32(size)))
+	h.bins = append(h.bins, cell...)
+	return off
+}
+
+// cell returns the data part of the cell at off
+func (h *hiveBuilder) cell(off uint32) []byte {
+	return h.bins[off+4:]
+}
+
+// free marks the cell at off as unallocated
+func (h *hiveBuilder) free(off uint32) {
+	size := int32(binary.LittleEndian.Uint32(h.bins[off:]))
+	if size < 0 {
+		binary.LittleEndian.PutUint32(h.bins[off:], uint32(-size))
+	}
+}
+
+// freeValue frees a vk cell and its data
+func (h *hiveBuilder) freeValue(off uint32) {
+	vk := h.cell(off)
+	if binary.LittleEndian.Uint32(vk[4:])&0x80000000 == 0 {
+		h.free(binary.LittleEndian.Uint32(vk[8:]))
+	}
+	h.free(off)
+}
+
+func (h *hiveBuilder) put(data []byte) uint32 {
+	off := h.alloc(len(data))
+	copy(h.cell(off), data)
+	return off
+}
+
+// lhHash is the hash stored in lh subkey lists
+func lhHash(name string) uint32 {
+	r := uint32(0)
+	for _, c := range utf16.Encode([]rune(name)) {
+		r = r*37 + uint32(unicode.ToUpper(rune(c)))
+	}
+	return r
+}
+
+// nameBytes encodes a key or value name the way Windows does: one byte per character if it fits, otherwise UTF-16
+func nameBytes(name string) ([]byte, bool) {
+	b := []byte{}
+	for _, c := range name {
+		if c > 0xff {
+			return utf16le(name), false
+		}
+		b = append(b, byte(c))
+	}
+	return b, true
+}
+
+// putIndex writes a subkey index of the given type
+func (h *hiveBuilder) putIndex(kind string, offs []uint32, names []string) uint32 {
+	if kind == "ri" {
+		//split into two lh lists
+		half := (len(offs) + 1) / 2
+		ri := []byte("ri")
+		ri = binary.LittleEndian.AppendUint16(ri, 2)
+		ri = binary.LittleEndian.AppendUint32(ri, h.putIndex("lh", offs[:half], names[:half]))
+		ri = binary.LittleEndian.AppendUint32(ri, h.putIndex("li", offs[half:], names[half:]))
+		return h.put(ri)
+	}
+	if kind == "" {
+		kind = "lh"
+	}
+	list := []byte(kind)
+	list = binary.LittleEndian.AppendUint16(list, uint16(len(offs)))
+	for i, off := range offs {
+		list = binary.LittleEndian.AppendUint32(list, off)
+		switch kind {
+		case "lh":
+			list = binary.LittleEndian.AppendUint32(list, lhHash(names[i]))
+		case "lf":
+			//first 4 characters as a hint
+			hint := make([]byte, 4)
+			for j, c := range []rune(names[i]) {
+				if j < 4 {
+					hint[j] = byte(c)
+				}
+			}
+			list = append(list, hint...)
+		}
+	}
+	return h.put(list)
+}
+
+func (h *hiveBuilder) addValue(v hiveValue) uint32 {
+	name, compressed := nameBytes(v.name)
+	vk := make([]byte, 0x14+len(name))
+	copy(vk, "vk")
+	binary.LittleEndian.PutUint16(vk[2:], uint16(len(name)))
+	if len(v.data) <= 4 {
+		//resident, the data lives in the offset field
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(v.data))|0x80000000)
+		copy(vk[8:12], v.data)
+	} else if len(v.data) > winregistry.BIG_DATA_SEGMENT_SIZE {
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(v.data)))
+		binary.LittleEndian.PutUint32(vk[8:], h.putBigData(v.data))
+	} else {
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(v.data)))
+		binary.LittleEndian.PutUint32(vk[8:], h.put(v.data))
+	}
+	binary.LittleEndian.PutUint32(vk[0xc:], v.typ)
+	if compressed {
+		binary.LittleEndian.PutUint16(vk[0x10:], 1)
+	}
+	copy(vk[0x14:], name)
+	return h.put(vk)
+}
+
+// putBigData splits data into segments and returns the offset of the db record pointing at them
+func (h *hiveBuilder) putBigData(data []byte) uint32 {
+	list := []byte{}
+	for len(data) > 0 {
+		n := len(data)
+		if n > winregistry.BIG_DATA_SEGMENT_SIZE {
+			n = winregistry.BIG_DATA_SEGMENT_SIZE
+		}
+		list = binary.LittleEndian.AppendUint32(list, h.put(data[:n]))
+		data = data[n:]
+	}
+	db := make([]byte, 8)
+	copy(db, "db")
+	binary.LittleEndian.PutUint16(db[2:], uint16(len(list)/4))
+	binary.LittleEndian.PutUint32(db[4:], h.put(list))
+	return h.put(db)
+}
+
+func (h *hiveBuilder) addKey(k *hiveKey, parent uint32, root bool) uint32 {
+	name, compressed := nameBytes(k.name)
+	off := h.alloc(0x4c + len(name))
+
+	live := []uint32{}
+	names := []string{}
+	for _, sk := range k.subkeys {
+		skOff := h.addKey(sk, off, false)
+		if !sk.deleted {
+			live = append(live, skOff)
+			names = append(names, sk.name)
+		}
+	}
+	subkeys := uint32(0xffffffff)
+	if len(live) > 0 {
+		subkeys = h.putIndex(k.index, live, names)
+	}
+	valOffs := []uint32{}
+	for _, v := range k.values {
+		vOff := h.addValue(v)
+		if v.deleted {
+			h.freeValue(vOff)
+			continue
+		}
+		valOffs = append(valOffs, vOff)
+	}
+	values := uint32(0xffffffff)
+	if len(valOffs) > 0 {
+		list := make([]byte, 4*len(valOffs))
+		for i, v := range valOffs {
+			binary.LittleEndian.PutUint32(list[i*4:], v)
+		}
+		values = h.put(list)
+	}
+	class := uint32(0xffffffff)
+	if k.class != "" {
+		class = h.put(utf16le(k.class))
+	}
+	sk := uint32(0xffffffff)
+	if k.sd != nil {
+		rec := make([]byte, 0x14)
+		copy(rec, "sk")
+		binary.LittleEndian.PutUint32(rec[0xc:], 1)
+		binary.LittleEndian.PutUint32(rec[0x10:], uint32(len(k.sd)))
+		sk = h.put(append(rec, k.sd...))
+	}
+
+	nk := h.cell(off)
+	copy(nk, "nk")
+	flags := uint16(0)
+	if compressed {
+		flags = 0x20
+	}
+	if root {
+		flags |= 0xc
+	}
+	binary.LittleEndian.PutUint16(nk[2:], flags)
+	binary.LittleEndian.PutUint64(nk[4:], k.lastWrite)
+	binary.LittleEndian.PutUint32(nk[0x10:], parent)
+	binary.LittleEndian.PutUint32(nk[0x14:], uint32(len(live)))
+	binary.LittleEndian.PutUint32(nk[0x1c:], subkeys)
+	binary.LittleEndian.PutUint32(nk[0x20:], 0xffffffff)
+	binary.LittleEndian.PutUint32(nk[0x24:], uint32(len(valOffs)))
+	binary.LittleEndian.PutUint32(nk[0x28:], values)
+	binary.LittleEndian.PutUint32(nk[0x2c:], sk)
+	binary.LittleEndian.PutUint32(nk[0x30:], class)
+	binary.LittleEndian.PutUint16(nk[0x48:], uint16(len(name)))
+	binary.LittleEndian.PutUint16(nk[0x4a:], uint16(len(k.class)*2))
+	copy(nk[0x4c:], name)
+	if k.deleted {
+		for _, v := range valOffs {
+			h.freeValue(v)
+		}
+		if values != 0xffffffff {
+			h.free(values)
+		}
+		if subkeys != 0xffffffff {
+			h.free(subkeys)
+		}
+		h.free(off)
+	}
+	return off
+}
+
+// baseBlockChecksum is the XOR of the first 508 bytes of a base block as dwords
+func baseBlockChecksum(b []byte) uint32 {
+	x := uint32(0)
+	for i := 0; i < 0x1fc; i += 4 {
+		x ^= binary.LittleEndian.Uint32(b[i:])
+	}
+	return x
+}
+
+// baseBlock builds a regf base block
+func baseBlock(seq1, seq2, rootOff, binsSize uint32, minor uint32) []byte {
+	b := make([]byte, 4096)
+	copy(b, "regf")
+	binary.LittleEndian.PutUint32(b[4:], seq1)
+	binary.LittleEndian.PutUint32(b[8:], seq2)
+	binary.LittleEndian.PutUint32(b[0x14:], 1)
+	binary.LittleEndian.PutUint32(b[0x18:], minor)
+	binary.LittleEndian.PutUint32(b[0x20:], 1)
+	binary.LittleEndian.PutUint32(b[0x24:], rootOff)
+	binary.LittleEndian.PutUint32(b[0x28:], binsSize)
+	binary.LittleEndian.PutUint32(b[0x2c:], 1)
+	binary.LittleEndian.PutUint32(b[0x1fc:], baseBlockChecksum(b))
+	return b
+}
+
+// buildHive lays out a clean hive file: the base block, then a single hive bin holding every cell
+func buildHive(root *hiveKey) []byte {
+	h := hiveBuilder{bins: make([]byte, 0x20)}
+	rootOff := h.addKey(root, 0xffffffff, true)
+	size := (len(h.bins) + 4095) &^ 4095
+	//the rest of the bin is one free cell
+	if free := size - len(h.bins); free > 0 {
+		cell := make([]byte, free)
+		binary.LittleEndian.PutUint32(cell, uint32(free))
+		h.bins = append(h.bins, cell...)
+	}
+	copy(h.bins, "hbin")
+	binary.LittleEndian.PutUint32(h.bins[8:], uint32(size))
+	return append(baseBlock(1, 1, rootOff, uint32(size), 5), h.bins...)
+}
+
+// dirty marks a hive as not cleanly written, the way it looks with changes still in the logs
+func dirty(hive []byte) []byte {
+	r := append([]byte{}, hive...)
+	binary.LittleEndian.PutUint32(r[4:], 2)
+	binary.LittleEndian.PutUint32(r[0x1fc:], baseBlockChecksum(r))
+	return r
+}
+
+// newFormatLog builds a .LOG1 with a single HvLE entry holding every 512 byte page of bins that differs from old
+func newFormatLog(old, bins []byte, seq uint32, corrupt bool) []byte {
+	refs := []byte{}
+	pages := []byte{}
+	count := 0
+	for off := 0; off < len(bins); off += 512 {
+		if off+512 <= len(old) && bytes.Equal(old[off:off+512], bins[off:off+512]) {
+			continue
+		}
+		ref := make([]byte, 8)
+		binary.LittleEndian.PutUint32(ref, uint32(off))
+		binary.LittleEndian.PutUint32(ref[4:], 512)
+		refs = append(refs, ref...)
+		pages = append(pages, bins[off:off+512]...)
+		count++
+	}
+	entry := make([]byte, 0x28)
+	copy(entry, "HvLE")
+	entry = append(entry, refs...)
+	entry = append(entry, pages...)
+	entry = append(entry, make([]byte, (512-len(entry)%512)%512)...)
+	binary.LittleEndian.PutUint32(entry[4:], uint32(len(entry)))
+	binary.LittleEndian.PutUint32(entry[0xc:], seq)
+	binary.LittleEndian.PutUint32(entry[0x10:], uint32(len(bins)))
+	binary.LittleEndian.PutUint32(entry[0x14:], uint32(count))
+	binary.LittleEndian.PutUint64(entry[0x18:], winregistry.Marvin32(entry[0x28:], winregistry.MARVIN_SEED))
+	binary.LittleEndian.PutUint64(entry[0x20:], winregistry.Marvin32(entry[:0x20], winregistry.MARVIN_SEED))
+	if corrupt {
+		entry[len(entry)-1] ^= 0xff
+	}
+	log := baseBlock(seq, seq, 0x20, uint32(len(old)), 5)[:512]
+	binary.LittleEndian.PutUint32(log[0x1c:], 6) //new format log
+	binary.LittleEndian.PutUint32(log[0x1fc:], baseBlockChecksum(log))
+	return append(log, entry...)
+}
+
+// legacyLog builds a pre 8.1 log: a dirty vector with a bit per 512 byte sector, then the dirty sectors
+func legacyLog(old, bins []byte, seq uint32) []byte {
+	log := baseBlock(seq, seq, 0x20, uint32(len(bins)), 3)[:512]
+	binary.LittleEndian.PutUint32(log[0x1c:], 1)
+	binary.LittleEndian.PutUint32(log[0x1fc:], baseBlockChecksum(log))
+	bitmap := make([]byte, len(bins)/512/8)
+	sectors := []byte{}
+	for i := 0; i < len(bins)/512; i++ {
+		off := i * 512
+		if off+512 <= len(old) && bytes.Equal(old[off:off+512], bins[off:off+512]) {
+			continue
+		}
+		bitmap[i/8] |= 1 << (i % 8)
+		sectors = append(sectors, bins[off:off+512]...)
+	}
+	log = append(log, "DIRT"...)
+	log = append(log, bitmap...)
+	log = append(log, make([]byte, (512-len(log)%512)%512)...)
+	return append(log, sectors...)
+}
+
+// logHives returns the hive before and after a change that only made it into the logs
+func logHives() (old, updated []byte) {
+	old = buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{
+		{name: "Test", values: []hiveValue{{name: "Secret", typ: 3, data: []byte("old-secret-value")}}},
+	}})
+	subkeys := []*hiveKey{
+		{name: "Test", values: []hiveValue{{name: "Secret", typ: 3, data: []byte("new-secret-value")}}},
+	}
+	//enough keys to spill into a second page of hive bins
+	for i := 0; i < 40; i++ {
+		subkeys = append(subkeys, &hiveKey{name: "Added" + strings.Repeat("x", i), values: []hiveValue{{name: "V", typ: 3, data: []byte("added value")}}})
+	}
+	updated = buildHive(&hiveKey{name: "ROOT", subkeys: subkeys})
+	return old, updated
+}
+
+func openHive(t *testing.T, hive []byte, logs map[string][]byte) winregistry.WinregRegistry {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "SYSTEM")
+	if err := os.WriteFile(p, hive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for suffix, b := range logs {
+		if err := os.WriteFile(p+suffix, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := winregistry.InitOffline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.(winregistry.WinregRegistry)
+}
+
+func checkVal(t *testing.T, r winregistry.WinRegIF, path, want string) {
+	_, v, err := r.GetVal(path)
+	if err != nil {
+		t.Errorf("%s: %s", path, err)
+		return
+	}
+	if string(v) != want {
+		t.Errorf("%s: expected %q got %q", path, want, v)
+	}
+}
+
+func TestSyntheticHive(t *testing.T) {
+	old, _ := logHives()
+	r := openHive(t, old, nil)
+	checkVal(t, r, "\\Test\\Secret", "old-secret-value")
+	if r.Recovery().Dirty {
+		t.Error("clean hive reported as dirty")
+	}
+}
+
+func TestHiveLogNewFormat(t *testing.T) {
+	old, updated := logHives()
+	log := newFormatLog(old[4096:], updated[4096:], 1, false)
+
+	r := openHive(t, dirty(old), map[string][]byte{".LOG1": log})
+	checkVal(t, r, "\\Test\\Secret", "new-secret-value")
+	checkVal(t, r, "\\Added"+strings.Repeat("x", 39)+"\\V", "added value")
+	rec := r.Recovery()
+	if !rec.Dirty || rec.Entries != 1 || rec.Pages == 0 || len(rec.Logs) != 1 || filepath.Base(rec.Name) != "SYSTEM" {
+		t.Errorf("unexpected recovery %+v", rec)
+	}
+
+	//a clean hive ignores the logs, and isn't reported
+	clean := openHive(t, old, map[string][]byte{".LOG1": log})
+	checkVal(t, clean, "\\Test\\Secret", "old-secret-value")
+	if recs := winregistry.Recoveries(clean, r); len(recs) != 1 || recs[0].Name != rec.Name {
+		t.Errorf("unexpected recoveries %+v", recs)
+	}
+
+	//entries older than the last clean write are ignored
+	r = openHive(t, dirty(old), map[string][]byte{".LOG1": newFormatLog(old[4096:], updated[4096:], 0, false)})
+	checkVal(t, r, "\\Test\\Secret", "old-secret-value")
+}
+
+func TestHiveLogReader(t *testing.T) {
+	old, updated := logHives()
+	hive, log := dirty(old), newFormatLog(old[4096:], updated[4096:], 1, false)
+	r, err := winregistry.InitReader(bytes.NewReader(hive), int64(len(hive)), winregistry.Options{
+		Name: "SYSTEM",
+		Logs: []winregistry.Log{{Name: "SYSTEM.LOG1", R: bytes.NewReader(log), Size: int64(len(log))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVal(t, r, "\\Test\\Secret", "new-secret-value")
+	if rec := r.(winregistry.WinregRegistry).Recovery(); rec.Name != "SYSTEM" || rec.Entries != 1 || !reflect.DeepEqual(rec.Logs, []string{"SYSTEM.LOG1"}) {
+		t.Errorf("unexpected recovery %+v", rec)
+	}
+
+	//no logs, nothing to replay
+	r, err = winregistry.InitReader(bytes.NewReader(hive), int64(len(hive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVal(t, r, "\\Test\\Secret", "old-secret-value")
+}
+
+func TestHiveLogBadHash(t *testing.T) {
+	old, updated := logHives()
+	r := openHive(t, dirty(old), map[string][]byte{".LOG1": newFormatLog(old[4096:], updated[4096:], 1, true)})
+	checkVal(t, r, "\\Test\\Secret", "old-secret-value")
+	if rec := r.Recovery(); !rec.Dirty || rec.Entries != 0 {
+		t.Errorf("unexpected recovery %+v", rec)
+	}
+}
+
+func TestHiveLogLegacy(t *testing.T) {
+	old, updated := logHives()
+	r := openHive(t, dirty(old), map[string][]byte{".LOG": legacyLog(old[4096:], updated[4096:], 1)})
+	checkVal(t, r, "\\Test\\Secret", "new-secret-value")
+	checkVal(t, r, "\\Added\\V", "added value")
+	if rec := r.Recovery(); rec.Entries != 1 || rec.Pages == 0 {
+		t.Errorf("unexpected recovery %+v", rec)
+	}
+}
+
+func TestHiveBigData(t *testing.T) {
+	pattern := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i * 7 / 3)
+		}
+		return b
+	}
+	big := pattern(40000)
+	single := pattern(winregistry.BIG_DATA_SEGMENT_SIZE)
+	hive := buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{
+		{name: "Users", values: []hiveValue{
+			{name: "V", typ: 3, data: big},
+			{name: "Single", typ: 3, data: single},
+			{name: "Short", typ: 3, data: []byte{0xaa, 0xbb}},
+			{name: "Dword", typ: 4, data: []byte{1, 2, 3, 4}},
+		}},
+	}})
+	r := openHive(t, hive, nil)
+	checkVal(t, r, "\\Users\\V", string(big))
+	checkVal(t, r, "\\Users\\Single", string(single))
+	checkVal(t, r, "\\Users\\Short", "\xaa\xbb")
+	checkVal(t, r, "\\Users\\Dword", "\x01\x02\x03\x04")
+
+	//a broken segment list is an error rather than truncated data
+	i := bytes.Index(hive[4096:], []byte("db\x03\x00")) + 4096
+	binary.LittleEndian.PutUint32(hive[i+4:], 0xfff0)
+	if _, _, err := openHive(t, hive, nil).GetVal("\\Users\\V"); !errors.Is(err, winregistry.ErrCorruptHive) {
+		t.Errorf("expected corrupt hive error, got %v", err)
+	}
+}
+
+func TestHiveEnumValues(t *testing.T) {
+	sd := []byte{1, 0, 4, 0x80, 0x14, 0, 0, 0}
+	hive := buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{
+		{name: "Triage", class: "abcd", lastWrite: 0x01d5a3e4b9c7f000, sd: sd, values: []hiveValue{
+			{name: "", typ: winregistry.REG_SZ, data: utf16le("default\x00")},
+			{name: "Path", typ: winregistry.REG_EXPAND_SZ, data: utf16le("%SystemRoot%\\system32\x00")},
+			{name: "Multi", typ: winregistry.REG_MULTISZ, data: utf16le("one\x00two\x00\x00")},
+			{name: "Count", typ: winregistry.REG_DWORD, data: []byte{0x2a, 0, 0, 0}},
+			{name: "Big", typ: winregistry.REG_QWORD, data: []byte{1, 0, 0, 0, 0, 0, 0, 0x80}},
+			{name: "Blob", typ: winregistry.REG_BINARY, data: []byte{0xde, 0xad, 0xbe, 0xef, 0x01}},
+		}, subkeys: []*hiveKey{{name: "Child"}}},
+	}})
+	r := openHive(t, hive, nil)
+
+	vals, err := r.EnumValues("\\Triage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name string
+		val  interface{}
+		str  string
+	}{
+		{"", "default", "default"},
+		{"Path", "%SystemRoot%\\system32", "%SystemRoot%\\system32"},
+		{"Multi", []string{"one", "two"}, "one\ntwo"},
+		{"Count", uint32(42), "42"},
+		{"Big", uint64(0x8000000000000001), "9223372036854775809"},
+		{"Blob", []byte{0xde, 0xad, 0xbe, 0xef, 0x01}, "deadbeef01"},
+	}
+	if len(vals) != len(want) {
+		t.Fatalf("expected %d values got %d", len(want), len(vals))
+	}
+	for i, w := range want {
+		v := vals[i]
+		if v.Name != w.name {
+			t.Errorf("value %d: expected name %q got %q", i, w.name, v.Name)
+		}
+		d, err := v.Decode()
+		if err != nil {
+			t.Errorf("%s: %s", v.Name, err)
+		}
+		if !reflect.DeepEqual(d, w.val) {
+			t.Errorf("%s: expected %#v got %#v", v.Name, w.val, d)
+		}
+		if v.String() != w.str {
+			t.Errorf("%s: expected %q got %q", v.Name, w.str, v.String())
+		}
+	}
+	if vals[2].TypeName() != "REG_MULTI_SZ" {
+		t.Errorf("unexpected type name %s", vals[2].TypeName())
+	}
+	if _, err := (winregistry.Value{Type: winregistry.REG_DWORD, Data: []byte{1}}).Decode(); !errors.Is(err, winregistry.ErrBadValue) {
+		t.Errorf("expected bad value error, got %v", err)
+	}
+
+	ki, err := r.KeyInfo("\\Triage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ki.Name != "Triage" || ki.SubKeys != 1 || ki.Values != 6 || ki.Class != "abcd" || !bytes.Equal(ki.SecurityDescriptor, sd) {
+		t.Errorf("unexpected key info %+v", ki)
+	}
+	if ki.FileTime != 0x01d5a3e4b9c7f000 || ki.LastWritten.Year() != 2019 {
+		t.Errorf("unexpected last written time %x %s", ki.FileTime, ki.LastWritten)
+	}
+
+	if vals, err := r.EnumValues("\\Triage\\Child"); err != nil || len(vals) != 0 {
+		t.Errorf("expected no values, got %v %v", vals, err)
+	}
+	if _, err := r.KeyInfo("\\Missing"); !errors.Is(err, winregistry.ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestHiveDeletedKeys(t *testing.T) {
+	hive := buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{
+		{name: "Policy", subkeys: []*hiveKey{
+			{name: "Secrets", subkeys: []*hiveKey{
+				{name: "Live", values: []hiveValue{
+					{name: "Current", typ: 3, data: []byte("still here")},
+					{name: "Removed", typ: 3, data: []byte("removed value"), deleted: true},
+				}},
+				{name: "Gone", deleted: true, lastWrite: 0x01d5a3e4b9c7f000, subkeys: []*hiveKey{
+					{name: "CurrVal", deleted: true, values: []hiveValue{{name: "", typ: 3, data: []byte("deleted secret")}}},
+				}},
+			}},
+		}},
+	}})
+	r := openHive(t, hive, nil)
+	if keys, err := r.EnumKeys("\\Policy\\Secrets"); err != nil || len(keys) != 1 || keys[0] != "Live" {
+		t.Errorf("expected only the live key, got %v %v", keys, err)
+	}
+
+	keys, err := r.DeletedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]winregistry.DeletedKey{}
+	for _, k := range keys {
+		found[k.Path] = k
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 deleted keys, got %+v", keys)
+	}
+	gone, ok := found["\\Policy\\Secrets\\Gone"]
+	if !ok || gone.Name != "Gone" || gone.LastWritten.Year() != 2019 || gone.Offset == 0 {
+		t.Errorf("missing or bad deleted key %+v", gone)
+	}
+	cv, ok := found["\\Policy\\Secrets\\Gone\\CurrVal"]
+	if !ok || cv.Parent != gone.Offset || len(cv.Values) != 1 || string(cv.Values[0].Data) != "deleted secret" {
+		t.Errorf("missing or bad deleted key %+v", cv)
+	}
+
+	vals, err := r.DeletedValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[0].Name != "Removed" || string(vals[0].Data) != "removed value" {
+		t.Errorf("unexpected deleted values %+v", vals)
+	}
+}
+
+func TestHiveNames(t *testing.T) {
+	users := func(index string) *hiveKey {
+		return &hiveKey{name: "Users" + index, index: index, subkeys: []*hiveKey{
+			{name: "Administrator"},
+			{name: "Bénédicte", values: []hiveValue{{name: "Réglage", typ: 3, data: []byte("latin1 value")}}},
+			{name: "Пользователь", values: []hiveValue{{name: "Значение", typ: 3, data: []byte("utf16 value")}}},
+			{name: "A"},
+			{name: "ΣΊΣΥΦΟΣ"},
+		}}
+	}
+	hive := buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{users("lf"), users("lh"), users("li"), users("ri")}})
+	r := openHive(t, hive, nil)
+
+	for _, index := range []string{"lf", "lh", "li", "ri"} {
+		base := "\\Users" + index
+		keys, err := r.EnumKeys(base)
+		if err != nil {
+			t.Fatalf("%s: %s", index, err)
+		}
+		want := []string{"Administrator", "Bénédicte", "Пользователь", "A", "ΣΊΣΥΦΟΣ"}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("%s: expected %q got %q", index, want, keys)
+		}
+		for _, k := range want {
+			if _, err := r.KeyInfo(base + "\\" + k); err != nil {
+				t.Errorf("%s: %s: %s", index, k, err)
+			}
+		}
+		//case insensitive, including outside ASCII
+		checkVal(t, r, base+"\\BÉNÉDICTE\\réglage", "latin1 value")
+		checkVal(t, r, base+"\\пользователь\\ЗНАЧЕНИЕ", "utf16 value")
+		for _, k := range []string{"administrator", "a", "σίσυφοσ"} {
+			if _, err := r.KeyInfo(base + "\\" + k); err != nil {
+				t.Errorf("%s: %s: %s", index, k, err)
+			}
+		}
+		if _, err := r.KeyInfo(base + "\\Admin"); !errors.Is(err, winregistry.ErrNotFound) {
+			t.Errorf("%s: expected not found for a prefix, got %v", index, err)
+		}
+	}
+
+	vals, err := r.EnumValues("\\Userslh\\Пользователь")
+	if err != nil || len(vals) != 1 || vals[0].Name != "Значение" {
+		t.Errorf("unexpected values %+v %v", vals, err)
+	}
+}
+
+func TestMarvin32(t *testing.T) {
+	//test vectors from the .NET implementation
+	for in, want := range map[string]uint64{
+		"":             0x30ED35C100CD3C7D,
+		"\xaf":         0x48E73FC77D75DDC1,
+		"\xe7\x0f":     0xB5F6E1FC485DBFF8,
+		"\x37\xf4\x95": 0xF0B07C789B8CF7E8,
+	} {
+		if got := winregistry.Marvin32([]byte(in), 0x004FB61A001BDBCC); got != want {
+			t.Errorf("%x: expected %x got %x", in, want, got)
+		}
+	}
+}
+
+func TestFiletimeToTime(t *testing.T) {
+	for ft, want := range map[uint64]time.Time{
+		0:                  {},
+		0x7fffffffffffffff: {},
+		//before 1970
+		0x0100000000000000: {},
+		116444736000000000: time.Unix(0, 0).UTC(),
+		0x01d5a3e4b9c7f000: time.Date(2019, 11, 25, 23, 4, 44, 205670400, time.UTC),
+	} {
+		if got := winregistry.FiletimeToTime(ft); !got.Equal(want) {
+			t.Errorf("%x: expected %s got %s", ft, want, got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/securityreader"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+func TestHostInfo(t *testing.T) {
+	ls, err := systemreader.New("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const cv = "\\Microsoft\\Windows NT\\CurrentVersion\\"
+	hive := hiveFromFake(fakeRegistry{vals: map[string][]byte{
+		cv + "ProductName":        utf16le("Windows Server 2016 Standard\x00"),
+		cv + "CurrentBuildNumber": utf16le("14393\x00"),
+		cv + "ReleaseId":          utf16le("1607\x00"),
+		cv + "UBR":                {0x5f, 0x0d, 0, 0},
+	}})
+	software, err := winregistry.InitReader(bytes.NewReader(hive), int64(len(hive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi, err := ls.HostInfo(software)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hi.ComputerName != "ADDEMO" || hi.Hostname != "addemo" || hi.Domain != "demo.local" {
+		t.Errorf("bad names %+v", hi)
+	}
+	if hi.ProductType != systemreader.PRODUCT_DC || hi.Role() != "domain controller" {
+		t.Errorf("bad role %s (%s)", hi.Role(), hi.ProductType)
+	}
+	if hi.TimeZone != "Romance Standard Time" {
+		t.Errorf("bad timezone %s", hi.TimeZone)
+	}
+	if hi.LastShutdown.Truncate(time.Second) != time.Date(2016, 7, 10, 10, 57, 22, 0, time.UTC) {
+		t.Errorf("bad shutdown time %s", hi.LastShutdown)
+	}
+	if hi.ControlSet != "ControlSet001" || hi.ControlSets != (systemreader.ControlSets{Current: 1, Default: 1, LastKnownGood: 2}) {
+		t.Errorf("bad control sets %s %+v", hi.ControlSet, hi.ControlSets)
+	}
+	if hi.OS.ProductName != "Windows Server 2016 Standard" || hi.OS.DisplayVersion != "1607" || hi.OS.Build() != "14393.3423" {
+		t.Errorf("bad OS version %+v", hi.OS)
+	}
+
+	//without a SOFTWARE hive there's no OS version, but everything else still works
+	if hi, err = ls.HostInfo(nil); err != nil || hi.OS != (systemreader.OSVersion{}) || hi.ComputerName != "ADDEMO" {
+		t.Errorf("unexpected host info %+v %v", hi, err)
+	}
+}
+
+func TestUseControlSet(t *testing.T) {
+	ls, err := systemreader.New("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.UseControlSet(9); !errors.Is(err, winregistry.ErrNotFound) {
+		t.Errorf("expected not found for a missing control set, got %v", err)
+	}
+	if err := ls.UseControlSet(2); err != nil {
+		t.Fatal(err)
+	}
+	hi, err := ls.HostInfo(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hi.ControlSet != "ControlSet002" || hi.LastShutdown.Truncate(time.Second) != time.Date(2016, 7, 10, 10, 54, 2, 0, time.UTC) {
+		t.Errorf("override not used: %s %s", hi.ControlSet, hi.LastShutdown)
+	}
+	bk, err := ls.BootKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bk, []byte{0x13, 0xd2, 0x09, 0x76, 0xd6, 0x3e, 0xa5, 0xe8, 0x36, 0x03, 0x6e, 0xc8, 0xbc, 0x68, 0xd6, 0xeb}) {
+		t.Errorf("bad bootkey from ControlSet002 %x", bk)
+	}
+}
+
+func TestReaderControlSet(t *testing.T) {
+	system, err := os.ReadFile("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hive := buildHive(&hiveKey{name: "ROOT"})
+	sys := systemreader.Options{ControlSet: 9}
+	_, err = securityreader.NewReader(bytes.NewReader(system), int64(len(system)), bytes.NewReader(hive), int64(len(hive)), securityreader.Options{System: sys})
+	if !errors.Is(err, winregistry.ErrNotFound) {
+		t.Errorf("SECURITY reader didn't use the control set: %v", err)
+	}
+	_, err = samreader.NewReader(bytes.NewReader(system), int64(len(system)), bytes.NewReader(hive), int64(len(hive)), samreader.Options{System: sys})
+	if !errors.Is(err, winregistry.ErrNotFound) {
+		t.Errorf("SAM reader didn't use the control set: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/pkg/diskimage"
+	"github.com/C-Sto/gosecretsdump/pkg/ntfs"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+)
+
+func mbrEntry(b []byte, typ byte, start, count uint32) {
+	b[4] = typ
+	binary.LittleEndian.PutUint32(b[8:], start)
+	binary.LittleEndian.PutUint32(b[12:], count)
+}
+
+// mbrDisk puts vol in a logical partition, after a FAT partition and behind an EBR
+func mbrDisk(vol []byte) []byte {
+	sectors := uint32(len(vol) / 512)
+	disk := make([]byte, (25+int(sectors))*512)
+	mbrEntry(disk[446:], 0x0b, 1, 16)
+	mbrEntry(disk[446+16:], diskimage.MBR_EXTENDED_LBA, 17, 8+sectors)
+	disk[510], disk[511] = 0x55, 0xaa
+	ebr := disk[17*512:]
+	mbrEntry(ebr[446:], 0x07, 8, sectors)
+	ebr[510], ebr[511] = 0x55, 0xaa
+	copy(disk[25*512:], vol)
+	return disk
+}
+
+// gptDisk puts vol in the second GPT partition
+func gptDisk(vol []byte) []byte {
+	sectors := uint64(len(vol) / 512)
+	disk := make([]byte, (40+int(sectors))*512)
+	mbrEntry(disk[446:], diskimage.MBR_GPT, 1, uint32(len(disk)/512-1))
+	disk[510], disk[511] = 0x55, 0xaa
+	hdr := disk[512:]
+	copy(hdr, "EFI PART")
+	binary.LittleEndian.PutUint64(hdr[0x48:], 2)
+	binary.LittleEndian.PutUint32(hdr[0x50:], 4)
+	binary.LittleEndian.PutUint32(hdr[0x54:], 128)
+	for i, p := range []struct {
+		typ         string
+		first, last uint64
+		name        string
+	}{
+		{"C12A7328-F81F-11D2-BA4B-00A0C93EC93B", 34, 39, "EFI system partition"},
+		{"EBD0A0A2-B9E5-4433-87C0-68B6B72699C7", 40, 40 + sectors - 1, "Basic data partition"},
+	} {
+		e := disk[2*512+i*128:]
+		copy(e, mixedGUID(p.typ))
+		binary.LittleEndian.PutUint64(e[32:], p.first)
+		binary.LittleEndian.PutUint64(e[40:], p.last)
+		copy(e[56:], utf16le(p.name))
+	}
+	copy(disk[40*512:], vol)
+	return disk
+}
+
+func mixedGUID(s string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5], b[6], b[7] = b[5], b[4], b[7], b[6]
+	return b
+}
+
+func vhdFooter(size uint64, diskType uint32, dataOffset uint64) []byte {
+	f := make([]byte, 512)
+	copy(f, diskimage.VHD_COOKIE)
+	binary.BigEndian.PutUint64(f[16:], dataOffset)
+	binary.BigEndian.PutUint64(f[48:], size)
+	binary.BigEndian.PutUint32(f[60:], diskType)
+	return f
+}
+
+func fixedVHD(disk []byte) []byte {
+	return append(append([]byte{}, disk...), vhdFooter(uint64(len(disk)), diskimage.VHD_FIXED, 0xffffffffffffffff)...)
+}
+
+// dynamicVHD stores disk in 64K blocks, leaving blocks of zeros unallocated
+func dynamicVHD(disk []byte) []byte {
+	const blockSize = 64 * 1024
+	blocks := (len(disk) + blockSize - 1) / blockSize
+	batSize := (blocks*4 + 511) / 512 * 512
+	footer := vhdFooter(uint64(len(disk)), diskimage.VHD_DYNAMIC, 512)
+	r := append([]byte{}, footer...)
+	hdr := make([]byte, 1024)
+	copy(hdr, diskimage.VHD_DYNAMIC_COOKIE)
+	binary.BigEndian.PutUint64(hdr[16:], 1536)
+	binary.BigEndian.PutUint32(hdr[28:], uint32(blocks))
+	binary.BigEndian.PutUint32(hdr[32:], blockSize)
+	r = append(r, hdr...)
+	bat := make([]byte, batSize)
+	r = append(r, bat...)
+	for i := 0; i < blocks; i++ {
+		block := make([]byte, blockSize)
+		copy(block, disk[i*blockSize:])
+		if bytes.Equal(block, make([]byte, blockSize)) {
+			binary.BigEndian.PutUint32(bat[i*4:], 0xffffffff)
+			continue
+		}
+		binary.BigEndian.PutUint32(bat[i*4:], uint32(len(r)/512))
+		r = append(append(r, bytes.Repeat([]byte{0xff}, 512)...), block...)
+	}
+	copy(r[1536:], bat)
+	return append(r, footer...)
+}
+
+// vhdx stores disk in 1MB blocks, with the BAT and metadata regions after the headers
+func vhdx(disk []byte) []byte {
+	const (
+		mb        = 1 << 20
+		blockSize = mb
+		metadata  = mb
+		bat       = 2 * mb
+	)
+	blocks := (len(disk) + blockSize - 1) / blockSize
+	r := make([]byte, 3*mb)
+	copy(r, diskimage.VHDX_SIGNATURE)
+	checksum := func(b []byte) {
+		binary.LittleEndian.PutUint32(b[4:], crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	for i, off := range []int{64 * 1024, 128 * 1024} {
+		h := r[off : off+4096]
+		copy(h, "head")
+		binary.LittleEndian.PutUint64(h[8:], uint64(i+1))
+		copy(h[16:], mixedGUID("11111111-2222-3333-4444-555555555555"))
+		checksum(h)
+	}
+	for _, off := range []int{192 * 1024, 256 * 1024} {
+		rt := r[off : off+64*1024]
+		copy(rt, "regi")
+		binary.LittleEndian.PutUint32(rt[8:], 2)
+		copy(rt[16:], mixedGUID("2DC27766-F623-4200-9D64-115E9BFD4A08"))
+		binary.LittleEndian.PutUint64(rt[32:], bat)
+		binary.LittleEndian.PutUint32(rt[40:], mb)
+		copy(rt[48:], mixedGUID("8B7CA206-4790-4B9A-B8FE-575F050F886E"))
+		binary.LittleEndian.PutUint64(rt[64:], metadata)
+		binary.LittleEndian.PutUint32(rt[72:], 64*1024)
+		checksum(rt)
+	}
+	md := r[metadata : metadata+64*1024]
+	copy(md, "metadata")
+	binary.LittleEndian.PutUint16(md[10:], 3)
+	items := []struct {
+		id    string
+		value []byte
+	}{
+		{"CAA16737-FA36-4D43-B3B6-33F0AA44E76B", binary.LittleEndian.AppendUint32(make([]byte, 4), 0)},
+		{"2FA54224-CD1B-4876-B211-5DBED83BF4B8", binary.LittleEndian.AppendUint64(nil, uint64(len(disk)))},
+		{"8141BF1D-A96F-4709-BA47-F233A8FAAB5F", binary.LittleEndian.AppendUint32(nil, 512)},
+	}
+	binary.LittleEndian.PutUint32(items[0].value, blockSize)
+	for i, it := range items {
+		e := md[32+i*32:]
+		copy(e, mixedGUID(it.id))
+		off := 0x1000 + i*0x100
+		binary.LittleEndian.PutUint32(e[16:], uint32(off))
+		binary.LittleEndian.PutUint32(e[20:], uint32(len(it.value)))
+		copy(md[off:], it.value)
+	}
+	for i := 0; i < blocks; i++ {
+		block := make([]byte, blockSize)
+		copy(block, disk[i*blockSize:])
+		if bytes.Equal(block, make([]byte, blockSize)) {
+			binary.LittleEndian.PutUint64(r[bat+i*8:], diskimage.VHDX_BLOCK_ZERO)
+			continue
+		}
+		binary.LittleEndian.PutUint64(r[bat+i*8:], uint64(len(r))|diskimage.VHDX_BLOCK_FULLY_PRESENT)
+		r = append(r, block...)
+	}
+	return r
+}
+
+func TestDiskImages(t *testing.T) {
+	vol := windowsVolume(t)
+	for _, tc := range []struct {
+		name   string
+		image  []byte
+		format string
+		index  int
+		typ    string
+	}{
+		{"bare volume", vol, diskimage.FORMAT_RAW, 1, "volume"},
+		{"mbr", mbrDisk(vol), diskimage.FORMAT_RAW, 2, "0x07"},
+		{"gpt", gptDisk(vol), diskimage.FORMAT_RAW, 2, "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"},
+		{"fixed vhd", fixedVHD(gptDisk(vol)), diskimage.FORMAT_VHD, 2, "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"},
+		{"dynamic vhd", dynamicVHD(mbrDisk(vol)), diskimage.FORMAT_VHD, 2, "0x07"},
+		{"vhdx", vhdx(gptDisk(vol)), diskimage.FORMAT_VHDX, 2, "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"},
+	} {
+		d, err := diskimage.NewReader(bytes.NewReader(tc.image), int64(len(tc.image)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if d.Format() != tc.format || d.Dirty {
+			t.Errorf("%s: bad format %s dirty=%v", tc.name, d.Format(), d.Dirty)
+		}
+		parts, err := d.NTFSPartitions()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(parts) != 1 || parts[0].Index != tc.index || parts[0].Type != tc.typ || parts[0].Size != int64(len(vol)) {
+			t.Fatalf("%s: unexpected partitions %+v", tc.name, parts)
+		}
+		fsys, err := ntfs.New(parts[0])
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		sr, err := samreader.NewFS(fsys, "Windows/System32/config/SYSTEM", "Windows/System32/config/SAM")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		dh := dumpSAM(t, sr)
+		if len(dh) != 1 || dh[0].Username != "labuser" {
+			t.Fatalf("%s: unexpected users %+v", tc.name, dh)
+		}
+		checkHashes(t, tc.name, [][]byte{dh[0].NTHash}, [][]byte{samNT})
+	}
+}
+
+func TestDiskImageErrors(t *testing.T) {
+	if _, err := diskimage.NewReader(bytes.NewReader(vhdFooter(4096, diskimage.VHD_DIFFERENCING, 0)), 512); !errors.Is(err, diskimage.ErrUnsupported) {
+		t.Errorf("expected unsupported differencing disk, got %v", err)
+	}
+	//a fixed disk bigger than its file
+	if _, err := diskimage.NewReader(bytes.NewReader(vhdFooter(1<<20, diskimage.VHD_FIXED, 0)), 512); !errors.Is(err, diskimage.ErrCorruptImage) {
+		t.Errorf("expected corrupt image, got %v", err)
+	}
+	//both VHDX headers with bad checksums
+	img := vhdx(make([]byte, 4096))
+	img[64*1024+8]++
+	img[128*1024+8]++
+	if _, err := diskimage.NewReader(bytes.NewReader(img), int64(len(img))); !errors.Is(err, diskimage.ErrCorruptImage) {
+		t.Errorf("expected corrupt image, got %v", err)
+	}
+	d, err := diskimage.NewReader(bytes.NewReader(make([]byte, 4096)), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Partitions(); !errors.Is(err, diskimage.ErrNoPartitions) {
+		t.Errorf("expected no partitions, got %v", err)
+	}
+	//an EBR that links to itself
+	disk := mbrDisk(make([]byte, 4096))
+	mbrEntry(disk[17*512+446+16:], diskimage.MBR_EXTENDED, 0, 1)
+	if d, err = diskimage.NewReader(bytes.NewReader(disk), int64(len(disk))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Partitions(); !errors.Is(err, diskimage.ErrCorruptImage) {
+		t.Errorf("expected corrupt image, got %v", err)
+	}
+	//GPT entries far too big to be real
+	disk = gptDisk(make([]byte, 4096))
+	binary.LittleEndian.PutUint32(disk[512+0x54:], 1<<30)
+	if d, err = diskimage.NewReader(bytes.NewReader(disk), int64(len(disk))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Partitions(); !errors.Is(err, diskimage.ErrCorruptImage) {
+		t.Errorf("expected corrupt image, got %v", err)
+	}
+}
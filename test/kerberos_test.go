@@ -0,0 +1,97 @@
+package test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestMD4(t *testing.T) {
+	//RFC 1320 test suite
+	cases := map[string]string{
+		"":               "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"a":              "bde52cb31de33e46245e05fbdbd6fb24",
+		"abc":            "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest": "d9130a8164549fe818874806e1c7014b",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	}
+	for in, want := range cases {
+		if got := hex.EncodeToString(kdf.MD4([]byte(in))); got != want {
+			t.Errorf("md4(%q): expected %s got %s", in, want, got)
+		}
+	}
+
+	if got := hex.EncodeToString(kdf.NTHash("password")); got != "8846f7eaee8fb117ad06bdd830b7586c" {
+		t.Errorf("bad NT hash for password: %s", got)
+	}
+	if got := kdf.NTHash(""); hex.EncodeToString(got) != hex.EncodeToString(ditreader.EmptyNT) {
+		t.Errorf("bad empty NT hash: %x", got)
+	}
+}
+
+func TestNFold(t *testing.T) {
+	//RFC 3961 appendix A.1
+	cases := []struct {
+		in   string
+		bits int
+		want string
+	}{
+		{"012345", 64, "be072631276b1955"},
+		{"password", 56, "78a07b6caf85fa"},
+		{"Rough Consensus, and Running Code", 64, "bb6ed30870b7f0e0"},
+		{"password", 168, "59e4a8ca7c0385c3c37b3f6d2000247cb6e6bd5b3e"},
+		{"MASSACHVSETTS INSTITVTE OF TECHNOLOGY", 192, "db3b0d8f0b061e603282b308a50841229ad798fab9540c1b"},
+		{"Q", 168, "518a54a215a8452a518a54a215a8452a518a54a215"},
+		{"ba", 168, "fb25d531ae8974499f52fd92ea9857c4ba24cf297e"},
+		{"kerberos", 64, "6b65726265726f73"},
+		{"kerberos", 128, "6b65726265726f737b9b5b2b93132b93"},
+		{"kerberos", 168, "8372c236344e5f1550cd0747e15d62ca7a5a3bcea4"},
+		{"kerberos", 256, "6b65726265726f737b9b5b2b93132b935c9bdcdad95c9899c4cae4dee6d6cae4"},
+	}
+	for _, c := range cases {
+		if got := hex.EncodeToString(kdf.NFold([]byte(c.in), c.bits/8)); got != c.want {
+			t.Errorf("%d-fold(%q): expected %s got %s", c.bits, c.in, c.want, got)
+		}
+	}
+}
+
+func TestAESStringToKey(t *testing.T) {
+	//RFC 3962 appendix B
+	cases := []struct {
+		iterations int
+		pass, salt string
+		pbkdf2     string
+		aes128     string
+		aes256     string
+	}{
+		{1, "password", "ATHENA.MIT.EDUraeburn",
+			"cdedb5281bb2f801565a1122b25635150ad1f7a04bb9f3a333ecc0e2e1f70837",
+			"42263c6e89f4fc28b8df68ee09799f15",
+			"fe697b52bc0d3ce14432ba036a92e65bbb52280990a2fa27883998d72af30161"},
+		{2, "password", "ATHENA.MIT.EDUraeburn",
+			"01dbee7f4a9e243e988b62c73cda935da05378b93244ec8f48a99e61ad799d86",
+			"c651bf29e2300ac27fa469d693bdda13",
+			"a2e16d16b36069c135d5e9d2e25f896102685618b95914b467c67622225824ff"},
+		{1200, "password", "ATHENA.MIT.EDUraeburn",
+			"5c08eb61fdf71e4e4ec3cf6ba1f5512ba7e52ddbc5e5142f708a31e2e62b1e13",
+			"4c01cd46d632d01e6dbe230a01ed642a",
+			"55a6ac740ad17b4846941051e1e8b0a7548d93b0ab30a8bc3ff16280382b8c2a"},
+	}
+	for _, c := range cases {
+		if got := hex.EncodeToString(pbkdf2.Key([]byte(c.pass), []byte(c.salt), c.iterations, 32, sha1.New)); got != c.pbkdf2 {
+			t.Errorf("pbkdf2 %d: expected %s got %s", c.iterations, c.pbkdf2, got)
+		}
+		k, err := kdf.AESStringToKey([]byte(c.pass), []byte(c.salt), c.iterations, 16)
+		if err != nil || hex.EncodeToString(k) != c.aes128 {
+			t.Errorf("aes128 %d: expected %s got %x (%v)", c.iterations, c.aes128, k, err)
+		}
+		k, err = kdf.AESStringToKey([]byte(c.pass), []byte(c.salt), c.iterations, 32)
+		if err != nil || hex.EncodeToString(k) != c.aes256 {
+			t.Errorf("aes256 %d: expected %s got %x (%v)", c.iterations, c.aes256, k, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/esent"
+)
+
+const esePageSize = 8192
+
+// eseTag is an entry on a database page
+type eseTag struct {
+	flags uint16
+	data  []byte
+}
+
+// esePage builds a (Windows 7 format) database page
+func esePage(flags, next uint32, tags ...eseTag) []byte {
+	p := make([]byte, esePageSize)
+	binary.LittleEndian.PutUint32(p[20:], next)
+	binary.LittleEndian.PutUint16(p[34:], uint16(len(tags)))
+	binary.LittleEndian.PutUint32(p[36:], flags)
+	off := 0
+	for i, t := range tags {
+		copy(p[40+off:], t.data)
+		tag := p[len(p)-4*(i+1):]
+		binary.LittleEndian.PutUint16(tag, uint16(len(t.data)))
+		binary.LittleEndian.PutUint16(tag[2:], uint16(off)|t.flags<<13)
+		off += len(t.data)
+	}
+	return p
+}
+
+// eseEntry is a leaf or branch entry, keyed on the first common bytes of the page prefix and then key
+func eseEntry(common int, key, data []byte) eseTag {
+	t := eseTag{}
+	if common > 0 {
+		t.flags = 4
+		t.data = binary.LittleEndian.AppendUint16(t.data, uint16(common))
+	}
+	t.data = binary.LittleEndian.AppendUint16(t.data, uint16(len(key)))
+	t.data = append(append(t.data, key...), data...)
+	return t
+}
+
+// eseCatalog is a catalog entry for a table (with its first page), column (with its type) or long value tree
+func eseCatalog(typ uint16, id, n uint32, name string) eseTag {
+	fixed := binary.LittleEndian.AppendUint32(nil, 2)
+	fixed = binary.LittleEndian.AppendUint16(fixed, typ)
+	fixed = binary.LittleEndian.AppendUint32(fixed, id)
+	fixed = binary.LittleEndian.AppendUint32(fixed, n)
+	if typ == esent.CATALOG_TYPE_COLUMN {
+		fixed = append(fixed, make([]byte, 12)...)
+	} else {
+		fixed = append(fixed, make([]byte, 4)...)
+	}
+	d := []byte{0, 128}
+	d = binary.LittleEndian.AppendUint16(d, uint16(4+len(fixed)))
+	d = append(d, fixed...)
+	d = binary.LittleEndian.AppendUint16(d, uint16(len(name)))
+	return eseEntry(0, nil, append(d, name...))
+}
+
+// longValueDit builds a datatable with one account, whose replication metadata is too big for the record so is in
+// the long value tree, split over two leaves. The record points at lid, and the tree has the value as treeLID.
+func longValueDit(sid, meta []byte, lid, treeLID uint32) []byte {
+	lidKey := binary.BigEndian.AppendUint32(nil, treeLID)
+	segKey := func(off int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(off)) }
+	split := len(meta) / 2
+
+	//objectSid inline, then the replication metadata flagged as a long value, holding its LID
+	rec := []byte{0, 127, 4, 0}
+	rec = binary.LittleEndian.AppendUint16(rec, 257)
+	rec = binary.LittleEndian.AppendUint16(rec, 8)
+	rec = binary.LittleEndian.AppendUint16(rec, 258)
+	rec = binary.LittleEndian.AppendUint16(rec, uint16(8+len(sid))|0x4000)
+	rec = append(rec, sid...)
+	rec = append(rec, 4)
+	rec = binary.LittleEndian.AppendUint32(rec, lid)
+
+	lvRoot := binary.LittleEndian.AppendUint32(nil, 1)
+	lvRoot = binary.LittleEndian.AppendUint32(lvRoot, uint32(len(meta)))
+
+	pages := [][]byte{
+		//database pages 1-3
+		esePage(0, 0), esePage(0, 0), esePage(0, 0),
+		//4, the catalog. The record has no unicodePwd, so that column's only there to come first.
+		esePage(esent.FLAGS_LEAF|esent.FLAGS_ROOT, 0,
+			eseTag{},
+			eseCatalog(esent.CATALOG_TYPE_TABLE, 2, 5, "datatable"),
+			eseCatalog(esent.CATALOG_TYPE_COLUMN, 256, esent.JET_coltypLongBinary, "ATTk589914"),
+			eseCatalog(esent.CATALOG_TYPE_COLUMN, 257, esent.JET_coltypBinary, "ATTr589970"),
+			eseCatalog(esent.CATALOG_TYPE_COLUMN, 258, esent.JET_coltypLongBinary, "ATTk589827"),
+			eseCatalog(esent.CATALOG_TYPE_LONG_VALUE, 3, 6, "LV"),
+		),
+		//5, the datatable
+		esePage(esent.FLAGS_LEAF|esent.FLAGS_ROOT, 0, eseTag{}, eseEntry(0, nil, rec)),
+		//6, the root of the long value tree
+		esePage(esent.FLAGS_ROOT|esent.FLAGS_LONG_VALUE, 0, eseTag{}, eseEntry(0, nil, binary.LittleEndian.AppendUint32(nil, 7))),
+		//7 and 8, the leaves, the second with the LID as the common prefix of its keys
+		esePage(esent.FLAGS_LEAF|esent.FLAGS_LONG_VALUE, 8,
+			eseTag{},
+			eseEntry(0, lidKey, lvRoot),
+			eseEntry(0, append(append([]byte{}, lidKey...), segKey(0)...), meta[:split]),
+		),
+		esePage(esent.FLAGS_LEAF|esent.FLAGS_LONG_VALUE, 0,
+			eseTag{data: lidKey},
+			eseEntry(4, segKey(split), meta[split:]),
+		),
+	}
+
+	hdr := make([]byte, esePageSize)
+	binary.LittleEndian.PutUint32(hdr[8:], 0x620)
+	binary.LittleEndian.PutUint32(hdr[232:], 0x11)
+	binary.LittleEndian.PutUint32(hdr[236:], esePageSize)
+	//header, shadow header, the pages, and two on the end that aren't read
+	b := append(hdr, make([]byte, esePageSize)...)
+	for _, p := range pages {
+		b = append(b, p...)
+	}
+	return append(b, make([]byte, 2*esePageSize)...)
+}
+
+func TestLongValue(t *testing.T) {
+	changed := time.Date(2019, 6, 11, 9, 19, 0, 0, time.UTC)
+	//30 attributes is well over what fits in a record
+	meta := make([]byte, 16+48*30)
+	binary.LittleEndian.PutUint32(meta[0:], 1)
+	binary.LittleEndian.PutUint32(meta[8:], 30)
+	for i := 0; i < 30; i++ {
+		e := meta[16+48*i:]
+		binary.LittleEndian.PutUint32(e[0:], 589800+uint32(i))
+		binary.LittleEndian.PutUint32(e[4:], 1)
+	}
+	//unicodePwd, changed 3 times
+	e := meta[16+48*29:]
+	binary.LittleEndian.PutUint32(e[0:], 589914)
+	binary.LittleEndian.PutUint32(e[4:], 3)
+	binary.LittleEndian.PutUint64(e[8:], uint64(changed.Unix()+11644473600))
+
+	sid := []byte{1, 5, 0, 0, 0, 0, 0, 5}
+	for _, sa := range []uint32{21, 1, 2, 3, 1104} {
+		sid = binary.BigEndian.AppendUint32(sid, sa)
+	}
+
+	b := longValueDit(sid, meta, 0x01020304, 0x01020304)
+	db, err := (esent.Esedb{}).InitReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenTable("datatable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := db.GetNextRow(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := record.GetBytVal("ATTk589827"); !bytes.Equal(v, meta) {
+		t.Fatalf("long value not resolved, got %d bytes %x", len(v), v[:min(len(v), 16)])
+	}
+	if v, _ := record.GetBytVal("ATTr589970"); !bytes.Equal(v, sid) {
+		t.Errorf("bad objectSid %x", v)
+	}
+
+	dh, err := (&ditreader.DitReader{}).DecryptRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dh.Rid != 1104 || len(dh.ReplMetaData) != 30 || !dh.History.PwdLastSet.Equal(changed) || dh.History.PwdChangeCount != 3 {
+		t.Errorf("bad record %d %d %s %d", dh.Rid, len(dh.ReplMetaData), dh.History.PwdLastSet, dh.History.PwdChangeCount)
+	}
+
+	//a LID that isn't in the tree is left as it is, and the password set time just isn't known
+	b = longValueDit(sid, meta, 0x01020304, 0x01020305)
+	db, err = (esent.Esedb{}).InitReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = db.OpenTable("datatable"); err != nil {
+		t.Fatal(err)
+	}
+	if record, err = db.GetNextRow(c); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := record.GetBytVal("ATTk589827"); len(v) != 4 {
+		t.Errorf("expected the LID, got %x", v)
+	}
+	if dh, err = (&ditreader.DitReader{}).DecryptRecord(record); err != nil || !dh.History.PwdLastSet.IsZero() {
+		t.Errorf("bad record %v %s", err, dh.History.PwdLastSet)
+	}
+}
@@ -0,0 +1,552 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ntfs"
+)
+
+const (
+	ntfsCluster = 4096
+	ntfsRecord  = 1024
+)
+
+// ntfsNode is a file or directory to put on a synthetic NTFS volume
+type ntfsNode struct {
+	name     string
+	dosName  string //extra 8.3 entry in the parent index
+	dir      bool
+	children []*ntfsNode
+	data     []byte
+	fragment bool  //split the data into two runs, the second before the first on disk
+	sparse   int64 //clusters of zeros at the start of the data that aren't stored
+	attrList bool  //put the data in two extension records, found through an attribute list
+	record   uint64
+	ext      []uint64
+}
+
+type ntfsBuilder struct {
+	vol     []byte
+	records map[uint64][]byte
+	next    uint64
+}
+
+// alloc grabs n clusters at the end of the volume
+func (b *ntfsBuilder) alloc(n int64) int64 {
+	lcn := int64(len(b.vol)) / ntfsCluster
+	b.vol = append(b.vol, make([]byte, n*ntfsCluster)...)
+	return lcn
+}
+
+type ntfsRun struct{ lcn, length int64 }
+
+// store writes data to newly allocated clusters, returning the runs
+func (b *ntfsBuilder) store(data []byte, fragment bool, sparse int64) []ntfsRun {
+	clusters := int64(len(data)+ntfsCluster-1) / ntfsCluster
+	runs := []ntfsRun{}
+	if sparse > 0 {
+		runs = append(runs, ntfsRun{-1, sparse})
+		data = data[sparse*ntfsCluster:]
+		clusters -= sparse
+	}
+	if !fragment || clusters < 2 {
+		lcn := b.alloc(clusters)
+		copy(b.vol[lcn*ntfsCluster:], data)
+		return append(runs, ntfsRun{lcn, clusters})
+	}
+	half := clusters / 2
+	second := b.alloc(clusters - half)
+	b.alloc(1) //gap
+	first := b.alloc(half)
+	copy(b.vol[first*ntfsCluster:], data[:half*ntfsCluster])
+	copy(b.vol[second*ntfsCluster:], data[half*ntfsCluster:])
+	return append(runs, ntfsRun{first, half}, ntfsRun{second, clusters - half})
+}
+
+func minBytes(v int64, signed bool) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	n := 8
+	for n > 1 {
+		top, next := b[n-1], b[n-2]
+		if signed && ((top == 0 && next&0x80 == 0) || (top == 0xff && next&0x80 != 0)) || !signed && top == 0 {
+			n--
+			continue
+		}
+		break
+	}
+	return b[:n]
+}
+
+func encodeRuns(runs []ntfsRun) []byte {
+	r := []byte{}
+	prev := int64(0)
+	for _, rn := range runs {
+		l := minBytes(rn.length, false)
+		if rn.lcn < 0 {
+			r = append(append(r, byte(len(l))), l...)
+			continue
+		}
+		o := minBytes(rn.lcn-prev, true)
+		prev = rn.lcn
+		r = append(append(append(r, byte(len(l))|byte(len(o))<<4), l...), o...)
+	}
+	return append(r, 0)
+}
+
+func align8(n int) int { return (n + 7) &^ 7 }
+
+func utf16Bytes(s string) []byte {
+	r := []byte{}
+	for _, c := range utf16.Encode([]rune(s)) {
+		r = append(r, byte(c), byte(c>>8))
+	}
+	return r
+}
+
+func residentAttr(typ uint32, name string, id uint16, value []byte) []byte {
+	n := utf16Bytes(name)
+	valOff := align8(0x18 + len(n))
+	a := make([]byte, align8(valOff+len(value)))
+	binary.LittleEndian.PutUint32(a, typ)
+	binary.LittleEndian.PutUint32(a[4:], uint32(len(a)))
+	a[9] = byte(len(n) / 2)
+	binary.LittleEndian.PutUint16(a[0x0a:], 0x18)
+	binary.LittleEndian.PutUint16(a[0x0e:], id)
+	binary.LittleEndian.PutUint32(a[0x10:], uint32(len(value)))
+	binary.LittleEndian.PutUint16(a[0x14:], uint16(valOff))
+	copy(a[0x18:], n)
+	copy(a[valOff:], value)
+	return a
+}
+
+func nonResidentAttr(typ uint32, name string, id uint16, runs []ntfsRun, startVCN, size int64) []byte {
+	n := utf16Bytes(name)
+	rl := encodeRuns(runs)
+	runOff := align8(0x40 + len(n))
+	a := make([]byte, align8(runOff+len(rl)))
+	binary.LittleEndian.PutUint32(a, typ)
+	binary.LittleEndian.PutUint32(a[4:], uint32(len(a)))
+	a[8] = 1
+	a[9] = byte(len(n) / 2)
+	binary.LittleEndian.PutUint16(a[0x0a:], 0x40)
+	binary.LittleEndian.PutUint16(a[0x0e:], id)
+	clusters := int64(0)
+	for _, r := range runs {
+		clusters += r.length
+	}
+	binary.LittleEndian.PutUint64(a[0x10:], uint64(startVCN))
+	binary.LittleEndian.PutUint64(a[0x18:], uint64(startVCN+clusters-1))
+	binary.LittleEndian.PutUint16(a[0x20:], uint16(runOff))
+	if startVCN == 0 {
+		binary.LittleEndian.PutUint64(a[0x28:], uint64(((size+ntfsCluster-1)/ntfsCluster)*ntfsCluster))
+		binary.LittleEndian.PutUint64(a[0x30:], uint64(size))
+		binary.LittleEndian.PutUint64(a[0x38:], uint64(size))
+	}
+	copy(a[0x40:], n)
+	copy(a[runOff:], rl)
+	return a
+}
+
+// ntfsFixup applies the update sequence to a record or index block
+func ntfsFixup(b []byte, usaOff int) {
+	count := len(b)/512 + 1
+	binary.LittleEndian.PutUint16(b[4:], uint16(usaOff))
+	binary.LittleEndian.PutUint16(b[6:], uint16(count))
+	binary.LittleEndian.PutUint16(b[usaOff:], 0x0007)
+	for i := 1; i < count; i++ {
+		end := i*512 - 2
+		copy(b[usaOff+i*2:], b[end:end+2])
+		binary.LittleEndian.PutUint16(b[end:], 0x0007)
+	}
+}
+
+func mftRecord(flags uint16, base uint64, attrs ...[]byte) []byte {
+	r := make([]byte, ntfsRecord)
+	copy(r, "FILE")
+	binary.LittleEndian.PutUint16(r[0x10:], 1)
+	binary.LittleEndian.PutUint16(r[0x14:], 0x38)
+	binary.LittleEndian.PutUint16(r[0x16:], flags)
+	binary.LittleEndian.PutUint32(r[0x1c:], ntfsRecord)
+	binary.LittleEndian.PutUint64(r[0x20:], base)
+	off := 0x38
+	for _, a := range attrs {
+		off += copy(r[off:], a)
+	}
+	binary.LittleEndian.PutUint32(r[off:], 0xffffffff)
+	binary.LittleEndian.PutUint32(r[0x18:], uint32(off+8))
+	ntfsFixup(r, 0x30)
+	return r
+}
+
+// creation and modification times, different so a listing that picks the wrong one shows up
+const (
+	ntfsCreated = 0x01d1da0000000000
+	ntfsModTime = 0x01d1da99d5b39e45
+)
+
+func stdInfo() []byte {
+	v := make([]byte, 0x30)
+	binary.LittleEndian.PutUint64(v, ntfsCreated)
+	binary.LittleEndian.PutUint64(v[8:], ntfsModTime)
+	return residentAttr(ntfs.ATTR_STANDARD_INFORMATION, "", 0, v)
+}
+
+func fileName(parent uint64, name string, namespace byte, dir bool, size int64) []byte {
+	n := utf16Bytes(name)
+	v := make([]byte, 0x42+len(n))
+	binary.LittleEndian.PutUint64(v, parent|1<<48)
+	binary.LittleEndian.PutUint64(v[8:], ntfsCreated)
+	binary.LittleEndian.PutUint64(v[0x10:], ntfsModTime)
+	binary.LittleEndian.PutUint64(v[0x28:], uint64(size))
+	binary.LittleEndian.PutUint64(v[0x30:], uint64(size))
+	flags := uint32(0x20)
+	if dir {
+		flags = ntfs.FILE_NAME_DIRECTORY
+	}
+	binary.LittleEndian.PutUint32(v[0x38:], flags)
+	v[0x40] = byte(len(n) / 2)
+	v[0x41] = namespace
+	copy(v[0x42:], n)
+	return v
+}
+
+func indexEntry(ref uint64, key []byte, flags uint32, child int64) []byte {
+	length := align8(0x10 + len(key))
+	if flags&ntfs.INDEX_ENTRY_NODE != 0 {
+		length += 8
+	}
+	e := make([]byte, length)
+	binary.LittleEndian.PutUint64(e, ref|1<<48)
+	binary.LittleEndian.PutUint16(e[8:], uint16(length))
+	binary.LittleEndian.PutUint16(e[0x0a:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(e[0x0c:], flags)
+	copy(e[0x10:], key)
+	if flags&ntfs.INDEX_ENTRY_NODE != 0 {
+		binary.LittleEndian.PutUint64(e[length-8:], uint64(child))
+	}
+	return e
+}
+
+func nodeHeader(entries []byte, headerOff, size int, flags byte) []byte {
+	h := make([]byte, 0x10)
+	binary.LittleEndian.PutUint32(h, uint32(headerOff))
+	binary.LittleEndian.PutUint32(h[4:], uint32(headerOff+len(entries)))
+	binary.LittleEndian.PutUint32(h[8:], uint32(size))
+	h[0x0c] = flags
+	return h
+}
+
+func indxBlock(vcn int64, entries []byte) []byte {
+	b := make([]byte, ntfsCluster)
+	copy(b, "INDX")
+	binary.LittleEndian.PutUint64(b[0x10:], uint64(vcn))
+	copy(b[0x18:], nodeHeader(entries, 0x28, ntfsCluster-0x18, 0))
+	copy(b[0x40:], entries)
+	ntfsFixup(b, 0x28)
+	return b
+}
+
+func (n *ntfsNode) size() int64 {
+	return int64(len(n.data))
+}
+
+// assign gives every node (and its extension records) a record number
+func (b *ntfsBuilder) assign(n *ntfsNode) {
+	for _, c := range n.children {
+		c.record = b.next
+		b.next++
+		if c.attrList {
+			c.ext = []uint64{b.next, b.next + 1}
+			b.next += 2
+		}
+		b.assign(c)
+	}
+}
+
+// build writes the records for n and its children
+func (b *ntfsBuilder) build(n *ntfsNode, parent uint64) {
+	attrs := [][]byte{stdInfo()}
+	name := residentAttr(ntfs.ATTR_FILE_NAME, "", 2, fileName(parent, n.name, 1, n.dir, n.size()))
+	flags := uint16(ntfs.RECORD_IN_USE)
+	switch {
+	case n.dir:
+		flags |= ntfs.RECORD_DIRECTORY
+		attrs = append(attrs, name)
+		attrs = append(attrs, b.index(n)...)
+	case n.attrList:
+		runs := b.store(n.data, true, 0)
+		b.records[n.ext[0]] = mftRecord(ntfs.RECORD_IN_USE, n.record, nonResidentAttr(ntfs.ATTR_DATA, "", 0, runs[:1], 0, n.size()))
+		b.records[n.ext[1]] = mftRecord(ntfs.RECORD_IN_USE, n.record, nonResidentAttr(ntfs.ATTR_DATA, "", 0, runs[1:], runs[0].length, 0))
+		list := []byte{}
+		for _, e := range []struct {
+			typ uint32
+			vcn int64
+			ref uint64
+			id  uint16
+		}{
+			{ntfs.ATTR_STANDARD_INFORMATION, 0, n.record, 0},
+			{ntfs.ATTR_FILE_NAME, 0, n.record, 2},
+			{ntfs.ATTR_DATA, 0, n.ext[0], 0},
+			{ntfs.ATTR_DATA, runs[0].length, n.ext[1], 0},
+		} {
+			le := make([]byte, 0x20)
+			binary.LittleEndian.PutUint32(le, e.typ)
+			binary.LittleEndian.PutUint16(le[4:], 0x20)
+			le[7] = 0x1a
+			binary.LittleEndian.PutUint64(le[8:], uint64(e.vcn))
+			binary.LittleEndian.PutUint64(le[0x10:], e.ref|1<<48)
+			binary.LittleEndian.PutUint16(le[0x18:], e.id)
+			list = append(list, le...)
+		}
+		attrs = append(attrs, residentAttr(ntfs.ATTR_ATTRIBUTE_LIST, "", 1, list), name)
+	case len(n.data) <= 600 && !n.fragment && n.sparse == 0:
+		attrs = append(attrs, name, residentAttr(ntfs.ATTR_DATA, "", 3, n.data))
+	default:
+		attrs = append(attrs, name, nonResidentAttr(ntfs.ATTR_DATA, "", 3, b.store(n.data, n.fragment, n.sparse), 0, n.size()))
+	}
+	b.records[n.record] = mftRecord(flags, 0, attrs...)
+	for _, c := range n.children {
+		b.build(c, n.record)
+	}
+}
+
+// index builds the $I30 index of a directory. Small directories fit in the root, bigger ones are split over two
+// INDX blocks with the middle entry left in the root.
+func (b *ntfsBuilder) index(n *ntfsNode) [][]byte {
+	type key struct {
+		name string
+		ref  uint64
+		key  []byte
+	}
+	keys := []key{}
+	for _, c := range n.children {
+		keys = append(keys, key{c.name, c.record, fileName(n.record, c.name, 1, c.dir, c.size())})
+		if c.dosName != "" {
+			keys = append(keys, key{c.dosName, c.record, fileName(n.record, c.dosName, 2, c.dir, c.size())})
+		}
+	}
+	if n.record == ntfs.ROOT_RECORD {
+		keys = append(keys, key{".", ntfs.ROOT_RECORD, fileName(ntfs.ROOT_RECORD, ".", 3, true, 0)})
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.ToUpper(keys[i].name) < strings.ToUpper(keys[j].name) })
+	entries := func(ks []key) []byte {
+		r := []byte{}
+		for _, k := range ks {
+			r = append(r, indexEntry(k.ref, k.key, 0, 0)...)
+		}
+		return r
+	}
+	rootValue := func(e []byte, flags byte) []byte {
+		v := make([]byte, 0x10)
+		binary.LittleEndian.PutUint32(v, ntfs.ATTR_FILE_NAME)
+		binary.LittleEndian.PutUint32(v[4:], 1)
+		binary.LittleEndian.PutUint32(v[8:], ntfsCluster)
+		v[0x0c] = 1
+		v = append(v, nodeHeader(e, 0x10, 0x10+len(e), flags)...)
+		return append(v, e...)
+	}
+	if len(keys) <= 3 {
+		e := append(entries(keys), indexEntry(0, nil, ntfs.INDEX_ENTRY_END, 0)...)
+		return [][]byte{residentAttr(ntfs.ATTR_INDEX_ROOT, "$I30", 4, rootValue(e, 0))}
+	}
+	mid := len(keys) / 2
+	left := indxBlock(0, append(entries(keys[:mid]), indexEntry(0, nil, ntfs.INDEX_ENTRY_END, 0)...))
+	right := indxBlock(1, append(entries(keys[mid+1:]), indexEntry(0, nil, ntfs.INDEX_ENTRY_END, 0)...))
+	e := append(indexEntry(keys[mid].ref, keys[mid].key, ntfs.INDEX_ENTRY_NODE, 0),
+		indexEntry(0, nil, ntfs.INDEX_ENTRY_END|ntfs.INDEX_ENTRY_NODE, 1)...)
+	runs := b.store(append(left, right...), true, 0)
+	return [][]byte{
+		residentAttr(ntfs.ATTR_INDEX_ROOT, "$I30", 4, rootValue(e, 1)),
+		nonResidentAttr(ntfs.ATTR_INDEX_ALLOCATION, "$I30", 5, runs, 0, 2*ntfsCluster),
+	}
+}
+
+// buildNTFS lays out a volume holding the tree under root. The MFT is split in two, with its second half at the end
+// of the volume.
+func buildNTFS(root *ntfsNode) []byte {
+	//the first 8K is $Boot
+	b := &ntfsBuilder{vol: make([]byte, 2*ntfsCluster), records: map[uint64][]byte{}, next: 16}
+	root.record, root.dir = ntfs.ROOT_RECORD, true
+	b.assign(root)
+	mftClusters := int64(b.next*ntfsRecord+ntfsCluster-1) / ntfsCluster
+	half := mftClusters / 2
+	first := b.alloc(half)
+	b.build(root, ntfs.ROOT_RECORD)
+	second := b.alloc(mftClusters - half)
+	mftRuns := []ntfsRun{{first, half}, {second, mftClusters - half}}
+	b.records[ntfs.MFT_RECORD] = mftRecord(ntfs.RECORD_IN_USE, 0, stdInfo(),
+		residentAttr(ntfs.ATTR_FILE_NAME, "", 2, fileName(ntfs.ROOT_RECORD, "$MFT", 3, false, mftClusters*ntfsCluster)),
+		nonResidentAttr(ntfs.ATTR_DATA, "", 3, mftRuns, 0, mftClusters*ntfsCluster))
+	for n, rec := range b.records {
+		off := int64(n) * ntfsRecord
+		lcn := first + off/ntfsCluster
+		if off/ntfsCluster >= half {
+			lcn = second + off/ntfsCluster - half
+		}
+		copy(b.vol[lcn*ntfsCluster+off%ntfsCluster:], rec)
+	}
+
+	boot := b.vol[:512]
+	copy(boot, "\xeb\x52\x90"+ntfs.NTFS_OEM_ID)
+	binary.LittleEndian.PutUint16(boot[0x0b:], 512)
+	boot[0x0d] = ntfsCluster / 512
+	binary.LittleEndian.PutUint64(boot[0x28:], uint64(len(b.vol)/512-1))
+	binary.LittleEndian.PutUint64(boot[0x30:], uint64(first))
+	boot[0x40] = 0xf6 //-10, 1024 byte records
+	boot[0x44] = 1
+	boot[510], boot[511] = 0x55, 0xaa
+	return b.vol
+}
+
+// windowsVolume is a volume with the hives where Windows keeps them
+func windowsVolume(t *testing.T) []byte {
+	return windowsVolumeSAM(t, func() fakeRegistry { return samRegistry(t, true) })
+}
+
+// windowsVolumeSAM is windowsVolume with a different SAM, which is built with the bootkey of the real SYSTEM hive
+func windowsVolumeSAM(t *testing.T, sam func() fakeRegistry) []byte {
+	samBootKey = []byte{0x13, 0xd2, 0x09, 0x76, 0xd6, 0x3e, 0xa5, 0xe8, 0x36, 0x03, 0x6e, 0xc8, 0xbc, 0x68, 0xd6, 0xeb}
+	defer func() { samBootKey = []byte("fedcba9876543210") }()
+	system, err := os.ReadFile("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ntfsNode{name: "config", dir: true, children: []*ntfsNode{
+		{name: "SYSTEM", data: system, attrList: true},
+		{name: "SAM", data: hiveFromFake(sam()), fragment: true},
+		{name: "SAM.LOG1", data: []byte{}},
+	}}
+	sys32 := &ntfsNode{name: "System32", dir: true, children: []*ntfsNode{config}}
+	//enough files to push System32 into index blocks
+	for _, n := range []string{"cmd.exe", "kernel32.dll", "ntdll.dll", "lsass.exe", "winlogon.exe", "drivers"} {
+		sys32.children = append(sys32.children, &ntfsNode{name: n, data: []byte(n)})
+	}
+	return buildNTFS(&ntfsNode{children: []*ntfsNode{
+		{name: "Windows", dosName: "WINDOWS~1", dir: true, children: []*ntfsNode{sys32}},
+		{name: "sparse.bin", data: append(make([]byte, 3*ntfsCluster), bytes.Repeat([]byte("tail"), 2000)...), sparse: 3},
+		{name: "hello.txt", data: []byte("hello world")},
+	}})
+}
+
+func TestNTFS(t *testing.T) {
+	vol, err := ntfs.New(bytes.NewReader(windowsVolume(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	system, _ := os.ReadFile("system")
+	for name, want := range map[string][]byte{
+		"hello.txt":                        []byte("hello world"),
+		"sparse.bin":                       append(make([]byte, 3*ntfsCluster), bytes.Repeat([]byte("tail"), 2000)...),
+		"Windows/System32/config/SYSTEM":   system,
+		"windows/system32/CONFIG/system":   system,
+		"WINDOWS~1/System32/kernel32.dll":  []byte("kernel32.dll"),
+		"Windows/System32/config/SAM.LOG1": {},
+		"Windows/System32/winlogon.exe":    []byte("winlogon.exe"),
+	} {
+		got, err := fs.ReadFile(vol, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes, expected %d", name, len(got), len(want))
+		}
+	}
+	if _, err := vol.Open("Windows/System32/config/SECURITY"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, err := vol.Open("/Windows"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected invalid path, got %v", err)
+	}
+
+	//random access into the fragmented file
+	f, err := vol.Open("Windows/System32/config/SYSTEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10000)
+	if _, err := f.(io.ReaderAt).ReadAt(buf, 1234567); err != nil || !bytes.Equal(buf, system[1234567:1234567+10000]) {
+		t.Errorf("bad ReadAt across runs: %v", err)
+	}
+
+	//DOS names are only for lookups, and the root doesn't list itself
+	entries, err := fs.ReadDir(vol, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != "Windows,hello.txt,sparse.bin" {
+		t.Errorf("bad root listing %v", names)
+	}
+	if entries, err := fs.ReadDir(vol, "Windows/System32"); err != nil || len(entries) != 7 {
+		t.Errorf("expected 7 entries in System32, got %d %v", len(entries), err)
+	}
+
+	if err := fstest.TestFS(vol, "hello.txt", "Windows/System32/config/SAM", "Windows/System32/drivers"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNTFSCorrupt(t *testing.T) {
+	v := windowsVolume(t)
+	if _, err := ntfs.New(bytes.NewReader(make([]byte, 4096))); !errors.Is(err, ntfs.ErrNotNTFS) {
+		t.Errorf("expected not NTFS, got %v", err)
+	}
+	//tear the MFT record of the root directory
+	bad := append([]byte{}, v...)
+	mft := int64(binary.LittleEndian.Uint64(bad[0x30:])) * ntfsCluster
+	bad[mft+ntfs.ROOT_RECORD*ntfsRecord+510] ^= 0xff
+	vol, err := ntfs.New(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vol.Open("hello.txt"); !errors.Is(err, ntfs.ErrCorruptVolume) {
+		t.Errorf("expected corrupt volume, got %v", err)
+	}
+	//a file bigger than the volume isn't allocated for
+	bad = append([]byte{}, v...)
+	binary.LittleEndian.PutUint64(bad[0x28:], 100)
+	if vol, err = ntfs.New(bytes.NewReader(bad)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vol.ReadFile("Windows/System32/config/SYSTEM"); !errors.Is(err, ntfs.ErrCorruptVolume) {
+		t.Errorf("expected corrupt volume, got %v", err)
+	}
+	if b, err := vol.ReadFile("hello.txt"); err != nil || string(b) != "hello world" {
+		t.Errorf("bad small file %q %v", b, err)
+	}
+	//junk everywhere but the boot sector shouldn't panic
+	junked := append(append([]byte{}, v[:512]...), junk(len(v)-512)...)
+	if vol, err := ntfs.New(bytes.NewReader(junked)); err == nil {
+		if _, err := fs.ReadFile(vol, "hello.txt"); err == nil {
+			t.Error("expected an error reading from a junk volume")
+		}
+	}
+
+	//nothing recovers panics, so bad values anywhere in the first MFT records have to be caught where they're used
+	bad = append([]byte{}, v...)
+	for off := mft; off < mft+(ntfs.ROOT_RECORD+20)*ntfsRecord; off++ {
+		for _, x := range []byte{0xff, 0x80} {
+			bad[off] ^= x
+			if vol, err := ntfs.New(bytes.NewReader(bad)); err == nil {
+				fs.ReadFile(vol, "hello.txt")
+				fs.ReadDir(vol, ".")
+			}
+			bad[off] ^= x
+		}
+	}
+}
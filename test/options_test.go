@@ -0,0 +1,86 @@
+package test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/esent"
+)
+
+// fakeRecord builds a datatable row with the columns the filters look at
+func fakeRecord(t *testing.T, name string, rid uint32, accType, uac int32, usn uint64, classes ...uint32) esent.Esent_record {
+	r := esent.NewRecord(8)
+
+	n := []byte{}
+	for _, c := range name {
+		n = append(n, byte(c), 0)
+	}
+	r.UpdateBytVal(n, "ATTm590045")
+	if err := r.SetString("ATTm590045", 1200); err != nil {
+		t.Fatal(err)
+	}
+
+	//S-1-5-21-1-2-3-rid, the rid is big endian in the dit
+	sid := []byte{1, 5, 0, 0, 0, 0, 0, 5}
+	for _, sa := range []uint32{21, 1, 2, 3, rid} {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, sa)
+		sid = append(sid, b...)
+	}
+	r.UpdateBytVal(sid, "ATTr589970")
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(accType))
+	r.UpdateBytVal(b, "ATTj590126")
+	b = make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(uac))
+	r.UpdateBytVal(b, "ATTj589832")
+	b = make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, usn)
+	r.UpdateBytVal(b, "ATTq131192")
+
+	//multi valued, so offsets then values, hex encoded like esent does
+	mv := make([]byte, len(classes)*6)
+	for i, c := range classes {
+		binary.LittleEndian.PutUint16(mv[i*2:], uint16(len(classes)*2+i*4))
+		binary.LittleEndian.PutUint32(mv[len(classes)*2+i*4:], c)
+	}
+	r.UpdateBytVal([]byte(hex.EncodeToString(mv)), "ATTc0")
+	return r
+}
+
+func TestOptionsMatch(t *testing.T) {
+	admin := fakeRecord(t, "Administrator", 500, ditreader.SAM_NORMAL_USER_ACCOUNT, 0x200, 8000, 0x10000, 0x30000, ditreader.CLASS_USER)
+	guest := fakeRecord(t, "Guest", 501, ditreader.SAM_NORMAL_USER_ACCOUNT, 0x202, 12, 0x10000, 0x30000, ditreader.CLASS_USER)
+	dc := fakeRecord(t, "DC01$", 1000, ditreader.SAM_MACHINE_ACCOUNT, 0x82000, 9000, 0x10000, ditreader.CLASS_USER, ditreader.CLASS_COMPUTER)
+
+	cases := []struct {
+		name string
+		opts ditreader.Options
+		want []bool //admin, guest, dc
+	}{
+		{"none", ditreader.Options{}, []bool{true, true, true}},
+		{"machines", ditreader.Options{AccountTypes: []int32{ditreader.SAM_MACHINE_ACCOUNT}}, []bool{false, false, true}},
+		{"enabled", ditreader.Options{EnabledOnly: true}, []bool{true, false, true}},
+		{"regex", ditreader.Options{UsernameRegex: regexp.MustCompile(`(?i)^adm`)}, []bool{true, false, false}},
+		{"rid", ditreader.Options{RIDRanges: []ditreader.RIDRange{{Min: 501, Max: 501}, {Min: 1000, Max: 2000}}}, []bool{false, true, true}},
+		{"computer class", ditreader.Options{ObjectClasses: []uint32{ditreader.CLASS_COMPUTER}}, []bool{false, false, true}},
+		{"user class", ditreader.Options{ObjectClasses: []uint32{ditreader.CLASS_USER}}, []bool{true, true, true}},
+		{"usn", ditreader.Options{ChangedSinceUSN: 8000}, []bool{false, false, true}},
+		{"custom", ditreader.Options{Filters: []ditreader.RecordFilter{func(r esent.Esent_record) bool {
+			v, _ := r.GetLongVal("ATTj589832")
+			return v&0x80000 == 0
+		}}}, []bool{true, true, false}},
+	}
+
+	for _, c := range cases {
+		for i, r := range []esent.Esent_record{admin, guest, dc} {
+			if got := c.opts.Match(r); got != c.want[i] {
+				t.Errorf("%s: record %d expected %v got %v", c.name, i, c.want[i], got)
+			}
+		}
+	}
+}
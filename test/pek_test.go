@@ -0,0 +1,157 @@
+package test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+// synthetic key material and hashes, encrypted the same way a 2016+ DC stores LM history. There's no public 2016+
+// dit with non-empty LM history to test against: the 2016 reference dump (impacket-out/2016) only has empty LM
+// history, and its dit isn't in the repo.
+var (
+	fixturePEK, _ = hex.DecodeString("8c9f3aba7d0f4c2e6a3f1e5b4d2c1a09")
+	fixtureIV, _  = hex.DecodeString("00112233445566778899aabbccddeeff")
+	fixtureRid    = uint32(1104)
+	fixtureLMHist = []string{
+		"e52cac67419a9a224a3b108f3fa6cb6d",
+		"aad3b435b51404eeaad3b435b51404ee",
+		"ff17365faf1ffe89aad3b435b51404ee",
+	}
+)
+
+// desLayer applies the per-RID DES layer that RemoveDES removes
+func desLayer(t *testing.T, h []byte, rid uint32) []byte {
+	k1, k2 := ditreader.DeriveKey(rid)
+	c1, err := des.NewCipher(k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := des.NewCipher(k2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 16)
+	c1.Encrypt(r[:8], h[:8])
+	c2.Encrypt(r[8:], h[8:])
+	return r
+}
+
+// historyPlain builds the PEK-decrypted form of a history attribute. The first entry is the current password.
+func historyPlain(t *testing.T) []byte {
+	plain := desLayer(t, bytes.Repeat([]byte{0x41}, 16), fixtureRid)
+	for _, h := range fixtureLMHist {
+		b, _ := hex.DecodeString(h)
+		plain = append(plain, desLayer(t, b, fixtureRid)...)
+	}
+	return plain
+}
+
+func aesHistoryBlob(t *testing.T, pekIndex byte) []byte {
+	plain := historyPlain(t)
+	block, err := aes.NewCipher(fixturePEK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, fixtureIV).CryptBlocks(ct, plain)
+
+	blob := []byte{0x13, 0, 0, 0, pekIndex, 0, 0, 0}
+	blob = append(blob, fixtureIV...)
+	l := make([]byte, 4)
+	binary.LittleEndian.PutUint32(l, uint32(len(plain)))
+	blob = append(blob, l...)
+	return append(blob, ct...)
+}
+
+func rc4HistoryBlob(t *testing.T) []byte {
+	plain := historyPlain(t)
+	key := md5.Sum(append(append([]byte{}, fixturePEK...), fixtureIV...))
+	rc, err := rc4.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := make([]byte, len(plain))
+	rc.XORKeyStream(ct, plain)
+	blob := []byte{0x11, 0, 0, 0, 0, 0, 0, 0}
+	blob = append(blob, fixtureIV...)
+	return append(blob, ct...)
+}
+
+func checkHistory(t *testing.T, got [][]byte) {
+	if len(got) != len(fixtureLMHist) {
+		t.Fatalf("expected %d history entries, got %d", len(fixtureLMHist), len(got))
+	}
+	for i, h := range got {
+		if hex.EncodeToString(h) != fixtureLMHist[i] {
+			t.Errorf("history %d: expected %s got %x", i, fixtureLMHist[i], h)
+		}
+	}
+}
+
+func TestLMHistoryAES(t *testing.T) {
+	peks := ditreader.PEKList{fixturePEK}
+	hist, err := peks.DecryptHistory(aesHistoryBlob(t, 0), fixtureRid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, hist)
+}
+
+func TestLMHistoryRC4(t *testing.T) {
+	peks := ditreader.PEKList{fixturePEK}
+	hist, err := peks.DecryptHistory(rc4HistoryBlob(t), fixtureRid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, hist)
+}
+
+func TestPEKIndex(t *testing.T) {
+	//rotated PEKs: the blob points at the second key
+	peks := ditreader.PEKList{bytes.Repeat([]byte{0xff}, 16), fixturePEK}
+	hist, err := peks.DecryptHistory(aesHistoryBlob(t, 1), fixtureRid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, hist)
+
+	//an index past the end of the list is an error, not a panic
+	if _, err := peks.DecryptHistory(aesHistoryBlob(t, 2), fixtureRid); err == nil {
+		t.Error("expected an error for an out of range PEK index")
+	}
+}
+
+func TestAESPEKList(t *testing.T) {
+	//32 byte header, then index/key entries, ended by the 08080808 entry seen on real dits
+	plain := make([]byte, 32)
+	keys := [][]byte{fixturePEK, bytes.Repeat([]byte{0x11}, 16), bytes.Repeat([]byte{0x22}, 16)}
+	for i, k := range keys {
+		plain = binary.LittleEndian.AppendUint32(plain, uint32(i))
+		plain = append(plain, k...)
+	}
+	plain = append(plain, bytes.Repeat([]byte{0x08}, 20)...)
+
+	got := ditreader.NewPeklistPlain(plain).AESKeys()
+	if len(got) != len(keys) {
+		t.Fatalf("expected %d keys, got %d: %x", len(keys), len(got), got)
+	}
+	for i := range keys {
+		if !bytes.Equal(got[i], keys[i]) {
+			t.Errorf("key %d: expected %x got %x", i, keys[i], got[i])
+		}
+	}
+
+	//running out of data part way through an entry
+	if got := ditreader.NewPeklistPlain(plain[:32+20+10]).AESKeys(); len(got) != 1 {
+		t.Errorf("expected 1 key from a truncated list, got %x", got)
+	}
+}
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/C-Sto/gosecretsdump/pkg/esent"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// hiveFromFake lays out the keys and values of a fakeRegistry as a real hive
+func hiveFromFake(f fakeRegistry) []byte {
+	root := &hiveKey{name: "ROOT"}
+	getKey := func(path []string) *hiveKey {
+		k := root
+		for _, name := range path {
+			var next *hiveKey
+			for _, sk := range k.subkeys {
+				if sk.name == name {
+					next = sk
+				}
+			}
+			if next == nil {
+				next = &hiveKey{name: name}
+				k.subkeys = append(k.subkeys, next)
+			}
+			k = next
+		}
+		return k
+	}
+	paths := []string{}
+	for p := range f.vals {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		parts := strings.Split(strings.TrimPrefix(p, "\\"), "\\")
+		name := parts[len(parts)-1]
+		if name == "default" {
+			name = ""
+		}
+		k := getKey(parts[:len(parts)-1])
+		k.values = append(k.values, hiveValue{name: name, typ: 3, data: f.vals[p]})
+	}
+	for p, subkeys := range f.keys {
+		parts := strings.Split(strings.TrimPrefix(p, "\\"), "\\")
+		for _, sk := range subkeys {
+			getKey(append(parts, sk))
+		}
+	}
+	return buildHive(root)
+}
+
+func TestHiveReader(t *testing.T) {
+	old, updated := logHives()
+	r, err := winregistry.InitReader(bytes.NewReader(old), int64(len(old)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVal(t, r, "\\Test\\Secret", "old-secret-value")
+	if _, err := winregistry.InitReader(bytes.NewReader(old[:100]), 100); !errors.Is(err, winregistry.ErrCorruptHive) {
+		t.Errorf("expected corrupt hive error, got %v", err)
+	}
+
+	//logs are found next to the hive in the FS
+	fsys := fstest.MapFS{
+		"hives/SYSTEM":      {Data: dirty(old)},
+		"hives/SYSTEM.LOG1": {Data: newFormatLog(old[4096:], updated[4096:], 1, false)},
+	}
+	r, err = winregistry.InitFS(fsys, "hives/SYSTEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVal(t, r, "\\Test\\Secret", "new-secret-value")
+	if _, err := winregistry.InitFS(fsys, "hives/SAM"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
+
+func TestSAMReader(t *testing.T) {
+	//the SAM has to be encrypted with the bootkey of the real SYSTEM hive
+	samBootKey = []byte{0x13, 0xd2, 0x09, 0x76, 0xd6, 0x3e, 0xa5, 0xe8, 0x36, 0x03, 0x6e, 0xc8, 0xbc, 0x68, 0xd6, 0xeb}
+	defer func() { samBootKey = []byte("fedcba9876543210") }()
+	sam := hiveFromFake(samRegistry(t, true))
+	system, err := os.ReadFile("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromFS, err := samreader.NewFS(fstest.MapFS{"SYSTEM": {Data: system}, "SAM": {Data: sam}}, "SYSTEM", "SAM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromReader, err := samreader.NewReader(bytes.NewReader(system), int64(len(system)), bytes.NewReader(sam), int64(len(sam)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sr := range []samreader.SamReader{fromFS, fromReader} {
+		dh := dumpSAM(t, sr)
+		if len(dh) != 1 || dh[0].Username != "labuser" {
+			t.Fatalf("unexpected users %+v", dh)
+		}
+		checkHashes(t, "nt", [][]byte{dh[0].NTHash}, [][]byte{samNT})
+	}
+}
+
+func TestESEReader(t *testing.T) {
+	b := junk(3 * 8192)
+	if _, err := (esent.Esedb{}).InitReader(bytes.NewReader(b), int64(len(b))); !errors.Is(err, esent.ErrCorruptPage) {
+		t.Errorf("expected corrupt page error, got %v", err)
+	}
+	if _, err := (esent.Esedb{}).InitFS(fstest.MapFS{"ntds.dit": {Data: b}}, "ntds.dit"); !errors.Is(err, esent.ErrCorruptPage) {
+		t.Errorf("expected corrupt page error, got %v", err)
+	}
+}
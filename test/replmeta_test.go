@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+func TestParseReplMetaData(t *testing.T) {
+	changed := time.Date(2019, 6, 11, 9, 19, 0, 0, time.UTC)
+	dstime := changed.Unix() + 11644473600
+
+	b := make([]byte, 16+48*2)
+	binary.LittleEndian.PutUint32(b[0:], 1)
+	binary.LittleEndian.PutUint32(b[8:], 2)
+	//objectSid, version 1
+	binary.LittleEndian.PutUint32(b[16:], 589970)
+	binary.LittleEndian.PutUint32(b[20:], 1)
+	//unicodePwd, changed 4 times
+	e := b[64:]
+	binary.LittleEndian.PutUint32(e[0:], 589914)
+	binary.LittleEndian.PutUint32(e[4:], 4)
+	binary.LittleEndian.PutUint64(e[8:], uint64(dstime))
+	copy(e[16:32], []byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	binary.LittleEndian.PutUint64(e[32:], 12345)
+	binary.LittleEndian.PutUint64(e[40:], 23456)
+
+	md, err := ditreader.ParseReplMetaData(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(md) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(md))
+	}
+	pwd, ok := md.Attribute(589914)
+	if !ok {
+		t.Fatal("unicodePwd entry not found")
+	}
+	if pwd.Version != 4 || !pwd.Changed.Equal(changed) || pwd.OriginatingUSN != 12345 || pwd.LocalUSN != 23456 {
+		t.Errorf("unexpected entry: %+v", pwd)
+	}
+	if pwd.OriginatingDSA != "00112233-4455-6677-8899-aabbccddeeff" {
+		t.Errorf("unexpected originating DSA: %s", pwd.OriginatingDSA)
+	}
+
+	if _, err := ditreader.ParseReplMetaData(b[:100]); err == nil {
+		t.Error("expected an error for truncated metadata")
+	}
+}
+
+func TestReplMetaDataOutput(t *testing.T) {
+	changed := time.Date(2019, 6, 11, 9, 19, 0, 0, time.UTC)
+	dh := ditreader.DumpedHash{
+		Username: "alice",
+		Rid:      1104,
+		History: ditreader.PwdHistory{
+			NTHist:         [][]byte{ditreader.EmptyNT, ditreader.EmptyNT},
+			PwdLastSet:     changed,
+			PwdChangeCount: 4,
+		},
+		ReplMetaData: ditreader.ReplMetaData{{AttrID: 589914, Version: 4, Changed: changed, OriginatingUSN: 12345, LocalUSN: 23456}},
+	}
+
+	//only the current password (history0) has a known set time
+	h := dh.HistoryStrings()
+	if len(h) != 2 || !strings.HasSuffix(h[0], "::: (pwdLastSet=2019-06-11 09:19)") || !strings.HasSuffix(h[1], ":::") {
+		t.Errorf("bad history %q", h)
+	}
+	if dh.HistoryString() != h[0]+"\n"+h[1]+"\n" {
+		t.Errorf("bad history %q", dh.HistoryString())
+	}
+
+	b, err := json.Marshal(dh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := struct {
+		PwdLastSet     string `json:"pwdLastSet"`
+		PwdChangeCount uint32 `json:"pwdChangeCount"`
+		ReplMetaData   []struct {
+			AttributeID uint32 `json:"attributeId"`
+			Version     uint32 `json:"version"`
+			Changed     string `json:"changed"`
+			LocalUSN    int64  `json:"localUsn"`
+		} `json:"replMetaData"`
+	}{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.PwdLastSet != "2019-06-11T09:19:00Z" || got.PwdChangeCount != 4 || len(got.ReplMetaData) != 1 {
+		t.Fatalf("bad json %s", b)
+	}
+	if e := got.ReplMetaData[0]; e.AttributeID != 589914 || e.Version != 4 || e.Changed != "2019-06-11T09:19:00Z" || e.LocalUSN != 23456 {
+		t.Errorf("bad json %s", b)
+	}
+}
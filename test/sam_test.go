@@ -0,0 +1,302 @@
+package test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+var (
+	samBootKey = []byte("fedcba9876543210")
+	samHBK     = []byte("HASHEDBOOTKEY!!!")
+	samRid     = uint32(1001)
+	samNT, _   = hex.DecodeString("8846f7eaee8fb117ad06bdd830b7586c")
+	samLM, _   = hex.DecodeString("e52cac67419a9a224a3b108f3fa6cb6d")
+	samNTHist  = [][]byte{samNT, bytes.Repeat([]byte{0x01}, 16), bytes.Repeat([]byte{0x02}, 16)}
+	samLMHist  = [][]byte{samLM, bytes.Repeat([]byte{0x03}, 16)}
+)
+
+// samF builds the Account\F value holding the hashed bootkey
+func samF(t *testing.T, aesStyle bool) []byte {
+	f := make([]byte, 0x68)
+	if aesStyle {
+		binary.LittleEndian.PutUint16(f, 3)
+		salt := bytes.Repeat([]byte{0x44}, 16)
+		//hashed bootkey and the checksum, 32 bytes
+		plain := append(append([]byte{}, samHBK...), bytes.Repeat([]byte{0x55}, 16)...)
+		block, err := aes.NewCipher(samBootKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := make([]byte, 32)
+		cipher.NewCBCEncrypter(block, salt).CryptBlocks(ct, plain)
+		hdr := make([]byte, 16)
+		binary.LittleEndian.PutUint32(hdr, 2)
+		binary.LittleEndian.PutUint32(hdr[12:], 32)
+		f = append(f, hdr...)
+		f = append(f, salt...)
+		return append(f, ct...)
+	}
+
+	binary.LittleEndian.PutUint16(f, 2)
+	salt := bytes.Repeat([]byte{0x66}, 16)
+	key := md5.Sum(append(append(append(append([]byte{}, salt...), "!@#$%^&*()qwertyUIOPAzxcvbnmQQQQQQQQQQQQ)(*@&%\x00"...), samBootKey...), "0123456789012345678901234567890123456789\x00"...))
+	rc, err := rc4.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := md5.Sum(append(append(append(append([]byte{}, samHBK...), "0123456789012345678901234567890123456789\x00"...), samHBK...), "!@#$%^&*()qwertyUIOPAzxcvbnmQQQQQQQQQQQQ)(*@&%\x00"...))
+	ct := make([]byte, 32)
+	rc.XORKeyStream(ct, append(append([]byte{}, samHBK...), checksum[:]...))
+	hdr := make([]byte, 8)
+	binary.LittleEndian.PutUint32(hdr, 1)
+	f = append(f, hdr...)
+	f = append(f, salt...)
+	f = append(f, ct...)
+	//reserved
+	return append(f, make([]byte, 8)...)
+}
+
+// samEntry encrypts hashes the way they are stored in the V value
+func samEntry(t *testing.T, hashes [][]byte, constant string, aesStyle bool) []byte {
+	plain := []byte{}
+	for _, h := range hashes {
+		plain = append(plain, desLayer(t, h, samRid)...)
+	}
+	if aesStyle {
+		salt := bytes.Repeat([]byte{0x77}, 16)
+		//pkcs7, always a full block for hash sized data
+		plain = append(plain, bytes.Repeat([]byte{16}, 16)...)
+		block, err := aes.NewCipher(samHBK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, salt).CryptBlocks(ct, plain)
+		r := []byte{0, 0, 2, 0, 0, 0, 0, 0}
+		r = append(r, salt...)
+		return append(r, ct...)
+	}
+	rid := make([]byte, 4)
+	binary.LittleEndian.PutUint32(rid, samRid)
+	key := md5.Sum(append(append(append([]byte{}, samHBK...), rid...), constant...))
+	rc, err := rc4.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := make([]byte, len(plain))
+	rc.XORKeyStream(ct, plain)
+	return append([]byte{0, 0, 1, 0}, ct...)
+}
+
+// samV builds a user's V value
+func samV(t *testing.T, aesStyle bool) []byte {
+	entries := make([][]byte, 17)
+	entries[1] = utf16le("labuser")
+	entries[13] = samEntry(t, [][]byte{samLM}, "LMPASSWORD\x00", aesStyle)
+	entries[14] = samEntry(t, [][]byte{samNT}, "NTPASSWORD\x00", aesStyle)
+	entries[15] = samEntry(t, samNTHist, "NTPASSWORDHISTORY\x00", aesStyle)
+	entries[16] = samEntry(t, samLMHist, "LMPASSWORDHISTORY\x00", aesStyle)
+
+	hdr := make([]byte, 17*12)
+	data := []byte{}
+	for i, e := range entries {
+		binary.LittleEndian.PutUint32(hdr[i*12:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(hdr[i*12+4:], uint32(len(e)))
+		data = append(data, e...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	return append(hdr, data...)
+}
+
+// samUserF builds a user's F value: disabled, locked out, 3 bad passwords and 7 logons
+func samUserF() []byte {
+	f := make([]byte, 0x50)
+	binary.LittleEndian.PutUint32(f, 2)
+	binary.LittleEndian.PutUint64(f[0x8:], 0x01d5a2b3c4d5e6f0)  //last logon
+	binary.LittleEndian.PutUint64(f[0x18:], 0x01d4000000000000) //pwdLastSet
+	binary.LittleEndian.PutUint64(f[0x20:], 0x7fffffffffffffff) //never expires
+	binary.LittleEndian.PutUint32(f[0x30:], samRid)
+	binary.LittleEndian.PutUint32(f[0x34:], 513)
+	binary.LittleEndian.PutUint32(f[0x38:], 0x1|0x10|0x200|0x400)
+	binary.LittleEndian.PutUint16(f[0x40:], 3)
+	binary.LittleEndian.PutUint16(f[0x42:], 7)
+	return f
+}
+
+func samRegistry(t *testing.T, aesStyle bool) fakeRegistry {
+	return fakeRegistry{
+		vals: map[string][]byte{
+			"\\SAM\\Domains\\Account\\F":                  samF(t, aesStyle),
+			"\\SAM\\Domains\\Account\\Users\\000003E9\\V": samV(t, aesStyle),
+			"\\SAM\\Domains\\Account\\Users\\000003E9\\F": samUserF(),
+		},
+		keys: map[string][]string{"\\SAM\\Domains\\Account\\Users": {"000003E9", "Names"}},
+	}
+}
+
+func dumpSAM(t *testing.T, sr samreader.SamReader) []ditreader.DumpedHash {
+	r := []ditreader.DumpedHash{}
+	done := make(chan struct{})
+	go func() {
+		for dh := range sr.GetOutChan() {
+			r = append(r, dh)
+		}
+		close(done)
+	}()
+	if err := sr.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	return r
+}
+
+func checkHashes(t *testing.T, name string, got, want [][]byte) {
+	if len(got) != len(want) {
+		t.Fatalf("%s: expected %d entries, got %d", name, len(want), len(got))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("%s %d: expected %x got %x", name, i, want[i], got[i])
+		}
+	}
+}
+
+func TestSAMHashes(t *testing.T) {
+	for _, aesStyle := range []bool{true, false} {
+		dh := dumpSAM(t, samreader.FromRegistry(samRegistry(t, aesStyle), samBootKey, false))
+		if len(dh) != 1 {
+			t.Fatalf("aes=%v: expected 1 user, got %d", aesStyle, len(dh))
+		}
+		u := dh[0]
+		if u.Username != "labuser" || u.Rid != samRid {
+			t.Errorf("aes=%v: bad user %s %d", aesStyle, u.Username, u.Rid)
+		}
+		checkHashes(t, "nt", [][]byte{u.NTHash}, [][]byte{samNT})
+		checkHashes(t, "lm", [][]byte{u.LMHash}, [][]byte{samLM})
+		//the current password isn't repeated in the history
+		checkHashes(t, "nt history", u.History.NTHist, samNTHist[1:])
+		checkHashes(t, "lm history", u.History.LmHist, samLMHist[1:])
+	}
+
+	//no LM history when the policy says there are no LM hashes
+	dh := dumpSAM(t, samreader.FromRegistry(samRegistry(t, true), samBootKey, true))
+	if len(dh[0].History.LmHist) != 0 {
+		t.Errorf("expected no LM history, got %d entries", len(dh[0].History.LmHist))
+	}
+}
+
+func TestSAMAccountF(t *testing.T) {
+	dh := dumpSAM(t, samreader.FromRegistry(samRegistry(t, true), samBootKey, true))
+	u := dh[0]
+	want := ditreader.UF_ACCOUNTDISABLE | ditreader.UF_NORMAL_ACCOUNT | ditreader.UF_DONT_EXPIRE_PASSWORD | ditreader.UF_LOCKOUT
+	if u.UAC != want {
+		t.Errorf("expected UAC %s got %s", want, u.UAC)
+	}
+	if u.Logon.BadPwdCount != 3 || u.Logon.LogonCount != 7 {
+		t.Errorf("expected 3 bad passwords and 7 logons, got %d and %d", u.Logon.BadPwdCount, u.Logon.LogonCount)
+	}
+	if !u.Logon.LastLogon.Equal(winregistry.FiletimeToTime(0x01d5a2b3c4d5e6f0)) || u.Logon.LastLogon.Year() != 2019 {
+		t.Errorf("bad last logon %s", u.Logon.LastLogon)
+	}
+	if u.History.PwdLastSet.IsZero() {
+		t.Error("expected pwdLastSet to be set")
+	}
+	if !u.Logon.AccountExpires.IsZero() || !u.Logon.LastBadPassword.IsZero() {
+		t.Errorf("expected no expiry or bad password time, got %s %s", u.Logon.AccountExpires, u.Logon.LastBadPassword)
+	}
+}
+
+func TestSAMChecksum(t *testing.T) {
+	//right bootkey
+	hbk, err := samreader.FromRegistry(samRegistry(t, false), samBootKey, true).SysKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hbk, samHBK) {
+		t.Errorf("expected hashed bootkey %x got %x", samHBK, hbk)
+	}
+
+	//wrong bootkey is an error rather than garbage hashes
+	if _, err := samreader.FromRegistry(samRegistry(t, false), []byte("0123456789abcdef"), true).SysKey(); err == nil {
+		t.Error("expected a checksum error for the wrong bootkey")
+	}
+	if err := samreader.FromRegistry(samRegistry(t, false), []byte("0123456789abcdef"), true).Dump(); err == nil {
+		t.Error("expected Dump to fail with the wrong bootkey")
+	}
+}
+
+func TestSAMStartupPassword(t *testing.T) {
+	//SecureBoot mode 2, the bootkey is MD5 of the UTF-16 password
+	samBootKey = systemreader.StartupPasswordKey("syskey-pw")
+	defer func() { samBootKey = []byte("fedcba9876543210") }()
+	if hex.EncodeToString(samBootKey) != hex.EncodeToString(md5Sum(utf16le("syskey-pw"))) {
+		t.Fatalf("bad startup password key %x", samBootKey)
+	}
+
+	dh := dumpSAM(t, samreader.FromRegistry(samRegistry(t, false), samBootKey, true))
+	checkHashes(t, "nt", [][]byte{dh[0].NTHash}, [][]byte{samNT})
+}
+
+func md5Sum(b []byte) []byte {
+	h := md5.Sum(b)
+	return h[:]
+}
+
+func TestSecureBoot(t *testing.T) {
+	s, err := systemreader.New("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, err := s.SecureBoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != systemreader.SECUREBOOT_REGISTRY {
+		t.Errorf("expected SecureBoot mode 1, got %d", mode)
+	}
+}
+
+func TestSAMDeleted(t *testing.T) {
+	hive := buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{
+		{name: "SAM", subkeys: []*hiveKey{
+			{name: "Domains", subkeys: []*hiveKey{
+				{name: "Account", values: []hiveValue{{name: "F", typ: 3, data: samF(t, true)}}, subkeys: []*hiveKey{
+					{name: "Users", subkeys: []*hiveKey{
+						{name: "000003E9", deleted: true, values: []hiveValue{
+							{name: "F", typ: 3, data: samUserF()},
+							{name: "V", typ: 3, data: samV(t, true)},
+						}},
+						{name: "Names"},
+					}},
+				}},
+			}},
+		}},
+	}})
+	reg := openHive(t, hive, nil)
+
+	if dh := dumpSAM(t, samreader.FromRegistry(reg, samBootKey, false)); len(dh) != 0 {
+		t.Errorf("expected no users without deleted accounts, got %d", len(dh))
+	}
+	dh := dumpSAM(t, samreader.FromRegistry(reg, samBootKey, false, samreader.Options{IncludeDeleted: true}))
+	if len(dh) != 1 {
+		t.Fatalf("expected 1 deleted user, got %d", len(dh))
+	}
+	u := dh[0]
+	if !u.Deleted || u.Username != "labuser" || u.Rid != samRid || !u.UAC.Has(ditreader.UF_ACCOUNTDISABLE) {
+		t.Errorf("bad deleted user %+v", u)
+	}
+	checkHashes(t, "nt", [][]byte{u.NTHash}, [][]byte{samNT})
+}
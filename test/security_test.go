@@ -0,0 +1,410 @@
+package test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/securityreader"
+	"github.com/C-Sto/gosecretsdump/pkg/systemreader"
+	"github.com/C-Sto/gosecretsdump/pkg/winregistry"
+)
+
+// fakeRegistry is a WinRegIF backed by maps, for building hives in tests
+type fakeRegistry struct {
+	vals map[string][]byte
+	keys map[string][]string
+}
+
+func (f fakeRegistry) GetVal(path string) (uint32, []byte, error) {
+	if v, ok := f.vals[path]; ok {
+		return 3, v, nil
+	}
+	return 0, nil, winregistry.ErrNotFound
+}
+
+func (f fakeRegistry) GetClass(path string) ([]byte, error) {
+	return nil, winregistry.ErrNotFound
+}
+
+func (f fakeRegistry) EnumKeys(path string) ([]string, error) {
+	if v, ok := f.keys[path]; ok {
+		return v, nil
+	}
+	return nil, winregistry.ErrNotFound
+}
+
+func (f fakeRegistry) EnumValues(path string) ([]winregistry.Value, error) {
+	r := []winregistry.Value{}
+	for k, v := range f.vals {
+		if strings.HasPrefix(k, path+"\\") && !strings.Contains(k[len(path)+1:], "\\") {
+			r = append(r, winregistry.Value{Name: k[len(path)+1:], Type: 3, Data: v})
+		}
+	}
+	return r, nil
+}
+
+func (f fakeRegistry) KeyInfo(path string) (winregistry.KeyInfo, error) {
+	return winregistry.KeyInfo{SubKeys: uint32(len(f.keys[path]))}, nil
+}
+
+var (
+	secBootKey = []byte("0123456789abcdef")
+	secLSAKey  = []byte("LSAKEYLSAKEYLSAKEYLSAKEYLSAKEY!!")
+	dpapiBlob  = append(append([]byte{1, 0, 0, 0}, bytes.Repeat([]byte{0x11}, 20)...), bytes.Repeat([]byte{0x22}, 20)...)
+	nlkmBlob   = bytes.Repeat([]byte{0x33}, 64)
+)
+
+func utf16le(s string) []byte {
+	r := []byte{}
+	for _, c := range s {
+		r = append(r, byte(c), byte(c>>8))
+	}
+	return r
+}
+
+// vistaEncrypt builds an LSA_SECRET wrapping an LSA_SECRET_BLOB holding secret
+func vistaEncrypt(t *testing.T, key, secret []byte) []byte {
+	blob := make([]byte, 16)
+	binary.LittleEndian.PutUint32(blob, uint32(len(secret)))
+	blob = append(blob, secret...)
+	for len(blob)%16 != 0 {
+		blob = append(blob, 0)
+	}
+
+	salt := bytes.Repeat([]byte{0x5a}, 32)
+	h := sha256.New()
+	h.Write(key)
+	for i := 0; i < 1000; i++ {
+		h.Write(salt)
+	}
+	c, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := make([]byte, len(blob))
+	for i := 0; i < len(blob); i += 16 {
+		c.Encrypt(ct[i:i+16], blob[i:i+16])
+	}
+	r := make([]byte, 28)
+	binary.LittleEndian.PutUint32(r, 1)
+	r = append(r, salt...)
+	return append(r, ct...)
+}
+
+// xpEncrypt builds a legacy secret, DES encrypted with 7 byte chunks of the LSA key
+func xpEncrypt(t *testing.T, key, secret []byte) []byte {
+	plain := make([]byte, 8)
+	binary.LittleEndian.PutUint32(plain, uint32(len(secret)))
+	binary.LittleEndian.PutUint32(plain[4:], 1)
+	plain = append(plain, secret...)
+	for len(plain)%8 != 0 {
+		plain = append(plain, 0)
+	}
+	ct := []byte{}
+	key0 := key
+	for i := 0; i < len(plain); i += 8 {
+		c, err := des.NewCipher(ditreader.TransformKey(key0[:7]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 8)
+		c.Encrypt(b, plain[i:i+8])
+		ct = append(ct, b...)
+		key0 = key0[7:]
+		if len(key0) < 7 {
+			key0 = key[len(key0):]
+		}
+	}
+	r := make([]byte, 12)
+	binary.LittleEndian.PutUint32(r, uint32(len(ct)))
+	return append(r, ct...)
+}
+
+// xpPolicyKey builds a PolSecretEncryptionKey value holding lsaKey
+func xpPolicyKey(t *testing.T, lsaKey []byte) []byte {
+	material := bytes.Repeat([]byte{0x42}, 16)
+	plain := make([]byte, 48)
+	copy(plain[16:], lsaKey)
+	h := md5.New()
+	h.Write(secBootKey)
+	for i := 0; i < 1000; i++ {
+		h.Write(material)
+	}
+	rc, err := rc4.NewCipher(h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	polVal := make([]byte, 76)
+	rc.XORKeyStream(polVal[12:60], plain)
+	copy(polVal[60:], material)
+	return polVal
+}
+
+func secretsRegistry(t *testing.T, polKey string, polVal []byte, enc func(*testing.T, []byte, []byte) []byte, lsaKey []byte) fakeRegistry {
+	f := fakeRegistry{
+		vals: map[string][]byte{polKey: polVal},
+		keys: map[string][]string{"\\Policy\\Secrets": {"DPAPI_SYSTEM", "NL$KM", "_SC_MSSQLSERVER", "DefaultPassword", "EmptySecret"}},
+	}
+	f.vals["\\Policy\\Secrets\\DPAPI_SYSTEM\\CurrVal\\default"] = enc(t, lsaKey, dpapiBlob)
+	f.vals["\\Policy\\Secrets\\NL$KM\\CurrVal\\default"] = enc(t, lsaKey, nlkmBlob)
+	f.vals["\\Policy\\Secrets\\_SC_MSSQLSERVER\\CurrVal\\default"] = enc(t, lsaKey, utf16le("Summer2019!"))
+	f.vals["\\Policy\\Secrets\\_SC_MSSQLSERVER\\OldVal\\default"] = enc(t, lsaKey, utf16le("Spring2019!"))
+	f.vals["\\Policy\\Secrets\\DefaultPassword\\CurrVal\\default"] = enc(t, lsaKey, utf16le("autologon"))
+	f.vals["\\Policy\\Secrets\\EmptySecret\\CurrVal\\default"] = enc(t, lsaKey, make([]byte, 16))
+	return f
+}
+
+func checkSecrets(t *testing.T, sr securityreader.SecurityReader) {
+	secrets, err := sr.LSASecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]securityreader.LSASecret{}
+	for _, s := range secrets {
+		got[s.DisplayName()] = s
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 secrets, got %d: %v", len(got), got)
+	}
+
+	m, u, err := got["DPAPI_SYSTEM"].DPAPIKeys()
+	if err != nil || !bytes.Equal(m, dpapiBlob[4:24]) || !bytes.Equal(u, dpapiBlob[24:44]) {
+		t.Errorf("bad dpapi keys %x %x (%v)", m, u, err)
+	}
+	if s := got["NL$KM"]; s.Type != securityreader.SECRET_NLKM || !bytes.Equal(s.Secret, nlkmBlob) {
+		t.Errorf("bad NL$KM: %x", s.Secret)
+	}
+	if s := got["_SC_MSSQLSERVER"].String(); s != "(Unknown User):Summer2019!" {
+		t.Errorf("bad service secret: %s", s)
+	}
+	if s := got["_SC_MSSQLSERVER_history"]; !s.History || s.String() != "(Unknown User):Spring2019!" {
+		t.Errorf("bad old service secret: %s", s.String())
+	}
+	dh := got["DefaultPassword"].DumpedHash()
+	if dh.Supp.ClearPassword != "autologon" || !strings.HasPrefix(dh.Secret, "[DefaultPassword]\n") {
+		t.Errorf("bad DefaultPassword output: %+v", dh)
+	}
+}
+
+func TestLSASecretsVista(t *testing.T) {
+	lsaBlob := append(bytes.Repeat([]byte{0}, 52), secLSAKey...)
+	reg := secretsRegistry(t, "\\Policy\\PolEKList\\default", vistaEncrypt(t, secBootKey, lsaBlob), vistaEncrypt, secLSAKey)
+	sr := securityreader.FromRegistry(reg, secBootKey)
+
+	k, vista, err := sr.LSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vista || !bytes.Equal(k, secLSAKey) {
+		t.Fatalf("bad LSA key %x (vista=%v)", k, vista)
+	}
+	checkSecrets(t, sr)
+}
+
+func TestLSASecretsXP(t *testing.T) {
+	lsaKey := secLSAKey[:16]
+	reg := secretsRegistry(t, "\\Policy\\PolSecretEncryptionKey\\default", xpPolicyKey(t, lsaKey), xpEncrypt, lsaKey)
+	sr := securityreader.FromRegistry(reg, secBootKey)
+
+	k, vista, err := sr.LSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vista || !bytes.Equal(k, lsaKey) {
+		t.Fatalf("bad LSA key %x (vista=%v)", k, vista)
+	}
+	checkSecrets(t, sr)
+}
+
+var (
+	cacheHash, _ = hex.DecodeString("f3a9e2d1c0b5a49382716a5b4c3d2e1f")
+	cacheTime    = time.Date(2019, 6, 11, 9, 19, 0, 0, time.UTC)
+)
+
+// cacheEntry builds an encrypted NL_RECORD for CONTOSO\jsmith
+func cacheEntry(t *testing.T, nlkm []byte, vista bool) []byte {
+	user, domain, dns := utf16le("jsmith"), utf16le("CONTOSO"), utf16le("contoso.local")
+	padTo4 := func(b []byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	plain := make([]byte, 0x48)
+	copy(plain, cacheHash)
+	plain = append(plain, padTo4(user)...)
+	plain = append(plain, padTo4(domain)...)
+	plain = append(plain, padTo4(dns)...)
+	for len(plain)%16 != 0 {
+		plain = append(plain, 0)
+	}
+
+	iv := bytes.Repeat([]byte{0x7e}, 16)
+	ct := make([]byte, len(plain))
+	if vista {
+		block, err := aes.NewCipher(nlkm[16:32])
+		if err != nil {
+			t.Fatal(err)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ct, plain)
+	} else {
+		h := hmac.New(md5.New, nlkm)
+		h.Write(iv)
+		rc, err := rc4.NewCipher(h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.XORKeyStream(ct, plain)
+	}
+
+	hdr := make([]byte, 96)
+	binary.LittleEndian.PutUint16(hdr[0:], uint16(len(user)))
+	binary.LittleEndian.PutUint16(hdr[2:], uint16(len(domain)))
+	binary.LittleEndian.PutUint32(hdr[16:], 1105)
+	binary.LittleEndian.PutUint64(hdr[32:], uint64(cacheTime.Unix()+11644473600)*10000000)
+	binary.LittleEndian.PutUint32(hdr[48:], 1)
+	binary.LittleEndian.PutUint16(hdr[60:], uint16(len(dns)))
+	copy(hdr[64:], iv)
+	return append(hdr, ct...)
+}
+
+func checkCache(t *testing.T, sr securityreader.SecurityReader, expected string) {
+	cached, err := sr.CachedCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 1 {
+		t.Fatalf("expected 1 cached entry, got %d", len(cached))
+	}
+	c := cached[0]
+	if c.Username != "jsmith" || c.Domain != "CONTOSO" || c.DNSDomain != "contoso.local" || c.UserID != 1105 || c.Slot != 1 {
+		t.Errorf("bad cache entry: %+v", c)
+	}
+	if !c.LastWrite.Equal(cacheTime) {
+		t.Errorf("bad last write %s", c.LastWrite)
+	}
+	if c.String() != expected {
+		t.Errorf("expected %s\ngot      %s", expected, c.String())
+	}
+}
+
+func TestCachedCredentialsVista(t *testing.T) {
+	lsaBlob := append(bytes.Repeat([]byte{0}, 52), secLSAKey...)
+	reg := secretsRegistry(t, "\\Policy\\PolEKList\\default", vistaEncrypt(t, secBootKey, lsaBlob), vistaEncrypt, secLSAKey)
+	reg.vals["\\Cache\\NL$1"] = cacheEntry(t, nlkmBlob, true)
+	//unused slot
+	reg.vals["\\Cache\\NL$2"] = make([]byte, 96)
+	reg.vals["\\Cache\\NL$IterationCount"] = []byte{20, 0, 0, 0}
+
+	checkCache(t, securityreader.FromRegistry(reg, secBootKey),
+		"contoso.local/jsmith:$DCC2$20480#jsmith#f3a9e2d1c0b5a49382716a5b4c3d2e1f: (2019-06-11 09:19:00)")
+}
+
+func TestCachedCredentialsXP(t *testing.T) {
+	lsaKey := secLSAKey[:16]
+	reg := secretsRegistry(t, "\\Policy\\PolSecretEncryptionKey\\default", xpPolicyKey(t, lsaKey), xpEncrypt, lsaKey)
+	reg.vals["\\Cache\\NL$1"] = cacheEntry(t, nlkmBlob, false)
+
+	checkCache(t, securityreader.FromRegistry(reg, secBootKey),
+		"contoso.local/jsmith:f3a9e2d1c0b5a49382716a5b4c3d2e1f:jsmith: (2019-06-11 09:19:00)")
+}
+
+func TestTcpipParameters(t *testing.T) {
+	s, err := systemreader.New("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, domain, err := s.TcpipParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "addemo" || domain != "demo.local" {
+		t.Errorf("expected addemo/demo.local, got %s/%s", host, domain)
+	}
+}
+
+func TestMachineAccount(t *testing.T) {
+	//machine passwords are 120 random UTF-16 characters, including unpaired surrogates
+	secret := utf16le("S3cr3t-Machine-Passw0rd")
+	secret = append(secret, 0x00, 0xd8, 0x41, 0x00)
+
+	s := securityreader.LSASecret{Name: "$MACHINE.ACC", Type: securityreader.SECRET_MACHINE_ACC, Hostname: "ADDEMO", Domain: "demo.local", Secret: secret}
+	if s.MachineAccount() != "demo.local\\ADDEMO$" {
+		t.Errorf("bad machine account name %s", s.MachineAccount())
+	}
+	if s.KerberosSalt() != "DEMO.LOCALhostaddemo.demo.local" {
+		t.Errorf("bad salt %s", s.KerberosSalt())
+	}
+	if !bytes.Equal(s.NTHash(), kdf.MD4(secret)) {
+		t.Errorf("NT hash should be md4 of the raw secret")
+	}
+
+	aes256, aes128, err := s.AESKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the unpaired surrogate becomes U+FFFD before the key is derived
+	password := []byte("S3cr3t-Machine-Passw0rd�A")
+	want256, _ := kdf.AESStringToKey(password, []byte("DEMO.LOCALhostaddemo.demo.local"), 4096, 32)
+	want128, _ := kdf.AESStringToKey(password, []byte("DEMO.LOCALhostaddemo.demo.local"), 4096, 16)
+	if !bytes.Equal(aes256, want256) || !bytes.Equal(aes128, want128) {
+		t.Errorf("bad aes keys %x %x", aes256, aes128)
+	}
+
+	lines := strings.Split(s.String(), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "demo.local\\ADDEMO$:aad3b435b51404eeaad3b435b51404ee:") ||
+		!strings.HasPrefix(lines[1], "demo.local\\ADDEMO$:aes256-cts-hmac-sha1-96:") {
+		t.Errorf("unexpected output:\n%s", s.String())
+	}
+
+	//no domain, no keys
+	s.Domain = ""
+	if _, _, err := s.AESKeys(); err == nil {
+		t.Error("expected an error without a domain")
+	}
+	if !strings.HasPrefix(s.String(), "$MACHINE.ACC:aad3b435b51404eeaad3b435b51404ee:") {
+		t.Errorf("unexpected output:\n%s", s.String())
+	}
+}
+
+func TestSecurityStartupKey(t *testing.T) {
+	lsaBlob := append(bytes.Repeat([]byte{0}, 52), secLSAKey...)
+	hive := buildHive(&hiveKey{name: "ROOT", subkeys: []*hiveKey{
+		{name: "Policy", subkeys: []*hiveKey{
+			{name: "PolEKList", values: []hiveValue{{name: "default", typ: 3, data: vistaEncrypt(t, secBootKey, lsaBlob)}}},
+		}},
+	}})
+	system, err := os.ReadFile("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the bootkey in the SYSTEM hive isn't the one the SECURITY hive was encrypted with
+	sr, err := securityreader.NewReader(bytes.NewReader(system), int64(len(system)), bytes.NewReader(hive), int64(len(hive)),
+		securityreader.Options{System: systemreader.Options{StartupKey: secBootKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _, err := sr.LSAKey(); err != nil || !bytes.Equal(k, secLSAKey) {
+		t.Errorf("startup key not used, got LSA key %x (%v)", k, err)
+	}
+
+	if _, err := securityreader.NewReader(bytes.NewReader(system), int64(len(system)), bytes.NewReader(hive), int64(len(hive)),
+		securityreader.Options{System: systemreader.Options{StartupKey: secBootKey[:8]}}); err == nil {
+		t.Error("expected an error for a short startup key")
+	}
+}
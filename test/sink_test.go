@@ -0,0 +1,211 @@
+package test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/cmd"
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+// sinkHashes is one of each kind of thing a dumper sends
+func sinkHashes() []ditreader.DumpedHash {
+	return []ditreader.DumpedHash{
+		{
+			Username: "alice",
+			Rid:      1104,
+			LMHash:   ditreader.EmptyLM,
+			NTHash:   kdf.NTHash("Password1"),
+			Supp: ditreader.SuppInfo{
+				Username:      "alice",
+				ClearPassword: "Password1",
+				KerbKeys:      []string{"alice:aes256-cts-hmac-sha1-96:aa", "alice:aes128-cts-hmac-sha1-96:bb"},
+			},
+			History: ditreader.PwdHistory{NTHist: [][]byte{kdf.NTHash("Password0")}},
+		},
+		{
+			Username: "bob",
+			Rid:      1105,
+			UAC:      ditreader.UF_ACCOUNTDISABLE,
+			LMHash:   ditreader.EmptyLM,
+			NTHash:   ditreader.EmptyNT,
+			Deleted:  true,
+		},
+		{Secret: "[*] DefaultPassword\n(Unknown User):hunter2"},
+		{CachedHash: "CORP/carol:$DCC2$10240#carol#00112233445566778899aabbccddeeff"},
+	}
+}
+
+func writeAll(t *testing.T, s cmd.Sink) {
+	for _, dh := range sinkHashes() {
+		if err := s.Write(dh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecretsdumpFormat(t *testing.T) {
+	dh := sinkHashes()
+	alice := dh[0].HashString()
+	got := cmd.SecretsdumpFormat(true, true)(dh[0])
+	want := []cmd.Line{
+		{Kind: cmd.OUT_HASHES, Text: alice + " (status=Enabled)"},
+		{Kind: cmd.OUT_CLEARTEXT, Text: "alice:CLEARTEXT:Password1 (status=Enabled)"},
+		{Kind: cmd.OUT_KERB, Text: "alice:aes256-cts-hmac-sha1-96:aa (status=Enabled)"},
+		{Kind: cmd.OUT_KERB, Text: "alice:aes128-cts-hmac-sha1-96:bb (status=Enabled)"},
+		{Kind: cmd.OUT_HASHES, Text: dh[0].HistoryStrings()[0]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bad lines %q", got)
+	}
+
+	got = cmd.SecretsdumpFormat(false, false)(dh[1])
+	if len(got) != 1 || got[0].Text != dh[1].HashString()+" (deleted)" {
+		t.Errorf("bad lines %q", got)
+	}
+	if got := cmd.SecretsdumpFormat(true, true)(dh[2]); !reflect.DeepEqual(got, []cmd.Line{{Kind: cmd.OUT_SECRETS, Text: dh[2].Secret}}) {
+		t.Errorf("bad lines %q", got)
+	}
+	if got := cmd.SecretsdumpFormat(true, true)(dh[3]); !reflect.DeepEqual(got, []cmd.Line{{Kind: cmd.OUT_CACHED, Text: dh[3].CachedHash}}) {
+		t.Errorf("bad lines %q", got)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	format := cmd.SecretsdumpFormat(false, true)
+	for _, stream := range []bool{false, true} {
+		base := filepath.Join(t.TempDir(), "out")
+		//left over from an earlier run, should be replaced rather than added to
+		os.WriteFile(base, []byte("stale:1:aa:bb:::\n"), 0644)
+		os.WriteFile(base+cmd.OUT_CACHED, []byte("stale\n"), 0644)
+
+		//only enabled accounts, but secrets and cached creds aren't accounts
+		fs, err := cmd.NewFileSink(base, format, stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeAll(t, cmd.NewFilterSink(fs, cmd.KeepEnabled))
+
+		dh := sinkHashes()
+		want := map[string]string{
+			cmd.OUT_HASHES:    dh[0].HashString() + "\n" + dh[0].HistoryStrings()[0] + "\n",
+			cmd.OUT_CLEARTEXT: "alice:CLEARTEXT:Password1\n",
+			cmd.OUT_KERB:      "alice:aes256-cts-hmac-sha1-96:aa\nalice:aes128-cts-hmac-sha1-96:bb\n",
+			cmd.OUT_SECRETS:   dh[2].Secret + "\n",
+			cmd.OUT_CACHED:    dh[3].CachedHash + "\n",
+		}
+		for kind, w := range want {
+			b, err := os.ReadFile(base + kind)
+			if err != nil {
+				t.Fatalf("stream=%v: %v", stream, err)
+			}
+			if string(b) != w {
+				t.Errorf("stream=%v: %s has %q", stream, base+kind, b)
+			}
+		}
+	}
+
+	//nothing to put in a file, so it isn't created, and the files of an earlier run (in any format) don't survive
+	base := filepath.Join(t.TempDir(), "out")
+	for _, kind := range []string{cmd.OUT_HASHES, cmd.OUT_CLEARTEXT, cmd.OUT_KERB, ".nt.hashcat-1000", ".aes256.john-krb5-18"} {
+		os.WriteFile(base+kind, []byte("stale\n"), 0644)
+	}
+	s, err := cmd.NewFileSink(base, format, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write(sinkHashes()[2])
+	s.Close()
+	for _, kind := range []string{cmd.OUT_HASHES, cmd.OUT_CLEARTEXT, cmd.OUT_KERB, cmd.OUT_CACHED, ".nt.hashcat-1000", ".aes256.john-krb5-18"} {
+		if _, err := os.Stat(base + kind); !os.IsNotExist(err) {
+			t.Errorf("%s shouldn't exist: %v", base+kind, err)
+		}
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "out")
+	console := &bytes.Buffer{}
+	format := cmd.SecretsdumpFormat(true, false)
+	fs, err := cmd.NewFileSink(base, format, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, cmd.NewMultiSink(cmd.NewConsoleSink(console, format), fs))
+
+	//the console gets everything, in the order it was dumped
+	lines := []string{}
+	for _, dh := range sinkHashes() {
+		for _, l := range format(dh) {
+			lines = append(lines, l.Text)
+		}
+	}
+	if console.String() != strings.Join(lines, "\n")+"\n" {
+		t.Errorf("bad console output %q", console)
+	}
+	b, err := os.ReadFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "bob:1105:") || !strings.Contains(string(b), "(status=Disabled) (deleted)") {
+		t.Errorf("bad hashes file %q", b)
+	}
+
+	//somewhere files can't go
+	if _, err := cmd.NewFileSink(filepath.Join(base, "not a dir"), format, true); err == nil {
+		t.Error("expected an error opening under a file")
+	}
+
+	//an output that fails doesn't stop the rest
+	console.Reset()
+	dir := filepath.Join(t.TempDir(), "gone")
+	os.Mkdir(dir, 0755)
+	if fs, err = cmd.NewFileSink(filepath.Join(dir, "out"), format, true); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(dir)
+	s := cmd.NewMultiSink(fs, cmd.NewConsoleSink(console, format))
+	if err := s.Write(sinkHashes()[0]); err == nil {
+		t.Error("expected an error writing to a removed directory")
+	}
+	if !strings.HasPrefix(console.String(), "alice:1104:") {
+		t.Errorf("console missed output %q", console)
+	}
+	s.Close()
+}
+
+func TestJSONFormat(t *testing.T) {
+	dh := sinkHashes()
+	got := cmd.JSONFormat(dh[1])
+	if len(got) != 1 || got[0].Kind != cmd.OUT_HASHES {
+		t.Fatalf("bad lines %q", got)
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(got[0].Text), &m); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"username": "bob",
+		"rid":      float64(1105),
+		"lmHash":   hex.EncodeToString(ditreader.EmptyLM),
+		"ntHash":   hex.EncodeToString(ditreader.EmptyNT),
+		"uac":      []interface{}{"ACCOUNTDISABLE"},
+		"deleted":  true,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("bad json %s", got[0].Text)
+	}
+	if got := cmd.JSONFormat(dh[0])[0].Text; !strings.Contains(got, `"cleartext":"Password1"`) || !strings.Contains(got, `"ntHistory":["`) {
+		t.Errorf("bad json %s", got)
+	}
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/internal/kdf"
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+	"github.com/C-Sto/gosecretsdump/pkg/softwarereader"
+)
+
+func softwareHive(t *testing.T, vals map[string][]byte) softwarereader.SoftwareReader {
+	hive := hiveFromFake(fakeRegistry{vals: vals})
+	sr, err := softwarereader.NewReader(bytes.NewReader(hive), int64(len(hive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sr
+}
+
+func TestAutologon(t *testing.T) {
+	wl := softwarereader.WINLOGON_KEY + "\\"
+	sr := softwareHive(t, map[string][]byte{
+		wl + "AutoAdminLogon":    utf16le("1\x00"),
+		wl + "DefaultDomainName": utf16le("LAB\x00"),
+		wl + "DefaultUserName":   utf16le("kiosk\x00"),
+		wl + "DefaultPassword":   utf16le("Summer2024!\x00"),
+		wl + "Shell":             utf16le("explorer.exe\x00"),
+	})
+	al, err := sr.Autologon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !al.Enabled || al.Forced || !al.HasPassword || al.HasAltPassword || al.Username != "kiosk" || al.Password != "Summer2024!" {
+		t.Errorf("bad autologon %+v", al)
+	}
+
+	dh := dumpSoftware(t, sr)
+	if len(dh) != 1 {
+		t.Fatalf("expected 1 credential, got %d", len(dh))
+	}
+	if dh[0].Username != "LAB\\kiosk" || dh[0].Supp.ClearPassword != "Summer2024!" || !bytes.Equal(dh[0].NTHash, kdf.NTHash("Summer2024!")) {
+		t.Errorf("bad dumped hash %+v", dh[0])
+	}
+	if !strings.HasSuffix(dh[0].Secret, "\nLAB\\kiosk:Summer2024!") {
+		t.Errorf("bad secret %q", dh[0].Secret)
+	}
+
+	//a hive without the Winlogon key just has nothing to report
+	creds, err := softwareHive(t, map[string][]byte{"\\Classes\\x": {1}}).Credentials()
+	if err != nil || len(creds) != 0 {
+		t.Errorf("expected no credentials, got %+v %v", creds, err)
+	}
+}
+
+func TestVNCPasswords(t *testing.T) {
+	sr := softwareHive(t, map[string][]byte{
+		"\\TightVNC\\Server\\Password":              {0xdb, 0xd8, 0x3c, 0xfd, 0x72, 0x7a, 0x14, 0x58},
+		"\\Wow6432Node\\RealVNC\\WinVNC4\\Password": utf16le("494015f9a35e8b22\x00"),
+	})
+	creds, err := sr.VNCPasswords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 2 {
+		t.Fatalf("expected 2 passwords, got %+v", creds)
+	}
+	for i, want := range []softwarereader.Credential{
+		{Source: "TightVNC", Path: "\\TightVNC\\Server\\Password", Password: "password"},
+		{Source: "RealVNC", Path: "\\Wow6432Node\\RealVNC\\WinVNC4\\Password", Password: "123456"},
+	} {
+		if creds[i] != want {
+			t.Errorf("expected %+v, got %+v", want, creds[i])
+		}
+	}
+	//no account, so no hash to crack
+	if dh := creds[0].DumpedHash(); dh.NTHash != nil || dh.Secret != "[TightVNC \\TightVNC\\Server\\Password]\n"+softwarereader.UNKNOWN_USER+":password" {
+		t.Errorf("bad dumped hash %+v", dh)
+	}
+
+	bad := softwareHive(t, map[string][]byte{"\\TightVNC\\Server\\Password": {1, 2, 3}})
+	if _, err := bad.VNCPasswords(); err == nil {
+		t.Error("expected an error for a short password")
+	}
+}
+
+func dumpSoftware(t *testing.T, sr softwarereader.SoftwareReader) []ditreader.DumpedHash {
+	r := []ditreader.DumpedHash{}
+	done := make(chan struct{})
+	go func() {
+		for dh := range sr.GetOutChan() {
+			r = append(r, dh)
+		}
+		close(done)
+	}()
+	if err := sr.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	return r
+}
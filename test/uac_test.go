@@ -0,0 +1,112 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/C-Sto/gosecretsdump/pkg/ditreader"
+)
+
+func TestUACFlags(t *testing.T) {
+	cases := []struct {
+		val  uint32
+		flag ditreader.UACFlags
+		name string
+	}{
+		{0x1, ditreader.UF_SCRIPT, "SCRIPT"},
+		{0x2, ditreader.UF_ACCOUNTDISABLE, "ACCOUNTDISABLE"},
+		{0x8, ditreader.UF_HOMEDIR_REQUIRED, "HOMEDIR_REQUIRED"},
+		{0x10, ditreader.UF_LOCKOUT, "LOCKOUT"},
+		{0x20, ditreader.UF_PASSWD_NOTREQD, "PASSWD_NOTREQD"},
+		{0x40, ditreader.UF_PASSWD_CANT_CHANGE, "PASSWD_CANT_CHANGE"},
+		{0x80, ditreader.UF_ENCRYPTED_TEXT_PWD_ALLOWED, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+		{0x100, ditreader.UF_TEMP_DUPLICATE_ACCOUNT, "TEMP_DUPLICATE_ACCOUNT"},
+		{0x200, ditreader.UF_NORMAL_ACCOUNT, "NORMAL_ACCOUNT"},
+		{0x800, ditreader.UF_INTERDOMAIN_TRUST_ACCOUNT, "INTERDOMAIN_TRUST_ACCOUNT"},
+		{0x1000, ditreader.UF_WORKSTATION_TRUST_ACCOUNT, "WORKSTATION_TRUST_ACCOUNT"},
+		{0x2000, ditreader.UF_SERVER_TRUST_ACCOUNT, "SERVER_TRUST_ACCOUNT"},
+		{0x10000, ditreader.UF_DONT_EXPIRE_PASSWORD, "DONT_EXPIRE_PASSWORD"},
+		{0x20000, ditreader.UF_MNS_LOGON_ACCOUNT, "MNS_LOGON_ACCOUNT"},
+		{0x40000, ditreader.UF_SMARTCARD_REQUIRED, "SMARTCARD_REQUIRED"},
+		{0x80000, ditreader.UF_TRUSTED_FOR_DELEGATION, "TRUSTED_FOR_DELEGATION"},
+		{0x100000, ditreader.UF_NOT_DELEGATED, "NOT_DELEGATED"},
+		{0x200000, ditreader.UF_USE_DES_KEY_ONLY, "USE_DES_KEY_ONLY"},
+		{0x400000, ditreader.UF_DONT_REQUIRE_PREAUTH, "DONT_REQ_PREAUTH"},
+		{0x800000, ditreader.UF_PASSWORD_EXPIRED, "PASSWORD_EXPIRED"},
+		{0x1000000, ditreader.UF_TRUSTED_TO_AUTH_FOR_DELEGATION, "TRUSTED_TO_AUTH_FOR_DELEGATION"},
+		{0x2000000, ditreader.UF_NO_AUTH_DATA_REQUIRED, "NO_AUTH_DATA_REQUIRED"},
+		{0x4000000, ditreader.UF_PARTIAL_SECRETS_ACCOUNT, "PARTIAL_SECRETS_ACCOUNT"},
+		{0x8000000, ditreader.UF_USE_AES_KEYS, "USE_AES_KEYS"},
+	}
+
+	for _, c := range cases {
+		u := ditreader.UACFlags(c.val)
+		if uint32(c.flag) != c.val {
+			t.Errorf("%s: expected value 0x%x, got 0x%x", c.name, c.val, uint32(c.flag))
+		}
+		if !u.Has(c.flag) {
+			t.Errorf("%s: Has returned false for its own bit", c.name)
+		}
+		if u.String() != c.name {
+			t.Errorf("0x%x: expected %s, got %s", c.val, c.name, u.String())
+		}
+		//no other documented bit should be reported as set
+		for _, o := range cases {
+			if o.flag != c.flag && u.Has(o.flag) {
+				t.Errorf("0x%x: unexpectedly has %s", c.val, o.name)
+			}
+		}
+	}
+
+	//the old decoder reported lockout for anything with 0x2|0x4 set
+	if ditreader.UACFlags(0x206).Has(ditreader.UF_LOCKOUT) {
+		t.Error("0x206 should not be locked out")
+	}
+}
+
+func TestComputedUAC(t *testing.T) {
+	cases := []struct {
+		name          string
+		uac           ditreader.UACFlags
+		lockoutTime   int64
+		pwdLastSet    int64
+		hasPwdLastSet bool
+		want          ditreader.UACFlags
+	}{
+		{"normal", ditreader.UF_NORMAL_ACCOUNT, 0, 132000000000000000, true, 0},
+		{"locked out", ditreader.UF_NORMAL_ACCOUNT, 132000000000000000, 132000000000000000, true, ditreader.UF_LOCKOUT},
+		{"must change password", ditreader.UF_NORMAL_ACCOUNT, 0, 0, true, ditreader.UF_PASSWORD_EXPIRED},
+		{"password never expires", ditreader.UF_NORMAL_ACCOUNT | ditreader.UF_DONT_EXPIRE_PASSWORD, 0, 0, true, 0},
+		//no pwdLastSet at all isn't the same as it being 0
+		{"no pwdLastSet", ditreader.UF_NORMAL_ACCOUNT, 0, 0, false, 0},
+		{"both", ditreader.UF_NORMAL_ACCOUNT, 1, 0, true, ditreader.UF_LOCKOUT | ditreader.UF_PASSWORD_EXPIRED},
+	}
+	for _, c := range cases {
+		if got := ditreader.ComputedUAC(c.uac, c.lockoutTime, c.pwdLastSet, c.hasPwdLastSet); got != c.want {
+			t.Errorf("%s: expected %s got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestUACFlagsJSON(t *testing.T) {
+	u := ditreader.UF_NORMAL_ACCOUNT | ditreader.UF_ACCOUNTDISABLE | ditreader.UACFlags(0x4)
+	b, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `["ACCOUNTDISABLE","NORMAL_ACCOUNT","0x4"]` {
+		t.Errorf("unexpected json: %s", b)
+	}
+
+	var back ditreader.UACFlags
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back != u {
+		t.Errorf("round trip failed: expected %s got %s", u, back)
+	}
+
+	if err := json.Unmarshal([]byte("514"), &back); err != nil || back != 514 {
+		t.Errorf("expected raw value to decode to 514, got %d (%v)", back, err)
+	}
+}
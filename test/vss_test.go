@@ -0,0 +1,266 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/C-Sto/gosecretsdump/cmd"
+	"github.com/C-Sto/gosecretsdump/pkg/ntfs"
+	"github.com/C-Sto/gosecretsdump/pkg/samreader"
+	"github.com/C-Sto/gosecretsdump/pkg/vss"
+)
+
+var vssCreated = []time.Time{
+	time.Date(2016, 7, 1, 9, 30, 0, 0, time.UTC),
+	time.Date(2016, 7, 8, 9, 30, 0, 0, time.UTC),
+}
+
+func vssBlock(recordType uint32) []byte {
+	b := make([]byte, vss.BLOCK_SIZE)
+	copy(b, vss.VSS_IDENTIFIER)
+	binary.LittleEndian.PutUint32(b[0x10:], 1)
+	binary.LittleEndian.PutUint32(b[0x14:], recordType)
+	return b
+}
+
+func blockOf(b []byte, off int) []byte {
+	r := make([]byte, vss.BLOCK_SIZE)
+	if off < len(b) {
+		copy(r, b[off:])
+	}
+	return r
+}
+
+func toFiletime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+// shadowVolume builds the current (last) version of a volume, with a shadow copy store for each older version
+// holding the blocks that changed after it. The first changed block of a store is a forwarder, the second an
+// overlay, the rest are plain copies. The catalog lists the newest snapshot first.
+func shadowVolume(versions ...[]byte) []byte {
+	out := append([]byte{}, versions[len(versions)-1]...)
+	out = append(out, make([]byte, (vss.BLOCK_SIZE-len(out)%vss.BLOCK_SIZE)%vss.BLOCK_SIZE)...)
+	appendBlock := func(b []byte) int64 {
+		off := int64(len(out))
+		out = append(out, b...)
+		return off
+	}
+	//chains entries over as many blocks as they need
+	appendList := func(recordType uint32, entries [][]byte) int64 {
+		per := (vss.BLOCK_SIZE - 0x80) / len(entries[0])
+		var first, prev int64
+		for i := 0; i < len(entries); i += per {
+			b := vssBlock(recordType)
+			for j := i; j < len(entries) && j < i+per; j++ {
+				copy(b[0x80+(j-i)*len(entries[j]):], entries[j])
+			}
+			off := appendBlock(b)
+			if prev == 0 {
+				first = off
+			} else {
+				binary.LittleEndian.PutUint64(out[prev+0x28:], uint64(off))
+			}
+			prev = off
+		}
+		return first
+	}
+
+	catalog := [][]byte{}
+	for i, old := range versions[:len(versions)-1] {
+		next := versions[i+1]
+		descs := [][]byte{}
+		for b := 0; b < len(old); b += vss.BLOCK_SIZE {
+			oldB, nextB := blockOf(old, b), blockOf(next, b)
+			if bytes.Equal(oldB, nextB) {
+				continue
+			}
+			d := make([]byte, 32)
+			binary.LittleEndian.PutUint64(d, uint64(b))
+			data := appendBlock(oldB)
+			switch len(descs) {
+			case 0:
+				binary.LittleEndian.PutUint64(d[8:], uint64(data))
+				binary.LittleEndian.PutUint32(d[0x18:], vss.BLOCK_FORWARDER)
+			case 1:
+				binary.LittleEndian.PutUint64(d[0x10:], uint64(data))
+				binary.LittleEndian.PutUint32(d[0x18:], vss.BLOCK_OVERLAY)
+				bitmap := uint32(0)
+				for s := 0; s < 32; s++ {
+					if !bytes.Equal(oldB[s*512:(s+1)*512], nextB[s*512:(s+1)*512]) {
+						bitmap |= 1 << uint(s)
+					}
+				}
+				binary.LittleEndian.PutUint32(d[0x1c:], bitmap)
+			default:
+				binary.LittleEndian.PutUint64(d[0x10:], uint64(data))
+			}
+			descs = append(descs, d)
+		}
+		//a descriptor for a block that isn't used
+		unused := make([]byte, 32)
+		binary.LittleEndian.PutUint64(unused[0x10:], 0x123456)
+		binary.LittleEndian.PutUint32(unused[0x18:], vss.BLOCK_NOT_USED)
+		descs = append(descs, unused)
+
+		storeID := mixedGUID("5a1e0000-0000-4000-8000-00000000000" + string(rune('0'+i)))
+		header := vssBlock(vss.RECORD_STORE_HEADER)
+		copy(header[0x80+0x10:], mixedGUID("b5946137-7b9f-4925-af80-51abd60b20d"+string(rune('0'+i))))
+		headerOff := appendBlock(header)
+		blockList := appendList(vss.RECORD_BLOCK_LIST, descs)
+
+		snap := make([]byte, 128)
+		binary.LittleEndian.PutUint64(snap, vss.CATALOG_SNAPSHOT)
+		binary.LittleEndian.PutUint64(snap[8:], uint64(len(old)))
+		copy(snap[0x10:], storeID)
+		binary.LittleEndian.PutUint64(snap[0x30:], toFiletime(vssCreated[i]))
+		st := make([]byte, 128)
+		binary.LittleEndian.PutUint64(st, vss.CATALOG_STORE)
+		binary.LittleEndian.PutUint64(st[8:], uint64(blockList))
+		copy(st[0x10:], storeID)
+		binary.LittleEndian.PutUint64(st[0x20:], uint64(headerOff))
+		catalog = append([][]byte{snap, st}, catalog...)
+	}
+	catalogOff := appendList(vss.RECORD_CATALOG, catalog)
+
+	hdr := out[vss.HEADER_OFFSET:]
+	copy(hdr, vss.VSS_IDENTIFIER)
+	binary.LittleEndian.PutUint32(hdr[0x10:], 1)
+	binary.LittleEndian.PutUint32(hdr[0x14:], vss.RECORD_VOLUME_HEADER)
+	binary.LittleEndian.PutUint64(hdr[0x30:], uint64(catalogOff))
+	return out
+}
+
+// shadowVersions is a volume that had labuser in its SAM for both snapshots, with the account since removed
+func shadowVersions(t *testing.T) [][]byte {
+	noUsers := func() fakeRegistry {
+		reg := samRegistry(t, true)
+		delete(reg.vals, "\\SAM\\Domains\\Account\\Users\\000003E9\\V")
+		delete(reg.vals, "\\SAM\\Domains\\Account\\Users\\000003E9\\F")
+		reg.keys["\\SAM\\Domains\\Account\\Users"] = []string{"Names"}
+		return reg
+	}
+	versions := [][]byte{
+		windowsVolumeSAM(t, func() fakeRegistry { return samRegistry(t, true) }),
+		windowsVolumeSAM(t, func() fakeRegistry { return samRegistry(t, false) }),
+		windowsVolumeSAM(t, noUsers),
+	}
+	//free space at the end, with a few sectors of each block rewritten every version
+	for i := range versions {
+		tail := bytes.Repeat([]byte{0xf5}, 4*vss.BLOCK_SIZE)
+		for s := 0; s < len(tail)/512; s += 3 {
+			copy(tail[s*512:(s+1)*512], bytes.Repeat([]byte{byte(i)}, 512))
+		}
+		versions[i] = append(versions[i], tail...)
+	}
+	return versions
+}
+
+func TestVSS(t *testing.T) {
+	versions := shadowVersions(t)
+	img := shadowVolume(versions...)
+	snaps, err := vss.Snapshots(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(snaps))
+	}
+	for i, snap := range snaps {
+		if !snap.Created.Equal(vssCreated[i]) || snap.ID != "{b5946137-7b9f-4925-af80-51abd60b20d"+string(rune('0'+i))+"}" {
+			t.Errorf("snapshot %d: bad id %s or creation time %s", i, snap.ID, snap.Created)
+		}
+		got, err := io.ReadAll(io.NewSectionReader(snap, 0, snap.Size()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		//the VSS header was added to the live volume after both versions, and hasn't been copied to a store
+		copy(got[vss.HEADER_OFFSET:], make([]byte, 0x80))
+		if !bytes.Equal(got, versions[i]) {
+			t.Errorf("snapshot %d doesn't match the volume at the time", i)
+		}
+		vol, err := ntfs.New(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sr, err := samreader.NewFS(vol, "Windows/System32/config/SYSTEM", "Windows/System32/config/SAM")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dh := dumpSAM(t, sr)
+		if len(dh) != 1 || dh[0].Username != "labuser" {
+			t.Fatalf("snapshot %d: unexpected users %+v", i, dh)
+		}
+		checkHashes(t, "nt", [][]byte{dh[0].NTHash}, [][]byte{samNT})
+	}
+
+	//the account has gone from the live SAM
+	vol, err := ntfs.New(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := samreader.NewFS(vol, "Windows/System32/config/SYSTEM", "Windows/System32/config/SAM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dh := dumpSAM(t, sr); len(dh) != 0 {
+		t.Errorf("expected no users on the current volume, got %d", len(dh))
+	}
+}
+
+func TestVSSDump(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(img, shadowVolume(shadowVersions(t)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	//left by an earlier -vss run, from a snapshot that's gone since
+	stale := out + ".vss-20160624T093000Z"
+	os.WriteFile(stale, []byte("stale:1:aa:bb:::\n"), 0644)
+	if err := cmd.GoSecretsDump(cmd.CLIArgs{ImageLoc: img, VSS: true, Outfile: out, NoPrint: true}); err != nil {
+		t.Fatal(err)
+	}
+	//each snapshot's readers are opened in turn, and the account is in both of them but not the live SAM
+	for _, created := range vssCreated {
+		b, err := os.ReadFile(out + ".vss-" + created.Format("20060102T150405Z"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), "labuser:") {
+			t.Errorf("bad snapshot output %q", b)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected nothing from the live volume, got %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale snapshot output left behind: %v", err)
+	}
+}
+
+func TestVSSErrors(t *testing.T) {
+	versions := shadowVersions(t)
+	if _, err := vss.Snapshots(bytes.NewReader(versions[0])); !errors.Is(err, vss.ErrNoSnapshots) {
+		t.Errorf("expected no snapshots, got %v", err)
+	}
+	//point the last catalog block back at itself
+	img := shadowVolume(versions[1:]...)
+	catalog := int64(binary.LittleEndian.Uint64(img[vss.HEADER_OFFSET+0x30:]))
+	binary.LittleEndian.PutUint64(img[catalog+0x28:], uint64(catalog))
+	if _, err := vss.Snapshots(bytes.NewReader(img)); !errors.Is(err, vss.ErrCorruptStore) {
+		t.Errorf("expected corrupt store, got %v", err)
+	}
+	//and the catalog at junk
+	binary.LittleEndian.PutUint64(img[vss.HEADER_OFFSET+0x30:], 0x4000)
+	if _, err := vss.Snapshots(bytes.NewReader(img)); !errors.Is(err, vss.ErrCorruptStore) {
+		t.Errorf("expected corrupt store, got %v", err)
+	}
+}